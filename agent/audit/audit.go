@@ -0,0 +1,229 @@
+// Package audit records every role transition of a node, e.g. the lock being acquired or the validator being stopped, with the time,
+// the epoch and the block heights of the node, and ships the records to CloudWatch Logs in every region or to a local file. The
+// records of all the nodes are merged back into a cluster-wide timeline by `failoverctl timeline`.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/election"
+)
+
+// Type is the kind of transition an event records
+type Type string
+
+// Types of the events, in the order a term of a validator goes through them
+const (
+	LockAcquired     Type = "lock-acquired"
+	GuardPassed      Type = "guard-passed"
+	GuardFailed      Type = "guard-failed"
+	EpochAdvanced    Type = "epoch-advanced"
+	KeysInserted     Type = "keys-inserted"
+	ValidatorStarted Type = "validator-started"
+	SteppingDown     Type = "stepping-down"
+	ValidatorStopped Type = "validator-stopped"
+	SteppedDown      Type = "stepped-down"
+	LockReleased     Type = "lock-released"
+	Fenced           Type = "fenced"
+	ElectionLeft     Type = "election-left"
+)
+
+// Types of the operator actions recorded by failoverctl. The instance of a handoff is the node asked to take over, freezing and
+// unfreezing the elections concern the whole deployment and have no instance
+const (
+	HandoffRequested Type = "handoff-requested"
+	Frozen           Type = "frozen"
+	Unfrozen         Type = "unfrozen"
+)
+
+// DefaultMaxPending bounds the events kept per sink while the sink fails. The oldest events are dropped first
+const DefaultMaxPending = 1000
+
+// Event is a single record of the audit log
+type Event struct {
+	Time     time.Time `json:"time"`
+	Instance string    `json:"instance"`
+	Region   string    `json:"region,omitempty"`
+	Type     Type      `json:"type"`
+	// State is the election state the node is in after the event
+	State string `json:"state,omitempty"`
+	// Epoch is the validator epoch the node holds, Finalized the finalized block of the node and BestBlock the best_block published
+	// by the node at the time of the event
+	Epoch     uint64 `json:"epoch,omitempty"`
+	Finalized uint64 `json:"finalized,omitempty"`
+	BestBlock uint64 `json:"best_block,omitempty"`
+	Message   string `json:"message,omitempty"`
+
+	// seq orders the queued events, so a write can be acknowledged while older events are dropped
+	seq uint64
+}
+
+// Sink stores events, e.g. a file or a CloudWatch Logs stream
+type Sink interface {
+	Write(ctx context.Context, events []Event) error
+}
+
+// Log stamps the events of the local node and ships them to every sink. Recording never blocks the election: events are queued and
+// written by Run, and a failing sink keeps its events until it recovers.
+type Log struct {
+	Sinks    []Sink
+	Instance string
+	Region   string
+	// Heights returns the finalized block of the node and the best_block it published. It must not block, e.g. Finality.Latest
+	Heights func() (finalized uint64, bestBlock uint64)
+	// Epoch returns the validator epoch the node holds, e.g. Epoch.Held
+	Epoch func() uint64
+	// State returns the election state of the node, e.g. Elector.State
+	State func() election.State
+	// MaxPending bounds the queue of each sink, DefaultMaxPending if zero
+	MaxPending int
+	// Interval is the delay between two writes of Run
+	Interval time.Duration
+	Logf     func(format string, args ...interface{})
+	Now      func() time.Time
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[int][]Event
+}
+
+func (l *Log) logf(format string, args ...interface{}) {
+	if l.Logf != nil {
+		l.Logf(format, args...)
+	}
+}
+
+func (l *Log) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// Record queues an event of the local node. A nil log records nothing, so the audit log can be disabled
+func (l *Log) Record(eventType Type, format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	event := Event{
+		Time:     l.now().UTC(),
+		Instance: l.Instance,
+		Region:   l.Region,
+		Type:     eventType,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	if l.Heights != nil {
+		event.Finalized, event.BestBlock = l.Heights()
+	}
+	if l.Epoch != nil {
+		event.Epoch = l.Epoch()
+	}
+	if l.State != nil {
+		event.State = string(l.State())
+	}
+	l.logf("INFO. Audit: %s %s", event.Type, event.Message)
+
+	maxPending := l.MaxPending
+	if maxPending <= 0 {
+		maxPending = DefaultMaxPending
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending == nil {
+		l.pending = make(map[int][]Event)
+	}
+	l.seq++
+	event.seq = l.seq
+	for i := range l.Sinks {
+		queue := append(l.pending[i], event)
+		if len(queue) > maxPending {
+			queue = queue[len(queue)-maxPending:]
+		}
+		l.pending[i] = queue
+	}
+}
+
+// Hook returns a hook recording an event, e.g. to record the epoch once the guard advanced it
+func (l *Log) Hook(eventType Type, message string) election.Hook {
+	return func(ctx context.Context) error {
+		l.Record(eventType, "%s", message)
+		return nil
+	}
+}
+
+// Transition records the events of a change of the election state. It is the OnTransition of the Elector
+func (l *Log) Transition(from, to election.State) {
+	switch {
+	case to == election.StateGuarding:
+		l.Record(LockAcquired, "lock acquired")
+	case from == election.StateGuarding && to == election.StateInsertingKeys:
+		l.Record(GuardPassed, "double-signing guard passed")
+	case from == election.StateInsertingKeys && to == election.StateStartingValidator:
+		l.Record(KeysInserted, "session keys inserted")
+	case from == election.StateStartingValidator && to == election.StateHolding:
+		l.Record(ValidatorStarted, "node restarted as a validator")
+	case to == election.StateSteppingDown:
+		l.Record(SteppingDown, "stepping down from %s", from)
+	case from == election.StateSteppingDown:
+		l.Record(LockReleased, "lock released")
+	}
+}
+
+// Flush writes the queued events to every sink and reports the first error. The events a sink failed to write stay queued
+func (l *Log) Flush(ctx context.Context) error {
+	var firstErr error
+	for i, sink := range l.Sinks {
+		l.mu.Lock()
+		events := l.pending[i]
+		l.mu.Unlock()
+		if len(events) == 0 {
+			continue
+		}
+
+		if err := sink.Write(ctx, events); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		// Events were recorded, and old ones possibly dropped, during the write
+		last := events[len(events)-1].seq
+		l.mu.Lock()
+		queue := l.pending[i]
+		for len(queue) > 0 && queue[0].seq <= last {
+			queue = queue[1:]
+		}
+		l.pending[i] = queue
+		l.mu.Unlock()
+	}
+	return firstErr
+}
+
+// Run writes the queued events every interval until the context is done, then writes the last events with a short timeout
+func (l *Log) Run(ctx context.Context) {
+	interval := l.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := l.Flush(flushCtx); err != nil {
+				l.logf("ERROR! Unable to write the last audit events: %s", err)
+			}
+			return
+		case <-ticker.C:
+			if err := l.Flush(ctx); err != nil && ctx.Err() == nil {
+				l.logf("ERROR! Unable to write audit events: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/election"
+)
+
+type fakeSink struct {
+	mu     sync.Mutex
+	events []Event
+	err    error
+}
+
+func (f *fakeSink) Write(ctx context.Context, events []Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.events = append(f.events, events...)
+	return nil
+}
+
+func (f *fakeSink) types() []Type {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var types []Type
+	for _, event := range f.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestLogTransitions(t *testing.T) {
+	sink := &fakeSink{}
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	log := &Log{
+		Sinks:    []Sink{sink},
+		Instance: "i-1",
+		Region:   "us-east-1",
+		Heights:  func() (uint64, uint64) { return 100, 98 },
+		Epoch:    func() uint64 { return 4 },
+		Now:      func() time.Time { return now },
+	}
+
+	states := []election.State{
+		election.StateAcquiring, election.StateGuarding, election.StateInsertingKeys, election.StateStartingValidator,
+		election.StateHolding, election.StateSteppingDown, election.StateAcquiring, election.StateGuarding,
+		election.StateSteppingDown, election.StateStopped,
+	}
+	for i := 1; i < len(states); i++ {
+		log.Transition(states[i-1], states[i])
+	}
+	require.NoError(t, log.Flush(context.Background()))
+
+	assert.Equal(t, []Type{
+		LockAcquired, GuardPassed, KeysInserted, ValidatorStarted, SteppingDown, LockReleased,
+		LockAcquired, SteppingDown, LockReleased,
+	}, sink.types())
+	assert.Equal(t, Event{
+		Time: now, Instance: "i-1", Region: "us-east-1", Type: LockAcquired, Epoch: 4, Finalized: 100, BestBlock: 98,
+		Message: "lock acquired", seq: 1,
+	}, sink.events[0])
+	assert.Equal(t, "stepping down from holding", sink.events[4].Message)
+
+	// A disabled audit log records nothing
+	var disabled *Log
+	disabled.Record(Fenced, "not recorded")
+	assert.NoError(t, disabled.Hook(Fenced, "not recorded")(context.Background()))
+}
+
+func TestLogKeepsEventsOfFailingSink(t *testing.T) {
+	healthy, failing := &fakeSink{}, &fakeSink{err: errors.New("unreachable")}
+	log := &Log{Sinks: []Sink{healthy, failing}, Instance: "i-1", MaxPending: 2}
+
+	log.Record(LockAcquired, "lock acquired")
+	assert.Error(t, log.Flush(context.Background()))
+	assert.Equal(t, []Type{LockAcquired}, healthy.types())
+
+	// The oldest events of the failing sink are dropped
+	log.Record(GuardPassed, "guard passed")
+	log.Record(EpochAdvanced, "epoch advanced")
+	failing.err = nil
+	require.NoError(t, log.Flush(context.Background()))
+	assert.Equal(t, []Type{LockAcquired, GuardPassed, EpochAdvanced}, healthy.types())
+	assert.Equal(t, []Type{GuardPassed, EpochAdvanced}, failing.types())
+
+	// Written events are not written again
+	require.NoError(t, log.Flush(context.Background()))
+	assert.Len(t, healthy.types(), 3)
+}
+
+func TestTimeline(t *testing.T) {
+	at := func(minute int) time.Time {
+		return time.Date(2020, 6, 1, 12, minute, 0, 0, time.UTC)
+	}
+	first := []Event{
+		{Time: at(0), Instance: "i-1", Type: LockAcquired},
+		{Time: at(1), Instance: "i-1", Type: ValidatorStarted},
+		{Time: at(5), Instance: "i-1", Type: ValidatorStopped},
+	}
+	second := []Event{
+		{Time: at(6), Instance: "i-2", Type: LockAcquired},
+		{Time: at(7), Instance: "i-2", Type: ValidatorStarted, Epoch: 2, Finalized: 1200, BestBlock: 1190, Message: "node restarted as a validator"},
+	}
+	// The events of every node are read from every region
+	events := Timeline(second, first, first, second)
+	require.Len(t, events, 5)
+	for i := 1; i < len(events); i++ {
+		assert.False(t, events[i].Time.Before(events[i-1].Time))
+	}
+	assert.Empty(t, Overlaps(events))
+
+	overlapping := Timeline(events, []Event{
+		{Time: at(3), Instance: "i-3", Type: ValidatorStarted},
+		{Time: at(4), Instance: "i-3", Type: ValidatorStopped},
+	})
+	assert.Equal(t, []string{"i-3 started validating at 2020-06-01T12:03:00Z while i-1 validated since 2020-06-01T12:01:00Z"}, Overlaps(overlapping))
+
+	var table bytes.Buffer
+	require.NoError(t, WriteTable(&table, events))
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	require.Len(t, lines, 6)
+	assert.True(t, strings.HasPrefix(lines[0], "TIME"))
+	assert.Equal(t, []string{"2020-06-01T12:07:00.000Z", "i-2", "validator-started", "2", "1200", "1190", "node", "restarted", "as", "a", "validator"},
+		strings.Fields(lines[5]))
+}
@@ -0,0 +1,187 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+)
+
+// MaxEventsPerRequest is the number of events sent in a single PutLogEvents call, far below the limits of CloudWatch Logs
+const MaxEventsPerRequest = 500
+
+// LogGroup returns the CloudWatch Logs group of the deployment, created by Terraform in every region
+func LogGroup(prefix string) string {
+	return "/polkadot/validator-failover/" + prefix + "/audit"
+}
+
+// File appends the events to a file, one JSON object per line
+type File struct {
+	Path string
+}
+
+// Write implements Sink
+func (f *File) Write(ctx context.Context, events []Event) error {
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Read parses the events of a file written by File. Lines that are not events, e.g. a line cut by a crash, are reported with their number
+func Read(r io.Reader) ([]Event, error) {
+	var events []Event
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return events, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// CloudWatch writes the events to a stream of a CloudWatch Logs group, the stream of a node being named after its instance
+type CloudWatch struct {
+	Client cloudwatchlogsiface.CloudWatchLogsAPI
+	Group  string
+	Stream string
+
+	created bool
+	token   *string
+}
+
+// Write implements Sink. The stream is created on the first write
+func (c *CloudWatch) Write(ctx context.Context, events []Event) error {
+	if !c.created {
+		_, err := c.Client.CreateLogStreamWithContext(ctx, &cloudwatchlogs.CreateLogStreamInput{
+			LogGroupName:  aws.String(c.Group),
+			LogStreamName: aws.String(c.Stream),
+		})
+		if err != nil && !isCode(err, cloudwatchlogs.ErrCodeResourceAlreadyExistsException) {
+			return fmt.Errorf("unable to create log stream %s of %s: %w", c.Stream, c.Group, err)
+		}
+		if err != nil {
+			// The agent restarted, the stream has a sequence token already
+			if err := c.refreshToken(ctx); err != nil {
+				return err
+			}
+		}
+		c.created = true
+	}
+
+	for start := 0; start < len(events); start += MaxEventsPerRequest {
+		end := start + MaxEventsPerRequest
+		if end > len(events) {
+			end = len(events)
+		}
+		if err := c.put(ctx, events[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// put sends a batch, fetching the sequence token again once if another writer advanced it
+func (c *CloudWatch) put(ctx context.Context, events []Event) error {
+	input := &cloudwatchlogs.PutLogEventsInput{LogGroupName: aws.String(c.Group), LogStreamName: aws.String(c.Stream)}
+	for _, event := range events {
+		message, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		input.LogEvents = append(input.LogEvents, &cloudwatchlogs.InputLogEvent{
+			Message:   aws.String(string(message)),
+			Timestamp: aws.Int64(event.Time.UnixNano() / 1e6),
+		})
+	}
+
+	for attempt := 0; ; attempt++ {
+		input.SequenceToken = c.token
+		output, err := c.Client.PutLogEventsWithContext(ctx, input)
+		if err == nil {
+			c.token = output.NextSequenceToken
+			return nil
+		}
+		if isCode(err, cloudwatchlogs.ErrCodeDataAlreadyAcceptedException) {
+			return c.refreshToken(ctx)
+		}
+		if attempt > 0 || !isCode(err, cloudwatchlogs.ErrCodeInvalidSequenceTokenException) {
+			return fmt.Errorf("unable to put events to %s of %s: %w", c.Stream, c.Group, err)
+		}
+		if err := c.refreshToken(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *CloudWatch) refreshToken(ctx context.Context) error {
+	output, err := c.Client.DescribeLogStreamsWithContext(ctx, &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        aws.String(c.Group),
+		LogStreamNamePrefix: aws.String(c.Stream),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to describe log stream %s of %s: %w", c.Stream, c.Group, err)
+	}
+	for _, stream := range output.LogStreams {
+		if aws.StringValue(stream.LogStreamName) == c.Stream {
+			c.token = stream.UploadSequenceToken
+			return nil
+		}
+	}
+	return fmt.Errorf("log stream %s of %s not found", c.Stream, c.Group)
+}
+
+func isCode(err error, code string) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == code
+}
+
+// Fetch returns the events of every stream of the group in the time range
+func Fetch(ctx context.Context, client cloudwatchlogsiface.CloudWatchLogsAPI, group string, start, end int64) ([]Event, error) {
+	var events []Event
+	var parseErr error
+	err := client.FilterLogEventsPagesWithContext(ctx, &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName: aws.String(group),
+		StartTime:    aws.Int64(start),
+		EndTime:      aws.Int64(end),
+	}, func(output *cloudwatchlogs.FilterLogEventsOutput, last bool) bool {
+		for _, logEvent := range output.Events {
+			var event Event
+			if err := json.Unmarshal([]byte(aws.StringValue(logEvent.Message)), &event); err != nil {
+				parseErr = fmt.Errorf("event %s of stream %s: %w", aws.StringValue(logEvent.EventId), aws.StringValue(logEvent.LogStreamName), err)
+				return false
+			}
+			events = append(events, event)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", group, err)
+	}
+	return events, parseErr
+}
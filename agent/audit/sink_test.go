@@ -0,0 +1,122 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLogs keeps a single stream and checks the sequence tokens the way CloudWatch Logs does
+type fakeLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+
+	exists   bool
+	token    int
+	messages []string
+}
+
+func (f *fakeLogs) CreateLogStreamWithContext(ctx aws.Context, input *cloudwatchlogs.CreateLogStreamInput, opts ...request.Option) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	if f.exists {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceAlreadyExistsException, "The specified log stream already exists", nil)
+	}
+	f.exists = true
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+func (f *fakeLogs) expected() *string {
+	if f.token == 0 {
+		return nil
+	}
+	return aws.String(strings.Repeat("t", f.token))
+}
+
+func (f *fakeLogs) PutLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.PutLogEventsInput, opts ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	if aws.StringValue(input.SequenceToken) != aws.StringValue(f.expected()) {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidSequenceTokenException, "The given sequenceToken is invalid", nil)
+	}
+	for _, event := range input.LogEvents {
+		f.messages = append(f.messages, aws.StringValue(event.Message))
+	}
+	f.token++
+	return &cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: f.expected()}, nil
+}
+
+func (f *fakeLogs) DescribeLogStreamsWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogStreamsInput, opts ...request.Option) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	return &cloudwatchlogs.DescribeLogStreamsOutput{LogStreams: []*cloudwatchlogs.LogStream{
+		{LogStreamName: aws.String("i-10"), UploadSequenceToken: aws.String("x")},
+		{LogStreamName: input.LogStreamNamePrefix, UploadSequenceToken: f.expected()},
+	}}, nil
+}
+
+func (f *fakeLogs) FilterLogEventsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.FilterLogEventsInput, fn func(*cloudwatchlogs.FilterLogEventsOutput, bool) bool, opts ...request.Option) error {
+	for i, message := range f.messages {
+		if !fn(&cloudwatchlogs.FilterLogEventsOutput{Events: []*cloudwatchlogs.FilteredLogEvent{{Message: aws.String(message)}}}, i == len(f.messages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sink := &File{Path: filepath.Join(dir, "failover-audit.jsonl")}
+
+	written := []Event{
+		{Time: time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC), Instance: "i-1", Type: LockAcquired, Message: "lock acquired"},
+		{Time: time.Date(2020, 6, 1, 12, 1, 0, 0, time.UTC), Instance: "i-1", Type: ValidatorStarted, Epoch: 2, Finalized: 1200},
+	}
+	require.NoError(t, sink.Write(context.Background(), written[:1]))
+	require.NoError(t, sink.Write(context.Background(), written[1:]))
+
+	file, err := os.Open(sink.Path)
+	require.NoError(t, err)
+	defer file.Close()
+	read, err := Read(file)
+	require.NoError(t, err)
+	assert.Equal(t, written, read)
+
+	_, err = Read(strings.NewReader(`{"type":"lock-acquired"}` + "\n" + `{"type":"gua`))
+	assert.EqualError(t, err, "line 2: unexpected end of JSON input")
+}
+
+func TestCloudWatchSink(t *testing.T) {
+	client := &fakeLogs{}
+	sink := &CloudWatch{Client: client, Group: LogGroup("test"), Stream: "i-1"}
+	event := Event{Time: time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC), Instance: "i-1", Type: LockAcquired}
+
+	require.NoError(t, sink.Write(context.Background(), []Event{event}))
+	require.NoError(t, sink.Write(context.Background(), []Event{event, event}))
+	assert.Len(t, client.messages, 3)
+
+	// A restarted agent finds the stream and its token
+	restarted := &CloudWatch{Client: client, Group: LogGroup("test"), Stream: "i-1"}
+	require.NoError(t, restarted.Write(context.Background(), []Event{event}))
+
+	// Another writer advanced the token
+	client.token++
+	require.NoError(t, sink.Write(context.Background(), []Event{event}))
+	assert.Len(t, client.messages, 5)
+
+	var decoded Event
+	require.NoError(t, json.Unmarshal([]byte(client.messages[0]), &decoded))
+	assert.Equal(t, event, decoded)
+
+	events, err := Fetch(context.Background(), client, LogGroup("test"), 0, 1)
+	require.NoError(t, err)
+	assert.Len(t, events, 5)
+}
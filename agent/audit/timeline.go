@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Timeline merges the events of every node and every source into a single list ordered by time. The same event read from several
+// regions or files is kept once
+func Timeline(sources ...[]Event) []Event {
+	type identity struct {
+		time     time.Time
+		instance string
+		typ      Type
+		message  string
+	}
+	seen := make(map[identity]bool)
+	var events []Event
+	for _, source := range sources {
+		for _, event := range source {
+			id := identity{event.Time.UTC(), event.Instance, event.Type, event.Message}
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events
+}
+
+// Overlaps lists the periods in which more than one node validated according to the timeline, the double-signing the election
+// prevents. A node validates from ValidatorStarted until ValidatorStopped or, if its agent died, its next LockAcquired
+func Overlaps(events []Event) []string {
+	validating := make(map[string]time.Time)
+	var overlaps []string
+	for _, event := range events {
+		switch event.Type {
+		case ValidatorStarted:
+			var others []string
+			for instance := range validating {
+				if instance != event.Instance {
+					others = append(others, instance)
+				}
+			}
+			sort.Strings(others)
+			for _, instance := range others {
+				overlaps = append(overlaps, fmt.Sprintf("%s started validating at %s while %s validated since %s",
+					event.Instance, event.Time.UTC().Format(time.RFC3339), instance, validating[instance].UTC().Format(time.RFC3339)))
+			}
+			validating[event.Instance] = event.Time
+		case ValidatorStopped, LockAcquired, ElectionLeft, Fenced:
+			delete(validating, event.Instance)
+		}
+	}
+	return overlaps
+}
+
+// WriteTable prints the timeline as a table, one event per line
+func WriteTable(w io.Writer, events []Event) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TIME\tINSTANCE\tREGION\tEVENT\tEPOCH\tFINALIZED\tBEST BLOCK\tMESSAGE")
+	for _, event := range events {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n", event.Time.UTC().Format("2006-01-02T15:04:05.000Z"), event.Instance,
+			event.Region, event.Type, event.Epoch, event.Finalized, event.BestBlock, event.Message)
+	}
+	return table.Flush()
+}
@@ -0,0 +1,256 @@
+// Package config sets the flags of failover-agent from a YAML or JSON file, the environment and SSM, so that the same binary and
+// the same file can run in every region and in a local test harness.
+//
+// The flags are the schema: every setting is named after a flag, takes the values the flag takes and defaults to the default of
+// the flag. Sources override each other in the order default, file, SSM, environment and command line, whatever the order they are
+// applied in, and every setting remembers where its value came from so that errors point at the offending line or variable.
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultEnvPrefix is prepended to the names of the environment variables, e.g. FAILOVER_AGENT_MIN_PEERS sets min-peers
+const DefaultEnvPrefix = "FAILOVER_AGENT_"
+
+// Ranks of the sources, a value only replaces a value of the same or a lower rank
+const (
+	rankDefault = iota
+	rankFile
+	rankSSM
+	rankEnv
+	rankFlag
+)
+
+type origin struct {
+	rank  int
+	where string
+}
+
+// Errors lists every invalid setting
+type Errors []error
+
+func (e Errors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Settings applies the sources to the flags and remembers the origin of every value
+type Settings struct {
+	Flags *flag.FlagSet
+	// Ignored lists the flags that can only be set on the command line, e.g. the flag naming the file
+	Ignored []string
+
+	origins map[string]origin
+}
+
+// New returns the settings of the parsed flags. The flags set on the command line keep their values
+func New(flags *flag.FlagSet, ignored ...string) *Settings {
+	s := &Settings{Flags: flags, Ignored: ignored, origins: make(map[string]origin)}
+	flags.Visit(func(f *flag.Flag) {
+		s.origins[f.Name] = origin{rank: rankFlag, where: "flag -" + f.Name}
+	})
+	return s
+}
+
+// Origin returns where the value of the setting came from, e.g. agent.yaml:12, environment variable FAILOVER_AGENT_MIN_PEERS or default
+func (s *Settings) Origin(name string) string {
+	if o, ok := s.origins[name]; ok {
+		return o.where
+	}
+	return "default"
+}
+
+// Errorf returns an error about the setting that points at the origin of its value
+func (s *Settings) Errorf(name, format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s: %s", s.Origin(name), name, fmt.Sprintf(format, args...))
+}
+
+func (s *Settings) ignored(name string) bool {
+	for _, ignored := range s.Ignored {
+		if ignored == name {
+			return true
+		}
+	}
+	return false
+}
+
+// set applies a single value unless a source of a higher rank already set the setting
+func (s *Settings) set(name, value string, o origin) error {
+	f := s.Flags.Lookup(name)
+	if f == nil || s.ignored(name) {
+		return fmt.Errorf("%s: unknown setting %q", o.where, name)
+	}
+	if current, ok := s.origins[name]; ok && current.rank > o.rank {
+		return nil
+	}
+	if err := s.Flags.Set(name, value); err != nil {
+		return fmt.Errorf("%s: %s: invalid value %q: %s", o.where, name, value, cause(err))
+	}
+	s.origins[name] = o
+	return nil
+}
+
+// cause strips the flag package wording from the errors of flag.Set
+func cause(err error) string {
+	message := err.Error()
+	if i := strings.LastIndex(message, ": "); i >= 0 {
+		return message[i+2:]
+	}
+	return message
+}
+
+// ApplyFile reads the settings from a YAML or JSON file: a single mapping of setting names to scalar values
+func (s *Settings) ApplyFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return s.ApplyYAML(path, content)
+}
+
+// ApplyYAML reads the settings from the content of a file. JSON is read as the YAML subset it is
+func (s *Settings) ApplyYAML(name string, content []byte) error {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("%s: %s", name, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	if len(document.Content) == 0 {
+		return nil
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: expected a mapping of setting names to values", name, root.Line)
+	}
+
+	var errs Errors
+	seen := make(map[string]int)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		where := fmt.Sprintf("%s:%d", name, key.Line)
+		if line, ok := seen[key.Value]; ok {
+			errs = append(errs, fmt.Errorf("%s: %s is already set on line %d", where, key.Value, line))
+			continue
+		}
+		seen[key.Value] = key.Line
+		if value.Kind != yaml.ScalarNode || value.Tag == "!!null" {
+			errs = append(errs, fmt.Errorf("%s: %s: expected a single value, lists are comma separated", where, key.Value))
+			continue
+		}
+		if err := s.set(key.Value, value.Value, origin{rank: rankFile, where: where}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// EnvName returns the environment variable of the setting, e.g. FAILOVER_AGENT_MIN_PEERS for min-peers
+func EnvName(prefix, name string) string {
+	return prefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// ApplyEnv reads the settings from the environment variables named by EnvName, lookup is os.LookupEnv outside of tests
+func (s *Settings) ApplyEnv(prefix string, lookup func(string) (string, bool)) error {
+	var errs Errors
+	s.Flags.VisitAll(func(f *flag.Flag) {
+		if s.ignored(f.Name) {
+			return
+		}
+		variable := EnvName(prefix, f.Name)
+		if value, ok := lookup(variable); ok {
+			if err := s.set(f.Name, value, origin{rank: rankEnv, where: "environment variable " + variable}); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ApplySSM reads the settings from the parameters under the path, e.g. /polkadot/validator-failover/<prefix>/agent/min-peers
+func (s *Settings) ApplySSM(ctx context.Context, client ssmiface.SSMAPI, path string) error {
+	path = strings.TrimSuffix(path, "/") + "/"
+	var errs Errors
+	err := client.GetParametersByPathPagesWithContext(ctx, &ssm.GetParametersByPathInput{Path: aws.String(path), WithDecryption: aws.Bool(true)},
+		func(output *ssm.GetParametersByPathOutput, last bool) bool {
+			for _, parameter := range output.Parameters {
+				name := aws.StringValue(parameter.Name)
+				if err := s.set(strings.TrimPrefix(name, path), aws.StringValue(parameter.Value), origin{rank: rankSSM, where: "SSM parameter " + name}); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return fmt.Errorf("unable to read the settings under %s: %w", path, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Schema returns the JSON schema of the settings file, generated from the flags
+func Schema(flags *flag.FlagSet, ignored ...string) ([]byte, error) {
+	properties := make(map[string]interface{})
+	flags.VisitAll(func(f *flag.Flag) {
+		for _, name := range ignored {
+			if name == f.Name {
+				return
+			}
+		}
+		property := map[string]interface{}{"description": f.Usage}
+		var value interface{}
+		if getter, ok := f.Value.(flag.Getter); ok {
+			value = getter.Get()
+		}
+		switch value.(type) {
+		case bool:
+			property["type"] = "boolean"
+		case int, int64, uint, uint64:
+			property["type"] = "integer"
+		case float64:
+			property["type"] = "number"
+		case time.Duration:
+			property["type"] = "string"
+			property["pattern"] = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+		default:
+			property["type"] = "string"
+		}
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" && f.DefValue != "0s" {
+			var parsed interface{}
+			if property["type"] == "string" || json.Unmarshal([]byte(f.DefValue), &parsed) != nil {
+				parsed = f.DefValue
+			}
+			property["default"] = parsed
+		}
+		properties[f.Name] = property
+	})
+
+	return json.MarshalIndent(map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                "failover-agent settings",
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties":           properties,
+	}, "", "  ")
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// agentFlags is a subset of the flags of failover-agent
+type agentFlags struct {
+	flags    *flag.FlagSet
+	prefix   *string
+	regions  *string
+	election *bool
+	minPeers *int
+	interval *time.Duration
+}
+
+func newAgentFlags(t *testing.T, args ...string) agentFlags {
+	flags := flag.NewFlagSet("failover-agent", flag.ContinueOnError)
+	f := agentFlags{
+		flags:    flags,
+		prefix:   flags.String("prefix", "", "Prefix of the deployment"),
+		regions:  flags.String("regions", "us-east-1,us-east-2,eu-west-1", "Comma separated list of the regions"),
+		election: flags.Bool("election", false, "Take part in the validator election"),
+		minPeers: flags.Int("min-peers", 2, "Minimum number of peers"),
+		interval: flags.Duration("interval", 15*time.Second, "Delay between two samples"),
+	}
+	flags.String("config", "", "Settings file")
+	require.NoError(t, flags.Parse(args))
+	return f
+}
+
+func env(variables map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := variables[name]
+		return value, ok
+	}
+}
+
+func TestApplyYAML(t *testing.T) {
+	f := newAgentFlags(t)
+	settings := New(f.flags, "config")
+
+	require.NoError(t, settings.ApplyYAML("agent.yaml", []byte(`
+# Settings of the test harness
+prefix: test
+regions: us-east-1,us-west-1
+election: true
+interval: 10s
+`)))
+	assert.Equal(t, "test", *f.prefix)
+	assert.Equal(t, "us-east-1,us-west-1", *f.regions)
+	assert.True(t, *f.election)
+	assert.Equal(t, 10*time.Second, *f.interval)
+	assert.Equal(t, 2, *f.minPeers)
+	assert.Equal(t, "agent.yaml:3", settings.Origin("prefix"))
+	assert.Equal(t, "default", settings.Origin("min-peers"))
+
+	// JSON is read the same way
+	require.NoError(t, settings.ApplyYAML("agent.json", []byte(`{"prefix": "prod", "min-peers": 3}`)))
+	assert.Equal(t, "prod", *f.prefix)
+	assert.Equal(t, 3, *f.minPeers)
+}
+
+func TestErrorsPointAtTheField(t *testing.T) {
+	f := newAgentFlags(t)
+	settings := New(f.flags, "config")
+
+	err := settings.ApplyYAML("agent.yaml", []byte(`prefix: test
+min-peers: three
+prioirty: 10
+regions:
+  - us-east-1
+config: other.yaml
+prefix: again
+`))
+	require.Error(t, err)
+	// The causes of invalid values depend on the Go version
+	lines := strings.Split(err.Error(), "\n")
+	require.Len(t, lines, 5)
+	assert.True(t, strings.HasPrefix(lines[0], `agent.yaml:2: min-peers: invalid value "three": `), lines[0])
+	assert.Equal(t, `agent.yaml:3: unknown setting "prioirty"`, lines[1])
+	assert.Equal(t, "agent.yaml:4: regions: expected a single value, lists are comma separated", lines[2])
+	assert.Equal(t, `agent.yaml:6: unknown setting "config"`, lines[3])
+	assert.Equal(t, "agent.yaml:7: prefix is already set on line 1", lines[4])
+
+	assert.EqualError(t, settings.ApplyYAML("agent.yaml", []byte("- prefix")), "agent.yaml:1: expected a mapping of setting names to values")
+	assert.Error(t, settings.ApplyYAML("agent.yaml", []byte("prefix: [")))
+
+	err = settings.ApplyEnv(DefaultEnvPrefix, env(map[string]string{"FAILOVER_AGENT_INTERVAL": "often"}))
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), `environment variable FAILOVER_AGENT_INTERVAL: interval: invalid value "often": `), err.Error())
+
+	assert.EqualError(t, settings.Errorf("prefix", "is required"), "agent.yaml:1: prefix: is required")
+}
+
+type fakeSSM struct {
+	ssmiface.SSMAPI
+	parameters map[string]string
+}
+
+func (f *fakeSSM) GetParametersByPathPagesWithContext(ctx aws.Context, input *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool, opts ...request.Option) error {
+	output := &ssm.GetParametersByPathOutput{}
+	for name, value := range f.parameters {
+		output.Parameters = append(output.Parameters, &ssm.Parameter{Name: aws.String(name), Value: aws.String(value)})
+	}
+	fn(output, true)
+	return nil
+}
+
+func TestPrecedence(t *testing.T) {
+	f := newAgentFlags(t, "-min-peers", "5")
+	settings := New(f.flags, "config")
+
+	// Sources of a lower rank applied later do not override the ones of a higher rank
+	require.NoError(t, settings.ApplyEnv(DefaultEnvPrefix, env(map[string]string{
+		"FAILOVER_AGENT_MIN_PEERS": "4",
+		"FAILOVER_AGENT_INTERVAL":  "20s",
+	})))
+	client := &fakeSSM{parameters: map[string]string{
+		"/polkadot/validator-failover/test/agent/interval": "30s",
+		"/polkadot/validator-failover/test/agent/regions":  "eu-west-1",
+	}}
+	require.NoError(t, settings.ApplySSM(context.Background(), client, "/polkadot/validator-failover/test/agent"))
+	require.NoError(t, settings.ApplyYAML("agent.yaml", []byte("min-peers: 3\ninterval: 40s\nregions: us-east-1\nprefix: test\n")))
+
+	assert.Equal(t, 5, *f.minPeers)
+	assert.Equal(t, "flag -min-peers", settings.Origin("min-peers"))
+	assert.Equal(t, 20*time.Second, *f.interval)
+	assert.Equal(t, "environment variable FAILOVER_AGENT_INTERVAL", settings.Origin("interval"))
+	assert.Equal(t, "eu-west-1", *f.regions)
+	assert.Equal(t, "SSM parameter /polkadot/validator-failover/test/agent/regions", settings.Origin("regions"))
+	assert.Equal(t, "test", *f.prefix)
+}
+
+func TestSchema(t *testing.T) {
+	f := newAgentFlags(t)
+	content, err := Schema(f.flags, "config")
+	require.NoError(t, err)
+
+	var schema struct {
+		AdditionalProperties bool                              `json:"additionalProperties"`
+		Properties           map[string]map[string]interface{} `json:"properties"`
+	}
+	require.NoError(t, json.Unmarshal(content, &schema))
+	assert.False(t, schema.AdditionalProperties)
+	assert.NotContains(t, schema.Properties, "config")
+	assert.Equal(t, map[string]interface{}{"type": "string", "description": "Prefix of the deployment"}, schema.Properties["prefix"])
+	assert.Equal(t, "boolean", schema.Properties["election"]["type"])
+	assert.NotContains(t, schema.Properties["election"], "default")
+	assert.Equal(t, "integer", schema.Properties["min-peers"]["type"])
+	assert.Equal(t, float64(2), schema.Properties["min-peers"]["default"])
+	assert.Equal(t, "15s", schema.Properties["interval"]["default"])
+	assert.Equal(t, "us-east-1,us-east-2,eu-west-1", schema.Properties["regions"]["default"])
+}
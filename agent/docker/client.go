@@ -0,0 +1,301 @@
+// Package docker talks to the local Docker Engine API over its unix socket. Only the calls the agent needs are implemented.
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultSocket is the path of the Docker Engine API socket
+const DefaultSocket = "/var/run/docker.sock"
+
+// Client calls the Docker Engine API
+type Client struct {
+	HTTP *http.Client
+	// Host is only used to build request URLs, the connection always goes through the socket
+	Host string
+}
+
+// NewClient creates a client connected to the unix socket at path
+func NewClient(path string) *Client {
+	return &Client{
+		HTTP: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+		Host: "http://docker",
+	}
+}
+
+// ContainerState is the state part of the container inspect response
+type ContainerState struct {
+	Status     string `json:"Status"`
+	Running    bool   `json:"Running"`
+	Restarting bool   `json:"Restarting"`
+	ExitCode   int    `json:"ExitCode"`
+}
+
+// PortBinding is a port of the host a container port is published on
+type PortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// RestartPolicy is the restart policy of a container
+type RestartPolicy struct {
+	Name string `json:"Name"`
+}
+
+// HostConfig is the part of the host configuration of a container the agent sets
+type HostConfig struct {
+	NanoCPUs      int64                    `json:"NanoCpus"`
+	Memory        int64                    `json:"Memory"`
+	Binds         []string                 `json:"Binds"`
+	PortBindings  map[string][]PortBinding `json:"PortBindings"`
+	RestartPolicy RestartPolicy            `json:"RestartPolicy"`
+}
+
+// ContainerConfig is the part of the configuration of a container the agent sets
+type ContainerConfig struct {
+	Image        string              `json:"Image"`
+	Cmd          []string            `json:"Cmd"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+}
+
+// Container is the part of the container inspect response the agent needs
+type Container struct {
+	ID         string          `json:"Id"`
+	Name       string          `json:"Name"`
+	Image      string          `json:"Image"`
+	State      ContainerState  `json:"State"`
+	Config     ContainerConfig `json:"Config"`
+	HostConfig HostConfig      `json:"HostConfig"`
+}
+
+// Image is the part of the image inspect response the agent needs
+type Image struct {
+	ID          string   `json:"Id"`
+	RepoDigests []string `json:"RepoDigests"`
+}
+
+// ErrNotFound is returned when the container does not exist
+var ErrNotFound = errors.New("container not found")
+
+// ErrImageNotFound is returned when the image is not present locally
+var ErrImageNotFound = errors.New("image not found")
+
+// do sends a request with an optional JSON body
+func (c *Client) do(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	return c.send(ctx, c.HTTP, method, path, body)
+}
+
+// doLong sends a request that may take longer than the timeout of the client, e.g. a pull, bounded by the context only
+func (c *Client) doLong(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	client := *c.HTTP
+	client.Timeout = 0
+	return c.send(ctx, &client, method, path, body)
+}
+
+func (c *Client) send(ctx context.Context, client *http.Client, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, c.Host+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return client.Do(req.WithContext(ctx))
+}
+
+// statusError returns the error message of a failed call
+func statusError(call string, resp *http.Response) error {
+	var message struct {
+		Message string `json:"message"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&message) == nil && message.Message != "" {
+		return fmt.Errorf("%s: %s", call, message.Message)
+	}
+	return fmt.Errorf("%s: unexpected HTTP status %s", call, resp.Status)
+}
+
+// Inspect returns the container with the given name or ID
+func (c *Client) Inspect(ctx context.Context, name string) (Container, error) {
+	var container Container
+
+	req, err := http.NewRequest(http.MethodGet, c.Host+"/containers/"+url.PathEscape(name)+"/json", nil)
+	if err != nil {
+		return container, err
+	}
+	resp, err := c.HTTP.Do(req.WithContext(ctx))
+	if err != nil {
+		return container, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		err = json.NewDecoder(resp.Body).Decode(&container)
+		return container, err
+	case http.StatusNotFound:
+		return container, ErrNotFound
+	default:
+		return container, fmt.Errorf("inspect %s: unexpected HTTP status %s", name, resp.Status)
+	}
+}
+
+// Running reports whether the container exists and is running
+func (c *Client) Running(ctx context.Context, name string) (bool, error) {
+	container, err := c.Inspect(ctx, name)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return container.State.Running, nil
+}
+
+// Create creates a container with the given name and returns its ID
+func (c *Client) Create(ctx context.Context, name string, config ContainerConfig, host HostConfig) (string, error) {
+	body := struct {
+		ContainerConfig
+		HostConfig HostConfig `json:"HostConfig"`
+	}{config, host}
+	resp, err := c.do(ctx, http.MethodPost, "/containers/create?name="+url.QueryEscape(name), body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", statusError("create "+name, resp)
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	return created.ID, err
+}
+
+// Start starts the container. Starting a running container is not an error
+func (c *Client) Start(ctx context.Context, name string) error {
+	resp, err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/start", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusNotModified:
+		return nil
+	case http.StatusNotFound:
+		return ErrNotFound
+	default:
+		return statusError("start "+name, resp)
+	}
+}
+
+// Stop stops the container, killing it after timeout. Stopping a stopped or missing container is not an error
+func (c *Client) Stop(ctx context.Context, name string, timeout time.Duration) error {
+	path := "/containers/" + url.PathEscape(name) + "/stop?t=" + strconv.Itoa(int(timeout.Seconds()))
+	resp, err := c.doLong(ctx, http.MethodPost, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusNotFound:
+		return nil
+	default:
+		return statusError("stop "+name, resp)
+	}
+}
+
+// Remove removes the container, killing it if it still runs. Removing a missing container is not an error
+func (c *Client) Remove(ctx context.Context, name string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/containers/"+url.PathEscape(name)+"?force=true", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return statusError("remove "+name, resp)
+	}
+}
+
+// InspectImage returns the local image with the given reference, a name with a tag or a digest
+func (c *Client) InspectImage(ctx context.Context, reference string) (Image, error) {
+	var image Image
+
+	resp, err := c.do(ctx, http.MethodGet, "/images/"+reference+"/json", nil)
+	if err != nil {
+		return image, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		err = json.NewDecoder(resp.Body).Decode(&image)
+		return image, err
+	case http.StatusNotFound:
+		return image, ErrImageNotFound
+	default:
+		return image, statusError("inspect image "+reference, resp)
+	}
+}
+
+// Pull pulls the image with the given reference. The progress stream is read to the end, as the pull is only complete then
+func (c *Client) Pull(ctx context.Context, reference string) error {
+	resp, err := c.doLong(ctx, http.MethodPost, "/images/create?fromImage="+url.QueryEscape(reference), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError("pull "+reference, resp)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var progress struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&progress); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("pull %s: %w", reference, err)
+		}
+		if progress.Error != "" {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			return fmt.Errorf("pull %s: %s", reference, progress.Error)
+		}
+	}
+}
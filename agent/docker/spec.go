@@ -0,0 +1,216 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Mode is the role the Polkadot node runs in
+type Mode string
+
+// Modes of the node
+const (
+	ModeFull      Mode = "full"
+	ModeValidator Mode = "validator"
+)
+
+// Spec declares the Polkadot container the way the bootstrap script ran it with `docker run`, for both modes
+type Spec struct {
+	// Name is the name of the container
+	Name string
+	// Image is the image reference, a tag or preferably a digest, e.g. chevdor/polkadot@sha256:...
+	Image string
+	Chain string
+	// CPUs and MemoryGB are the limits of the container, the cpu_limit and ram_limit parameters
+	CPUs     float64
+	MemoryGB int64
+	// DataDir is the directory of the host mounted as /data
+	DataDir string
+	// P2PPort is published on every interface, RPCPort on the loopback interface only
+	P2PPort int
+	RPCPort int
+	// NodeName and NodeKey are only passed to the validator
+	NodeName string
+	NodeKey  string
+}
+
+// Config returns the configuration of the container in the mode. The full node keeps the whole history, the validator exposes
+// the unsafe RPC methods to the local agent to insert the session keys
+func (s Spec) Config(mode Mode) (ContainerConfig, HostConfig) {
+	cmd := []string{"polkadot", "--chain", s.Chain}
+	if mode == ModeValidator {
+		cmd = append(cmd, "--unsafe-rpc-external", "--rpc-cors=all", "--validator", "--name", s.NodeName, "--node-key", s.NodeKey)
+	} else {
+		cmd = append(cmd, "--rpc-external", "--rpc-cors=all", "--pruning=archive")
+	}
+
+	p2p, rpc := strconv.Itoa(s.P2PPort)+"/tcp", strconv.Itoa(s.RPCPort)+"/tcp"
+	config := ContainerConfig{
+		Image:        s.Image,
+		Cmd:          cmd,
+		ExposedPorts: map[string]struct{}{p2p: {}, rpc: {}},
+	}
+	host := HostConfig{
+		NanoCPUs: int64(s.CPUs * 1e9),
+		Memory:   s.MemoryGB << 30,
+		Binds:    []string{s.DataDir + ":/data"},
+		PortBindings: map[string][]PortBinding{
+			p2p: {{HostPort: strconv.Itoa(s.P2PPort)}},
+			rpc: {{HostIP: "127.0.0.1", HostPort: strconv.Itoa(s.RPCPort)}},
+		},
+		RestartPolicy: RestartPolicy{Name: "unless-stopped"},
+	}
+	return config, host
+}
+
+// Differences lists how the container differs from the configuration, imageID being the ID of the image of the spec. The command is
+// not printed, as it holds the node key
+func Differences(container Container, config ContainerConfig, host HostConfig, imageID string) []string {
+	var differences []string
+	if container.Image != imageID {
+		differences = append(differences, fmt.Sprintf("image is %s instead of %s (%s)", container.Image, imageID, config.Image))
+	}
+	if !reflect.DeepEqual(container.Config.Cmd, config.Cmd) {
+		differences = append(differences, "command differs")
+	}
+	if container.HostConfig.NanoCPUs != host.NanoCPUs {
+		differences = append(differences, fmt.Sprintf("CPU limit is %d instead of %d nano CPUs", container.HostConfig.NanoCPUs, host.NanoCPUs))
+	}
+	if container.HostConfig.Memory != host.Memory {
+		differences = append(differences, fmt.Sprintf("memory limit is %d instead of %d bytes", container.HostConfig.Memory, host.Memory))
+	}
+	if !reflect.DeepEqual(container.HostConfig.Binds, host.Binds) {
+		differences = append(differences, fmt.Sprintf("volumes are %v instead of %v", container.HostConfig.Binds, host.Binds))
+	}
+	if !reflect.DeepEqual(container.HostConfig.PortBindings, host.PortBindings) {
+		differences = append(differences, fmt.Sprintf("ports are %v instead of %v", container.HostConfig.PortBindings, host.PortBindings))
+	}
+	if container.HostConfig.RestartPolicy != host.RestartPolicy {
+		differences = append(differences, fmt.Sprintf("restart policy is %q instead of %q", container.HostConfig.RestartPolicy.Name, host.RestartPolicy.Name))
+	}
+	return differences
+}
+
+// Controller keeps the Polkadot container matching the spec in the current mode. It replaces the `docker stop`, `docker rm` and
+// `docker run` calls of the bootstrap script
+type Controller struct {
+	Client *Client
+	Spec   Spec
+	// StopTimeout is the time the node gets to stop before it is killed
+	StopTimeout time.Duration
+	// Interval is the delay between two checks of Run
+	Interval time.Duration
+	Logf     func(format string, args ...interface{})
+
+	mu   sync.Mutex
+	mode Mode
+}
+
+func (c *Controller) logf(format string, args ...interface{}) {
+	if c.Logf != nil {
+		c.Logf(format, args...)
+	}
+}
+
+// Mode returns the mode the container is kept in, ModeFull until Validator is called
+func (c *Controller) Mode() Mode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current()
+}
+
+func (c *Controller) current() Mode {
+	if c.mode == "" {
+		return ModeFull
+	}
+	return c.mode
+}
+
+// Validator restarts the node as a validator
+func (c *Controller) Validator(ctx context.Context) error {
+	return c.Ensure(ctx, ModeValidator)
+}
+
+// Full restarts the node as a full node
+func (c *Controller) Full(ctx context.Context) error {
+	return c.Ensure(ctx, ModeFull)
+}
+
+// Ensure makes the container match the spec in the mode and keeps the mode for Run. A container that differs from the spec is
+// recreated, a stopped one is started, one that matches and runs is left alone
+func (c *Controller) Ensure(ctx context.Context, mode Mode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mode = mode
+	return c.ensure(ctx, mode)
+}
+
+// ensure makes the container match the spec in the mode. It is called with the mutex held, so the mode can not change meanwhile
+func (c *Controller) ensure(ctx context.Context, mode Mode) error {
+	image, err := c.Client.InspectImage(ctx, c.Spec.Image)
+	if err == ErrImageNotFound {
+		c.logf("INFO. Pulling %s", c.Spec.Image)
+		if err := c.Client.Pull(ctx, c.Spec.Image); err != nil {
+			return err
+		}
+		image, err = c.Client.InspectImage(ctx, c.Spec.Image)
+	}
+	if err != nil {
+		return err
+	}
+
+	config, host := c.Spec.Config(mode)
+	container, err := c.Client.Inspect(ctx, c.Spec.Name)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if err == nil {
+		differences := Differences(container, config, host, image.ID)
+		if len(differences) == 0 {
+			if container.State.Running {
+				return nil
+			}
+			c.logf("INFO. Starting the %s container, it is %s", c.Spec.Name, container.State.Status)
+			return c.Client.Start(ctx, c.Spec.Name)
+		}
+		c.logf("INFO. Recreating the %s container as a %s node: %v", c.Spec.Name, mode, differences)
+		if err := c.Client.Stop(ctx, c.Spec.Name, c.StopTimeout); err != nil {
+			return err
+		}
+		if err := c.Client.Remove(ctx, c.Spec.Name); err != nil {
+			return err
+		}
+	} else {
+		c.logf("INFO. Creating the %s container as a %s node", c.Spec.Name, mode)
+	}
+
+	if _, err := c.Client.Create(ctx, c.Spec.Name, config, host); err != nil {
+		return err
+	}
+	return c.Client.Start(ctx, c.Spec.Name)
+}
+
+// Run checks the container every interval until the context is done, restarting it when it drifts from the spec
+func (c *Controller) Run(ctx context.Context) error {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	for {
+		c.mu.Lock()
+		err := c.ensure(ctx, c.current())
+		c.mu.Unlock()
+		if err != nil && ctx.Err() == nil {
+			c.logf("ERROR! Unable to check the %s container: %s", c.Spec.Name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
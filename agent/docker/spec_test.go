@@ -0,0 +1,222 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEngine serves the calls of the controller from memory and records them
+type fakeEngine struct {
+	mu         sync.Mutex
+	images     map[string]string
+	registry   map[string]string
+	containers map[string]*Container
+	calls      []string
+}
+
+func newFakeEngine() *fakeEngine {
+	return &fakeEngine{images: make(map[string]string), registry: make(map[string]string), containers: make(map[string]*Container)}
+}
+
+func (f *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/images/"):
+		id, ok := f.images[strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json")]
+		if !ok {
+			http.Error(w, `{"message": "no such image"}`, http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(Image{ID: id})
+
+	case r.Method == http.MethodPost && path == "/images/create":
+		reference := r.URL.Query().Get("fromImage")
+		f.calls = append(f.calls, "pull "+reference)
+		fmt.Fprintln(w, `{"status": "Pulling from chevdor/polkadot"}`)
+		if id, ok := f.registry[reference]; ok {
+			f.images[reference] = id
+			fmt.Fprintln(w, `{"status": "Downloaded newer image"}`)
+		} else {
+			fmt.Fprintln(w, `{"error": "manifest unknown"}`)
+		}
+
+	case r.Method == http.MethodPost && path == "/containers/create":
+		name := r.URL.Query().Get("name")
+		f.calls = append(f.calls, "create "+name)
+		var body struct {
+			ContainerConfig
+			HostConfig HostConfig
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := f.containers[name]; ok {
+			http.Error(w, `{"message": "Conflict. The container name is already in use"}`, http.StatusConflict)
+			return
+		}
+		f.containers[name] = &Container{ID: "c-" + name, Name: "/" + name, Image: f.images[body.Image], Config: body.ContainerConfig, HostConfig: body.HostConfig}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]string{"Id": "c-" + name})
+
+	case strings.HasPrefix(path, "/containers/"):
+		parts := strings.Split(strings.TrimPrefix(path, "/containers/"), "/")
+		container, ok := f.containers[parts[0]]
+		if !ok {
+			http.Error(w, `{"message": "No such container"}`, http.StatusNotFound)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet:
+			_ = json.NewEncoder(w).Encode(container)
+		case r.Method == http.MethodDelete:
+			f.calls = append(f.calls, "remove "+parts[0])
+			delete(f.containers, parts[0])
+			w.WriteHeader(http.StatusNoContent)
+		case parts[1] == "start":
+			f.calls = append(f.calls, "start "+parts[0])
+			container.State = ContainerState{Status: "running", Running: true}
+			w.WriteHeader(http.StatusNoContent)
+		case parts[1] == "stop":
+			f.calls = append(f.calls, "stop "+parts[0])
+			container.State = ContainerState{Status: "exited"}
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// takeCalls returns the calls recorded since the last call
+func (f *fakeEngine) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func (f *fakeEngine) container(name string) Container {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return *f.containers[name]
+}
+
+var spec = Spec{
+	Name:     "polkadot",
+	Image:    "chevdor/polkadot:latest",
+	Chain:    "kusama",
+	CPUs:     1.5,
+	MemoryGB: 4,
+	DataDir:  "/data",
+	P2PPort:  30333,
+	RPCPort:  9933,
+	NodeName: "validator",
+	NodeKey:  "0xnodekey",
+}
+
+func TestSpecMatchesDockerRun(t *testing.T) {
+	// docker run --cpus 1.5 --memory 4GB --restart unless-stopped -p 30333:30333 -p 127.0.0.1:9933:9933 -v /data:/data
+	config, host := spec.Config(ModeFull)
+	assert.Equal(t, []string{"polkadot", "--chain", "kusama", "--rpc-external", "--rpc-cors=all", "--pruning=archive"}, config.Cmd)
+	assert.Equal(t, int64(1500000000), host.NanoCPUs)
+	assert.Equal(t, int64(4*1024*1024*1024), host.Memory)
+	assert.Equal(t, []string{"/data:/data"}, host.Binds)
+	assert.Equal(t, map[string][]PortBinding{
+		"30333/tcp": {{HostPort: "30333"}},
+		"9933/tcp":  {{HostIP: "127.0.0.1", HostPort: "9933"}},
+	}, host.PortBindings)
+	assert.Equal(t, "unless-stopped", host.RestartPolicy.Name)
+
+	config, _ = spec.Config(ModeValidator)
+	assert.Equal(t, []string{"polkadot", "--chain", "kusama", "--unsafe-rpc-external", "--rpc-cors=all", "--validator", "--name", "validator", "--node-key", "0xnodekey"}, config.Cmd)
+}
+
+func TestController(t *testing.T) {
+	engine := newFakeEngine()
+	engine.registry[spec.Image] = "sha256:1"
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	var logs []string
+	controller := &Controller{
+		Client: &Client{HTTP: server.Client(), Host: server.URL},
+		Spec:   spec,
+		Logf:   func(format string, args ...interface{}) { logs = append(logs, fmt.Sprintf(format, args...)) },
+	}
+	ctx := context.Background()
+
+	// The image is pulled and the container created as a full node
+	require.NoError(t, controller.Full(ctx))
+	assert.Equal(t, []string{"pull chevdor/polkadot:latest", "create polkadot", "start polkadot"}, engine.takeCalls())
+
+	// A container matching the spec is left alone
+	require.NoError(t, controller.Full(ctx))
+	assert.Empty(t, engine.takeCalls())
+
+	// Switching the mode recreates the container
+	require.NoError(t, controller.Validator(ctx))
+	assert.Equal(t, []string{"stop polkadot", "remove polkadot", "create polkadot", "start polkadot"}, engine.takeCalls())
+	assert.Contains(t, engine.container("polkadot").Config.Cmd, "--validator")
+	assert.Equal(t, ModeValidator, controller.Mode())
+
+	// A stopped container is started again in the current mode
+	engine.mu.Lock()
+	engine.containers["polkadot"].State = ContainerState{Status: "exited", ExitCode: 137}
+	engine.mu.Unlock()
+	require.NoError(t, controller.Ensure(ctx, controller.Mode()))
+	assert.Equal(t, []string{"start polkadot"}, engine.takeCalls())
+
+	// A container that drifted from the spec, e.g. recreated by hand with another limit or image, is recreated
+	engine.mu.Lock()
+	engine.containers["polkadot"].HostConfig.Memory = 2 << 30
+	engine.containers["polkadot"].Image = "sha256:0"
+	engine.mu.Unlock()
+	require.NoError(t, controller.Ensure(ctx, controller.Mode()))
+	assert.Equal(t, []string{"stop polkadot", "remove polkadot", "create polkadot", "start polkadot"}, engine.takeCalls())
+	assert.Equal(t, "sha256:1", engine.container("polkadot").Image)
+	assert.Contains(t, engine.container("polkadot").Config.Cmd, "--validator")
+
+	for _, line := range logs {
+		assert.NotContains(t, line, spec.NodeKey)
+	}
+}
+
+func TestControllerAdoptsMatchingContainer(t *testing.T) {
+	engine := newFakeEngine()
+	engine.images[spec.Image] = "sha256:1"
+	config, host := spec.Config(ModeFull)
+	// The container the bootstrap script started with docker run. Exposed ports are not compared
+	config.ExposedPorts = nil
+	engine.containers["polkadot"] = &Container{ID: "c-polkadot", Image: "sha256:1", State: ContainerState{Running: true}, Config: config, HostConfig: host}
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	controller := &Controller{Client: &Client{HTTP: server.Client(), Host: server.URL}, Spec: spec}
+	require.NoError(t, controller.Full(context.Background()))
+	assert.Empty(t, engine.takeCalls())
+}
+
+func TestControllerPullFailure(t *testing.T) {
+	engine := newFakeEngine()
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	controller := &Controller{Client: &Client{HTTP: server.Client(), Host: server.URL}, Spec: spec}
+	err := controller.Full(context.Background())
+	assert.EqualError(t, err, "pull chevdor/polkadot:latest: manifest unknown")
+	assert.Equal(t, []string{"pull chevdor/polkadot:latest"}, engine.takeCalls())
+}
@@ -0,0 +1,319 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// DefaultLockKey is the key `consul lock prefix` used, so the lock stays visible to the existing tooling and tests
+const DefaultLockKey = "prefix/.lock"
+
+// DefaultCheckID is the ID of the TTL check the agent keeps passing while it runs
+const DefaultCheckID = "failover-agent"
+
+// ConsulLocker acquires the lock through a Consul session. The session is bound to the serf health of the node and,
+// if CheckID is set, to a TTL check kept alive by Heartbeat, so the lock is released when either the node or the agent dies.
+type ConsulLocker struct {
+	Client *api.Client
+	// Key is the lock key, DefaultLockKey if empty
+	Key string
+	// SessionTTL is the TTL of the session, "15s" if empty
+	SessionTTL string
+	// LockDelay is the time Consul refuses to grant the lock again after the session was invalidated
+	LockDelay time.Duration
+	// CheckID is the ID of the TTL check the session is bound to. No check other than serfHealth is used if empty
+	CheckID string
+
+	lock *api.Lock
+}
+
+// NewConsulLocker creates a locker using the Consul agent configured by the CONSUL_HTTP_ADDR environment variable, the local agent by default
+func NewConsulLocker() (*ConsulLocker, error) {
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &ConsulLocker{Client: client, Key: DefaultLockKey, SessionTTL: "15s", LockDelay: 15 * time.Second, CheckID: DefaultCheckID}, nil
+}
+
+// Lock implements Locker
+func (l *ConsulLocker) Lock(ctx context.Context) (<-chan struct{}, error) {
+	key := l.Key
+	if key == "" {
+		key = DefaultLockKey
+	}
+	ttl := l.SessionTTL
+	if ttl == "" {
+		ttl = "15s"
+	}
+
+	checks := []string{"serfHealth"}
+	if l.CheckID != "" {
+		checks = append(checks, l.CheckID)
+	}
+
+	lock, err := l.Client.LockOpts(&api.LockOptions{
+		Key: key,
+		SessionOpts: &api.SessionEntry{
+			Name:      "failover-agent",
+			TTL:       ttl,
+			LockDelay: l.LockDelay,
+			Behavior:  api.SessionBehaviorRelease,
+			Checks:    checks,
+		},
+		MonitorRetries: 3,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(stop)
+		case <-done:
+		}
+	}()
+
+	lost, err := lock.Lock(stop)
+	if err != nil {
+		return nil, err
+	}
+	if lost == nil {
+		return nil, ctx.Err()
+	}
+
+	l.lock = lock
+	return lost, nil
+}
+
+// Unlock implements Locker
+func (l *ConsulLocker) Unlock() error {
+	if l.lock == nil {
+		return nil
+	}
+	err := l.lock.Unlock()
+	l.lock = nil
+	if errors.Is(err, api.ErrLockNotHeld) {
+		return nil
+	}
+	return err
+}
+
+// RegisterCheck registers the TTL check the session is bound to. The check starts passing, so a lock can be acquired right away
+func (l *ConsulLocker) RegisterCheck(ttl time.Duration) error {
+	return l.Client.Agent().CheckRegister(&api.AgentCheckRegistration{
+		ID:   l.CheckID,
+		Name: "Failover agent",
+		AgentServiceCheck: api.AgentServiceCheck{
+			TTL:    ttl.String(),
+			Status: api.HealthPassing,
+		},
+	})
+}
+
+// Heartbeat keeps the TTL check passing until the context is done
+func (l *ConsulLocker) Heartbeat(ctx context.Context, interval time.Duration, logf func(format string, args ...interface{})) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := l.Client.Agent().UpdateTTL(l.CheckID, "agent is running", api.HealthPassing); err != nil && logf != nil {
+			logf("ERROR! Unable to update the %s check: %s", l.CheckID, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DefaultBestBlockKey is the key the validator publishes its last finalized block to
+const DefaultBestBlockKey = "best_block"
+
+// ErrValidatorActive is returned by the guard when the best block keeps changing, i.e. another node is still validating
+var ErrValidatorActive = errors.New("best block was updated by another validator")
+
+// ErrConcurrentUpdate is returned when best_block was modified between reading and writing it
+var ErrConcurrentUpdate = errors.New("best block was updated concurrently")
+
+// BestBlock reads and writes the last block finalized by the validator
+type BestBlock struct {
+	KV *api.KV
+	// Key is DefaultBestBlockKey if empty
+	Key string
+	// Finalized returns the last block finalized by the local node
+	Finalized func(ctx context.Context) (uint64, error)
+	// Interval is the delay between two checks of the guard and two updates while holding the lock
+	Interval time.Duration
+	Logf     func(format string, args ...interface{})
+
+	mu        sync.Mutex
+	published uint64
+}
+
+func (b *BestBlock) key() string {
+	if b.Key == "" {
+		return DefaultBestBlockKey
+	}
+	return b.Key
+}
+
+func (b *BestBlock) logf(format string, args ...interface{}) {
+	if b.Logf != nil {
+		b.Logf(format, args...)
+	}
+}
+
+// Get returns the best block published by the validator and whether it exists
+func (b *BestBlock) Get(ctx context.Context) (uint64, bool, error) {
+	pair, _, err := b.KV.Get(b.key(), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil || pair == nil {
+		return 0, false, err
+	}
+	var number uint64
+	if _, err := fmt.Sscan(string(pair.Value), &number); err != nil {
+		return 0, false, fmt.Errorf("%s has unexpected value %q", b.key(), pair.Value)
+	}
+	return number, true, nil
+}
+
+// Guard implements the double-signing control: it blocks until the local node has finalized a block past the best block of the previous
+// validator. It fails if the best block changes meanwhile, as it means the previous validator is still running.
+func (b *BestBlock) Guard(ctx context.Context) error {
+	best, ok, err := b.Get(ctx)
+	for err != nil {
+		b.logf("ERROR! Unable to read %s: %s", b.key(), err)
+		if !sleep(ctx, b.Interval) {
+			return ctx.Err()
+		}
+		best, ok, err = b.Get(ctx)
+	}
+	if !ok {
+		b.logf("INFO. No best block published yet, nobody has validated before")
+		return nil
+	}
+
+	for {
+		current, _, err := b.Get(ctx)
+		if err != nil {
+			b.logf("ERROR! Unable to read %s: %s", b.key(), err)
+		} else if current != best {
+			return fmt.Errorf("%w: %d -> %d", ErrValidatorActive, best, current)
+		} else {
+			validated, err := b.Finalized(ctx)
+			if err != nil {
+				b.logf("ERROR! Unable to get the finalized block: %s", err)
+			} else if validated > best {
+				b.logf("INFO. Finalized block %d is past the best block %d of the previous validator", validated, best)
+				return nil
+			} else {
+				b.logf("INFO. Previous validator best block - %d, finalized block - %d", best, validated)
+			}
+		}
+		if !sleep(ctx, b.Interval) {
+			return ctx.Err()
+		}
+	}
+}
+
+// Publish puts the finalized block of the local node to Consul every interval until the context is done
+func (b *BestBlock) Publish(ctx context.Context) error {
+	for {
+		number, err := b.Finalized(ctx)
+		if err != nil {
+			b.logf("ERROR! Unable to get the finalized block: %s", err)
+		} else if number > 0 {
+			if err := b.publish(ctx, number); err != nil && ctx.Err() == nil {
+				b.logf("ERROR! Unable to publish best block: %s", err)
+			}
+		}
+		if !sleep(ctx, b.Interval) {
+			return nil
+		}
+	}
+}
+
+// Final publishes the finalized block of the node once more after the validator stopped and returns it. It retries until the
+// restarted node answers and never lowers best_block.
+func (b *BestBlock) Final(ctx context.Context) (uint64, error) {
+	for {
+		number, err := b.Finalized(ctx)
+		if err == nil && number > 0 {
+			if err := b.publish(ctx, number); err != nil {
+				return 0, err
+			}
+			current, _, err := b.Get(ctx)
+			if err != nil {
+				return 0, err
+			}
+			return current, nil
+		}
+		if err != nil {
+			b.logf("INFO. Waiting for the node to restart: %s", err)
+		}
+		if !sleep(ctx, b.Interval) {
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Published returns the last block the local node put to Consul, zero if none
+func (b *BestBlock) Published() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.published
+}
+
+// publish writes the number with check-and-set against the value it read, so a concurrent writer is detected instead of being
+// overwritten. best_block is never lowered.
+func (b *BestBlock) publish(ctx context.Context, number uint64) error {
+	pair, _, err := b.KV.Get(b.key(), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	// A zero ModifyIndex only creates the key if it does not exist yet
+	var index uint64
+	if pair != nil {
+		index = pair.ModifyIndex
+		var current uint64
+		if _, err := fmt.Sscan(string(pair.Value), &current); err == nil && current >= number {
+			return nil
+		}
+	}
+
+	written, _, err := b.KV.CAS(&api.KVPair{Key: b.key(), Value: []byte(fmt.Sprint(number)), ModifyIndex: index}, (&api.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if !written {
+		return ErrConcurrentUpdate
+	}
+
+	b.mu.Lock()
+	b.published = number
+	b.mu.Unlock()
+	return nil
+}
+
+// sleep waits for the interval and reports false if the context was done before
+func sleep(ctx context.Context, interval time.Duration) bool {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(interval):
+		return true
+	}
+}
@@ -0,0 +1,351 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The tests in this file run against a real Consul agent and are skipped unless CONSUL_HTTP_ADDR is set, e.g.:
+//
+//	consul agent -dev &
+//	CONSUL_HTTP_ADDR=127.0.0.1:8500 go test ./agent/election/
+func consulLocker(t *testing.T, key string) *ConsulLocker {
+	if os.Getenv("CONSUL_HTTP_ADDR") == "" {
+		t.Skip("CONSUL_HTTP_ADDR is not set, skipping test against a Consul agent")
+	}
+	locker, err := NewConsulLocker()
+	require.NoError(t, err)
+	locker.Key = key
+	locker.LockDelay = time.Millisecond
+	locker.CheckID = ""
+	return locker
+}
+
+func TestConsulSingleHolder(t *testing.T) {
+	key := "failover-test-" + strconv.FormatInt(time.Now().UnixNano(), 10) + "/.lock"
+	first, second := consulLocker(t, key), consulLocker(t, key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := first.Lock(ctx)
+	require.NoError(t, err)
+
+	// The second node keeps waiting while the first one holds the lock
+	waitCtx, waitCancel := context.WithTimeout(ctx, 2*time.Second)
+	_, err = second.Lock(waitCtx)
+	waitCancel()
+	assert.Error(t, err)
+
+	pair, _, err := first.Client.KV().Get(key, nil)
+	require.NoError(t, err)
+	require.NotNil(t, pair)
+	assert.NotEmpty(t, pair.Session)
+
+	// Releasing the lock lets the second node in
+	require.NoError(t, first.Unlock())
+	_, err = second.Lock(ctx)
+	require.NoError(t, err)
+	require.NoError(t, second.Unlock())
+}
+
+func TestConsulElectorsTakeOver(t *testing.T) {
+	key := "failover-test-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	first, second := consulLocker(t, key+"/.lock"), consulLocker(t, key+"/.lock")
+
+	bestBlock := &BestBlock{KV: first.Client.KV(), Key: key + "/best_block", Interval: 100 * time.Millisecond}
+	block := uint64(100)
+	bestBlock.Finalized = func(ctx context.Context) (uint64, error) { return atomic.LoadUint64(&block), nil }
+
+	elector := func(locker Locker) *Elector {
+		return &Elector{Locker: locker, Guard: bestBlock.Guard, Hold: bestBlock.Publish, RetryDelay: 100 * time.Millisecond}
+	}
+	firstElector, secondElector := elector(first), elector(second)
+
+	firstCtx, firstCancel := context.WithCancel(context.Background())
+	firstDone := make(chan error)
+	go func() { firstDone <- firstElector.Run(firstCtx) }()
+	waitFor(t, firstElector, StateHolding)
+
+	secondCtx, secondCancel := context.WithCancel(context.Background())
+	defer secondCancel()
+	go func() { _ = secondElector.Run(secondCtx) }()
+	time.Sleep(time.Second)
+	assert.Equal(t, StateAcquiring, secondElector.State())
+
+	// The first node steps down, the second one waits for a finalized block past the published one and takes over
+	firstCancel()
+	require.NoError(t, <-firstDone)
+	time.Sleep(time.Second)
+	assert.Equal(t, StateGuarding, secondElector.State())
+
+	atomic.StoreUint64(&block, 101)
+	waitFor(t, secondElector, StateHolding)
+
+	secondCancel()
+	_, err := first.Client.KV().DeleteTree(key, nil)
+	require.NoError(t, err)
+}
+
+func TestConsulHandoff(t *testing.T) {
+	locker := consulLocker(t, "unused")
+	key := "failover-test-" + strconv.FormatInt(time.Now().UnixNano(), 10) + "/handoff"
+	handoff := func(node string) *Handoff {
+		return &Handoff{KV: locker.Client.KV(), Key: key, Node: node, Interval: 10 * time.Millisecond}
+	}
+	validator, chosen, other := handoff("validator"), handoff("chosen"), handoff("other")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	watched := make(chan error)
+	go func() { watched <- validator.Watch(ctx) }()
+
+	value, err := json.Marshal(HandoffRequest{From: "validator", To: "chosen", RequestedAt: time.Now()})
+	require.NoError(t, err)
+	_, err = locker.Client.KV().Put(&api.KVPair{Key: key, Value: value}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, ErrSteppedDown, <-watched)
+
+	require.NoError(t, validator.Complete(ctx, 42))
+	request, _, err := chosen.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), request.FinalBlock)
+
+	// Only the chosen standby contends for the lock while the handoff is in progress
+	require.NoError(t, chosen.WaitTurn(ctx))
+	for _, h := range []*Handoff{validator, other} {
+		waitCtx, waitCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		assert.Error(t, h.WaitTurn(waitCtx))
+		waitCancel()
+	}
+
+	require.NoError(t, chosen.Done(ctx))
+	request, _, err = chosen.Get(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, request)
+	require.NoError(t, other.WaitTurn(ctx))
+}
+
+func TestConsulHandoffExpiry(t *testing.T) {
+	locker := consulLocker(t, "unused")
+	key := "failover-test-" + strconv.FormatInt(time.Now().UnixNano(), 10) + "/handoff"
+	validator := &Handoff{KV: locker.Client.KV(), Key: key, Node: "validator", Interval: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	expire := func() {
+		request, _, err := validator.Get(ctx)
+		require.NoError(t, err)
+		request.RequestedAt = time.Now().Add(-2 * DefaultHandoffTimeout)
+		value, err := json.Marshal(request)
+		require.NoError(t, err)
+		_, err = locker.Client.KV().Put(&api.KVPair{Key: key, Value: value}, nil)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, validator.Request(ctx, "chosen"))
+	assert.True(t, errors.Is(validator.Request(ctx, "other"), ErrHandoffPending))
+
+	// The request of the validator itself is kept until it expires
+	require.NoError(t, validator.Done(ctx))
+	request, _, err := validator.Get(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, request)
+
+	// The chosen node never took over and the validator acquired the lock again: the expired request is removed
+	expire()
+	require.NoError(t, validator.Done(ctx))
+	request, _, err = validator.Get(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, request)
+
+	// A second request is written, and replaces the first one once that expired
+	require.NoError(t, validator.Request(ctx, "chosen"))
+	expire()
+	require.NoError(t, validator.Request(ctx, "other"))
+	request, _, err = validator.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "other", request.To)
+	assert.False(t, request.Expired(time.Now()))
+
+	_, err = locker.Client.KV().Delete(key, nil)
+	require.NoError(t, err)
+}
+
+func TestConsulFreeze(t *testing.T) {
+	locker := consulLocker(t, "unused")
+	key := "failover-test-" + strconv.FormatInt(time.Now().UnixNano(), 10) + "/freeze"
+	freeze := &Freeze{KV: locker.Client.KV(), Key: key, Interval: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var requested []string
+	request := freeze.Unfrozen(func(ctx context.Context, to string) error {
+		requested = append(requested, to)
+		return nil
+	})
+	require.NoError(t, freeze.Wait(ctx))
+
+	value, err := json.Marshal(FreezeFlag{By: "operator", Reason: "incident", FrozenAt: time.Now()})
+	require.NoError(t, err)
+	_, err = locker.Client.KV().Put(&api.KVPair{Key: key, Value: value}, nil)
+	require.NoError(t, err)
+
+	// No node contends for the lock and the validator does not hand over while the elections are frozen
+	waitCtx, waitCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	assert.Error(t, freeze.Wait(waitCtx))
+	waitCancel()
+	assert.Equal(t, ErrFrozen, request(ctx, "other"))
+
+	waited := make(chan error)
+	go func() { waited <- freeze.Wait(ctx) }()
+	_, err = locker.Client.KV().Delete(key, nil)
+	require.NoError(t, err)
+	require.NoError(t, <-waited)
+	require.NoError(t, request(ctx, "other"))
+	assert.Equal(t, []string{"other"}, requested)
+}
+
+func TestConsulBestBlockCheckAndSet(t *testing.T) {
+	locker := consulLocker(t, "unused")
+	key := "failover-test-" + strconv.FormatInt(time.Now().UnixNano(), 10) + "/best_block"
+	ctx := context.Background()
+	bestBlock := &BestBlock{KV: locker.Client.KV(), Key: key}
+
+	require.NoError(t, bestBlock.publish(ctx, 100))
+	require.NoError(t, bestBlock.publish(ctx, 90))
+	number, ok, err := bestBlock.Get(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(100), number, "best block must never be lowered")
+	assert.Equal(t, uint64(100), bestBlock.Published())
+
+	// Values written by others are respected
+	_, err = locker.Client.KV().Put(&api.KVPair{Key: key, Value: []byte("150")}, nil)
+	require.NoError(t, err)
+	require.NoError(t, bestBlock.publish(ctx, 120))
+	number, _, err = bestBlock.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(150), number)
+
+	require.NoError(t, bestBlock.publish(ctx, 160))
+	number, _, err = bestBlock.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(160), number)
+}
+
+func TestConsulEpochFencing(t *testing.T) {
+	locker := consulLocker(t, "unused")
+	key := "failover-test-" + strconv.FormatInt(time.Now().UnixNano(), 10) + "/epoch"
+	dir, err := ioutil.TempDir("", "epoch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	first := &Epoch{KV: locker.Client.KV(), Key: key, Node: "first", File: filepath.Join(dir, "first")}
+	second := &Epoch{KV: locker.Client.KV(), Key: key, Node: "second", File: filepath.Join(dir, "second")}
+
+	require.NoError(t, first.Advance(ctx))
+	assert.Equal(t, uint64(1), first.Held())
+	recorded, err := first.Recorded()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), recorded)
+	require.NoError(t, first.Check(ctx))
+
+	watched := make(chan error)
+	go func() { watched <- first.Watch(ctx) }()
+
+	// The promotion of another node fences the first one, both while it runs and when it tries to restart the validator
+	require.NoError(t, second.Advance(ctx))
+	assert.Equal(t, uint64(2), second.Held())
+	assert.True(t, errors.Is(<-watched, ErrFenced))
+	assert.True(t, errors.Is(first.Check(ctx), ErrFenced))
+
+	current, _, err := second.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "second", current.Node)
+}
+
+func TestConsulProtectionRecord(t *testing.T) {
+	locker := consulLocker(t, "unused")
+	key := "failover-test-" + strconv.FormatInt(time.Now().UnixNano(), 10) + "/protection"
+	dir, err := ioutil.TempDir("", "protection")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	height := func(value *uint64) func(ctx context.Context) (uint64, error) {
+		return func(ctx context.Context) (uint64, error) { return atomic.LoadUint64(value), nil }
+	}
+	validatorFinalized, standbyFinalized := uint64(100), uint64(50)
+	validatorBest := func(ctx context.Context) (uint64, error) { return atomic.LoadUint64(&validatorFinalized) + 2, nil }
+	validator := &Protection{KV: locker.Client.KV(), Key: key, File: filepath.Join(dir, "validator"), Node: "validator",
+		Finalized: height(&validatorFinalized), Best: validatorBest, Epoch: func() uint64 { return 3 }, Interval: 10 * time.Millisecond}
+	standby := &Protection{KV: locker.Client.KV(), Key: key, File: filepath.Join(dir, "standby"), Node: "standby",
+		Finalized: height(&standbyFinalized), Interval: 10 * time.Millisecond}
+
+	// The validator records its heights to its volume and to Consul
+	trackCtx, stopTracking := context.WithCancel(ctx)
+	tracked := make(chan error)
+	go func() { tracked <- validator.Track(trackCtx) }()
+	assert.Eventually(t, func() bool {
+		record, err := validator.Replicated(ctx)
+		return err == nil && record != nil && record.Best == 102
+	}, 10*time.Second, 10*time.Millisecond)
+	local, err := validator.Local()
+	require.NoError(t, err)
+	assert.Equal(t, ProtectionRecord{Node: "validator", Epoch: 3, Finalized: 100, Best: 102, UpdatedAt: local.UpdatedAt}, *local)
+
+	// A standby behind the record refuses to validate, and gives up once the validator turns out to be still validating
+	guarded := make(chan error)
+	go func() { guarded <- standby.Guard(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+	atomic.StoreUint64(&validatorFinalized, 110)
+	assert.True(t, errors.Is(<-guarded, ErrRecordAdvanced))
+	stopTracking()
+	require.NoError(t, <-tracked)
+
+	// Consul lost the record, the validator puts back the one of its volume
+	_, err = locker.Client.KV().Delete(key, nil)
+	require.NoError(t, err)
+	require.NoError(t, validator.Restore(ctx))
+	record, err := standby.Replicated(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(112), record.Best)
+
+	// The standby validates once it finalized a block past the record
+	go func() { guarded <- standby.Guard(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-guarded:
+		t.Fatalf("guard passed below the record: %v", err)
+	default:
+	}
+	atomic.StoreUint64(&standbyFinalized, 113)
+	require.NoError(t, <-guarded)
+
+	// A lower record never replaces a higher one
+	require.NoError(t, standby.replicate(ctx, ProtectionRecord{Node: "standby", Finalized: 105, Best: 106}))
+	record, err = standby.Replicated(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "validator", record.Node)
+}
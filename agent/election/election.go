@@ -0,0 +1,293 @@
+// Package election implements the validator election that used to be a single `consul lock prefix "..."` command in the bootstrap script.
+//
+// Every node runs an Elector. The node that acquires the lock goes through the same stages the chained shell commands did:
+// the double-signing guard, key insertion, restarting the node as a validator and holding the lock while publishing the best block.
+// Losing the lock, failing a stage or being asked to stop makes the node step down, i.e. stop validating and release the lock.
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// State is a stage of the election lifecycle of a single node
+type State string
+
+// States of the lifecycle in the order a successful term goes through them
+const (
+	StateAcquiring         State = "acquiring"
+	StateGuarding          State = "guarding"
+	StateInsertingKeys     State = "inserting-keys"
+	StateStartingValidator State = "starting-validator"
+	StateHolding           State = "holding"
+	StateSteppingDown      State = "stepping-down"
+	StateStopped           State = "stopped"
+)
+
+// DefaultMaxAttempts is the number of terms a node may go through before giving up, the same as the retry loop of the bootstrap script
+const DefaultMaxAttempts = 6
+
+// Locker acquires the cluster-wide validator lock
+type Locker interface {
+	// Lock blocks until the lock is acquired or the context is done. The returned channel is closed once the lock is lost
+	Lock(ctx context.Context) (<-chan struct{}, error)
+	// Unlock releases the lock, so other nodes can acquire it without waiting for the session to expire
+	Unlock() error
+}
+
+// Hook performs the side effect of a single stage. Nil hooks are skipped
+type Hook func(ctx context.Context) error
+
+// ErrAttemptsExhausted is returned when the node went through MaxAttempts terms without being stopped
+var ErrAttemptsExhausted = errors.New("election attempts exhausted")
+
+// GuardError is returned when the double-signing guard found another validator running, i.e. the guard failed with ErrValidatorActive
+// or ErrRecordAdvanced. The node must never retry the election after it, someone else may still be validating with the same keys.
+// Any other error of the guard stage, e.g. Consul not answering, only ends the term.
+type GuardError struct {
+	Err error
+}
+
+func (e *GuardError) Error() string {
+	return "double-signing guard failed: " + e.Err.Error()
+}
+
+func (e *GuardError) Unwrap() error {
+	return e.Err
+}
+
+// Elector drives a node through the election lifecycle
+type Elector struct {
+	Locker Locker
+
+	// BeforeAcquire blocks until the node may contend for the lock, e.g. while a handoff to another node is in progress
+	BeforeAcquire Hook
+	// Guard blocks until it is safe to start validating, e.g. until the node has finalized the last block the previous validator published
+	Guard Hook
+	// InsertKeys puts the session keys into the keystore of the node
+	InsertKeys Hook
+	// StartValidator restarts the node with the --validator flag
+	StartValidator Hook
+	// Hold runs for as long as the lock is held, e.g. publishes the best block. Returning an error makes the node step down
+	Hold Hook
+	// StopValidator restarts the node as a regular full node. It is called on step down if StartValidator was called in the term
+	StopValidator Hook
+	// OnStepDown runs after StopValidator and before the lock is released when the hold stage returned ErrSteppedDown,
+	// e.g. to publish the final block of the validator
+	OnStepDown Hook
+
+	// MaxAttempts is the number of terms, failed lock attempts included, before Run gives up. DefaultMaxAttempts is used if zero
+	MaxAttempts int
+	// RetryDelay is the pause between two terms
+	RetryDelay time.Duration
+	// StepDownTimeout bounds the time spent stopping the validator after the context was cancelled
+	StepDownTimeout time.Duration
+	// OnTransition is called on every change of the state
+	OnTransition func(from State, to State)
+	// Logf receives progress messages. Can be nil
+	Logf func(format string, args ...interface{})
+
+	mu    sync.Mutex
+	state State
+}
+
+// State returns the current state of the node
+func (e *Elector) State() State {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.state
+}
+
+func (e *Elector) transition(to State) {
+	e.mu.Lock()
+	from := e.state
+	e.state = to
+	e.mu.Unlock()
+
+	if from == to {
+		return
+	}
+	e.logf("INFO. Election state: %s -> %s", from, to)
+	if e.OnTransition != nil {
+		e.OnTransition(from, to)
+	}
+}
+
+func (e *Elector) logf(format string, args ...interface{}) {
+	if e.Logf != nil {
+		e.Logf(format, args...)
+	}
+}
+
+// Run takes part in the election until the context is cancelled, the guard fails or the attempts are exhausted.
+// It returns nil if the context was cancelled, a *GuardError if the guard failed, an error wrapping ErrFenced if a newer epoch was
+// observed and ErrAttemptsExhausted otherwise.
+// Voluntary step-downs do not count as attempts. The node never validates after Run returned.
+func (e *Elector) Run(ctx context.Context) error {
+	maxAttempts := e.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := e.term(ctx)
+
+		if errors.Is(err, ErrSteppedDown) && ctx.Err() == nil {
+			e.logf("INFO. Stepped down voluntarily")
+			attempt--
+			continue
+		}
+
+		if ctx.Err() != nil {
+			e.transition(StateStopped)
+			return nil
+		}
+
+		var guardErr *GuardError
+		if errors.As(err, &guardErr) || errors.Is(err, ErrFenced) {
+			e.transition(StateStopped)
+			return err
+		}
+
+		if err != nil {
+			e.logf("ERROR! Election term %d/%d ended: %s", attempt, maxAttempts, err)
+		} else {
+			e.logf("ERROR! Election term %d/%d ended: lock lost", attempt, maxAttempts)
+		}
+
+		if attempt >= maxAttempts {
+			e.transition(StateStopped)
+			return ErrAttemptsExhausted
+		}
+
+		select {
+		case <-ctx.Done():
+			e.transition(StateStopped)
+			return nil
+		case <-time.After(e.RetryDelay):
+		}
+	}
+}
+
+// term acquires the lock once, leads for as long as possible and steps down. A nil error means the lock was lost
+func (e *Elector) term(ctx context.Context) error {
+	e.transition(StateAcquiring)
+
+	if err := run(ctx, e.BeforeAcquire); err != nil {
+		return err
+	}
+
+	lost, err := e.Locker.Lock(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to acquire lock: %w", err)
+	}
+	e.logf("INFO. Lock acquired")
+
+	// Every stage is cancelled as soon as the lock is lost
+	leadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-leadCtx.Done():
+		}
+	}()
+
+	started, err := e.lead(leadCtx)
+
+	e.transition(StateSteppingDown)
+	if started && e.StopValidator != nil {
+		timeout := e.StepDownTimeout
+		if timeout <= 0 {
+			timeout = time.Minute
+		}
+		stopCtx, stopCancel := context.WithTimeout(context.Background(), timeout)
+		if stopErr := e.StopValidator(stopCtx); stopErr != nil {
+			e.logf("ERROR! Unable to stop validator: %s", stopErr)
+		} else if errors.Is(err, ErrSteppedDown) && e.OnStepDown != nil {
+			if stepDownErr := e.OnStepDown(stopCtx); stepDownErr != nil {
+				e.logf("ERROR! Unable to complete the step down: %s", stepDownErr)
+			}
+		}
+		stopCancel()
+	}
+	if unlockErr := e.Locker.Unlock(); unlockErr != nil {
+		e.logf("ERROR! Unable to release lock: %s", unlockErr)
+	}
+
+	if leadCtx.Err() != nil && ctx.Err() == nil {
+		// The lock was lost, whatever error the interrupted stage returned is a consequence of it
+		var guardErr *GuardError
+		if !errors.As(err, &guardErr) {
+			return nil
+		}
+	}
+	return err
+}
+
+// lead runs the stages while the lock is held. It reports whether StartValidator was called
+func (e *Elector) lead(ctx context.Context) (bool, error) {
+	e.transition(StateGuarding)
+	if e.Guard != nil {
+		if err := e.Guard(ctx); err != nil {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			if errors.Is(err, ErrValidatorActive) || errors.Is(err, ErrRecordAdvanced) {
+				return false, &GuardError{Err: err}
+			}
+			return false, fmt.Errorf("guard stage failed: %w", err)
+		}
+	}
+
+	e.transition(StateInsertingKeys)
+	if err := run(ctx, e.InsertKeys); err != nil {
+		return false, fmt.Errorf("unable to insert keys: %w", err)
+	}
+
+	e.transition(StateStartingValidator)
+	if err := run(ctx, e.StartValidator); err != nil {
+		return true, fmt.Errorf("unable to start validator: %w", err)
+	}
+
+	e.transition(StateHolding)
+	if e.Hold == nil {
+		<-ctx.Done()
+		return true, ctx.Err()
+	}
+	err := e.Hold(ctx)
+	if ctx.Err() != nil {
+		return true, ctx.Err()
+	}
+	if err == nil {
+		err = errors.New("hook returned")
+	}
+	return true, fmt.Errorf("stopped holding the lock: %w", err)
+}
+
+func run(ctx context.Context, hook Hook) error {
+	if hook == nil {
+		return ctx.Err()
+	}
+	if err := hook(ctx); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// Command returns a hook running the command, e.g. one of the scripts written by the bootstrap script. The output is included in the error
+func Command(name string, args ...string) Hook {
+	return func(ctx context.Context) error {
+		output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,339 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLocker grants the lock on every Lock call unless an error is queued. The lock is lost when lose is called
+type fakeLocker struct {
+	mu       sync.Mutex
+	errs     []error
+	lost     chan struct{}
+	locks    int
+	unlocks  int
+	acquired chan struct{}
+}
+
+func newFakeLocker() *fakeLocker {
+	return &fakeLocker{acquired: make(chan struct{}, 10)}
+}
+
+func (f *fakeLocker) Lock(ctx context.Context) (<-chan struct{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.locks++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	f.lost = make(chan struct{})
+	f.acquired <- struct{}{}
+	return f.lost, nil
+}
+
+func (f *fakeLocker) Unlock() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unlocks++
+	return nil
+}
+
+func (f *fakeLocker) lose() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	close(f.lost)
+}
+
+// recorder records the hooks in the order they were called
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) hook(name string, err error) Hook {
+	return func(ctx context.Context) error {
+		r.mu.Lock()
+		r.calls = append(r.calls, name)
+		r.mu.Unlock()
+		return err
+	}
+}
+
+func (r *recorder) blocking(name string) Hook {
+	return func(ctx context.Context) error {
+		r.mu.Lock()
+		r.calls = append(r.calls, name)
+		r.mu.Unlock()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.calls...)
+}
+
+func newElector(locker Locker, r *recorder) (*Elector, *[]State) {
+	var mu sync.Mutex
+	var states []State
+	e := &Elector{
+		Locker:         locker,
+		Guard:          r.hook("guard", nil),
+		InsertKeys:     r.hook("insert-keys", nil),
+		StartValidator: r.hook("start-validator", nil),
+		Hold:           r.blocking("hold"),
+		StopValidator:  r.hook("stop-validator", nil),
+		MaxAttempts:    3,
+		OnTransition: func(from State, to State) {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, to)
+		},
+	}
+	return e, &states
+}
+
+func waitFor(t *testing.T, e *Elector, state State) {
+	deadline := time.Now().Add(5 * time.Second)
+	for e.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("elector never reached %s, last state %s", state, e.State())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFullTermAndShutdown(t *testing.T) {
+	locker := newFakeLocker()
+	r := &recorder{}
+	e, states := newElector(locker, r)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- e.Run(ctx) }()
+
+	waitFor(t, e, StateHolding)
+	cancel()
+	require.NoError(t, <-done)
+
+	assert.Equal(t, []string{"guard", "insert-keys", "start-validator", "hold", "stop-validator"}, r.get())
+	assert.Equal(t, []State{StateAcquiring, StateGuarding, StateInsertingKeys, StateStartingValidator, StateHolding, StateSteppingDown, StateStopped}, *states)
+	assert.Equal(t, 1, locker.unlocks)
+}
+
+func TestLockLostStepsDownAndRetries(t *testing.T) {
+	locker := newFakeLocker()
+	r := &recorder{}
+	e, _ := newElector(locker, r)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- e.Run(ctx) }()
+
+	<-locker.acquired
+	waitFor(t, e, StateHolding)
+	locker.lose()
+
+	<-locker.acquired
+	waitFor(t, e, StateHolding)
+	cancel()
+	require.NoError(t, <-done)
+
+	assert.Equal(t, []string{
+		"guard", "insert-keys", "start-validator", "hold", "stop-validator",
+		"guard", "insert-keys", "start-validator", "hold", "stop-validator",
+	}, r.get())
+	assert.Equal(t, 2, locker.locks)
+}
+
+func TestGuardFailureIsFinal(t *testing.T) {
+	locker := newFakeLocker()
+	r := &recorder{}
+	e, states := newElector(locker, r)
+	e.Guard = r.hook("guard", ErrValidatorActive)
+
+	err := e.Run(context.Background())
+
+	var guardErr *GuardError
+	require.True(t, errors.As(err, &guardErr))
+	assert.True(t, errors.Is(err, ErrValidatorActive))
+	// Neither keys nor the validator are touched once the guard failed, and the election is not retried
+	assert.Equal(t, []string{"guard"}, r.get())
+	assert.Equal(t, 1, locker.locks)
+	assert.Equal(t, 1, locker.unlocks)
+	assert.Equal(t, []State{StateAcquiring, StateGuarding, StateSteppingDown, StateStopped}, *states)
+}
+
+func TestTransientGuardErrorsAreRetried(t *testing.T) {
+	locker := newFakeLocker()
+	r := &recorder{}
+	e, states := newElector(locker, r)
+
+	// A Consul hiccup while advancing the epoch ends the term, but the node contends again instead of leaving the election
+	guards := 0
+	e.Guard = func(ctx context.Context) error {
+		guards++
+		if guards == 1 {
+			return r.hook("guard", errors.New("Unexpected response code: 500 (rpc error: No cluster leader)"))(ctx)
+		}
+		return r.hook("guard", nil)(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- e.Run(ctx) }()
+
+	<-locker.acquired
+	<-locker.acquired
+	waitFor(t, e, StateHolding)
+	cancel()
+
+	require.NoError(t, <-done)
+	assert.Equal(t, []string{"guard", "guard", "insert-keys", "start-validator", "hold", "stop-validator"}, r.get())
+	assert.Equal(t, 2, locker.unlocks)
+	assert.Equal(t, []State{
+		StateAcquiring, StateGuarding, StateSteppingDown,
+		StateAcquiring, StateGuarding, StateInsertingKeys, StateStartingValidator, StateHolding, StateSteppingDown, StateStopped,
+	}, *states)
+}
+
+func TestFencedGuardIsFinal(t *testing.T) {
+	locker := newFakeLocker()
+	r := &recorder{}
+	e, _ := newElector(locker, r)
+	e.Guard = r.hook("guard", fmt.Errorf("%w: failover/epoch was updated during the promotion", ErrFenced))
+
+	err := e.Run(context.Background())
+
+	assert.True(t, errors.Is(err, ErrFenced))
+	assert.Equal(t, []string{"guard"}, r.get())
+	assert.Equal(t, 1, locker.locks)
+}
+
+func TestFailedStagesExhaustAttempts(t *testing.T) {
+	locker := newFakeLocker()
+	r := &recorder{}
+	e, _ := newElector(locker, r)
+	e.InsertKeys = r.hook("insert-keys", errors.New("ssm unavailable"))
+
+	err := e.Run(context.Background())
+
+	assert.Equal(t, ErrAttemptsExhausted, err)
+	// The validator is never started without keys, so there is nothing to stop either
+	assert.Equal(t, []string{"guard", "insert-keys", "guard", "insert-keys", "guard", "insert-keys"}, r.get())
+	assert.Equal(t, 3, locker.unlocks)
+	assert.Equal(t, StateStopped, e.State())
+}
+
+func TestFailedValidatorStartIsStopped(t *testing.T) {
+	locker := newFakeLocker()
+	r := &recorder{}
+	e, _ := newElector(locker, r)
+	e.MaxAttempts = 1
+	e.StartValidator = r.hook("start-validator", errors.New("docker failed"))
+
+	assert.Equal(t, ErrAttemptsExhausted, e.Run(context.Background()))
+	assert.Equal(t, []string{"guard", "insert-keys", "start-validator", "stop-validator"}, r.get())
+}
+
+func TestLockErrorsCountAsAttempts(t *testing.T) {
+	locker := newFakeLocker()
+	locker.errs = []error{errors.New("no cluster leader"), errors.New("no cluster leader"), errors.New("no cluster leader")}
+	r := &recorder{}
+	e, _ := newElector(locker, r)
+
+	assert.Equal(t, ErrAttemptsExhausted, e.Run(context.Background()))
+	assert.Empty(t, r.get())
+	assert.Equal(t, 0, locker.unlocks)
+}
+
+func TestHoldReturningStepsDown(t *testing.T) {
+	locker := newFakeLocker()
+	r := &recorder{}
+	e, _ := newElector(locker, r)
+	e.MaxAttempts = 1
+	e.Hold = r.hook("hold", nil)
+
+	assert.Equal(t, ErrAttemptsExhausted, e.Run(context.Background()))
+	assert.Equal(t, []string{"guard", "insert-keys", "start-validator", "hold", "stop-validator"}, r.get())
+}
+
+func TestVoluntaryStepDown(t *testing.T) {
+	locker := newFakeLocker()
+	r := &recorder{}
+	e, _ := newElector(locker, r)
+	e.MaxAttempts = 1
+	e.BeforeAcquire = r.hook("before-acquire", nil)
+	e.OnStepDown = r.hook("on-step-down", nil)
+
+	terms := 0
+	e.Hold = func(ctx context.Context) error {
+		terms++
+		if terms == 1 {
+			return r.hook("hold", ErrSteppedDown)(ctx)
+		}
+		return r.blocking("hold")(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- e.Run(ctx) }()
+
+	<-locker.acquired
+	<-locker.acquired
+	waitFor(t, e, StateHolding)
+	cancel()
+
+	// The step-down does not count as an attempt, otherwise a single attempt would be exhausted by it
+	require.NoError(t, <-done)
+	assert.Equal(t, []string{
+		"before-acquire", "guard", "insert-keys", "start-validator", "hold", "stop-validator", "on-step-down",
+		"before-acquire", "guard", "insert-keys", "start-validator", "hold", "stop-validator",
+	}, r.get())
+	assert.Equal(t, 2, locker.unlocks)
+}
+
+func TestFencedValidatorLeavesTheElection(t *testing.T) {
+	locker := newFakeLocker()
+	r := &recorder{}
+	e, _ := newElector(locker, r)
+	e.Hold = r.hook("hold", fmt.Errorf("%w: validating under epoch 3, current epoch is 4", ErrFenced))
+
+	err := e.Run(context.Background())
+
+	assert.True(t, errors.Is(err, ErrFenced))
+	// A newer epoch means another node was promoted, so the node never tries again
+	assert.Equal(t, []string{"guard", "insert-keys", "start-validator", "hold", "stop-validator"}, r.get())
+	assert.Equal(t, 1, locker.locks)
+	assert.Equal(t, StateStopped, e.State())
+}
+
+func TestConcurrentlyReturnsFirstError(t *testing.T) {
+	r := &recorder{}
+	err := Concurrently(r.blocking("publish"), r.hook("watch", ErrFenced))(context.Background())
+	assert.Equal(t, ErrFenced, err)
+	assert.ElementsMatch(t, []string{"publish", "watch"}, r.get())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, Concurrently(r.hook("a", nil), r.hook("b", nil))(ctx))
+}
+
+func TestSequenceStopsAtFirstError(t *testing.T) {
+	r := &recorder{}
+	err := Sequence(r.hook("guard", nil), nil, r.hook("advance", ErrFenced), r.hook("never", nil))(context.Background())
+	assert.Equal(t, ErrFenced, err)
+	assert.Equal(t, []string{"guard", "advance"}, r.get())
+}
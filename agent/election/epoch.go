@@ -0,0 +1,232 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// DefaultEpochKey is the key holding the epoch of the current validator
+const DefaultEpochKey = "failover/epoch"
+
+// DefaultEpochFile is the file on the data volume the epoch the node validated under is recorded to. It moves with the volume
+const DefaultEpochFile = "/data/failover-epoch"
+
+// ErrFenced is returned once the node observes an epoch newer than the one it validates under, i.e. another node was promoted
+var ErrFenced = errors.New("a newer validator epoch was started")
+
+// EpochRecord is the value of the epoch key
+type EpochRecord struct {
+	Epoch      uint64    `json:"epoch"`
+	Node       string    `json:"node"`
+	PromotedAt time.Time `json:"promoted_at"`
+}
+
+// Epoch fences validators: every promotion increments the epoch with check-and-set, and a validator stops as soon as it sees an
+// epoch newer than its own. Unlike best_block, which only stops changing once the previous validator is gone, the epoch never goes back.
+type Epoch struct {
+	KV *api.KV
+	// Key is DefaultEpochKey if empty
+	Key string
+	// Node is the Consul node name of the local node
+	Node string
+	// File is DefaultEpochFile if empty
+	File string
+	// Interval is the delay after a failed read of the epoch
+	Interval time.Duration
+	Logf     func(format string, args ...interface{})
+
+	mu   sync.Mutex
+	held uint64
+}
+
+func (e *Epoch) key() string {
+	if e.Key == "" {
+		return DefaultEpochKey
+	}
+	return e.Key
+}
+
+func (e *Epoch) file() string {
+	if e.File == "" {
+		return DefaultEpochFile
+	}
+	return e.File
+}
+
+func (e *Epoch) logf(format string, args ...interface{}) {
+	if e.Logf != nil {
+		e.Logf(format, args...)
+	}
+}
+
+// Get returns the current epoch, nil if nobody was promoted yet, and the modify index of the key
+func (e *Epoch) Get(ctx context.Context) (*EpochRecord, uint64, error) {
+	record, pair, _, err := e.get((&api.QueryOptions{}).WithContext(ctx))
+	if err != nil || pair == nil {
+		return record, 0, err
+	}
+	return record, pair.ModifyIndex, nil
+}
+
+func (e *Epoch) get(opts *api.QueryOptions) (*EpochRecord, *api.KVPair, *api.QueryMeta, error) {
+	pair, meta, err := e.KV.Get(e.key(), opts)
+	if err != nil || pair == nil {
+		return nil, nil, meta, err
+	}
+	var record EpochRecord
+	if err := json.Unmarshal(pair.Value, &record); err != nil {
+		return nil, pair, meta, fmt.Errorf("%s has unexpected value: %w", e.key(), err)
+	}
+	return &record, pair, meta, nil
+}
+
+// Held returns the epoch the node validates under, zero if it does not
+func (e *Epoch) Held() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.held
+}
+
+// Advance starts a new epoch for the local node. It is called once the guard passed and before the keys are inserted. A concurrent
+// promotion makes the check-and-set fail with ErrFenced
+func (e *Epoch) Advance(ctx context.Context) error {
+	current, index, err := e.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	next := EpochRecord{Epoch: 1, Node: e.Node, PromotedAt: time.Now().UTC()}
+	if current != nil {
+		next.Epoch = current.Epoch + 1
+	}
+	value, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+
+	written, _, err := e.KV.CAS(&api.KVPair{Key: e.key(), Value: value, ModifyIndex: index}, (&api.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if !written {
+		return fmt.Errorf("%w: %s was updated during the promotion", ErrFenced, e.key())
+	}
+
+	e.mu.Lock()
+	e.held = next.Epoch
+	e.mu.Unlock()
+
+	if err := ioutil.WriteFile(e.file(), []byte(strconv.FormatUint(next.Epoch, 10)+"\n"), 0644); err != nil {
+		e.logf("ERROR! Unable to record epoch %d to %s: %s", next.Epoch, e.file(), err)
+	}
+	e.logf("INFO. Validating under epoch %d", next.Epoch)
+	return nil
+}
+
+// Check fails with ErrFenced if the current epoch is not the one the node was promoted under. It guards every (re)start of the validator
+func (e *Epoch) Check(ctx context.Context) error {
+	current, _, err := e.Get(ctx)
+	if err != nil {
+		return err
+	}
+	return e.compare(current)
+}
+
+func (e *Epoch) compare(current *EpochRecord) error {
+	held := e.Held()
+	if current == nil || current.Epoch != held {
+		var epoch uint64
+		if current != nil {
+			epoch = current.Epoch
+		}
+		return fmt.Errorf("%w: validating under epoch %d, current epoch is %d", ErrFenced, held, epoch)
+	}
+	return nil
+}
+
+// Watch blocks until the epoch moves past the one the node validates under and returns ErrFenced, or returns nil once the context is done
+func (e *Epoch) Watch(ctx context.Context) error {
+	var index uint64
+	for {
+		current, _, meta, err := e.get((&api.QueryOptions{WaitIndex: index, WaitTime: time.Minute}).WithContext(ctx))
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			e.logf("ERROR! Unable to watch %s: %s", e.key(), err)
+			if !sleep(ctx, e.Interval) {
+				return nil
+			}
+			continue
+		}
+		if err := e.compare(current); err != nil {
+			return err
+		}
+		index = meta.LastIndex
+	}
+}
+
+// Release forgets the epoch once the validator was stopped. The local record is kept
+func (e *Epoch) Release(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.held = 0
+	return nil
+}
+
+// Recorded returns the epoch the node last validated under according to the local record, zero if it never validated
+func (e *Epoch) Recorded() (uint64, error) {
+	content, err := ioutil.ReadFile(e.file())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// Concurrently runs the hooks in parallel, e.g. publishing the best block and watching the epoch while holding the lock.
+// The first error cancels the other hooks and is returned, nil is returned once all the hooks returned nil
+func Concurrently(hooks ...Hook) Hook {
+	return func(ctx context.Context) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		results := make(chan error, len(hooks))
+		for _, hook := range hooks {
+			go func(hook Hook) { results <- hook(ctx) }(hook)
+		}
+
+		var first error
+		for range hooks {
+			if err := <-results; err != nil && first == nil {
+				first = err
+				cancel()
+			}
+		}
+		return first
+	}
+}
+
+// Sequence runs the hooks one after another and stops at the first error, e.g. to advance the epoch once the guard passed
+func Sequence(hooks ...Hook) Hook {
+	return func(ctx context.Context) error {
+		for _, hook := range hooks {
+			if err := run(ctx, hook); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,100 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// DefaultFreezeKey is the key an operator puts a FreezeFlag to, see `failoverctl freeze`
+const DefaultFreezeKey = "failover/freeze"
+
+// ErrFrozen is returned instead of requesting a handoff while the elections are frozen
+var ErrFrozen = errors.New("elections are frozen")
+
+// FreezeFlag stops the elections: no node contends for the lock while it is set, so the current validator keeps the role and nobody
+// takes over if it fails. Unlike a handoff request it never expires
+type FreezeFlag struct {
+	// By is the operator who froze the elections
+	By       string    `json:"by"`
+	Reason   string    `json:"reason,omitempty"`
+	FrozenAt time.Time `json:"frozen_at"`
+}
+
+// Freeze reads the freeze flag from Consul
+type Freeze struct {
+	KV *api.KV
+	// Key is DefaultFreezeKey if empty
+	Key string
+	// Interval is the delay between two checks while the elections are frozen
+	Interval time.Duration
+	Logf     func(format string, args ...interface{})
+}
+
+func (f *Freeze) key() string {
+	if f.Key == "" {
+		return DefaultFreezeKey
+	}
+	return f.Key
+}
+
+func (f *Freeze) logf(format string, args ...interface{}) {
+	if f.Logf != nil {
+		f.Logf(format, args...)
+	}
+}
+
+// Get returns the freeze flag, nil if the elections are not frozen
+func (f *Freeze) Get(ctx context.Context) (*FreezeFlag, error) {
+	pair, _, err := f.KV.Get(f.key(), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil || pair == nil {
+		return nil, err
+	}
+	var flag FreezeFlag
+	if err := json.Unmarshal(pair.Value, &flag); err != nil {
+		return nil, fmt.Errorf("%s has unexpected value: %w", f.key(), err)
+	}
+	return &flag, nil
+}
+
+// Wait blocks while the elections are frozen. A flag that cannot be read keeps the node waiting as well, since the operator may have
+// frozen the elections because of an incident
+func (f *Freeze) Wait(ctx context.Context) error {
+	var frozen string
+	for {
+		flag, err := f.Get(ctx)
+		if err != nil {
+			f.logf("ERROR! Unable to read %s: %s", f.key(), err)
+		} else if flag == nil {
+			if frozen != "" {
+				f.logf("INFO. Elections are no longer frozen")
+			}
+			return nil
+		} else if message := fmt.Sprintf("frozen by %s since %s: %s", flag.By, flag.FrozenAt.Format(time.RFC3339), flag.Reason); message != frozen {
+			f.logf("INFO. Elections are %s, not contending for the lock", message)
+			frozen = message
+		}
+		if !sleep(ctx, f.Interval) {
+			return ctx.Err()
+		}
+	}
+}
+
+// Unfrozen wraps a handoff request, e.g. Handoff.Request, so that it fails with ErrFrozen while the elections are frozen. Otherwise the
+// validator would step down while no other node may take over
+func (f *Freeze) Unfrozen(request func(ctx context.Context, to string) error) func(ctx context.Context, to string) error {
+	return func(ctx context.Context, to string) error {
+		flag, err := f.Get(ctx)
+		if err != nil {
+			return err
+		}
+		if flag != nil {
+			return ErrFrozen
+		}
+		return request(ctx, to)
+	}
+}
@@ -0,0 +1,221 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// DefaultHandoffKey is the key an operator puts a HandoffRequest to, see `failoverctl stepdown`
+const DefaultHandoffKey = "failover/handoff"
+
+// DefaultHandoffTimeout is the time the chosen standby gets to take over before any other standby may contend for the lock
+const DefaultHandoffTimeout = 10 * time.Minute
+
+// ErrSteppedDown is returned by the hold stage when the validator was asked to step down
+var ErrSteppedDown = errors.New("validator was asked to step down")
+
+// ErrHandoffPending is returned by Handoff.Request while another request is pending
+var ErrHandoffPending = errors.New("a handoff is already pending")
+
+// HandoffRequest asks the validator to step down gracefully. Consul node names are the EC2 instance IDs
+type HandoffRequest struct {
+	// From is the node asked to step down
+	From string `json:"from"`
+	// To is the node that should take over. Empty lets any standby take over
+	To string `json:"to,omitempty"`
+	// RequestedAt is the time of the request. The request expires after Timeout
+	RequestedAt time.Time `json:"requested_at"`
+	// Timeout is DefaultHandoffTimeout if zero
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// FinalBlock is set by the validator once it stopped validating. It is the last block the next validator has to finalize past
+	FinalBlock uint64 `json:"final_block,omitempty"`
+}
+
+// Expired reports whether the request is too old to be honoured
+func (r *HandoffRequest) Expired(now time.Time) bool {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHandoffTimeout
+	}
+	return now.After(r.RequestedAt.Add(timeout))
+}
+
+// Handoff coordinates a voluntary step-down of the validator through a request stored in Consul
+type Handoff struct {
+	KV *api.KV
+	// Key is DefaultHandoffKey if empty
+	Key string
+	// Node is the Consul node name of the local node
+	Node string
+	// Interval is the delay between two checks while waiting for the turn of the node
+	Interval time.Duration
+	Logf     func(format string, args ...interface{})
+}
+
+func (h *Handoff) key() string {
+	if h.Key == "" {
+		return DefaultHandoffKey
+	}
+	return h.Key
+}
+
+func (h *Handoff) logf(format string, args ...interface{}) {
+	if h.Logf != nil {
+		h.Logf(format, args...)
+	}
+}
+
+// Get returns the pending request, nil if there is none
+func (h *Handoff) Get(ctx context.Context) (*HandoffRequest, uint64, error) {
+	return h.get((&api.QueryOptions{}).WithContext(ctx))
+}
+
+func (h *Handoff) get(opts *api.QueryOptions) (*HandoffRequest, uint64, error) {
+	pair, meta, err := h.KV.Get(h.key(), opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	request, err := h.decode(pair)
+	return request, meta.LastIndex, err
+}
+
+// pair returns the pending request along with the index it was last modified at, which is zero if there is no request
+func (h *Handoff) pair(ctx context.Context) (*HandoffRequest, uint64, error) {
+	pair, _, err := h.KV.Get(h.key(), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil || pair == nil {
+		return nil, 0, err
+	}
+	request, err := h.decode(pair)
+	return request, pair.ModifyIndex, err
+}
+
+func (h *Handoff) decode(pair *api.KVPair) (*HandoffRequest, error) {
+	if pair == nil {
+		return nil, nil
+	}
+	var request HandoffRequest
+	if err := json.Unmarshal(pair.Value, &request); err != nil {
+		return nil, fmt.Errorf("%s has unexpected value: %w", h.key(), err)
+	}
+	return &request, nil
+}
+
+// Watch blocks until a request asks the local node to step down and returns ErrSteppedDown, or returns nil once the context is done
+func (h *Handoff) Watch(ctx context.Context) error {
+	var index uint64
+	for {
+		request, lastIndex, err := h.get((&api.QueryOptions{WaitIndex: index, WaitTime: time.Minute}).WithContext(ctx))
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			h.logf("ERROR! Unable to watch %s: %s", h.key(), err)
+			if !sleep(ctx, h.Interval) {
+				return nil
+			}
+			continue
+		}
+		if request != nil && request.From == h.Node && request.FinalBlock == 0 && !request.Expired(time.Now()) {
+			h.logf("INFO. Asked to step down in favour of %q", request.To)
+			return ErrSteppedDown
+		}
+		index = lastIndex
+	}
+}
+
+// Complete records the final block of the validator that stepped down, so the operator can follow the handoff
+func (h *Handoff) Complete(ctx context.Context, finalBlock uint64) error {
+	request, _, err := h.Get(ctx)
+	if err != nil || request == nil {
+		return err
+	}
+	request.FinalBlock = finalBlock
+	value, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	_, err = h.KV.Put(&api.KVPair{Key: h.key(), Value: value}, (&api.WriteOptions{}).WithContext(ctx))
+	return err
+}
+
+// Request asks the local node to hand the validator role over to another node. It fails with ErrHandoffPending while another request
+// is pending, an expired request is replaced
+func (h *Handoff) Request(ctx context.Context, to string) error {
+	pending, index, err := h.pair(ctx)
+	if err != nil {
+		return err
+	}
+	if pending != nil && !pending.Expired(time.Now()) {
+		return fmt.Errorf("%w: %s asked to hand over to %q at %s", ErrHandoffPending, pending.From, pending.To, pending.RequestedAt.Format(time.RFC3339))
+	}
+
+	value, err := json.Marshal(HandoffRequest{From: h.Node, To: to, RequestedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	// A zero ModifyIndex only creates the key if it does not exist yet, otherwise only the expired request read above is replaced
+	written, _, err := h.KV.CAS(&api.KVPair{Key: h.key(), Value: value, ModifyIndex: index}, (&api.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if !written {
+		return fmt.Errorf("%w: %s was modified concurrently", ErrHandoffPending, h.key())
+	}
+	return nil
+}
+
+// WaitTurn blocks while a pending request gives the lock to another node: the node that stepped down waits until someone else
+// took over, other standbys wait for the chosen one. Expired requests are ignored, so a failed handoff never blocks the election.
+func (h *Handoff) WaitTurn(ctx context.Context) error {
+	for {
+		request, _, err := h.Get(ctx)
+		if err != nil {
+			h.logf("ERROR! Unable to read %s: %s", h.key(), err)
+		} else if request == nil || request.Expired(time.Now()) || !h.waits(request) {
+			return nil
+		} else {
+			h.logf("INFO. Handoff from %s to %q is in progress, not contending for the lock", request.From, request.To)
+		}
+		if !sleep(ctx, h.Interval) {
+			return ctx.Err()
+		}
+	}
+}
+
+func (h *Handoff) waits(request *HandoffRequest) bool {
+	if request.From == h.Node {
+		return true
+	}
+	return request.To != "" && request.To != h.Node
+}
+
+// Done removes the request once the local node took over. A request of the local node itself is only removed once it expired, i.e. the
+// node took the lock again because no other node did
+func (h *Handoff) Done(ctx context.Context) error {
+	request, index, err := h.pair(ctx)
+	if err != nil || request == nil {
+		return err
+	}
+	if request.From == h.Node && !request.Expired(time.Now()) {
+		return nil
+	}
+	// Check-and-set keeps a request put meanwhile
+	_, _, err = h.KV.DeleteCAS(&api.KVPair{Key: h.key(), ModifyIndex: index}, (&api.WriteOptions{}).WithContext(ctx))
+	return err
+}
+
+// Hold wraps the hold stage of the validator: it removes the request that made the node validator and runs publish until the
+// context is done or the node is asked to step down, in which case it returns ErrSteppedDown
+func (h *Handoff) Hold(publish Hook) Hook {
+	return func(ctx context.Context) error {
+		if err := h.Done(ctx); err != nil {
+			h.logf("ERROR! Unable to remove %s: %s", h.key(), err)
+		}
+		return Concurrently(publish, h.Watch)(ctx)
+	}
+}
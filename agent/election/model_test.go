@@ -0,0 +1,200 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/failover"
+	"github.com/stretchr/testify/assert"
+)
+
+// modelEvents maps every transition of the elector onto the events of the failover model. The elector cannot tell a lost lock from a
+// failed stage, so it always steps down the way the model handles an unhealthy node: the validator is stopped and the lock released.
+// A fenced node ends up Draining rather than Fenced, since its shutdown is left to the caller of Run
+var modelEvents = map[[2]State][]failover.Event{
+	{"", StateAcquiring}:                         {failover.EventEligible},
+	{StateAcquiring, StateGuarding}:              {failover.EventLockAcquired},
+	{StateAcquiring, StateStopped}:               {failover.EventShutdown},
+	{StateGuarding, StateInsertingKeys}:          {failover.EventGuardPassed},
+	{StateGuarding, StateSteppingDown}:           {failover.EventNodeUnhealthy},
+	{StateInsertingKeys, StateStartingValidator}: nil,
+	{StateInsertingKeys, StateSteppingDown}:      {failover.EventNodeUnhealthy},
+	{StateStartingValidator, StateHolding}:       nil,
+	{StateStartingValidator, StateSteppingDown}:  {failover.EventNodeUnhealthy},
+	{StateHolding, StateSteppingDown}:            {failover.EventNodeUnhealthy},
+	{StateSteppingDown, StateAcquiring}:          {failover.EventSynced, failover.EventEligible},
+	{StateSteppingDown, StateStopped}:            {failover.EventShutdown},
+}
+
+// modelStates is the state of the model every state of the elector corresponds to
+var modelStates = map[State]failover.State{
+	StateAcquiring:         failover.StateCandidate,
+	StateGuarding:          failover.StateGuarding,
+	StateInsertingKeys:     failover.StateValidating,
+	StateStartingValidator: failover.StateValidating,
+	StateHolding:           failover.StateValidating,
+	StateSteppingDown:      failover.StateSyncing,
+	StateStopped:           failover.StateDraining,
+}
+
+// modelChecker replays the transitions of an elector on the failover model and fails the test as soon as the elector moves to a state
+// the model does not reach, or performs a side effect the model did not request
+type modelChecker struct {
+	t       *testing.T
+	mu      sync.Mutex
+	machine *failover.Machine
+	// requested holds the actions of the latest transition of the model that requested any
+	requested []failover.Action
+}
+
+func newModelChecker(t *testing.T) *modelChecker {
+	machine := failover.NewMachine()
+	machine.Handle(failover.EventBootstrapped)
+	machine.Handle(failover.EventSynced)
+	return &modelChecker{t: t, machine: machine}
+}
+
+func (m *modelChecker) transition(from State, to State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events, ok := modelEvents[[2]State{from, to}]
+	if !ok {
+		m.t.Errorf("transition %s -> %s of the elector has no counterpart in the failover model", from, to)
+		return
+	}
+	if len(events) > 0 {
+		m.requested = nil
+	}
+	for _, event := range events {
+		m.requested = append(m.requested, m.machine.Handle(event)...)
+	}
+	assert.Equal(m.t, modelStates[to], m.machine.State(), "model state after the elector moved from %s to %s", from, to)
+}
+
+// perform fails the test unless the model requested the action on its latest transition, or the model is in the state the action
+// is continuously performed in
+func (m *modelChecker) perform(action failover.Action, in failover.State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, requested := range m.requested {
+		if requested == action {
+			return
+		}
+	}
+	if in == "" || m.machine.State() != in {
+		m.t.Errorf("elector performed %s in model state %s, the model requested %v", action, m.machine.State(), m.requested)
+	}
+}
+
+func (m *modelChecker) hook(action failover.Action, in failover.State, hook Hook) Hook {
+	return func(ctx context.Context) error {
+		m.perform(action, in)
+		return hook(ctx)
+	}
+}
+
+// modelLocker reports the lock operations of the wrapped locker to the checker
+type modelLocker struct {
+	*fakeLocker
+	checker *modelChecker
+}
+
+func (l modelLocker) Lock(ctx context.Context) (<-chan struct{}, error) {
+	l.checker.perform(failover.ActionAcquireLock, failover.StateCandidate)
+	return l.fakeLocker.Lock(ctx)
+}
+
+func (l modelLocker) Unlock() error {
+	l.checker.perform(failover.ActionReleaseLock, "")
+	return l.fakeLocker.Unlock()
+}
+
+// modelElector creates an elector whose transitions and hooks are checked against the failover model. The hooks can be replaced by
+// setup before they are wrapped
+func modelElector(t *testing.T, locker *fakeLocker, r *recorder, setup func(e *Elector)) *Elector {
+	checker := newModelChecker(t)
+	e, _ := newElector(modelLocker{fakeLocker: locker, checker: checker}, r)
+	if setup != nil {
+		setup(e)
+	}
+	e.OnTransition = checker.transition
+	e.Guard = checker.hook(failover.ActionStartGuard, failover.StateGuarding, e.Guard)
+	e.InsertKeys = checker.hook(failover.ActionInsertKeys, failover.StateValidating, e.InsertKeys)
+	e.StartValidator = checker.hook(failover.ActionStartValidator, failover.StateValidating, e.StartValidator)
+	e.Hold = checker.hook(failover.ActionPublishBestBlock, failover.StateValidating, e.Hold)
+	e.StopValidator = checker.hook(failover.ActionStopValidator, "", e.StopValidator)
+	return e
+}
+
+func TestElectorFollowsFailoverModel(t *testing.T) {
+	t.Run("lock lost and shutdown", func(t *testing.T) {
+		locker := newFakeLocker()
+		e := modelElector(t, locker, &recorder{}, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- e.Run(ctx) }()
+
+		<-locker.acquired
+		waitFor(t, e, StateHolding)
+		locker.lose()
+		<-locker.acquired
+		waitFor(t, e, StateHolding)
+		cancel()
+		assert.NoError(t, <-done)
+	})
+
+	t.Run("guard failure", func(t *testing.T) {
+		r := &recorder{}
+		e := modelElector(t, newFakeLocker(), r, func(e *Elector) {
+			e.Guard = r.hook("guard", ErrValidatorActive)
+		})
+
+		var guardErr *GuardError
+		assert.True(t, errors.As(e.Run(context.Background()), &guardErr))
+	})
+
+	t.Run("failed stages", func(t *testing.T) {
+		r := &recorder{}
+		e := modelElector(t, newFakeLocker(), r, func(e *Elector) {
+			e.InsertKeys = r.hook("insert-keys", errors.New("ssm unavailable"))
+		})
+
+		assert.Equal(t, ErrAttemptsExhausted, e.Run(context.Background()))
+	})
+
+	t.Run("lock errors", func(t *testing.T) {
+		locker := newFakeLocker()
+		locker.errs = []error{errors.New("no cluster leader"), errors.New("no cluster leader"), errors.New("no cluster leader")}
+		e := modelElector(t, locker, &recorder{}, nil)
+
+		assert.Equal(t, ErrAttemptsExhausted, e.Run(context.Background()))
+	})
+
+	t.Run("voluntary step down and fencing", func(t *testing.T) {
+		r := &recorder{}
+		e := modelElector(t, newFakeLocker(), r, func(e *Elector) {
+			e.MaxAttempts = 1
+			e.OnStepDown = r.hook("on-step-down", nil)
+			terms := 0
+			e.Hold = func(ctx context.Context) error {
+				terms++
+				if terms == 1 {
+					return ErrSteppedDown
+				}
+				return fmt.Errorf("%w: validating under epoch 3, current epoch is 4", ErrFenced)
+			}
+		})
+
+		assert.True(t, errors.Is(e.Run(context.Background()), ErrFenced))
+		assert.Equal(t, []string{
+			"guard", "insert-keys", "start-validator", "stop-validator", "on-step-down",
+			"guard", "insert-keys", "start-validator", "stop-validator",
+		}, r.get())
+	})
+}
@@ -0,0 +1,332 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// DefaultProtectionKey is the key the slashing-protection record is replicated to
+const DefaultProtectionKey = "failover/protection"
+
+// DefaultProtectionFile is the file on the data volume the node records the heights it validated to. It moves with the volume
+const DefaultProtectionFile = "/data/failover-protection.json"
+
+// ErrRecordAdvanced is returned by the protection guard when the record keeps advancing, i.e. another node is still validating
+var ErrRecordAdvanced = errors.New("slashing-protection record was updated by another validator")
+
+// ProtectionRecord holds the highest heights a node reached while validating
+type ProtectionRecord struct {
+	Node string `json:"node"`
+	// Epoch is the validator epoch and Session the session index of the chain the node validated in
+	Epoch   uint64 `json:"epoch"`
+	Session uint64 `json:"session"`
+	// Finalized and Best are the highest finalized and best blocks the node saw while validating
+	Finalized uint64    `json:"finalized"`
+	Best      uint64    `json:"best"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Protection keeps a slashing-protection record of the heights the node validated. The record is written to a local file on every
+// finalized head and replicated to Consul, where the highest record of any node is kept. Every node puts its local record back to
+// Consul if Consul lost it, so a promoted node refuses to validate below the heights of the previous validator even if best_block
+// and the epoch are gone.
+type Protection struct {
+	KV *api.KV
+	// Key is DefaultProtectionKey if empty
+	Key string
+	// File is DefaultProtectionFile if empty
+	File string
+	// Node is the Consul node name of the local node
+	Node string
+	// Finalized and Best return the finalized and best blocks of the local node, the best block is not recorded if Best is nil
+	Finalized func(ctx context.Context) (uint64, error)
+	Best      func(ctx context.Context) (uint64, error)
+	// Session returns the session index of the chain, the session is not recorded if nil
+	Session func(ctx context.Context) (uint64, error)
+	// Epoch returns the validator epoch the node validates under, e.g. Epoch.Held
+	Epoch func() uint64
+	// Interval is the delay between two updates of the record and two checks of the guard
+	Interval time.Duration
+	Logf     func(format string, args ...interface{})
+	Now      func() time.Time
+}
+
+func (p *Protection) key() string {
+	if p.Key == "" {
+		return DefaultProtectionKey
+	}
+	return p.Key
+}
+
+func (p *Protection) file() string {
+	if p.File == "" {
+		return DefaultProtectionFile
+	}
+	return p.File
+}
+
+func (p *Protection) logf(format string, args ...interface{}) {
+	if p.Logf != nil {
+		p.Logf(format, args...)
+	}
+}
+
+func (p *Protection) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+// Local returns the record of the local file, nil if the node never validated with this data volume
+func (p *Protection) Local() (*ProtectionRecord, error) {
+	content, err := ioutil.ReadFile(p.file())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record ProtectionRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		return nil, fmt.Errorf("%s has unexpected content: %w", p.file(), err)
+	}
+	return &record, nil
+}
+
+// save writes the record to the local file. The file is replaced atomically and synced, so a crash leaves either record
+func (p *Protection) save(record ProtectionRecord) error {
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(p.file()), filepath.Base(p.file())+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), p.file())
+}
+
+// Replicated returns the record kept in Consul, nil if there is none
+func (p *Protection) Replicated(ctx context.Context) (*ProtectionRecord, error) {
+	record, _, err := p.replicated(ctx)
+	return record, err
+}
+
+func (p *Protection) replicated(ctx context.Context) (*ProtectionRecord, uint64, error) {
+	pair, _, err := p.KV.Get(p.key(), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil || pair == nil {
+		return nil, 0, err
+	}
+	var record ProtectionRecord
+	if err := json.Unmarshal(pair.Value, &record); err != nil {
+		// A broken value is replaced by the next record
+		p.logf("ERROR! %s has unexpected value: %s", p.key(), err)
+		return nil, pair.ModifyIndex, nil
+	}
+	return &record, pair.ModifyIndex, nil
+}
+
+// replicate puts the record to Consul with check-and-set unless Consul already holds a record that is as high. The record in Consul
+// never goes back
+func (p *Protection) replicate(ctx context.Context, record ProtectionRecord) error {
+	current, index, err := p.replicated(ctx)
+	if err != nil {
+		return err
+	}
+	if current != nil && !record.above(*current) {
+		return nil
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	written, _, err := p.KV.CAS(&api.KVPair{Key: p.key(), Value: value, ModifyIndex: index}, (&api.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if !written {
+		return fmt.Errorf("%s was updated concurrently", p.key())
+	}
+	return nil
+}
+
+// above reports whether the record is higher than the other one, by best block first
+func (r ProtectionRecord) above(other ProtectionRecord) bool {
+	return r.Best > other.Best || (r.Best == other.Best && r.Finalized > other.Finalized)
+}
+
+// Restore puts the local record back to Consul if Consul lost it or holds a lower one
+func (p *Protection) Restore(ctx context.Context) error {
+	local, err := p.Local()
+	if err != nil || local == nil {
+		return err
+	}
+	return p.replicate(ctx, *local)
+}
+
+// Replicate restores the local record every interval until the context is done. Every node runs it, whether it validates or not
+func (p *Protection) Replicate(ctx context.Context) error {
+	for {
+		if err := p.Restore(ctx); err != nil && ctx.Err() == nil {
+			p.logf("ERROR! Unable to replicate the slashing-protection record: %s", err)
+		}
+		if !sleep(ctx, p.Interval) {
+			return nil
+		}
+	}
+}
+
+// Track records the heights of the validator on every finalized head until the context is done. It runs while the lock is held
+func (p *Protection) Track(ctx context.Context) error {
+	record, err := p.Local()
+	if err != nil {
+		p.logf("ERROR! Unable to read the slashing-protection record, starting a new one: %s", err)
+	}
+	// The volume may come from another instance, its heights are kept
+	if record == nil {
+		record = &ProtectionRecord{}
+	}
+	record.Node = p.Node
+
+	for {
+		if p.update(ctx, record) {
+			if err := p.save(*record); err != nil {
+				p.logf("ERROR! Unable to record block %d to %s: %s", record.Finalized, p.file(), err)
+			}
+			if err := p.replicate(ctx, *record); err != nil && ctx.Err() == nil {
+				p.logf("ERROR! Unable to replicate the slashing-protection record: %s", err)
+			}
+		}
+		if !sleep(ctx, p.Interval) {
+			return nil
+		}
+	}
+}
+
+// update raises the heights of the record to the ones of the node and reports whether the finalized block advanced
+func (p *Protection) update(ctx context.Context, record *ProtectionRecord) bool {
+	finalized, err := p.Finalized(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			p.logf("ERROR! Unable to get the finalized block: %s", err)
+		}
+		return false
+	}
+	if finalized <= record.Finalized && record.Epoch == p.epoch() {
+		return false
+	}
+
+	record.Finalized, record.Epoch, record.UpdatedAt = higher(record.Finalized, finalized), p.epoch(), p.now().UTC()
+	if p.Best != nil {
+		if best, err := p.Best(ctx); err == nil {
+			record.Best = higher(record.Best, best)
+		}
+	}
+	record.Best = higher(record.Best, record.Finalized)
+	if p.Session != nil {
+		if session, err := p.Session(ctx); err == nil {
+			record.Session = session
+		}
+	}
+	return true
+}
+
+func (p *Protection) epoch() uint64 {
+	if p.Epoch == nil {
+		return 0
+	}
+	return p.Epoch()
+}
+
+func higher(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Highest returns the higher of the local and the replicated record, nil if the deployment has no record
+func (p *Protection) Highest(ctx context.Context) (*ProtectionRecord, error) {
+	local, err := p.Local()
+	if err != nil {
+		return nil, err
+	}
+	replicated, err := p.Replicated(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if replicated == nil || (local != nil && local.above(*replicated)) {
+		return local, nil
+	}
+	return replicated, nil
+}
+
+// Guard blocks until the local node has finalized a block past the best block of the highest record, so that it never validates at
+// heights another node, or this node with the same volume, already validated at. It fails if the record of the same node advances
+// meanwhile, as it means that node is still validating.
+func (p *Protection) Guard(ctx context.Context) error {
+	record, err := p.Highest(ctx)
+	for err != nil {
+		p.logf("ERROR! Unable to read the slashing-protection record: %s", err)
+		if !sleep(ctx, p.Interval) {
+			return ctx.Err()
+		}
+		record, err = p.Highest(ctx)
+	}
+	if record == nil {
+		p.logf("INFO. No slashing-protection record yet, nobody has validated before")
+		return nil
+	}
+
+	for {
+		finalized, err := p.Finalized(ctx)
+		if err != nil {
+			p.logf("ERROR! Unable to get the finalized block: %s", err)
+		} else if finalized > record.Best {
+			p.logf("INFO. Finalized block %d is past block %d validated by %s under epoch %d in session %d", finalized, record.Best, record.Node, record.Epoch, record.Session)
+			return nil
+		} else {
+			p.logf("INFO. Refusing to validate: %s validated up to block %d under epoch %d in session %d, finalized block is %d", record.Node, record.Best, record.Epoch, record.Session, finalized)
+		}
+		if !sleep(ctx, p.Interval) {
+			return ctx.Err()
+		}
+
+		current, err := p.Highest(ctx)
+		if err != nil {
+			p.logf("ERROR! Unable to read the slashing-protection record: %s", err)
+		} else if current != nil && current.Best > record.Best {
+			if current.Node == record.Node {
+				return fmt.Errorf("%w: %s validated block %d after block %d", ErrRecordAdvanced, current.Node, current.Best, record.Best)
+			}
+			// A higher record of another node, e.g. restored from its volume, has to be passed as well
+			record = current
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package election
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtectionRecordFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protection")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	protection := &Protection{File: filepath.Join(dir, "failover-protection.json")}
+
+	record, err := protection.Local()
+	require.NoError(t, err)
+	assert.Nil(t, record, "a node that never validated has no record")
+
+	saved := ProtectionRecord{Node: "validator", Epoch: 3, Session: 7, Finalized: 100, Best: 102, UpdatedAt: time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)}
+	require.NoError(t, protection.save(saved))
+	record, err = protection.Local()
+	require.NoError(t, err)
+	assert.Equal(t, saved, *record)
+
+	// The file is replaced, no temporary file is left behind
+	saved.Finalized, saved.Best = 101, 103
+	require.NoError(t, protection.save(saved))
+	record, err = protection.Local()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(103), record.Best)
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	require.NoError(t, ioutil.WriteFile(protection.File, []byte("100"), 0644))
+	_, err = protection.Local()
+	assert.Error(t, err)
+}
+
+func TestProtectionRecordOrder(t *testing.T) {
+	record := ProtectionRecord{Finalized: 100, Best: 102}
+	assert.True(t, ProtectionRecord{Finalized: 90, Best: 103}.above(record))
+	assert.True(t, ProtectionRecord{Finalized: 101, Best: 102}.above(record))
+	assert.False(t, ProtectionRecord{Finalized: 100, Best: 102}.above(record))
+	assert.False(t, ProtectionRecord{Finalized: 110, Best: 101}.above(record))
+}
@@ -0,0 +1,188 @@
+// Package failover models the states a node of the deployment goes through and the side effects every transition requires.
+//
+// The model is derived from the bootstrap script: a node attaches its disk and joins the cluster, syncs as a regular full node,
+// contends for the validator lock, waits for the double-signing guard, inserts the keys and validates until it loses the lock or
+// is stopped. The package has no Consul, Docker or AWS code, it only decides what must happen next, so every transition can be tested.
+//
+// The main safety property is that keys are only inserted and the validator is only started on the transition from Guarding to
+// Validating, i.e. while the lock is held and after the guard has passed. The elector of the agent is replayed on the model in its tests,
+// see agent/election/model_test.go, so every stage and hook of the elector has to have a counterpart here.
+package failover
+
+import "fmt"
+
+// State of a node
+type State string
+
+// States of a node
+const (
+	// Bootstrapping: the disk is being attached, the node container started and the Consul cluster joined
+	StateBootstrapping State = "Bootstrapping"
+	// Syncing: the node runs as a full node and is catching up with the chain, or is not healthy
+	StateSyncing State = "Syncing"
+	// Standby: the node is synced and healthy, but does not contend for the lock yet
+	StateStandby State = "Standby"
+	// Candidate: the node is waiting for the validator lock
+	StateCandidate State = "Candidate"
+	// Guarding: the node holds the lock and waits until it has finalized a block past the last block of the previous validator
+	StateGuarding State = "Guarding"
+	// Validating: the node holds the lock and runs with the validator keys
+	StateValidating State = "Validating"
+	// Fenced: the node must never validate again, e.g. because another validator may still be running. It waits to be terminated
+	StateFenced State = "Fenced"
+	// Draining: the node is shutting down. It releases whatever it holds and never validates again
+	StateDraining State = "Draining"
+)
+
+// States lists all the states
+var States = []State{StateBootstrapping, StateSyncing, StateStandby, StateCandidate, StateGuarding, StateValidating, StateFenced, StateDraining}
+
+// Event is something the node observed
+type Event string
+
+// Events driving the state machine
+const (
+	// EventBootstrapped: the disk is attached, the node container runs and the Consul cluster is joined
+	EventBootstrapped Event = "Bootstrapped"
+	// EventSynced: the node caught up with the chain and is healthy
+	EventSynced Event = "Synced"
+	// EventEligible: the node may contend for the lock now, e.g. its priority delay passed
+	EventEligible Event = "Eligible"
+	// EventLockAcquired: the validator lock was granted to the node
+	EventLockAcquired Event = "LockAcquired"
+	// EventLockLost: the session of the node was invalidated or the lock was taken away
+	EventLockLost Event = "LockLost"
+	// EventGuardPassed: the node finalized a block past the last block published by the previous validator
+	EventGuardPassed Event = "GuardPassed"
+	// EventBestBlockChanged: best_block was updated by another node, i.e. another validator is running
+	EventBestBlockChanged Event = "BestBlockChanged"
+	// EventNodeUnhealthy: the node container is not running, does not answer RPC calls or fell behind
+	EventNodeUnhealthy Event = "NodeUnhealthy"
+	// EventShutdown: the agent was asked to stop, e.g. the instance is being terminated
+	EventShutdown Event = "Shutdown"
+)
+
+// Events lists all the events
+var Events = []Event{EventBootstrapped, EventSynced, EventEligible, EventLockAcquired, EventLockLost, EventGuardPassed, EventBestBlockChanged, EventNodeUnhealthy, EventShutdown}
+
+// Action is a side effect the caller must perform, in the order they are returned
+type Action string
+
+// Actions requested by the transitions
+const (
+	// ActionAcquireLock: start waiting for the validator lock
+	ActionAcquireLock Action = "AcquireLock"
+	// ActionReleaseLock: release the lock or stop waiting for it
+	ActionReleaseLock Action = "ReleaseLock"
+	// ActionStartGuard: start watching best_block and the finalized block of the node
+	ActionStartGuard Action = "StartGuard"
+	// ActionInsertKeys: put the session keys into the keystore of the node
+	ActionInsertKeys Action = "InsertKeys"
+	// ActionStartValidator: restart the node with the --validator flag
+	ActionStartValidator Action = "StartValidator"
+	// ActionPublishBestBlock: start publishing the finalized block of the node to best_block
+	ActionPublishBestBlock Action = "PublishBestBlock"
+	// ActionStopValidator: stop publishing best_block and restart the node as a full node
+	ActionStopValidator Action = "StopValidator"
+	// ActionShutdown: terminate the instance, so the autoscaling group replaces it
+	ActionShutdown Action = "Shutdown"
+)
+
+type transition struct {
+	to      State
+	actions []Action
+}
+
+// table holds every allowed transition. Events missing from the table of a state are ignored
+var table = map[State]map[Event]transition{
+	StateBootstrapping: {
+		EventBootstrapped: {StateSyncing, nil},
+		EventShutdown:     {StateDraining, nil},
+	},
+	StateSyncing: {
+		EventSynced:   {StateStandby, nil},
+		EventShutdown: {StateDraining, nil},
+	},
+	StateStandby: {
+		EventEligible:      {StateCandidate, []Action{ActionAcquireLock}},
+		EventNodeUnhealthy: {StateSyncing, nil},
+		EventShutdown:      {StateDraining, nil},
+	},
+	StateCandidate: {
+		EventLockAcquired:  {StateGuarding, []Action{ActionStartGuard}},
+		EventNodeUnhealthy: {StateSyncing, []Action{ActionReleaseLock}},
+		EventShutdown:      {StateDraining, []Action{ActionReleaseLock}},
+	},
+	StateGuarding: {
+		EventGuardPassed:      {StateValidating, []Action{ActionInsertKeys, ActionStartValidator, ActionPublishBestBlock}},
+		EventLockLost:         {StateStandby, nil},
+		EventBestBlockChanged: {StateFenced, []Action{ActionReleaseLock, ActionShutdown}},
+		EventNodeUnhealthy:    {StateSyncing, []Action{ActionReleaseLock}},
+		EventShutdown:         {StateDraining, []Action{ActionReleaseLock}},
+	},
+	StateValidating: {
+		EventLockLost:         {StateStandby, []Action{ActionStopValidator}},
+		EventBestBlockChanged: {StateFenced, []Action{ActionStopValidator, ActionReleaseLock, ActionShutdown}},
+		EventNodeUnhealthy:    {StateSyncing, []Action{ActionStopValidator, ActionReleaseLock}},
+		EventShutdown:         {StateDraining, []Action{ActionStopValidator, ActionReleaseLock}},
+	},
+	StateFenced: {
+		EventShutdown: {StateDraining, nil},
+	},
+	StateDraining: {},
+}
+
+// holdsLock reports whether a node in the state holds or waits for the lock
+func holdsLock(s State) bool {
+	return s == StateCandidate || s == StateGuarding || s == StateValidating
+}
+
+// Next returns the state the node moves to on the event and the actions to perform. Events that are not expected in the state
+// leave it unchanged. A lock granted to a node that no longer wants it is released right away.
+func Next(s State, e Event) (State, []Action) {
+	if _, ok := table[s]; !ok {
+		panic(fmt.Sprintf("unknown state %q", s))
+	}
+
+	t, ok := table[s][e]
+	if !ok {
+		if e == EventLockAcquired && !holdsLock(s) {
+			// A late grant of a lock the node stopped waiting for
+			return s, []Action{ActionReleaseLock}
+		}
+		return s, nil
+	}
+	return t.to, append([]Action(nil), t.actions...)
+}
+
+// Machine keeps the state of a node and the history of its transitions
+type Machine struct {
+	state   State
+	History []Step
+}
+
+// Step is a single transition of a Machine
+type Step struct {
+	From    State
+	Event   Event
+	To      State
+	Actions []Action
+}
+
+// NewMachine creates a machine in the Bootstrapping state
+func NewMachine() *Machine {
+	return &Machine{state: StateBootstrapping}
+}
+
+// State returns the current state
+func (m *Machine) State() State {
+	return m.state
+}
+
+// Handle applies the event and returns the actions to perform
+func (m *Machine) Handle(e Event) []Action {
+	to, actions := Next(m.state, e)
+	m.History = append(m.History, Step{From: m.state, Event: e, To: to, Actions: actions})
+	m.state = to
+	return actions
+}
@@ -0,0 +1,181 @@
+package failover
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type key struct {
+	state State
+	event Event
+}
+
+// expected lists every transition that changes the state or requests actions. Any other pair must be ignored
+var expected = map[key]transition{
+	{StateBootstrapping, EventBootstrapped}: {StateSyncing, nil},
+	{StateBootstrapping, EventShutdown}:     {StateDraining, nil},
+	{StateBootstrapping, EventLockAcquired}: {StateBootstrapping, []Action{ActionReleaseLock}},
+
+	{StateSyncing, EventSynced}:       {StateStandby, nil},
+	{StateSyncing, EventShutdown}:     {StateDraining, nil},
+	{StateSyncing, EventLockAcquired}: {StateSyncing, []Action{ActionReleaseLock}},
+
+	{StateStandby, EventEligible}:      {StateCandidate, []Action{ActionAcquireLock}},
+	{StateStandby, EventNodeUnhealthy}: {StateSyncing, nil},
+	{StateStandby, EventShutdown}:      {StateDraining, nil},
+	{StateStandby, EventLockAcquired}:  {StateStandby, []Action{ActionReleaseLock}},
+
+	{StateCandidate, EventLockAcquired}:  {StateGuarding, []Action{ActionStartGuard}},
+	{StateCandidate, EventNodeUnhealthy}: {StateSyncing, []Action{ActionReleaseLock}},
+	{StateCandidate, EventShutdown}:      {StateDraining, []Action{ActionReleaseLock}},
+
+	{StateGuarding, EventGuardPassed}:      {StateValidating, []Action{ActionInsertKeys, ActionStartValidator, ActionPublishBestBlock}},
+	{StateGuarding, EventLockLost}:         {StateStandby, nil},
+	{StateGuarding, EventBestBlockChanged}: {StateFenced, []Action{ActionReleaseLock, ActionShutdown}},
+	{StateGuarding, EventNodeUnhealthy}:    {StateSyncing, []Action{ActionReleaseLock}},
+	{StateGuarding, EventShutdown}:         {StateDraining, []Action{ActionReleaseLock}},
+
+	{StateValidating, EventLockLost}:         {StateStandby, []Action{ActionStopValidator}},
+	{StateValidating, EventBestBlockChanged}: {StateFenced, []Action{ActionStopValidator, ActionReleaseLock, ActionShutdown}},
+	{StateValidating, EventNodeUnhealthy}:    {StateSyncing, []Action{ActionStopValidator, ActionReleaseLock}},
+	{StateValidating, EventShutdown}:         {StateDraining, []Action{ActionStopValidator, ActionReleaseLock}},
+
+	{StateFenced, EventShutdown}:     {StateDraining, nil},
+	{StateFenced, EventLockAcquired}: {StateFenced, []Action{ActionReleaseLock}},
+
+	{StateDraining, EventLockAcquired}: {StateDraining, []Action{ActionReleaseLock}},
+}
+
+func TestEveryTransition(t *testing.T) {
+	for _, state := range States {
+		for _, event := range Events {
+			t.Run(string(state)+"/"+string(event), func(t *testing.T) {
+				want, ok := expected[key{state, event}]
+				if !ok {
+					want = transition{to: state}
+				}
+
+				to, actions := Next(state, event)
+				assert.Equal(t, want.to, to)
+				assert.Equal(t, want.actions, actions)
+			})
+		}
+	}
+}
+
+func contains(actions []Action, action Action) bool {
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func TestKeysOnlyOnTheValidatingPath(t *testing.T) {
+	for _, state := range States {
+		for _, event := range Events {
+			to, actions := Next(state, event)
+			if contains(actions, ActionInsertKeys) || contains(actions, ActionStartValidator) {
+				assert.Equal(t, StateGuarding, state, "keys used on %s/%s", state, event)
+				assert.Equal(t, EventGuardPassed, event, "keys used on %s/%s", state, event)
+				assert.Equal(t, StateValidating, to, "keys used on %s/%s", state, event)
+			}
+		}
+	}
+}
+
+func TestLeavingValidatingStopsTheValidator(t *testing.T) {
+	for _, event := range Events {
+		to, actions := Next(StateValidating, event)
+		if to != StateValidating {
+			assert.True(t, contains(actions, ActionStopValidator), "validator keeps running on %s", event)
+		}
+	}
+}
+
+// reachable returns the states that can be reached from the state by any sequence of events
+func reachable(from State) map[State]bool {
+	seen := map[State]bool{from: true}
+	queue := []State{from}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, event := range Events {
+			to, _ := Next(state, event)
+			if !seen[to] {
+				seen[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	return seen
+}
+
+func TestFencedNodesNeverValidate(t *testing.T) {
+	assert.False(t, reachable(StateFenced)[StateValidating])
+	assert.False(t, reachable(StateDraining)[StateValidating])
+	assert.True(t, reachable(StateBootstrapping)[StateValidating])
+}
+
+// TestRandomWalks feeds random sequences of events and checks the invariants every caller relies on:
+// keys are only inserted while the lock is held after the guard passed, and the lock is only held in the states that expect it.
+func TestRandomWalks(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for walk := 0; walk < 2000; walk++ {
+		m := NewMachine()
+		lockRequested, lockHeld, guardPassed, validating := false, false, false, false
+
+		for step := 0; step < 50; step++ {
+			event := Events[random.Intn(len(Events))]
+
+			// The environment only grants a lock that was requested and only takes away a lock that is held
+			if event == EventLockAcquired && !lockRequested {
+				continue
+			}
+			if event == EventLockLost && !lockHeld {
+				continue
+			}
+
+			from := m.State()
+			actions := m.Handle(event)
+
+			switch event {
+			case EventLockAcquired:
+				lockHeld, lockRequested = true, false
+			case EventLockLost:
+				lockHeld = false
+			case EventGuardPassed:
+				guardPassed = from == StateGuarding
+			}
+
+			for _, action := range actions {
+				switch action {
+				case ActionAcquireLock:
+					lockRequested = true
+				case ActionReleaseLock:
+					lockRequested, lockHeld = false, false
+				case ActionStartGuard:
+					guardPassed = false
+				case ActionInsertKeys, ActionStartValidator:
+					if !lockHeld || !guardPassed {
+						t.Fatalf("walk %d: %s without the lock or the guard: %v", walk, action, m.History)
+					}
+					validating = true
+				case ActionStopValidator:
+					validating = false
+				}
+			}
+
+			if validating != (m.State() == StateValidating) {
+				t.Fatalf("walk %d: validator running: %v in state %s: %v", walk, validating, m.State(), m.History)
+			}
+			if lockHeld && !holdsLock(m.State()) {
+				t.Fatalf("walk %d: lock held in state %s: %v", walk, m.State(), m.History)
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package keys inserts the validator session keys stored in SSM into the keystore of the local node, replacing key-insert.sh.
+//
+// Every key is stored under /polkadot/validator-failover/<prefix>/keys/<name>/ as three parameters: key (the public key), seed
+// (a SecureString) and type. Seeds are only ever sent to the node: they are never logged and never part of an error.
+package keys
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// Path returns the SSM path the keys of the deployment are stored under
+func Path(prefix string) string {
+	return "/polkadot/validator-failover/" + prefix + "/keys/"
+}
+
+// Key is a single session key
+type Key struct {
+	Name   string
+	Type   string
+	Public string
+	seed   string
+}
+
+// String describes the key without its seed
+func (k Key) String() string {
+	return fmt.Sprintf("%s (%s %s)", k.Name, k.Type, k.Public)
+}
+
+// GoString makes %#v as safe as %v
+func (k Key) GoString() string {
+	return k.String()
+}
+
+// Store reads the keys of a deployment from SSM
+type Store struct {
+	SSM    ssmiface.SSMAPI
+	Prefix string
+}
+
+// Load fetches all the keys in bulk with decryption. Keys missing one of the key, seed and type parameters are reported as an error
+func (s *Store) Load(ctx context.Context) ([]Key, error) {
+	path := Path(s.Prefix)
+	byName := make(map[string]*Key)
+	var missing []string
+
+	input := &ssm.GetParametersByPathInput{Path: aws.String(path), Recursive: aws.Bool(true), WithDecryption: aws.Bool(true)}
+	err := s.SSM.GetParametersByPathPagesWithContext(ctx, input, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+		for _, parameter := range page.Parameters {
+			parts := strings.Split(strings.TrimPrefix(aws.StringValue(parameter.Name), path), "/")
+			if len(parts) != 2 {
+				continue
+			}
+			key, ok := byName[parts[0]]
+			if !ok {
+				key = &Key{Name: parts[0]}
+				byName[parts[0]] = key
+			}
+			switch parts[1] {
+			case "key":
+				key.Public = aws.StringValue(parameter.Value)
+			case "seed":
+				key.seed = aws.StringValue(parameter.Value)
+			case "type":
+				key.Type = aws.StringValue(parameter.Value)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", path, err)
+	}
+
+	var result []Key
+	for _, key := range byName {
+		if key.Public == "" || key.seed == "" || key.Type == "" {
+			missing = append(missing, key.Name)
+			continue
+		}
+		result = append(result, *key)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("keys %s under %s lack one of the key, seed and type parameters", strings.Join(missing, ", "), path)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no keys found under %s", path)
+	}
+	return result, nil
+}
+
+// Keystore is the part of the node RPC the insertion needs, implemented by *polkadot.Client
+type Keystore interface {
+	InsertKey(ctx context.Context, keyType, seed, publicKey string) error
+	HasKey(ctx context.Context, publicKey, keyType string) (bool, error)
+}
+
+// MissingKeysError lists the keys the node does not have after the insertion
+type MissingKeysError struct {
+	Keys []Key
+}
+
+func (e *MissingKeysError) Error() string {
+	var names []string
+	for _, key := range e.Keys {
+		names = append(names, key.String())
+	}
+	return "keys missing from the keystore after insertion: " + strings.Join(names, ", ")
+}
+
+// Insert puts every key into the keystore and confirms it with author_hasKey. It returns a *MissingKeysError if any key is not
+// in the keystore afterwards, so the node must not start validating
+func Insert(ctx context.Context, keystore Keystore, keys []Key, logf func(format string, args ...interface{})) error {
+	var missing []Key
+
+	for _, key := range keys {
+		if err := keystore.InsertKey(ctx, key.Type, key.seed, key.Public); err != nil {
+			logf("ERROR! Unable to insert key %s: %s", key, err)
+			missing = append(missing, key)
+			continue
+		}
+
+		found, err := keystore.HasKey(ctx, key.Public, key.Type)
+		if err != nil {
+			logf("ERROR! Unable to verify key %s: %s", key, err)
+			missing = append(missing, key)
+			continue
+		}
+		if !found {
+			logf("ERROR! Key %s is not in the keystore after insertion", key)
+			missing = append(missing, key)
+			continue
+		}
+		logf("INFO. Inserted key %s", key)
+	}
+
+	if len(missing) > 0 {
+		return &MissingKeysError{Keys: missing}
+	}
+	return nil
+}
+
+// Inserter loads the keys from SSM and inserts them, it is the key insertion stage of the election
+type Inserter struct {
+	Store    *Store
+	Keystore Keystore
+	Logf     func(format string, args ...interface{})
+}
+
+// Insert loads and inserts all the keys
+func (i *Inserter) Insert(ctx context.Context) error {
+	logf := i.Logf
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+
+	keys, err := i.Store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	return Insert(ctx, i.Keystore, keys, logf)
+}
@@ -0,0 +1,161 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const seed = "0xsecretseed"
+
+// fakeSSM returns the parameters in pages of two
+type fakeSSM struct {
+	ssmiface.SSMAPI
+	parameters map[string]string
+	inputs     []*ssm.GetParametersByPathInput
+}
+
+func (f *fakeSSM) GetParametersByPathPagesWithContext(ctx aws.Context, input *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool, opts ...request.Option) error {
+	f.inputs = append(f.inputs, input)
+	var page []*ssm.Parameter
+	for name, value := range f.parameters {
+		page = append(page, &ssm.Parameter{Name: aws.String(name), Value: aws.String(value)})
+		if len(page) == 2 {
+			fn(&ssm.GetParametersByPathOutput{Parameters: page}, false)
+			page = nil
+		}
+	}
+	fn(&ssm.GetParametersByPathOutput{Parameters: page}, true)
+	return nil
+}
+
+func (f *fakeSSM) PutParameterWithContext(ctx aws.Context, input *ssm.PutParameterInput, opts ...request.Option) (*ssm.PutParameterOutput, error) {
+	if _, ok := f.parameters[aws.StringValue(input.Name)]; ok && !aws.BoolValue(input.Overwrite) {
+		return nil, errors.New("ParameterAlreadyExists")
+	}
+	f.parameters[aws.StringValue(input.Name)] = aws.StringValue(input.Value)
+	return &ssm.PutParameterOutput{}, nil
+}
+
+func parameters(names ...string) map[string]string {
+	result := make(map[string]string)
+	for _, name := range names {
+		path := Path("test") + name + "/"
+		result[path+"key"] = "0x" + name
+		result[path+"seed"] = seed
+		result[path+"type"] = name[:4]
+	}
+	return result
+}
+
+// fakeKeystore keeps the inserted keys. Keys in drop are accepted by InsertKey but never stored
+type fakeKeystore struct {
+	keys map[string]string
+	drop map[string]bool
+	err  error
+}
+
+func (f *fakeKeystore) InsertKey(ctx context.Context, keyType, seed, publicKey string) error {
+	if f.err != nil {
+		return f.err
+	}
+	if !f.drop[publicKey] {
+		f.keys[publicKey] = keyType
+	}
+	return nil
+}
+
+func (f *fakeKeystore) HasKey(ctx context.Context, publicKey, keyType string) (bool, error) {
+	return f.keys[publicKey] == keyType, nil
+}
+
+// logs collects the log output of the insertion
+type logs struct {
+	lines []string
+}
+
+func (l *logs) logf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestLoadGroupsParameters(t *testing.T) {
+	client := &fakeSSM{parameters: parameters("babe", "gran", "imon", "audi")}
+	store := &Store{SSM: client, Prefix: "test"}
+
+	keys, err := store.Load(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, client.inputs, 1)
+	assert.True(t, aws.BoolValue(client.inputs[0].WithDecryption))
+	assert.True(t, aws.BoolValue(client.inputs[0].Recursive))
+
+	require.Len(t, keys, 4)
+	assert.Equal(t, "audi", keys[0].Name)
+	assert.Equal(t, "0xaudi", keys[0].Public)
+	assert.Equal(t, "audi", keys[0].Type)
+	assert.Equal(t, seed, keys[0].seed)
+}
+
+func TestLoadRejectsIncompleteKeys(t *testing.T) {
+	params := parameters("babe", "gran")
+	delete(params, Path("test")+"gran/seed")
+
+	_, err := (&Store{SSM: &fakeSSM{parameters: params}, Prefix: "test"}).Load(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "gran")
+
+	_, err = (&Store{SSM: &fakeSSM{parameters: map[string]string{}}, Prefix: "test"}).Load(context.Background())
+	assert.Error(t, err)
+}
+
+func TestInsertVerifiesEveryKey(t *testing.T) {
+	keys, err := (&Store{SSM: &fakeSSM{parameters: parameters("babe", "gran")}, Prefix: "test"}).Load(context.Background())
+	require.NoError(t, err)
+
+	keystore := &fakeKeystore{keys: map[string]string{}}
+	l := &logs{}
+	require.NoError(t, Insert(context.Background(), keystore, keys, l.logf))
+	assert.Equal(t, map[string]string{"0xbabe": "babe", "0xgran": "gran"}, keystore.keys)
+}
+
+func TestMissingKeyFailsInsertion(t *testing.T) {
+	keys, err := (&Store{SSM: &fakeSSM{parameters: parameters("babe", "gran")}, Prefix: "test"}).Load(context.Background())
+	require.NoError(t, err)
+
+	keystore := &fakeKeystore{keys: map[string]string{}, drop: map[string]bool{"0xgran": true}}
+	l := &logs{}
+	err = Insert(context.Background(), keystore, keys, l.logf)
+
+	var missing *MissingKeysError
+	require.True(t, errors.As(err, &missing))
+	require.Len(t, missing.Keys, 1)
+	assert.Equal(t, "gran", missing.Keys[0].Name)
+
+	keystore = &fakeKeystore{err: errors.New("connection refused")}
+	err = Insert(context.Background(), keystore, keys, l.logf)
+	require.True(t, errors.As(err, &missing))
+	assert.Len(t, missing.Keys, 2)
+}
+
+func TestSeedsAreNeverPrinted(t *testing.T) {
+	keys, err := (&Store{SSM: &fakeSSM{parameters: parameters("babe")}, Prefix: "test"}).Load(context.Background())
+	require.NoError(t, err)
+
+	l := &logs{}
+	err = Insert(context.Background(), &fakeKeystore{keys: map[string]string{}, drop: map[string]bool{"0xbabe": true}}, keys, l.logf)
+	require.Error(t, err)
+
+	printed := append(l.lines, err.Error(), fmt.Sprint(keys), fmt.Sprintf("%+v", keys[0]), fmt.Sprintf("%#v", keys[0]))
+	for _, line := range printed {
+		assert.False(t, strings.Contains(line, seed), "seed printed in %q", line)
+	}
+}
@@ -0,0 +1,129 @@
+package keys
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// DefaultSessionTypes are the types of the session keys in the order of the SessionKeys of the Kusama and Polkadot runtimes:
+// GRANDPA, BABE, I'm online, parachain validator and authority discovery
+var DefaultSessionTypes = []string{"gran", "babe", "imon", "para", "audi"}
+
+// NewKey returns a key with its seed, e.g. one that was just generated
+func NewKey(name, keyType, public, seed string) Key {
+	return Key{Name: name, Type: keyType, Public: public, seed: seed}
+}
+
+// Scheme returns the signature scheme of the key type, GRANDPA keys are ed25519 and all the others sr25519
+func Scheme(keyType string) string {
+	if keyType == "gran" {
+		return "ed25519"
+	}
+	return "sr25519"
+}
+
+// SessionKeys concatenates the public keys into the blob the setKeys extrinsic of the session pallet takes. The keys have to be in the
+// order of the SessionKeys of the runtime
+func SessionKeys(keys []Key) string {
+	blob := "0x"
+	for _, key := range keys {
+		blob += strings.TrimPrefix(key.Public, "0x")
+	}
+	return blob
+}
+
+// SplitSessionKeys splits the blob author_rotateKeys returns into the 32 bytes public keys of the given types
+func SplitSessionKeys(blob string, types []string) ([]string, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(blob, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("session keys are not hex encoded: %w", err)
+	}
+	if len(raw) != 32*len(types) {
+		return nil, fmt.Errorf("session keys are %d bytes long, %d expected for %s", len(raw), 32*len(types), strings.Join(types, ", "))
+	}
+
+	var publics []string
+	for i := range types {
+		publics = append(publics, "0x"+hex.EncodeToString(raw[32*i:32*(i+1)]))
+	}
+	return publics, nil
+}
+
+// KeystoreFile returns the name of the file the node keeps the key in, inside the keystore directory of the chain
+func KeystoreFile(keyType, public string) string {
+	return hex.EncodeToString([]byte(keyType)) + strings.TrimPrefix(public, "0x")
+}
+
+// FromKeystoreFile returns the key kept in the content of a keystore file, a JSON string holding the seed or the phrase
+func FromKeystoreFile(name, keyType, public string, content []byte) (Key, error) {
+	var seed string
+	if err := json.Unmarshal(content, &seed); err != nil || seed == "" {
+		return Key{}, fmt.Errorf("keystore file of %s %s does not hold a seed", keyType, public)
+	}
+	return NewKey(name, keyType, public, seed), nil
+}
+
+// InsertRequest returns the author_insertKey JSON-RPC request of the key. It holds the seed, so it must only ever be sent to the node
+func InsertRequest(key Key) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  "author_insertKey",
+		"params":  []string{key.Type, key.seed, key.Public},
+	})
+}
+
+// Rename names the rotated keys after the stored keys of the same type, so that the rotated keys replace them. Keys of a type that is
+// not stored yet are named after their type
+func Rename(rotated, stored []Key) []Key {
+	names := make(map[string]string)
+	for _, key := range stored {
+		if _, ok := names[key.Type]; !ok {
+			names[key.Type] = key.Name
+		}
+	}
+
+	result := make([]Key, len(rotated))
+	for i, key := range rotated {
+		key.Name = names[key.Type]
+		if key.Name == "" {
+			key.Name = key.Type
+		}
+		result[i] = key
+	}
+	return result
+}
+
+// Save stores the keys the way Load reads them, overwriting the keys of the same name. The public key is written last, so that an
+// interrupted save leaves a key whose parameters do not match rather than the previous public key with a new seed
+func (s *Store) Save(ctx context.Context, keys []Key) error {
+	path := Path(s.Prefix)
+	for _, key := range keys {
+		parameters := []struct {
+			name, value, kind, description string
+		}{
+			{"type", key.Type, ssm.ParameterTypeString, "Validator key type"},
+			{"seed", key.seed, ssm.ParameterTypeSecureString, "Validator private seed"},
+			{"key", key.Public, ssm.ParameterTypeString, "Validator key"},
+		}
+		for _, parameter := range parameters {
+			_, err := s.SSM.PutParameterWithContext(ctx, &ssm.PutParameterInput{
+				Name:        aws.String(path + key.Name + "/" + parameter.name),
+				Value:       aws.String(parameter.value),
+				Type:        aws.String(parameter.kind),
+				Description: aws.String(parameter.description),
+				Overwrite:   aws.Bool(true),
+			})
+			if err != nil {
+				return fmt.Errorf("unable to store %s of key %s: %w", parameter.name, key, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package keys
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionKeys(t *testing.T) {
+	gran, babe := "0x"+strings.Repeat("11", 32), "0x"+strings.Repeat("22", 32)
+	blob := SessionKeys([]Key{{Type: "gran", Public: gran}, {Type: "babe", Public: babe}})
+	assert.Equal(t, "0x"+strings.Repeat("11", 32)+strings.Repeat("22", 32), blob)
+
+	publics, err := SplitSessionKeys(blob, []string{"gran", "babe"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{gran, babe}, publics)
+
+	_, err = SplitSessionKeys(blob, DefaultSessionTypes)
+	assert.EqualError(t, err, "session keys are 64 bytes long, 160 expected for gran, babe, imon, para, audi")
+	_, err = SplitSessionKeys("0xzz", []string{"gran"})
+	assert.Error(t, err)
+}
+
+func TestKeystoreFile(t *testing.T) {
+	public := "0x" + strings.Repeat("ab", 32)
+	assert.Equal(t, "6772616e"+strings.Repeat("ab", 32), KeystoreFile("gran", public))
+
+	key, err := FromKeystoreFile("gran", "gran", public, []byte(`"`+seed+`"`))
+	require.NoError(t, err)
+	assert.Equal(t, seed, key.seed)
+
+	_, err = FromKeystoreFile("gran", "gran", public, []byte(""))
+	assert.Error(t, err)
+}
+
+func TestSaveReplacesKeysOfTheSameType(t *testing.T) {
+	client := &fakeSSM{parameters: parameters("babe", "gran")}
+	store := &Store{SSM: client, Prefix: "test"}
+	stored, err := store.Load(context.Background())
+	require.NoError(t, err)
+
+	rotated := Rename([]Key{
+		NewKey("gran", "gran", "0xnewgran", "new gran seed"),
+		NewKey("imon", "imon", "0xnewimon", "new imon seed"),
+	}, stored)
+	require.NoError(t, store.Save(context.Background(), rotated))
+
+	loaded, err := store.Load(context.Background())
+	require.NoError(t, err)
+	require.Len(t, loaded, 3)
+	assert.Equal(t, NewKey("babe", "babe", "0xbabe", seed), loaded[0])
+	assert.Equal(t, NewKey("gran", "gran", "0xnewgran", "new gran seed"), loaded[1])
+	assert.Equal(t, NewKey("imon", "imon", "0xnewimon", "new imon seed"), loaded[2])
+}
+
+func TestRenameKeepsStoredNames(t *testing.T) {
+	stored := []Key{{Name: "key", Type: "gran"}, {Name: "key2", Type: "babe"}}
+	renamed := Rename([]Key{{Name: "gran", Type: "gran"}, {Name: "babe", Type: "babe"}, {Name: "audi", Type: "audi"}}, stored)
+	assert.Equal(t, "key", renamed[0].Name)
+	assert.Equal(t, "key2", renamed[1].Name)
+	assert.Equal(t, "audi", renamed[2].Name)
+}
@@ -0,0 +1,364 @@
+// Package metrics samples the local Polkadot node and publishes the same CloudWatch metrics the watcher.sh cron job used to publish:
+// "Health report" and "Validator count" per autoscaling group and "Block Number" per instance, to every region of the deployment.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/polkadot"
+)
+
+// Names of the metrics. CloudWatch alarms created by Terraform refer to them, so they must never change
+const (
+	MetricHealth      = "Health report"
+	MetricBlockNumber = "Block Number"
+	MetricValidator   = "Validator count"
+)
+
+// HealthFailure is the value of "Health report" sent when the node cannot run at all, the same value the bootstrap script sends on failure
+const HealthFailure = 1000
+
+const (
+	// MaxDatumsPerRequest is the number of datums sent in a single PutMetricData call
+	MaxDatumsPerRequest = 20
+	// DefaultMaxPending bounds the datums kept per region while CloudWatch in that region is not reachable. The oldest datums are dropped first
+	DefaultMaxPending = 600
+)
+
+// Sample is a single observation of the local node
+type Sample struct {
+	Time time.Time
+	// Healthy is true if the node container runs and answers RPC calls
+	Healthy bool
+	// BlockNumber is the best block of the node. It is not published if HasBlock is false
+	BlockNumber uint64
+	HasBlock    bool
+	// Validator is true if the node runs with the Authority role
+	Validator bool
+}
+
+// Publisher aggregates samples into one datum per metric per minute and sends them to CloudWatch in every region, batching all the datums
+// of a region into as few calls as possible.
+//
+// The alarms created by Terraform evaluate one-minute periods using the Sum and SampleCount statistics, which watcher.sh fed with exactly one
+// sample per minute. Aggregating keeps these statistics meaningful whatever the sampling interval is: within a minute the node is reported
+// unhealthy if any sample was unhealthy, as a validator if any sample saw the Authority role, and with the highest block seen.
+type Publisher struct {
+	Namespace        string
+	AutoScalingGroup string
+	InstanceID       string
+	// Clients maps a region name to the CloudWatch client of that region
+	Clients map[string]cloudwatchiface.CloudWatchAPI
+	// MaxPending bounds the buffer of each region. DefaultMaxPending is used if zero
+	MaxPending int
+	// Now is used to decide whether the current minute is over. time.Now is used if nil
+	Now func() time.Time
+
+	mu      sync.Mutex
+	current *Sample
+	pending map[string][]*cloudwatch.MetricDatum
+}
+
+// RegionError lists the regions a flush failed for
+type RegionError struct {
+	Errors map[string]error
+}
+
+func (e *RegionError) Error() string {
+	var regions []string
+	for region := range e.Errors {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	var messages []string
+	for _, region := range regions {
+		messages = append(messages, region+": "+e.Errors[region].Error())
+	}
+	return "unable to publish metrics to " + strings.Join(messages, "; ")
+}
+
+// Datums converts the sample into CloudWatch datums using the dimensions the alarms expect
+func (p *Publisher) Datums(s Sample) []*cloudwatch.MetricDatum {
+	asg := []*cloudwatch.Dimension{{Name: aws.String("AutoScalingGroupName"), Value: aws.String(p.AutoScalingGroup)}}
+
+	health := float64(0)
+	if !s.Healthy {
+		health = 1
+	}
+	validator := float64(0)
+	if s.Validator {
+		validator = 1
+	}
+
+	datums := []*cloudwatch.MetricDatum{
+		{MetricName: aws.String(MetricHealth), Dimensions: asg, Timestamp: aws.Time(s.Time), Value: aws.Float64(health)},
+		{MetricName: aws.String(MetricValidator), Dimensions: asg, Timestamp: aws.Time(s.Time), Value: aws.Float64(validator)},
+	}
+	if s.HasBlock {
+		datums = append(datums, &cloudwatch.MetricDatum{
+			MetricName: aws.String(MetricBlockNumber),
+			Dimensions: []*cloudwatch.Dimension{{Name: aws.String("InstanceID"), Value: aws.String(p.InstanceID)}},
+			Timestamp:  aws.Time(s.Time),
+			Value:      aws.Float64(float64(s.BlockNumber)),
+		})
+	}
+	return datums
+}
+
+// Add merges the sample into the minute it was taken in. Minutes are queued for every region once they are over
+func (p *Publisher) Add(s Sample) {
+	s.Time = s.Time.Truncate(time.Minute)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current != nil && !p.current.Time.Equal(s.Time) {
+		p.enqueue(p.Datums(*p.current))
+		p.current = nil
+	}
+	if p.current == nil {
+		p.current = &s
+		return
+	}
+
+	p.current.Healthy = p.current.Healthy && s.Healthy
+	p.current.Validator = p.current.Validator || s.Validator
+	if s.HasBlock && (!p.current.HasBlock || s.BlockNumber > p.current.BlockNumber) {
+		p.current.BlockNumber, p.current.HasBlock = s.BlockNumber, true
+	}
+}
+
+// enqueue adds the datums to the queue of every region. Must be called with the lock held
+func (p *Publisher) enqueue(datums []*cloudwatch.MetricDatum) {
+	if p.pending == nil {
+		p.pending = make(map[string][]*cloudwatch.MetricDatum)
+	}
+	max := p.MaxPending
+	if max <= 0 {
+		max = DefaultMaxPending
+	}
+	for region := range p.Clients {
+		queue := append(p.pending[region], datums...)
+		if len(queue) > max {
+			queue = queue[len(queue)-max:]
+		}
+		p.pending[region] = queue
+	}
+}
+
+// closeMinute queues the current minute if it is over, or unconditionally if force is set. Must be called with the lock held
+func (p *Publisher) closeMinute(force bool) {
+	now := time.Now
+	if p.Now != nil {
+		now = p.Now
+	}
+	if p.current != nil && (force || !now().Truncate(time.Minute).Equal(p.current.Time)) {
+		p.enqueue(p.Datums(*p.current))
+		p.current = nil
+	}
+}
+
+// Fail immediately sends HealthFailure to every region, so the alarms fire without waiting for the node to go silent
+func (p *Publisher) Fail(ctx context.Context) error {
+	datum := &cloudwatch.MetricDatum{
+		MetricName: aws.String(MetricHealth),
+		Dimensions: []*cloudwatch.Dimension{{Name: aws.String("AutoScalingGroupName"), Value: aws.String(p.AutoScalingGroup)}},
+		Timestamp:  aws.Time(time.Now()),
+		Value:      aws.Float64(HealthFailure),
+	}
+
+	p.mu.Lock()
+	p.enqueue([]*cloudwatch.MetricDatum{datum})
+	p.mu.Unlock()
+
+	return p.Flush(ctx)
+}
+
+// Pending returns the number of datums waiting to be sent to the region
+func (p *Publisher) Pending(region string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending[region])
+}
+
+// Flush sends the minutes that are over to all the regions concurrently. Datums that could not be sent stay queued for the next flush.
+// A *RegionError is returned if any region failed.
+func (p *Publisher) Flush(ctx context.Context) error {
+	return p.flush(ctx, false)
+}
+
+// FlushAll sends the current minute as well. Used on shutdown, when no more samples of the minute are coming
+func (p *Publisher) FlushAll(ctx context.Context) error {
+	return p.flush(ctx, true)
+}
+
+func (p *Publisher) flush(ctx context.Context, all bool) error {
+	p.mu.Lock()
+	p.closeMinute(all)
+	batches := p.pending
+	p.pending = make(map[string][]*cloudwatch.MetricDatum)
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := make(map[string]error)
+	unsent := make(map[string][]*cloudwatch.MetricDatum)
+
+	for region, datums := range batches {
+		if len(datums) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(region string, datums []*cloudwatch.MetricDatum) {
+			defer wg.Done()
+			rest, err := p.send(ctx, p.Clients[region], datums)
+			if err != nil {
+				mu.Lock()
+				failed[region] = err
+				unsent[region] = rest
+				mu.Unlock()
+			}
+		}(region, datums)
+	}
+	wg.Wait()
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	// Put unsent datums in front of the ones added during the flush, so they keep their order
+	p.mu.Lock()
+	for region, rest := range unsent {
+		p.pending[region] = append(rest, p.pending[region]...)
+	}
+	p.mu.Unlock()
+
+	return &RegionError{Errors: failed}
+}
+
+// send puts the datums in chunks and returns the datums that were not sent
+func (p *Publisher) send(ctx context.Context, client cloudwatchiface.CloudWatchAPI, datums []*cloudwatch.MetricDatum) ([]*cloudwatch.MetricDatum, error) {
+	for len(datums) > 0 {
+		n := len(datums)
+		if n > MaxDatumsPerRequest {
+			n = MaxDatumsPerRequest
+		}
+		_, err := client.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(p.Namespace),
+			MetricData: datums[:n],
+		})
+		if err != nil {
+			return datums, err
+		}
+		datums = datums[n:]
+	}
+	return nil, nil
+}
+
+// Node is the part of the Polkadot RPC client the sampler needs
+type Node interface {
+	Health(ctx context.Context) (polkadot.Health, error)
+	BestBlock(ctx context.Context) (uint64, error)
+	IsValidator(ctx context.Context) (bool, error)
+}
+
+// Containers reports whether a container is running
+type Containers interface {
+	Running(ctx context.Context, name string) (bool, error)
+}
+
+// Sampler observes the local node the same way watcher.sh did: the node is healthy if its container runs and system_health answers
+type Sampler struct {
+	Node       Node
+	Containers Containers
+	Container  string
+	Now        func() time.Time
+}
+
+// Sample observes the node. Failures are reflected in the sample, the returned error only describes them for logging
+func (s *Sampler) Sample(ctx context.Context) (Sample, error) {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	sample := Sample{Time: now()}
+	var problems []string
+
+	running, err := s.Containers.Running(ctx, s.Container)
+	if err != nil {
+		problems = append(problems, "container: "+err.Error())
+	} else if !running {
+		problems = append(problems, "container "+s.Container+" is not running")
+	}
+
+	// Only the fact that system_health answers matters, the same way watcher.sh only checked the exit code of curl
+	_, healthErr := s.Node.Health(ctx)
+	if healthErr != nil {
+		problems = append(problems, healthErr.Error())
+	}
+	sample.Healthy = err == nil && running && healthErr == nil
+
+	if number, err := s.Node.BestBlock(ctx); err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		sample.BlockNumber, sample.HasBlock = number, true
+	}
+
+	if validator, err := s.Node.IsValidator(ctx); err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		sample.Validator = validator
+	}
+
+	if len(problems) > 0 {
+		return sample, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return sample, nil
+}
+
+// Run samples the node every interval and flushes the minutes that are over every flushInterval until the context is done.
+// A flushInterval well below a minute keeps the delay between the end of a minute and its datums reaching CloudWatch short.
+// Errors are passed to logf and never stop the loop.
+func Run(ctx context.Context, sampler *Sampler, publisher *Publisher, interval time.Duration, flushInterval time.Duration, logf func(format string, args ...interface{})) {
+	sampleTicker := time.NewTicker(interval)
+	defer sampleTicker.Stop()
+	flushTicker := time.NewTicker(flushInterval)
+	defer flushTicker.Stop()
+
+	sample := func() {
+		s, err := sampler.Sample(ctx)
+		if err != nil {
+			logf("ERROR! Node is not healthy: %s", err)
+		}
+		publisher.Add(s)
+	}
+	sample()
+	for {
+		select {
+		case <-ctx.Done():
+			// Send what is left with a fresh deadline, the node may be going down
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := publisher.FlushAll(flushCtx); err != nil {
+				logf("ERROR! %s", err)
+			}
+			cancel()
+			return
+		case <-sampleTicker.C:
+			sample()
+		case <-flushTicker.C:
+			if err := publisher.Flush(ctx); err != nil {
+				logf("ERROR! %s", err)
+			}
+		}
+	}
+}
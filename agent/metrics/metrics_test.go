@@ -0,0 +1,272 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/polkadot"
+)
+
+type fakeCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+
+	mu    sync.Mutex
+	calls []*cloudwatch.PutMetricDataInput
+	err   error
+}
+
+func (f *fakeCloudWatch) PutMetricDataWithContext(ctx aws.Context, input *cloudwatch.PutMetricDataInput, opts ...request.Option) (*cloudwatch.PutMetricDataOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.calls = append(f.calls, input)
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func (f *fakeCloudWatch) datums() []*cloudwatch.MetricDatum {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []*cloudwatch.MetricDatum
+	for _, call := range f.calls {
+		result = append(result, call.MetricData...)
+	}
+	return result
+}
+
+func newPublisher() (*Publisher, map[string]*fakeCloudWatch) {
+	fakes := map[string]*fakeCloudWatch{"us-east-1": {}, "us-east-2": {}, "eu-west-1": {}}
+	clients := make(map[string]cloudwatchiface.CloudWatchAPI)
+	for region, fake := range fakes {
+		clients[region] = fake
+	}
+	return &Publisher{Namespace: "test", AutoScalingGroup: "test-polkadot-validator", InstanceID: "i-1", Clients: clients}, fakes
+}
+
+func dimensions(datum *cloudwatch.MetricDatum) map[string]string {
+	result := make(map[string]string)
+	for _, d := range datum.Dimensions {
+		result[aws.StringValue(d.Name)] = aws.StringValue(d.Value)
+	}
+	return result
+}
+
+func TestPublishBatchesPerRegion(t *testing.T) {
+	publisher, fakes := newPublisher()
+	now := time.Unix(1600000000, 0)
+
+	publisher.Add(Sample{Time: now, Healthy: true, BlockNumber: 42, HasBlock: true, Validator: true})
+	require.NoError(t, publisher.FlushAll(context.Background()))
+
+	for region, fake := range fakes {
+		require.Len(t, fake.calls, 1, "region %s should get a single call", region)
+		assert.Equal(t, "test", aws.StringValue(fake.calls[0].Namespace))
+
+		values := make(map[string]float64)
+		for _, datum := range fake.calls[0].MetricData {
+			values[aws.StringValue(datum.MetricName)] = aws.Float64Value(datum.Value)
+			assert.Equal(t, now.Truncate(time.Minute), aws.TimeValue(datum.Timestamp))
+
+			if aws.StringValue(datum.MetricName) == MetricBlockNumber {
+				assert.Equal(t, map[string]string{"InstanceID": "i-1"}, dimensions(datum))
+			} else {
+				assert.Equal(t, map[string]string{"AutoScalingGroupName": "test-polkadot-validator"}, dimensions(datum))
+			}
+		}
+		assert.Equal(t, map[string]float64{MetricHealth: 0, MetricValidator: 1, MetricBlockNumber: 42}, values)
+	}
+}
+
+func TestSamplesAreAggregatedPerMinute(t *testing.T) {
+	publisher, fakes := newPublisher()
+	minute := time.Unix(1600000020, 0).Truncate(time.Minute)
+	now := minute.Add(30 * time.Second)
+	publisher.Now = func() time.Time { return now }
+
+	publisher.Add(Sample{Time: minute.Add(5 * time.Second), Healthy: true, BlockNumber: 10, HasBlock: true})
+	publisher.Add(Sample{Time: minute.Add(20 * time.Second), Healthy: false, Validator: true})
+	publisher.Add(Sample{Time: minute.Add(35 * time.Second), Healthy: true, BlockNumber: 12, HasBlock: true})
+
+	// The minute is not over yet, nothing is sent so that the alarms never see more than one sample per minute
+	require.NoError(t, publisher.Flush(context.Background()))
+	assert.Empty(t, fakes["us-east-1"].datums())
+
+	now = minute.Add(61 * time.Second)
+	require.NoError(t, publisher.Flush(context.Background()))
+
+	values := make(map[string]float64)
+	for _, datum := range fakes["us-east-1"].datums() {
+		values[aws.StringValue(datum.MetricName)] = aws.Float64Value(datum.Value)
+		assert.Equal(t, minute, aws.TimeValue(datum.Timestamp))
+	}
+	assert.Equal(t, map[string]float64{MetricHealth: 1, MetricValidator: 1, MetricBlockNumber: 12}, values)
+}
+
+func TestNextMinuteClosesThePreviousOne(t *testing.T) {
+	publisher, fakes := newPublisher()
+	minute := time.Unix(1600000020, 0).Truncate(time.Minute)
+	publisher.Now = func() time.Time { return minute.Add(90 * time.Second) }
+
+	publisher.Add(Sample{Time: minute, Healthy: true})
+	publisher.Add(Sample{Time: minute.Add(time.Minute), Healthy: true})
+	require.NoError(t, publisher.Flush(context.Background()))
+
+	// Only the first minute is over
+	assert.Len(t, fakes["us-east-2"].datums(), 2)
+	require.NoError(t, publisher.FlushAll(context.Background()))
+	assert.Len(t, fakes["us-east-2"].datums(), 4)
+}
+
+func TestUnhealthySample(t *testing.T) {
+	publisher, _ := newPublisher()
+
+	values := make(map[string]float64)
+	for _, datum := range publisher.Datums(Sample{Time: time.Now()}) {
+		values[aws.StringValue(datum.MetricName)] = aws.Float64Value(datum.Value)
+	}
+
+	// No block number is sent if the node did not report it, so the block alarm is not fed with zeroes
+	assert.Equal(t, map[string]float64{MetricHealth: 1, MetricValidator: 0}, values)
+}
+
+func TestFlushSplitsLargeBatches(t *testing.T) {
+	publisher, fakes := newPublisher()
+
+	for i := 0; i < 10; i++ {
+		publisher.Add(Sample{Time: time.Unix(int64(i)*60, 0), Healthy: true, BlockNumber: uint64(i), HasBlock: true})
+	}
+	require.NoError(t, publisher.FlushAll(context.Background()))
+
+	fake := fakes["eu-west-1"]
+	require.Len(t, fake.calls, 2)
+	assert.Len(t, fake.calls[0].MetricData, MaxDatumsPerRequest)
+	assert.Len(t, fake.calls[1].MetricData, 30-MaxDatumsPerRequest)
+}
+
+func TestFailedRegionIsRetried(t *testing.T) {
+	publisher, fakes := newPublisher()
+	fakes["us-east-2"].err = errors.New("throttled")
+
+	publisher.Add(Sample{Time: time.Unix(0, 0), Healthy: true})
+	err := publisher.FlushAll(context.Background())
+
+	var regionErr *RegionError
+	require.True(t, errors.As(err, &regionErr))
+	assert.Contains(t, regionErr.Errors, "us-east-2")
+	assert.Len(t, regionErr.Errors, 1)
+
+	assert.Equal(t, 0, publisher.Pending("us-east-1"))
+	assert.Equal(t, 2, publisher.Pending("us-east-2"))
+
+	fakes["us-east-2"].err = nil
+	publisher.Add(Sample{Time: time.Unix(60, 0), Healthy: true})
+	require.NoError(t, publisher.FlushAll(context.Background()))
+
+	assert.Len(t, fakes["us-east-2"].datums(), 4)
+	assert.Len(t, fakes["us-east-1"].datums(), 4)
+	assert.Equal(t, 0, publisher.Pending("us-east-2"))
+}
+
+func TestPendingIsBounded(t *testing.T) {
+	publisher, fakes := newPublisher()
+	publisher.MaxPending = 4
+	fakes["us-east-1"].err = errors.New("unreachable")
+
+	for i := 0; i < 5; i++ {
+		publisher.Add(Sample{Time: time.Unix(int64(i)*60, 0), Healthy: true})
+		_ = publisher.FlushAll(context.Background())
+	}
+
+	assert.Equal(t, 4, publisher.Pending("us-east-1"))
+}
+
+func TestFail(t *testing.T) {
+	publisher, fakes := newPublisher()
+	require.NoError(t, publisher.Fail(context.Background()))
+
+	for _, fake := range fakes {
+		datums := fake.datums()
+		require.Len(t, datums, 1)
+		assert.Equal(t, MetricHealth, aws.StringValue(datums[0].MetricName))
+		assert.Equal(t, float64(HealthFailure), aws.Float64Value(datums[0].Value))
+	}
+}
+
+type fakeNode struct {
+	healthErr error
+	block     uint64
+	blockErr  error
+	validator bool
+}
+
+func (f *fakeNode) Health(ctx context.Context) (polkadot.Health, error) {
+	return polkadot.Health{Peers: 10}, f.healthErr
+}
+
+func (f *fakeNode) BestBlock(ctx context.Context) (uint64, error) {
+	return f.block, f.blockErr
+}
+
+func (f *fakeNode) IsValidator(ctx context.Context) (bool, error) {
+	return f.validator, nil
+}
+
+type fakeContainers bool
+
+func (f fakeContainers) Running(ctx context.Context, name string) (bool, error) {
+	return bool(f), nil
+}
+
+func TestSampler(t *testing.T) {
+	tests := []struct {
+		name     string
+		node     *fakeNode
+		running  bool
+		expected Sample
+		fails    bool
+	}{
+		{
+			name:     "healthy validator",
+			node:     &fakeNode{block: 100, validator: true},
+			running:  true,
+			expected: Sample{Healthy: true, BlockNumber: 100, HasBlock: true, Validator: true},
+		},
+		{
+			name:     "container stopped",
+			node:     &fakeNode{block: 100},
+			running:  false,
+			expected: Sample{BlockNumber: 100, HasBlock: true},
+			fails:    true,
+		},
+		{
+			name:     "RPC down",
+			node:     &fakeNode{healthErr: errors.New("refused"), blockErr: errors.New("refused")},
+			running:  true,
+			expected: Sample{},
+			fails:    true,
+		},
+	}
+
+	now := time.Unix(1600000000, 0)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sampler := &Sampler{Node: test.node, Containers: fakeContainers(test.running), Container: "polkadot", Now: func() time.Time { return now }}
+			sample, err := sampler.Sample(context.Background())
+
+			test.expected.Time = now
+			assert.Equal(t, test.expected, sample)
+			assert.Equal(t, test.fails, err != nil)
+		})
+	}
+}
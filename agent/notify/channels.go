@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultPagerDutyURL is the endpoint of the PagerDuty Events API v2
+const DefaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// Webhook posts the notification as JSON to any URL
+type Webhook struct {
+	URL string
+	// Client is a client with a 10 seconds timeout if nil
+	Client *http.Client
+}
+
+// Send implements Channel
+func (w *Webhook) Send(ctx context.Context, notification Notification) error {
+	return post(ctx, w.Client, w.URL, notification)
+}
+
+// Slack posts the notification to a Slack incoming webhook
+type Slack struct {
+	URL    string
+	Client *http.Client
+}
+
+var slackEmojis = map[Severity]string{
+	SeverityInfo:     ":information_source:",
+	SeverityWarning:  ":warning:",
+	SeverityCritical: ":rotating_light:",
+}
+
+// Send implements Channel
+func (s *Slack) Send(ctx context.Context, notification Notification) error {
+	text := fmt.Sprintf("%s *%s* %s", slackEmojis[notification.Severity], notification.Event, notification.Summary)
+	if notification.Suppressed > 0 {
+		text += fmt.Sprintf("\n_%d notifications were dropped by the rate limit_", notification.Suppressed)
+	}
+	return post(ctx, s.Client, s.URL, map[string]string{"text": text})
+}
+
+// PagerDuty triggers an alert of PagerDuty Events v2. The notification key is the dedup key, so PagerDuty groups repeated events
+// into a single incident as well
+type PagerDuty struct {
+	RoutingKey string
+	// URL is DefaultPagerDutyURL if empty
+	URL    string
+	Client *http.Client
+}
+
+// Send implements Channel
+func (p *PagerDuty) Send(ctx context.Context, notification Notification) error {
+	endpoint := p.URL
+	if endpoint == "" {
+		endpoint = DefaultPagerDutyURL
+	}
+	return post(ctx, p.Client, endpoint, map[string]interface{}{
+		"routing_key":  p.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    notification.Key,
+		"payload": map[string]interface{}{
+			"summary":        notification.Summary,
+			"source":         notification.Instance,
+			"severity":       notification.Severity,
+			"timestamp":      notification.Time.UTC().Format(time.RFC3339),
+			"component":      "polkadot-validator",
+			"group":          notification.Deployment,
+			"class":          notification.Event,
+			"custom_details": notification,
+		},
+	})
+}
+
+// post sends the body as JSON and fails unless the answer is a 2xx
+func post(ctx context.Context, client *http.Client, endpoint string, body interface{}) error {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := client.Do(request.WithContext(ctx))
+	if urlErr, ok := err.(*url.Error); ok {
+		// Webhook URLs hold their secret, only the host is reported
+		return fmt.Errorf("%s %s: %w", urlErr.Op, request.URL.Host, urlErr.Err)
+	}
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	answer, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s: %s", request.URL.Host, response.Status, bytes.TrimSpace(answer))
+	}
+	return nil
+}
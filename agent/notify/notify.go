@@ -0,0 +1,242 @@
+// Package notify sends the failover events of the audit log, e.g. a promotion or a fenced validator, to a generic webhook, a Slack
+// incoming webhook or PagerDuty. The same event is sent once per channel even if it is recorded again, and every channel is rate
+// limited, so a flapping node does not page the operators every few seconds.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/audit"
+)
+
+// Severity of a notification, the values are the severities of PagerDuty Events v2
+type Severity string
+
+// Severities from the lowest to the highest
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+var severityRanks = map[Severity]int{SeverityInfo: 0, SeverityWarning: 1, SeverityCritical: 2}
+
+// ParseSeverity returns the severity of its name
+func ParseSeverity(name string) (Severity, error) {
+	severity := Severity(strings.ToLower(strings.TrimSpace(name)))
+	if _, ok := severityRanks[severity]; !ok {
+		return "", fmt.Errorf("unknown severity %q, expected info, warning or critical", name)
+	}
+	return severity, nil
+}
+
+// Defaults of the notifier
+const (
+	DefaultDedupWindow = 30 * time.Minute
+	DefaultRateLimit   = 10
+	DefaultRateWindow  = time.Hour
+)
+
+// Notification describes a failover event
+type Notification struct {
+	// Key identifies the event, the same key is not sent twice to a channel within the dedup window
+	Key        string     `json:"key"`
+	Deployment string     `json:"deployment"`
+	Event      audit.Type `json:"event"`
+	Severity   Severity   `json:"severity"`
+	Summary    string     `json:"summary"`
+	Instance   string     `json:"instance"`
+	Region     string     `json:"region,omitempty"`
+	Epoch      uint64     `json:"epoch,omitempty"`
+	Finalized  uint64     `json:"finalized,omitempty"`
+	Time       time.Time  `json:"time"`
+	// Suppressed is the number of notifications the rate limit dropped since the last one sent to the channel
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// FromEvent returns the notification of an audit event and false if the event is not notified
+func FromEvent(deployment string, event audit.Event) (Notification, bool) {
+	notification := Notification{
+		Deployment: deployment,
+		Event:      event.Type,
+		Instance:   event.Instance,
+		Region:     event.Region,
+		Epoch:      event.Epoch,
+		Finalized:  event.Finalized,
+		Time:       event.Time,
+	}
+	where := event.Instance
+	if event.Region != "" {
+		where += " (" + event.Region + ")"
+	}
+
+	switch event.Type {
+	case audit.ValidatorStarted:
+		notification.Severity = SeverityWarning
+		notification.Summary = fmt.Sprintf("%s: %s was promoted to validator under epoch %d at finalized block %d", deployment, where, event.Epoch, event.Finalized)
+	case audit.SteppedDown:
+		notification.Severity = SeverityInfo
+		notification.Summary = fmt.Sprintf("%s: %s stepped down: %s", deployment, where, event.Message)
+	case audit.GuardFailed:
+		notification.Severity = SeverityCritical
+		notification.Summary = fmt.Sprintf("%s: %s aborted its promotion: %s", deployment, where, event.Message)
+	case audit.Fenced:
+		notification.Severity = SeverityCritical
+		notification.Summary = fmt.Sprintf("%s: %s was fenced: %s", deployment, where, event.Message)
+	case audit.ElectionLeft:
+		notification.Severity = SeverityCritical
+		notification.Summary = fmt.Sprintf("%s: %s left the election: %s", deployment, where, event.Message)
+	default:
+		return notification, false
+	}
+	notification.Key = fmt.Sprintf("%s/%s/%s/%d", deployment, event.Instance, event.Type, event.Epoch)
+	return notification, true
+}
+
+// Channel delivers notifications, e.g. to a Slack channel
+type Channel interface {
+	Send(ctx context.Context, notification Notification) error
+}
+
+// Target is a channel and the lowest severity it receives
+type Target struct {
+	Name        string
+	Channel     Channel
+	MinSeverity Severity
+}
+
+// Notifier sends the notifications of the audit events to every target. It is a sink of the audit log, which retries the events a
+// sink failed to write, and a notification is only marked as sent once the channel accepted it, so a failing channel gets it again
+// without the other channels getting it twice.
+type Notifier struct {
+	Deployment string
+	Targets    []Target
+	// DedupWindow is the time the same notification is not sent again, DefaultDedupWindow if zero
+	DedupWindow time.Duration
+	// RateLimit is the number of notifications a target receives per RateWindow, DefaultRateLimit and DefaultRateWindow if zero
+	RateLimit  int
+	RateWindow time.Duration
+	Logf       func(format string, args ...interface{})
+	Now        func() time.Time
+
+	mu         sync.Mutex
+	sent       map[string]time.Time
+	history    map[string][]time.Time
+	suppressed map[string]int
+}
+
+func (n *Notifier) logf(format string, args ...interface{}) {
+	if n.Logf != nil {
+		n.Logf(format, args...)
+	}
+}
+
+func (n *Notifier) now() time.Time {
+	if n.Now != nil {
+		return n.Now()
+	}
+	return time.Now()
+}
+
+// Write implements audit.Sink
+func (n *Notifier) Write(ctx context.Context, events []audit.Event) error {
+	var failed []string
+	for _, event := range events {
+		notification, ok := FromEvent(n.Deployment, event)
+		if !ok {
+			continue
+		}
+		if err := n.Notify(ctx, notification); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to notify: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Notify sends the notification to every target it is due to
+func (n *Notifier) Notify(ctx context.Context, notification Notification) error {
+	var failed []string
+	for _, target := range n.Targets {
+		if severityRanks[notification.Severity] < severityRanks[target.MinSeverity] {
+			continue
+		}
+		send, suppressed := n.admit(target.Name, notification.Key)
+		if !send {
+			continue
+		}
+
+		notification.Suppressed = suppressed
+		if err := target.Channel.Send(ctx, notification); err != nil {
+			n.release(target.Name, notification.Key, suppressed)
+			failed = append(failed, target.Name+": "+err.Error())
+			continue
+		}
+		n.logf("INFO. Notified %s: %s", target.Name, notification.Summary)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// admit reports whether the notification is due to the target and takes it into account for the dedup window and the rate limit.
+// It returns the number of notifications suppressed since the last one
+func (n *Notifier) admit(target, key string) (bool, int) {
+	dedupWindow, rateLimit, rateWindow := n.DedupWindow, n.RateLimit, n.RateWindow
+	if dedupWindow <= 0 {
+		dedupWindow = DefaultDedupWindow
+	}
+	if rateLimit <= 0 {
+		rateLimit = DefaultRateLimit
+	}
+	if rateWindow <= 0 {
+		rateWindow = DefaultRateWindow
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.sent == nil {
+		n.sent, n.history, n.suppressed = make(map[string]time.Time), make(map[string][]time.Time), make(map[string]int)
+	}
+	now := n.now()
+	if at, ok := n.sent[target+"\x00"+key]; ok && now.Sub(at) < dedupWindow {
+		return false, 0
+	}
+
+	var recent []time.Time
+	for _, at := range n.history[target] {
+		if now.Sub(at) < rateWindow {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= rateLimit {
+		n.history[target] = recent
+		n.suppressed[target]++
+		n.logf("ERROR! Rate limit of %s reached, dropping notification %s", target, key)
+		return false, 0
+	}
+
+	n.history[target] = append(recent, now)
+	n.sent[target+"\x00"+key] = now
+	suppressed := n.suppressed[target]
+	n.suppressed[target] = 0
+	return true, suppressed
+}
+
+// release forgets a notification the channel did not accept, so it is sent again
+func (n *Notifier) release(target, key string, suppressed int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.sent, target+"\x00"+key)
+	if history := n.history[target]; len(history) > 0 {
+		n.history[target] = history[:len(history)-1]
+	}
+	n.suppressed[target] += suppressed
+}
@@ -0,0 +1,176 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/audit"
+)
+
+// standIn records the bodies posted to every path and answers with the status of the path, 200 by default
+type standIn struct {
+	mu       sync.Mutex
+	bodies   map[string][]map[string]interface{}
+	statuses map[string]int
+}
+
+func newStandIn(t *testing.T) (*standIn, *httptest.Server) {
+	s := &standIn{bodies: make(map[string][]map[string]interface{}), statuses: make(map[string]int)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if status := s.statuses[r.URL.Path]; status != 0 {
+			w.WriteHeader(status)
+			w.Write([]byte("unavailable"))
+			return
+		}
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(content, &body))
+		s.bodies[r.URL.Path] = append(s.bodies[r.URL.Path], body)
+	}))
+	return s, server
+}
+
+func (s *standIn) received(path string) []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bodies[path]
+}
+
+func (s *standIn) fail(path string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[path] = status
+}
+
+func TestChannels(t *testing.T) {
+	stand, server := newStandIn(t)
+	defer server.Close()
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	notifier := &Notifier{
+		Deployment: "test",
+		Targets: []Target{
+			{Name: "webhook", Channel: &Webhook{URL: server.URL + "/webhook"}, MinSeverity: SeverityInfo},
+			{Name: "slack", Channel: &Slack{URL: server.URL + "/slack/T000/B000/secret"}, MinSeverity: SeverityWarning},
+			{Name: "pagerduty", Channel: &PagerDuty{RoutingKey: "routing", URL: server.URL + "/pagerduty"}, MinSeverity: SeverityCritical},
+		},
+		Now: func() time.Time { return now },
+	}
+
+	events := []audit.Event{
+		{Time: now, Instance: "i-1", Region: "us-east-1", Type: audit.LockAcquired},
+		{Time: now, Instance: "i-1", Region: "us-east-1", Type: audit.ValidatorStarted, Epoch: 4, Finalized: 1200},
+		{Time: now, Instance: "i-2", Region: "us-east-2", Type: audit.Fenced, Epoch: 3, Message: "epoch 4 was started by i-1"},
+	}
+	require.NoError(t, notifier.Write(context.Background(), events))
+
+	webhook := stand.received("/webhook")
+	require.Len(t, webhook, 2, "the lock is not notified")
+	assert.Equal(t, "validator-started", webhook[0]["event"])
+	assert.Equal(t, "test: i-1 (us-east-1) was promoted to validator under epoch 4 at finalized block 1200", webhook[0]["summary"])
+	assert.Equal(t, "test/i-1/validator-started/4", webhook[0]["key"])
+
+	slack := stand.received("/slack/T000/B000/secret")
+	require.Len(t, slack, 2)
+	assert.Equal(t, ":rotating_light: *fenced* test: i-2 (us-east-2) was fenced: epoch 4 was started by i-1", slack[1]["text"])
+
+	pagerDuty := stand.received("/pagerduty")
+	require.Len(t, pagerDuty, 1, "only critical events page")
+	assert.Equal(t, "routing", pagerDuty[0]["routing_key"])
+	assert.Equal(t, "trigger", pagerDuty[0]["event_action"])
+	assert.Equal(t, "test/i-2/fenced/3", pagerDuty[0]["dedup_key"])
+	payload := pagerDuty[0]["payload"].(map[string]interface{})
+	assert.Equal(t, "critical", payload["severity"])
+	assert.Equal(t, "i-2", payload["source"])
+	assert.Equal(t, "2020-06-01T12:00:00Z", payload["timestamp"])
+
+	// The secret path of the webhook is not reported
+	stand.fail("/slack/T000/B000/secret", http.StatusServiceUnavailable)
+	err := notifier.Write(context.Background(), []audit.Event{{Time: now, Instance: "i-2", Type: audit.ElectionLeft}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "503 Service Unavailable: unavailable")
+	assert.NotContains(t, err.Error(), "secret")
+}
+
+func TestDeduplication(t *testing.T) {
+	stand, server := newStandIn(t)
+	defer server.Close()
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	notifier := &Notifier{
+		Deployment: "test",
+		Targets: []Target{
+			{Name: "first", Channel: &Webhook{URL: server.URL + "/first"}},
+			{Name: "second", Channel: &Webhook{URL: server.URL + "/second"}},
+		},
+		Now: func() time.Time { return now },
+	}
+	fenced := []audit.Event{{Time: now, Instance: "i-2", Type: audit.Fenced, Epoch: 3}}
+
+	// The audit log writes the events again after a target failed, only the failed target gets them again
+	stand.fail("/second", http.StatusBadGateway)
+	assert.Error(t, notifier.Write(context.Background(), fenced))
+	stand.fail("/second", 0)
+	require.NoError(t, notifier.Write(context.Background(), fenced))
+	assert.Len(t, stand.received("/first"), 1)
+	assert.Len(t, stand.received("/second"), 1)
+
+	// The same event is sent again once the dedup window passed, and other epochs are other events
+	now = now.Add(DefaultDedupWindow - time.Second)
+	require.NoError(t, notifier.Write(context.Background(), fenced))
+	assert.Len(t, stand.received("/first"), 1)
+	require.NoError(t, notifier.Write(context.Background(), []audit.Event{{Time: now, Instance: "i-2", Type: audit.Fenced, Epoch: 4}}))
+	assert.Len(t, stand.received("/first"), 2)
+	now = now.Add(time.Second)
+	require.NoError(t, notifier.Write(context.Background(), fenced))
+	assert.Len(t, stand.received("/first"), 3)
+}
+
+func TestRateLimit(t *testing.T) {
+	stand, server := newStandIn(t)
+	defer server.Close()
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	notifier := &Notifier{
+		Deployment: "test",
+		Targets:    []Target{{Name: "slack", Channel: &Slack{URL: server.URL + "/slack"}}},
+		RateLimit:  2,
+		RateWindow: time.Hour,
+		Now:        func() time.Time { return now },
+	}
+	for epoch := uint64(1); epoch <= 5; epoch++ {
+		require.NoError(t, notifier.Write(context.Background(), []audit.Event{{Time: now, Instance: "i-1", Type: audit.ValidatorStarted, Epoch: epoch}}))
+		now = now.Add(time.Minute)
+	}
+	assert.Len(t, stand.received("/slack"), 2)
+
+	// The first notification after the window reports the dropped ones
+	now = now.Add(time.Hour)
+	require.NoError(t, notifier.Write(context.Background(), []audit.Event{{Time: now, Instance: "i-1", Type: audit.SteppedDown, Epoch: 5}}))
+	slack := stand.received("/slack")
+	require.Len(t, slack, 3)
+	assert.True(t, strings.HasSuffix(slack[2]["text"].(string), "_3 notifications were dropped by the rate limit_"), slack[2]["text"])
+}
+
+func TestParseSeverity(t *testing.T) {
+	severity, err := ParseSeverity(" Critical")
+	require.NoError(t, err)
+	assert.Equal(t, SeverityCritical, severity)
+	_, err = ParseSeverity("error")
+	assert.EqualError(t, err, `unknown severity "error", expected info, warning or critical`)
+}
@@ -0,0 +1,191 @@
+// Package polkadot is a minimal JSON-RPC client for the HTTP RPC endpoint of the Polkadot node the agent runs next to.
+package polkadot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultURL is the RPC endpoint the node container exposes on the host
+const DefaultURL = "http://127.0.0.1:9933"
+
+// RoleAuthority is the role reported by system_nodeRoles for a node that runs with --validator
+const RoleAuthority = "Authority"
+
+// Client calls the RPC methods of a single node
+type Client struct {
+	URL  string
+	HTTP *http.Client
+
+	id uint64
+}
+
+// NewClient creates a client for the node listening at url
+func NewClient(url string) *Client {
+	return &Client{URL: url, HTTP: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Error is an error returned by the node itself, as opposed to transport errors
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return "RPC error " + strconv.Itoa(e.Code) + ": " + e.Message
+}
+
+type request struct {
+	ID      uint64        `json:"id"`
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// Call invokes the method and decodes its result into result, which can be nil if the result is not needed
+func (c *Client) Call(ctx context.Context, method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(request{ID: atomic.AddUint64(&c.id, 1), JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected HTTP status %s", method, resp.Status)
+	}
+
+	var parsed response
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return fmt.Errorf("%s: unable to decode response: %w", method, err)
+	}
+	if parsed.Error != nil {
+		return fmt.Errorf("%s: %w", method, parsed.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(parsed.Result, result); err != nil {
+		return fmt.Errorf("%s: unable to decode result: %w", method, err)
+	}
+	return nil
+}
+
+// Health is the result of system_health
+type Health struct {
+	Peers           int  `json:"peers"`
+	IsSyncing       bool `json:"isSyncing"`
+	ShouldHavePeers bool `json:"shouldHavePeers"`
+}
+
+// Health returns the health of the node
+func (c *Client) Health(ctx context.Context) (Health, error) {
+	var health Health
+	err := c.Call(ctx, "system_health", &health)
+	return health, err
+}
+
+// NodeRoles returns the roles of the node, e.g. ["Full"] or ["Authority"]
+func (c *Client) NodeRoles(ctx context.Context) ([]string, error) {
+	var roles []string
+	err := c.Call(ctx, "system_nodeRoles", &roles)
+	return roles, err
+}
+
+// IsValidator reports whether the node runs with the Authority role
+func (c *Client) IsValidator(ctx context.Context) (bool, error) {
+	roles, err := c.NodeRoles(ctx)
+	if err != nil {
+		return false, err
+	}
+	return len(roles) > 0 && roles[0] == RoleAuthority, nil
+}
+
+// Header is the part of a block header the agent needs
+type Header struct {
+	ParentHash string `json:"parentHash"`
+	Number     string `json:"number"`
+}
+
+// BlockNumber decodes the hex encoded number of the block
+func (h Header) BlockNumber() (uint64, error) {
+	return strconv.ParseUint(strings.TrimPrefix(h.Number, "0x"), 16, 64)
+}
+
+// BestBlock returns the number of the best block known to the node
+func (c *Client) BestBlock(ctx context.Context) (uint64, error) {
+	var header Header
+	if err := c.Call(ctx, "chain_getHeader", &header); err != nil {
+		return 0, err
+	}
+	return header.BlockNumber()
+}
+
+// FinalizedBlock returns the number of the last block finalized by the node
+func (c *Client) FinalizedBlock(ctx context.Context) (uint64, error) {
+	var hash string
+	if err := c.Call(ctx, "chain_getFinalizedHead", &hash); err != nil {
+		return 0, err
+	}
+	var header Header
+	if err := c.Call(ctx, "chain_getHeader", &header, hash); err != nil {
+		return 0, err
+	}
+	return header.BlockNumber()
+}
+
+// sessionIndexKey is the storage key of Session.CurrentIndex, twox128("Session") ++ twox128("CurrentIndex")
+const sessionIndexKey = "0xcec5070d609dd3497f72bde07fc96ba072763800a36a99fdfc7c10f6415f6ee6"
+
+// SessionIndex returns the index of the current session of the chain, read from the storage of the session pallet
+func (c *Client) SessionIndex(ctx context.Context) (uint64, error) {
+	var value string
+	if err := c.Call(ctx, "state_getStorage", &value, sessionIndexKey); err != nil {
+		return 0, err
+	}
+	raw, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+	if err != nil || len(raw) != 4 {
+		return 0, fmt.Errorf("state_getStorage: unexpected session index %q", value)
+	}
+	// The index is a SCALE encoded u32, i.e. little endian
+	return uint64(binary.LittleEndian.Uint32(raw)), nil
+}
+
+// InsertKey puts a session key into the keystore of the node. The seed is only sent to the node, it is never part of the returned error
+func (c *Client) InsertKey(ctx context.Context, keyType, seed, publicKey string) error {
+	return c.Call(ctx, "author_insertKey", nil, keyType, seed, publicKey)
+}
+
+// HasKey reports whether the keystore of the node contains the public key of the given type
+func (c *Client) HasKey(ctx context.Context, publicKey, keyType string) (bool, error) {
+	var found bool
+	err := c.Call(ctx, "author_hasKey", &found, publicKey, keyType)
+	return found, err
+}
@@ -0,0 +1,80 @@
+package polkadot
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Finality follows the finalized head of the node by polling chain_getFinalizedHead, replacing the parsing of `docker logs`
+type Finality struct {
+	Client *Client
+	// Interval is the delay between two polls of Run
+	Interval time.Duration
+	Logf     func(format string, args ...interface{})
+
+	mu       sync.Mutex
+	number   uint64
+	at       time.Time
+	advanced time.Time
+}
+
+// NewFinality creates a follower of the node polling every interval
+func NewFinality(client *Client, interval time.Duration) *Finality {
+	return &Finality{Client: client, Interval: interval}
+}
+
+// Finalized asks the node for its finalized block and remembers it. It can be used wherever a fresh value is needed
+func (f *Finality) Finalized(ctx context.Context) (uint64, error) {
+	number, err := f.Client.FinalizedBlock(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// The node restarts as a full or a validator node from the same database, so the finalized block never goes back
+	if number > f.number || f.advanced.IsZero() {
+		f.advanced = time.Now()
+	}
+	if number >= f.number {
+		f.number, f.at = number, time.Now()
+	}
+	return number, nil
+}
+
+// Latest returns the last finalized block seen and when it was seen. The time is zero if the node never answered
+func (f *Finality) Latest() (uint64, time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.number, f.at
+}
+
+// Advanced returns the last finalized block seen and when the finalized block last advanced. A node that answers but keeps the same
+// finalized block, e.g. one that is stalled or on a fork GRANDPA does not finalize, keeps the time of its last progress
+func (f *Finality) Advanced() (uint64, time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.number, f.advanced
+}
+
+// Run polls the node until the context is done
+func (f *Finality) Run(ctx context.Context) {
+	interval := f.Interval
+	if interval <= 0 {
+		interval = 6 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := f.Finalized(ctx); err != nil && ctx.Err() == nil && f.Logf != nil {
+			f.Logf("ERROR! Unable to get the finalized block: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
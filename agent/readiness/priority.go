@@ -0,0 +1,198 @@
+package readiness
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Defaults of the preference
+const (
+	DefaultDelay  = 30 * time.Second
+	DefaultStable = 5 * time.Minute
+)
+
+// Rank returns the number of ready nodes with a higher priority than the given one
+func Rank(priority int, others []Height) int {
+	rank := 0
+	for _, other := range others {
+		if other.Ready && other.Priority > priority {
+			rank++
+		}
+	}
+	return rank
+}
+
+// Preferred returns the ready node with the highest priority if it is higher than the given one, nil otherwise. Ties are broken by name
+func Preferred(priority int, others []Height) *Height {
+	var preferred *Height
+	for i := range others {
+		other := &others[i]
+		if !other.Ready || other.Priority <= priority {
+			continue
+		}
+		if preferred == nil || other.Priority > preferred.Priority || (other.Priority == preferred.Priority && other.Node < preferred.Node) {
+			preferred = other
+		}
+	}
+	return preferred
+}
+
+// ParsePriorities parses a comma separated list of region=priority pairs, e.g. "us-east-1=100,us-east-2=50"
+func ParsePriorities(list string) (map[string]int, error) {
+	result := make(map[string]int)
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q should be in the region=priority form", pair)
+		}
+		priority, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("priority of %s: %w", parts[0], err)
+		}
+		result[strings.TrimSpace(parts[0])] = priority
+	}
+	return result, nil
+}
+
+// Preference makes a preferred node win the election whenever it is ready. Nodes with a lower priority delay their lock attempts
+// once the lock is free, and in the preemptive mode a validator hands its role over to a node with a higher priority that has been
+// ready for a while. Priorities are published by the Gate.
+type Preference struct {
+	Gate *Gate
+	// LockKey is the key of the validator lock, used to tell whether the lock is free
+	LockKey string
+	// Delay is the time a node waits for every ready node with a higher priority. DefaultDelay is used if zero
+	Delay time.Duration
+	// Stable is the time a node with a higher priority has to be ready before the validator hands over. DefaultStable is used if zero
+	Stable time.Duration
+	// Preemptive makes the validator hand over to a ready node with a higher priority. Otherwise it validates until it fails
+	Preemptive bool
+	// RequestHandoff asks the local validator to step down in favour of the node, see election.Handoff.Request
+	RequestHandoff func(ctx context.Context, to string) error
+}
+
+func (p *Preference) delay() time.Duration {
+	if p.Delay <= 0 {
+		return DefaultDelay
+	}
+	return p.Delay
+}
+
+func (p *Preference) stable() time.Duration {
+	if p.Stable <= 0 {
+		return DefaultStable
+	}
+	return p.Stable
+}
+
+// Wait delays the lock attempt of the node by Delay for every ready node with a higher priority. The delay only starts once the lock
+// is free, so the preferred node gets the first chance to take it. It is run before every attempt to acquire the lock
+func (p *Preference) Wait(ctx context.Context) error {
+	for {
+		others, err := p.Gate.Heights(ctx)
+		if err != nil {
+			p.Gate.logf("ERROR! Unable to read the priorities of the other nodes: %s", err)
+			return nil
+		}
+		rank := Rank(p.Gate.Priority, others)
+		if rank == 0 {
+			return nil
+		}
+
+		if err := p.waitFree(ctx); err != nil {
+			return err
+		}
+		delay := time.Duration(rank) * p.delay()
+		p.Gate.logf("INFO. %d ready nodes have a higher priority, delaying the lock attempt by %s", rank, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		// Somebody took the lock meanwhile, most likely the preferred node. Wait for it to be released again
+		free, err := p.free(ctx)
+		if err != nil || free {
+			return nil
+		}
+	}
+}
+
+// free reports whether nobody holds the lock
+func (p *Preference) free(ctx context.Context) (bool, error) {
+	pair, _, err := p.Gate.KV.Get(p.LockKey, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	return pair == nil || pair.Session == "", nil
+}
+
+// waitFree blocks until nobody holds the lock
+func (p *Preference) waitFree(ctx context.Context) error {
+	var index uint64
+	for {
+		pair, meta, err := p.Gate.KV.Get(p.LockKey, (&api.QueryOptions{WaitIndex: index, WaitTime: time.Minute}).WithContext(ctx))
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			p.Gate.logf("ERROR! Unable to watch %s: %s", p.LockKey, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(p.Gate.interval()):
+			}
+			continue
+		}
+		if pair == nil || pair.Session == "" {
+			return nil
+		}
+		index = meta.LastIndex
+	}
+}
+
+// Watch runs while the node validates. In the preemptive mode it requests a handoff to the preferred node once that node has been ready
+// for Stable. It returns nil once the context is done
+func (p *Preference) Watch(ctx context.Context) error {
+	if !p.Preemptive || p.RequestHandoff == nil {
+		<-ctx.Done()
+		return nil
+	}
+
+	var candidate string
+	var since time.Time
+	for {
+		others, err := p.Gate.Heights(ctx)
+		if err != nil {
+			p.Gate.logf("ERROR! Unable to read the priorities of the other nodes: %s", err)
+		} else if preferred := Preferred(p.Gate.Priority, others); preferred == nil {
+			candidate = ""
+		} else if preferred.Node != candidate {
+			candidate, since = preferred.Node, p.Gate.now()
+			p.Gate.logf("INFO. %s has a higher priority (%d) and is ready, handing over in %s unless it fails", candidate, preferred.Priority, p.stable())
+		} else if p.Gate.now().Sub(since) >= p.stable() {
+			p.Gate.logf("INFO. Handing the validator role over to %s", candidate)
+			if err := p.RequestHandoff(ctx, candidate); err != nil {
+				p.Gate.logf("ERROR! Unable to request the handoff: %s", err)
+			} else {
+				<-ctx.Done()
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(p.Gate.interval()):
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package readiness
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/election"
+	"github.com/protofire/polkadot-failover-mechanism/agent/polkadot"
+)
+
+func TestRankAndPreferred(t *testing.T) {
+	others := []Height{
+		{Node: "i-3", Priority: 100, Ready: false},
+		{Node: "i-2", Priority: 50, Ready: true},
+		{Node: "i-1", Priority: 50, Ready: true},
+		{Node: "i-4", Priority: 10, Ready: true},
+	}
+
+	assert.Equal(t, 0, Rank(100, others))
+	assert.Equal(t, 2, Rank(10, others))
+	assert.Equal(t, 3, Rank(0, others))
+
+	// Nodes that are not ready never delay the others, ties are broken by name
+	assert.Equal(t, "i-1", Preferred(10, others).Node)
+	assert.Nil(t, Preferred(50, others))
+	assert.Nil(t, Preferred(0, nil))
+}
+
+func TestParsePriorities(t *testing.T) {
+	priorities, err := ParsePriorities("us-east-1=100, us-east-2=50,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"us-east-1": 100, "us-east-2": 50}, priorities)
+
+	_, err = ParsePriorities("us-east-1")
+	assert.Error(t, err)
+	_, err = ParsePriorities("us-east-1=high")
+	assert.Error(t, err)
+}
+
+// syncedNode is a node that is always ready
+type syncedNode struct{}
+
+func (syncedNode) Health(ctx context.Context) (polkadot.Health, error) {
+	return polkadot.Health{Peers: 8}, nil
+}
+
+func (syncedNode) BestBlock(ctx context.Context) (uint64, error) {
+	return 100, nil
+}
+
+// electedNode runs the election of one node the way failover-agent does, with the validator hooks left out
+type electedNode struct {
+	elector *election.Elector
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// runNode publishes the height of the node and runs its election until stop is called
+func runNode(t *testing.T, gate *Gate, elector *election.Elector) *electedNode {
+	node := &electedNode{elector: elector, done: make(chan struct{})}
+	var ctx context.Context
+	ctx, node.cancel = context.WithCancel(context.Background())
+	go gate.Publish(ctx)
+	go func() {
+		defer close(node.done)
+		assert.NoError(t, node.elector.Run(ctx))
+	}()
+	return node
+}
+
+func startNode(t *testing.T, client *api.Client, base, name string, priority int, preemptive bool) *electedNode {
+	locker := &election.ConsulLocker{Client: client, Key: base + "/.lock", LockDelay: time.Millisecond}
+	gate := &Gate{Node: syncedNode{}, KV: client.KV(), Prefix: base + "/heights/", Name: name, MaxLag: 10, Priority: priority, MaxAge: 2 * time.Second, Interval: 100 * time.Millisecond}
+	handoff := &election.Handoff{KV: client.KV(), Key: base + "/handoff", Node: name, Interval: 100 * time.Millisecond}
+	preference := &Preference{Gate: gate, LockKey: locker.Key, Delay: 500 * time.Millisecond, Stable: time.Second, Preemptive: preemptive, RequestHandoff: handoff.Request}
+
+	noop := func(ctx context.Context) error { return nil }
+	return runNode(t, gate, &election.Elector{
+		Locker:        locker,
+		BeforeAcquire: election.Sequence(preference.Wait, handoff.WaitTurn),
+		Hold:          handoff.Hold(preference.Watch),
+		StopValidator: noop,
+		OnStepDown:    func(ctx context.Context) error { return handoff.Complete(ctx, 1) },
+		RetryDelay:    100 * time.Millisecond,
+	})
+}
+
+func (n *electedNode) stop() {
+	n.cancel()
+	<-n.done
+}
+
+func waitValidating(t *testing.T, node *electedNode, name string) {
+	deadline := time.Now().Add(20 * time.Second)
+	for node.elector.State() != election.StateHolding {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never became validator, state %s", name, node.elector.State())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// memoryKV is an in-memory Consul KV. A blocking Get returns once a key was written past its wait index
+type memoryKV struct {
+	mu      sync.Mutex
+	pairs   map[string]api.KVPair
+	index   uint64
+	changed chan struct{}
+}
+
+func newMemoryKV() *memoryKV {
+	return &memoryKV{pairs: make(map[string]api.KVPair), index: 1, changed: make(chan struct{})}
+}
+
+func (m *memoryKV) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var pairs api.KVPairs
+	for key, pair := range m.pairs {
+		if strings.HasPrefix(key, prefix) {
+			pair := pair
+			pairs = append(pairs, &pair)
+		}
+	}
+	return pairs, &api.QueryMeta{LastIndex: m.index}, nil
+}
+
+func (m *memoryKV) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	m.mu.Lock()
+	if q != nil && q.WaitIndex > 0 && q.WaitIndex >= m.index {
+		changed := m.changed
+		m.mu.Unlock()
+		select {
+		case <-changed:
+		case <-q.Context().Done():
+			return nil, nil, q.Context().Err()
+		}
+		m.mu.Lock()
+	}
+	defer m.mu.Unlock()
+
+	meta := &api.QueryMeta{LastIndex: m.index}
+	pair, ok := m.pairs[key]
+	if !ok {
+		return nil, meta, nil
+	}
+	return &pair, meta, nil
+}
+
+func (m *memoryKV) Put(p *api.KVPair, q *api.WriteOptions) (*api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.index++
+	m.pairs[p.Key] = *p
+	close(m.changed)
+	m.changed = make(chan struct{})
+	return &api.WriteMeta{}, nil
+}
+
+// memoryLock is a validator lock shared by in-memory nodes. It puts its holder to key as the session, the way a Consul lock does, and
+// hands the role over like election.Handoff: the holder is asked to step down and the lock is kept for the chosen node
+type memoryLock struct {
+	kv  *memoryKV
+	key string
+
+	mu       sync.Mutex
+	holder   string
+	next     string
+	stepDown chan struct{}
+}
+
+func (l *memoryLock) set(holder string) {
+	l.holder = holder
+	l.kv.Put(&api.KVPair{Key: l.key, Session: holder}, nil)
+}
+
+// handOver asks the holder to step down in favour of the given node
+func (l *memoryLock) handOver(to string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == "" || l.next != "" {
+		return errors.New("no validator to hand over from")
+	}
+	l.next = to
+	close(l.stepDown)
+	return nil
+}
+
+// memoryLocker is the election.Locker of one node
+type memoryLocker struct {
+	lock     *memoryLock
+	name     string
+	stepDown chan struct{}
+}
+
+func (m *memoryLocker) Lock(ctx context.Context) (<-chan struct{}, error) {
+	for !m.tryLock() {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	// The lock is never lost, the nodes only step down
+	return make(chan struct{}), nil
+}
+
+func (m *memoryLocker) tryLock() bool {
+	l := m.lock
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder != "" || (l.next != "" && l.next != m.name) {
+		return false
+	}
+	l.next = ""
+	l.stepDown = make(chan struct{})
+	m.stepDown = l.stepDown
+	l.set(m.name)
+	return true
+}
+
+func (m *memoryLocker) Unlock() error {
+	l := m.lock
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == m.name {
+		l.set("")
+	}
+	return nil
+}
+
+// waitStepDown is the part of the hold stage returning election.ErrSteppedDown once the validator was asked to hand over
+func (m *memoryLocker) waitStepDown(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-m.stepDown:
+		return election.ErrSteppedDown
+	}
+}
+
+func startMemoryNode(t *testing.T, lock *memoryLock, name string, priority int, preemptive bool) *electedNode {
+	locker := &memoryLocker{lock: lock, name: name}
+	gate := &Gate{Node: syncedNode{}, KV: lock.kv, Prefix: "heights/", Name: name, MaxLag: 10, Priority: priority, MaxAge: 2 * time.Second, Interval: 100 * time.Millisecond}
+	preference := &Preference{Gate: gate, LockKey: lock.key, Delay: 500 * time.Millisecond, Stable: time.Second, Preemptive: preemptive, RequestHandoff: func(ctx context.Context, to string) error {
+		return lock.handOver(to)
+	}}
+
+	return runNode(t, gate, &election.Elector{
+		Locker:        locker,
+		BeforeAcquire: preference.Wait,
+		Hold:          election.Concurrently(preference.Watch, locker.waitStepDown),
+		StopValidator: func(ctx context.Context) error { return nil },
+		RetryDelay:    100 * time.Millisecond,
+	})
+}
+
+// testPreferredNodeRegainsValidation runs the standby and the preferred node started by start through a failure of the preferred node
+func testPreferredNodeRegainsValidation(t *testing.T, start func(name string, priority int, preemptive bool) *electedNode) {
+	// Only the standby runs, so it validates
+	standby := start("standby", 10, true)
+	defer standby.stop()
+	waitValidating(t, standby, "standby")
+
+	// The preferred node takes over once it has been ready for a while
+	preferred := start("preferred", 100, true)
+	waitValidating(t, preferred, "preferred")
+
+	// The standby takes over when the preferred node fails, despite the delay
+	preferred.stop()
+	waitValidating(t, standby, "standby")
+
+	// And hands the role back once the preferred node recovered
+	preferred = start("preferred", 100, true)
+	defer preferred.stop()
+	waitValidating(t, preferred, "preferred")
+}
+
+// testNonPreemptiveValidatorKeepsValidating starts the preferred node while the standby validates in the non-preemptive mode
+func testNonPreemptiveValidatorKeepsValidating(t *testing.T, start func(name string, priority int, preemptive bool) *electedNode) {
+	standby := start("standby", 10, false)
+	defer standby.stop()
+	waitValidating(t, standby, "standby")
+
+	preferred := start("preferred", 100, false)
+	defer preferred.stop()
+
+	// Well past the time a preemptive validator hands over
+	time.Sleep(3 * time.Second)
+	assert.Equal(t, election.StateHolding, standby.elector.State())
+	assert.NotEqual(t, election.StateHolding, preferred.elector.State())
+}
+
+func TestPreferredNodeRegainsValidation(t *testing.T) {
+	lock := &memoryLock{kv: newMemoryKV(), key: ".lock"}
+	testPreferredNodeRegainsValidation(t, func(name string, priority int, preemptive bool) *electedNode {
+		return startMemoryNode(t, lock, name, priority, preemptive)
+	})
+}
+
+func TestNonPreemptiveValidatorKeepsValidating(t *testing.T) {
+	lock := &memoryLock{kv: newMemoryKV(), key: ".lock"}
+	testNonPreemptiveValidatorKeepsValidating(t, func(name string, priority int, preemptive bool) *electedNode {
+		return startMemoryNode(t, lock, name, priority, preemptive)
+	})
+}
+
+// TestConsulPreferredNodeRegainsValidation runs against a real Consul agent and is skipped unless CONSUL_HTTP_ADDR is set, e.g.:
+//
+//	consul agent -dev &
+//	CONSUL_HTTP_ADDR=127.0.0.1:8500 go test ./agent/readiness/
+func TestConsulPreferredNodeRegainsValidation(t *testing.T) {
+	if os.Getenv("CONSUL_HTTP_ADDR") == "" {
+		t.Skip("CONSUL_HTTP_ADDR is not set, skipping test against a Consul agent")
+	}
+	client, err := api.NewClient(api.DefaultConfig())
+	require.NoError(t, err)
+	base := "failover-test-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	testPreferredNodeRegainsValidation(t, func(name string, priority int, preemptive bool) *electedNode {
+		return startNode(t, client, base, name, priority, preemptive)
+	})
+}
+
+func TestConsulNonPreemptiveValidatorKeepsValidating(t *testing.T) {
+	if os.Getenv("CONSUL_HTTP_ADDR") == "" {
+		t.Skip("CONSUL_HTTP_ADDR is not set, skipping test against a Consul agent")
+	}
+	client, err := api.NewClient(api.DefaultConfig())
+	require.NoError(t, err)
+	base := "failover-test-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	testNonPreemptiveValidatorKeepsValidating(t, func(name string, priority int, preemptive bool) *electedNode {
+		return startNode(t, client, base, name, priority, preemptive)
+	})
+}
@@ -0,0 +1,236 @@
+// Package readiness decides whether the local node is synced well enough to contend for the validator lock.
+//
+// The bootstrap script only waited for the RPC port to answer, so a node that was still syncing could win the lock. The gate requires
+// the node to have finished syncing, to have enough peers and to be within a number of blocks of the heights the other nodes publish.
+// A node of a fresh deployment may sync for hours, so the syncing requirement can be bounded in time, after which the lag behind the
+// other nodes decides alone.
+package readiness
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/polkadot"
+)
+
+// DefaultPrefix is the Consul prefix every node publishes its best block under, as <prefix><node name>
+const DefaultPrefix = "failover/heights/"
+
+// Defaults of the gate
+const (
+	DefaultMinPeers = 2
+	DefaultMaxLag   = 10
+	DefaultMaxAge   = 2 * time.Minute
+)
+
+// Node is the part of the node RPC the gate needs, implemented by *polkadot.Client
+type Node interface {
+	Health(ctx context.Context) (polkadot.Health, error)
+	BestBlock(ctx context.Context) (uint64, error)
+}
+
+// KV is the part of the Consul KV API the gate and the preference need, implemented by *api.KV
+type KV interface {
+	List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	Put(p *api.KVPair, q *api.WriteOptions) (*api.WriteMeta, error)
+}
+
+// Height is the best block a node published, along with its priority and whether its own gate passed
+type Height struct {
+	Node     string    `json:"-"`
+	Block    uint64    `json:"block"`
+	Priority int       `json:"priority"`
+	Ready    bool      `json:"ready"`
+	At       time.Time `json:"at"`
+}
+
+// NotReadyError explains why the node may not contend for the lock
+type NotReadyError struct {
+	Reasons []string
+}
+
+func (e *NotReadyError) Error() string {
+	return "node is not ready: " + strings.Join(e.Reasons, ", ")
+}
+
+// Evaluate applies the gate to the state of the local node and the heights published by the other nodes. A syncing node only passes
+// when allowSyncing is set. It returns a *NotReadyError listing every unmet condition
+func Evaluate(health polkadot.Health, best uint64, others []Height, minPeers int, maxLag uint64, allowSyncing bool) error {
+	var reasons []string
+
+	if health.IsSyncing && !allowSyncing {
+		reasons = append(reasons, "node is syncing")
+	}
+	if health.Peers < minPeers {
+		reasons = append(reasons, fmt.Sprintf("%d peers, at least %d required", health.Peers, minPeers))
+	}
+	for _, other := range others {
+		if other.Block > best+maxLag {
+			reasons = append(reasons, fmt.Sprintf("best block %d is %d blocks behind %s", best, other.Block-best, other.Node))
+		}
+	}
+
+	if len(reasons) > 0 {
+		return &NotReadyError{Reasons: reasons}
+	}
+	return nil
+}
+
+// Gate keeps the node out of the election until it is ready and publishes its height for the gates of the other nodes
+type Gate struct {
+	Node Node
+	KV   KV
+	// Prefix is DefaultPrefix if empty
+	Prefix string
+	// Name is the Consul node name of the local node
+	Name string
+	// MinPeers is the minimum number of peers, MaxLag the number of blocks the node may be behind any other node
+	MinPeers int
+	MaxLag   uint64
+	// Priority is published along with the height, see Preference
+	Priority int
+	// MaxAge is the age after which a published height is ignored, e.g. of a node that was terminated. DefaultMaxAge is used if zero
+	MaxAge time.Duration
+	// MaxSyncWait is the time, counted from the first check, after which a syncing node may contend for the lock as long as it is
+	// within MaxLag of the other nodes. The node has to finish syncing if zero
+	MaxSyncWait time.Duration
+	// Interval is the delay between two checks of Wait and two updates of Publish
+	Interval time.Duration
+	Logf     func(format string, args ...interface{})
+	Now      func() time.Time
+
+	startOnce sync.Once
+	started   time.Time
+}
+
+func (g *Gate) prefix() string {
+	if g.Prefix == "" {
+		return DefaultPrefix
+	}
+	return g.Prefix
+}
+
+func (g *Gate) now() time.Time {
+	if g.Now != nil {
+		return g.Now()
+	}
+	return time.Now()
+}
+
+func (g *Gate) logf(format string, args ...interface{}) {
+	if g.Logf != nil {
+		g.Logf(format, args...)
+	}
+}
+
+// Heights returns the recent heights published by the other nodes
+func (g *Gate) Heights(ctx context.Context) ([]Height, error) {
+	pairs, _, err := g.KV.List(g.prefix(), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	maxAge := g.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+
+	var heights []Height
+	for _, pair := range pairs {
+		name := strings.TrimPrefix(pair.Key, g.prefix())
+		if name == g.Name {
+			continue
+		}
+		var height Height
+		if err := json.Unmarshal(pair.Value, &height); err != nil {
+			g.logf("ERROR! %s has unexpected value: %s", pair.Key, err)
+			continue
+		}
+		if g.now().Sub(height.At) > maxAge {
+			continue
+		}
+		height.Node = name
+		heights = append(heights, height)
+	}
+	return heights, nil
+}
+
+// Check evaluates the gate once
+func (g *Gate) Check(ctx context.Context) error {
+	health, err := g.Node.Health(ctx)
+	if err != nil {
+		return err
+	}
+	best, err := g.Node.BestBlock(ctx)
+	if err != nil {
+		return err
+	}
+	others, err := g.Heights(ctx)
+	if err != nil {
+		return err
+	}
+	return Evaluate(health, best, others, g.MinPeers, g.MaxLag, g.allowSyncing())
+}
+
+// allowSyncing tells whether MaxSyncWait has elapsed since the first check
+func (g *Gate) allowSyncing() bool {
+	g.startOnce.Do(func() { g.started = g.now() })
+	return g.MaxSyncWait > 0 && g.now().Sub(g.started) >= g.MaxSyncWait
+}
+
+// Wait blocks until the node is ready. It is run before every attempt to acquire the lock
+func (g *Gate) Wait(ctx context.Context) error {
+	var last string
+	for {
+		err := g.Check(ctx)
+		if err == nil {
+			if last != "" {
+				g.logf("INFO. Node is ready to contend for the lock")
+			}
+			return nil
+		}
+		if err.Error() != last {
+			g.logf("INFO. Not contending for the lock: %s", err)
+			last = err.Error()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(g.interval()):
+		}
+	}
+}
+
+// Publish puts the best block and the readiness of the local node to Consul every interval until the context is done
+func (g *Gate) Publish(ctx context.Context) error {
+	for {
+		best, err := g.Node.BestBlock(ctx)
+		if err != nil {
+			g.logf("ERROR! Unable to get the best block: %s", err)
+		} else {
+			value, _ := json.Marshal(Height{Block: best, Priority: g.Priority, Ready: g.Check(ctx) == nil, At: g.now().UTC()})
+			if _, err := g.KV.Put(&api.KVPair{Key: g.prefix() + g.Name, Value: value}, (&api.WriteOptions{}).WithContext(ctx)); err != nil && ctx.Err() == nil {
+				g.logf("ERROR! Unable to publish the height: %s", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(g.interval()):
+		}
+	}
+}
+
+func (g *Gate) interval() time.Duration {
+	if g.Interval <= 0 {
+		return 10 * time.Second
+	}
+	return g.Interval
+}
@@ -0,0 +1,71 @@
+package readiness
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/polkadot"
+)
+
+func TestEvaluate(t *testing.T) {
+	synced := polkadot.Health{Peers: 8}
+	others := []Height{{Node: "i-1", Block: 1010}, {Node: "i-2", Block: 1005}}
+
+	tests := []struct {
+		name         string
+		health       polkadot.Health
+		best         uint64
+		others       []Height
+		allowSyncing bool
+		reasons      []string
+	}{
+		{name: "ready", health: synced, best: 1000, others: others},
+		{name: "ahead of the others", health: synced, best: 2000, others: others},
+		{name: "alone", health: synced, best: 10},
+		{name: "syncing", health: polkadot.Health{Peers: 8, IsSyncing: true}, best: 1000, others: others, reasons: []string{"node is syncing"}},
+		{name: "syncing allowed", health: polkadot.Health{Peers: 8, IsSyncing: true}, best: 1000, others: others, allowSyncing: true},
+		{name: "syncing allowed but behind", health: polkadot.Health{Peers: 8, IsSyncing: true}, best: 900, others: others, allowSyncing: true, reasons: []string{"best block 900 is 110 blocks behind i-1", "best block 900 is 105 blocks behind i-2"}},
+		{name: "few peers", health: polkadot.Health{Peers: 1}, best: 1000, others: others, reasons: []string{"1 peers, at least 2 required"}},
+		{name: "behind", health: synced, best: 999, others: others, reasons: []string{"best block 999 is 11 blocks behind i-1"}},
+		{
+			name:    "fresh node",
+			health:  polkadot.Health{IsSyncing: true},
+			best:    0,
+			others:  others,
+			reasons: []string{"node is syncing", "0 peers, at least 2 required", "best block 0 is 1010 blocks behind i-1", "best block 0 is 1005 blocks behind i-2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Evaluate(test.health, test.best, test.others, 2, 10, test.allowSyncing)
+			if test.reasons == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var notReady *NotReadyError
+			require.True(t, errors.As(err, &notReady))
+			assert.Equal(t, test.reasons, notReady.Reasons)
+		})
+	}
+}
+
+func TestGateAllowSyncing(t *testing.T) {
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	bounded := &Gate{MaxSyncWait: 5 * time.Minute, Now: func() time.Time { return now }}
+	unbounded := &Gate{Now: func() time.Time { return now }}
+
+	assert.False(t, bounded.allowSyncing())
+	assert.False(t, unbounded.allowSyncing())
+
+	now = now.Add(4 * time.Minute)
+	assert.False(t, bounded.allowSyncing())
+
+	now = now.Add(time.Minute)
+	assert.True(t, bounded.allowSyncing())
+	assert.False(t, unbounded.allowSyncing())
+}
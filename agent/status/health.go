@@ -0,0 +1,148 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/polkadot"
+)
+
+// Paths of the health endpoint, from the weakest to the strongest condition
+const (
+	// AlivePath is probed by the NLB target groups: the node answers and its finalized head advances
+	AlivePath = "/health/alive"
+	// ReadyPath additionally requires the node to be synced and to have enough peers to take over as a validator
+	ReadyPath = "/health/ready"
+	// ValidatingPath additionally requires the node to run with the Authority role
+	ValidatingPath = "/health/validating"
+)
+
+// DefaultHealthListen is the address of the health endpoint. Unlike the status endpoint it listens on every interface, as the NLB
+// probes it from its private addresses
+const DefaultHealthListen = ":9781"
+
+// Defaults of the health endpoint
+const (
+	DefaultHealthMinPeers  = 2
+	DefaultMaxFinalizedAge = 5 * time.Minute
+	DefaultHealthSampleTTL = 2 * time.Second
+)
+
+// Node is the state of the node the health endpoint judges
+type Node struct {
+	Syncing bool `json:"syncing"`
+	Peers   int  `json:"peers"`
+	// FinalizedBlock is the last block finalized by the node and FinalizedAt the time the finalized block last advanced
+	FinalizedBlock uint64     `json:"finalized_block"`
+	FinalizedAt    *time.Time `json:"finalized_at,omitempty"`
+	Role           string     `json:"role"`
+}
+
+// HealthReport is the answer of the health endpoint, served with 200 if Healthy and 503 otherwise
+type HealthReport struct {
+	Healthy bool `json:"healthy"`
+	// Reasons lists every unmet condition of the path
+	Reasons []string `json:"reasons,omitempty"`
+	Node
+	Time time.Time `json:"time"`
+}
+
+// Health serves the health of the node on AlivePath, ReadyPath and ValidatingPath. The node is sampled at most once every
+// DefaultHealthSampleTTL, so the probes of every target group and every NLB node share the RPC calls
+type Health struct {
+	Sample func(ctx context.Context) (Node, error)
+	// MinPeers is the number of peers a ready node needs, DefaultHealthMinPeers if zero
+	MinPeers int
+	// MaxFinalizedAge is the time the finalized block of an alive node may stay the same, DefaultMaxFinalizedAge if zero
+	MaxFinalizedAge time.Duration
+	Now             func() time.Time
+
+	mu      sync.Mutex
+	sampled time.Time
+	node    Node
+	err     error
+}
+
+func (h *Health) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
+
+func (h *Health) sample(ctx context.Context) (Node, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if now := h.now(); h.sampled.IsZero() || now.Sub(h.sampled) >= DefaultHealthSampleTTL {
+		h.node, h.err = h.Sample(ctx)
+		h.sampled = now
+	}
+	return h.node, h.err
+}
+
+// Evaluate lists the conditions of the path the node does not meet
+func (h *Health) Evaluate(path string, node Node, err error) []string {
+	if err != nil {
+		return []string{"node does not answer: " + err.Error()}
+	}
+
+	maxAge := h.MaxFinalizedAge
+	if maxAge <= 0 {
+		maxAge = DefaultMaxFinalizedAge
+	}
+	var reasons []string
+	if node.FinalizedAt == nil {
+		reasons = append(reasons, "finalized block not seen yet")
+	} else if age := h.now().Sub(*node.FinalizedAt); age > maxAge {
+		reasons = append(reasons, fmt.Sprintf("finalized block %d did not advance for %s", node.FinalizedBlock, age.Round(time.Second)))
+	}
+	if path == AlivePath {
+		return reasons
+	}
+
+	minPeers := h.MinPeers
+	if minPeers <= 0 {
+		minPeers = DefaultHealthMinPeers
+	}
+	if node.Syncing {
+		reasons = append(reasons, "node is syncing")
+	}
+	if node.Peers < minPeers {
+		reasons = append(reasons, fmt.Sprintf("%d peers, at least %d required", node.Peers, minPeers))
+	}
+	if path == ReadyPath {
+		return reasons
+	}
+
+	if node.Role != polkadot.RoleAuthority {
+		reasons = append(reasons, fmt.Sprintf("role is %q instead of %q", node.Role, polkadot.RoleAuthority))
+	}
+	return reasons
+}
+
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path != AlivePath && r.URL.Path != ReadyPath && r.URL.Path != ValidatingPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	node, err := h.sample(r.Context())
+	report := HealthReport{Reasons: h.Evaluate(r.URL.Path, node, err), Node: node, Time: h.now().UTC()}
+	report.Healthy = len(report.Reasons) == 0
+
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+}
@@ -0,0 +1,92 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func probe(t *testing.T, handler http.Handler, path string) (int, HealthReport) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	var report HealthReport
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	return recorder.Code, report
+}
+
+func TestHealthPaths(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	finalized := now.Add(-30 * time.Second)
+	node := Node{Peers: 8, FinalizedBlock: 1200, FinalizedAt: &finalized, Role: "Full"}
+	var sampleErr error
+	health := &Health{
+		Sample: func(ctx context.Context) (Node, error) { return node, sampleErr },
+		Now:    func() time.Time { now = now.Add(DefaultHealthSampleTTL); return now },
+	}
+
+	// A synced standby is alive and ready, but does not validate
+	code, report := probe(t, health, AlivePath)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, report.Healthy)
+	assert.Equal(t, uint64(1200), report.FinalizedBlock)
+	code, _ = probe(t, health, ReadyPath)
+	assert.Equal(t, http.StatusOK, code)
+	code, report = probe(t, health, ValidatingPath)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{`role is "Full" instead of "Authority"`}, report.Reasons)
+
+	node.Role = "Authority"
+	code, _ = probe(t, health, ValidatingPath)
+	assert.Equal(t, http.StatusOK, code)
+
+	// A syncing node is alive as long as it finalizes blocks, so the autoscaling group does not replace it
+	node.Syncing, node.Peers = true, 1
+	code, _ = probe(t, health, AlivePath)
+	assert.Equal(t, http.StatusOK, code)
+	code, report = probe(t, health, ReadyPath)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"node is syncing", "1 peers, at least 2 required"}, report.Reasons)
+
+	// A stalled or forked node keeps answering, but its finalized block does not advance
+	node.Syncing, node.Peers = false, 8
+	stalled := now.Add(-10 * time.Minute)
+	node.FinalizedAt = &stalled
+	code, report = probe(t, health, AlivePath)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	require.Len(t, report.Reasons, 1)
+	assert.Contains(t, report.Reasons[0], "finalized block 1200 did not advance for 10m")
+
+	sampleErr = errors.New("connection refused")
+	code, report = probe(t, health, AlivePath)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"node does not answer: connection refused"}, report.Reasons)
+
+	recorder := httptest.NewRecorder()
+	health.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestHealthSharesSamples(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	samples := 0
+	health := &Health{
+		Sample: func(ctx context.Context) (Node, error) { samples++; return Node{FinalizedAt: &now}, nil },
+		Now:    func() time.Time { return now },
+	}
+
+	for _, path := range []string{AlivePath, ReadyPath, ValidatingPath, AlivePath} {
+		probe(t, health, path)
+	}
+	assert.Equal(t, 1, samples)
+
+	now = now.Add(DefaultHealthSampleTTL)
+	probe(t, health, AlivePath)
+	assert.Equal(t, 2, samples)
+}
@@ -0,0 +1,63 @@
+// Package status exposes the state of the agent over HTTP, so operators and tools can see what the node does without SSH and docker logs
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// DefaultListen keeps the endpoint local to the instance
+const DefaultListen = "127.0.0.1:9780"
+
+// Status is the state of the node as seen by the agent
+type Status struct {
+	Instance string `json:"instance"`
+	// Election is the state of the elector, empty if the agent does not take part in the election
+	Election string `json:"election,omitempty"`
+	// FinalizedBlock is the last block finalized by the node and FinalizedAt the time it was seen
+	FinalizedBlock uint64     `json:"finalized_block"`
+	FinalizedAt    *time.Time `json:"finalized_at,omitempty"`
+	// BestBlock is the last value of best_block put to Consul by the node, zero if it never validated
+	BestBlock uint64 `json:"best_block"`
+	// Epoch is the epoch the node validates under, zero if it does not validate
+	Epoch uint64    `json:"epoch"`
+	Time  time.Time `json:"time"`
+}
+
+// Handler serves the status returned by Collect as JSON
+type Handler struct {
+	Collect func() Status
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := h.Collect()
+	status.Time = time.Now().UTC()
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(status)
+}
+
+// Serve runs the HTTP server until the context is done
+func Serve(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler, ReadTimeout: 10 * time.Second, WriteTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
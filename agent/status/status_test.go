@@ -0,0 +1,37 @@
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerServesStatus(t *testing.T) {
+	seen := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	handler := &Handler{Collect: func() Status {
+		return Status{Instance: "i-1", Election: "holding", FinalizedBlock: 1200, FinalizedAt: &seen, BestBlock: 1199}
+	}}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var status Status
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Equal(t, "i-1", status.Instance)
+	assert.Equal(t, "holding", status.Election)
+	assert.Equal(t, uint64(1200), status.FinalizedBlock)
+	assert.Equal(t, seen, *status.FinalizedAt)
+	assert.Equal(t, uint64(1199), status.BestBlock)
+	assert.False(t, status.Time.IsZero())
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/status", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
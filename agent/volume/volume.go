@@ -0,0 +1,434 @@
+// Package volume attaches a data volume of the deployment to the instance, replacing the disk_attach function of the bootstrap script.
+//
+// disk_attach attached the first available volume of the prefix whatever its availability zone was, so the attempt failed whenever
+// the volume lived in another zone, and two instances starting at the same time raced for the same volume. The Manager only considers
+// the volumes of the availability zone of the instance, takes a lease on a volume through a tag before attaching it and waits for the
+// attachment with a deadline.
+package volume
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// Defaults of the manager
+const (
+	DefaultDevice         = "/dev/sdb"
+	DefaultLeaseTag       = "FailoverLease"
+	DefaultLeaseTTL       = 5 * time.Minute
+	DefaultSettle         = 5 * time.Second
+	DefaultAttachTimeout  = 5 * time.Minute
+	DefaultReleaseTimeout = 10 * time.Minute
+	DefaultTimeout        = 30 * time.Minute
+)
+
+// Manager attaches a volume tagged with the prefix of the deployment to the instance, creating a new one if none is available
+type Manager struct {
+	EC2 ec2iface.EC2API
+	// Prefix is the value of the prefix tag of the volumes
+	Prefix string
+	// InstanceID and AvailabilityZone identify the local instance
+	InstanceID       string
+	AvailabilityZone string
+	// Device is the device name the volume is attached as, DefaultDevice if empty
+	Device string
+	// Size is the size of a new volume in GiB
+	Size int64
+	// DeleteOnTermination makes the volume deleted along with the instance
+	DeleteOnTermination bool
+	// LeaseTag is the tag holding the lease, DefaultLeaseTag if empty. A lease expires after LeaseTTL, DefaultLeaseTTL if zero
+	LeaseTag string
+	LeaseTTL time.Duration
+	// Settle is the time a lease has to survive to be taken, DefaultSettle if zero. It has to exceed the time between reading and
+	// tagging a volume, so that one of two instances tagging the same volume sees the tag of the other one
+	Settle time.Duration
+	// AttachTimeout bounds the wait for a volume to be created or attached, DefaultAttachTimeout if zero
+	AttachTimeout time.Duration
+	// ReleaseTimeout bounds the wait for the volumes of stopping instances, DefaultReleaseTimeout if zero
+	ReleaseTimeout time.Duration
+	// Timeout bounds the attempts to take one of the available volumes, DefaultTimeout if zero
+	Timeout time.Duration
+	// Interval is the delay between two polls of the volumes
+	Interval time.Duration
+	Logf     func(format string, args ...interface{})
+	Now      func() time.Time
+}
+
+func (m *Manager) device() string {
+	if m.Device == "" {
+		return DefaultDevice
+	}
+	return m.Device
+}
+
+func (m *Manager) leaseTag() string {
+	if m.LeaseTag == "" {
+		return DefaultLeaseTag
+	}
+	return m.LeaseTag
+}
+
+func duration(value, fallback time.Duration) time.Duration {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func (m *Manager) interval() time.Duration {
+	return duration(m.Interval, 5*time.Second)
+}
+
+func (m *Manager) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+	return time.Now()
+}
+
+func (m *Manager) logf(format string, args ...interface{}) {
+	if m.Logf != nil {
+		m.Logf(format, args...)
+	}
+}
+
+func (m *Manager) sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// Attach attaches a volume to the instance and returns its ID. A volume already attached as the device is kept, e.g. when the
+// bootstrap script is run again. Otherwise the oldest available volume of the availability zone is taken, as it most likely holds
+// the most blocks. If there is none but a volume of the zone is still attached to a stopping instance, Attach waits for it up to
+// ReleaseTimeout instead of creating a new volume the node would have to sync from scratch. Volumes that fail to be leased or attached
+// are retried until Timeout passes.
+func (m *Manager) Attach(ctx context.Context) (string, error) {
+	volumes, err := m.volumes(ctx)
+	if err != nil {
+		return "", err
+	}
+	if volume := m.attached(volumes); volume != nil {
+		m.logf("INFO. Volume %s is already attached as %s", aws.StringValue(volume.VolumeId), m.device())
+		return aws.StringValue(volume.VolumeId), nil
+	}
+
+	releaseDeadline := m.now().Add(duration(m.ReleaseTimeout, DefaultReleaseTimeout))
+	timeout := duration(m.Timeout, DefaultTimeout)
+	deadline := m.now().Add(timeout)
+	for {
+		candidates := m.candidates(volumes)
+		for _, volume := range candidates {
+			id := aws.StringValue(volume.VolumeId)
+			leased, err := m.lease(ctx, id)
+			if err != nil {
+				if ctx.Err() != nil {
+					return "", ctx.Err()
+				}
+				m.logf("ERROR! %s", err)
+				continue
+			}
+			if !leased {
+				m.logf("INFO. Volume %s was leased by another instance", id)
+				continue
+			}
+			if err := m.attach(ctx, id); err != nil {
+				if ctx.Err() != nil {
+					return "", ctx.Err()
+				}
+				m.logf("ERROR! Unable to attach volume %s: %s", id, err)
+				continue
+			}
+			return id, m.complete(ctx, id)
+		}
+
+		if len(candidates) == 0 {
+			releasing, err := m.releasing(ctx, volumes)
+			if err != nil {
+				return "", err
+			}
+			if len(releasing) == 0 {
+				return m.create(ctx)
+			}
+			if m.now().After(releaseDeadline) {
+				m.logf("ERROR! Volumes %s were not released in time", strings.Join(releasing, ", "))
+				return m.create(ctx)
+			}
+			m.logf("INFO. Waiting for volumes %s of stopping instances to be released", strings.Join(releasing, ", "))
+		} else if m.now().After(deadline) {
+			return "", fmt.Errorf("none of the volumes %s was attached within %s", ids(candidates), timeout)
+		}
+
+		if err := m.sleep(ctx, m.interval()); err != nil {
+			return "", err
+		}
+		if volumes, err = m.volumes(ctx); err != nil {
+			return "", err
+		}
+	}
+}
+
+func ids(volumes []*ec2.Volume) string {
+	var ids []string
+	for _, volume := range volumes {
+		ids = append(ids, aws.StringValue(volume.VolumeId))
+	}
+	return strings.Join(ids, ", ")
+}
+
+// volumes returns the volumes of the prefix in the availability zone of the instance
+func (m *Manager) volumes(ctx context.Context) ([]*ec2.Volume, error) {
+	input := &ec2.DescribeVolumesInput{Filters: []*ec2.Filter{
+		{Name: aws.String("tag:prefix"), Values: aws.StringSlice([]string{m.Prefix})},
+		{Name: aws.String("availability-zone"), Values: aws.StringSlice([]string{m.AvailabilityZone})},
+	}}
+	var volumes []*ec2.Volume
+	for {
+		output, err := m.EC2.DescribeVolumesWithContext(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe the volumes: %w", err)
+		}
+		volumes = append(volumes, output.Volumes...)
+		if aws.StringValue(output.NextToken) == "" {
+			return volumes, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// attached returns the volume attached to the instance as the device, if any
+func (m *Manager) attached(volumes []*ec2.Volume) *ec2.Volume {
+	for _, volume := range volumes {
+		for _, attachment := range volume.Attachments {
+			if aws.StringValue(attachment.InstanceId) == m.InstanceID && aws.StringValue(attachment.Device) == m.device() {
+				return volume
+			}
+		}
+	}
+	return nil
+}
+
+// candidates returns the available volumes nobody else holds a lease on, the oldest first
+func (m *Manager) candidates(volumes []*ec2.Volume) []*ec2.Volume {
+	var candidates []*ec2.Volume
+	for _, volume := range volumes {
+		if aws.StringValue(volume.State) != ec2.VolumeStateAvailable {
+			continue
+		}
+		if holder, ok := m.holder(volume.Tags); ok && holder != m.InstanceID {
+			continue
+		}
+		candidates = append(candidates, volume)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return aws.TimeValue(candidates[i].CreateTime).Before(aws.TimeValue(candidates[j].CreateTime))
+	})
+	return candidates
+}
+
+// holder returns the instance holding an unexpired lease among the tags
+func (m *Manager) holder(tags []*ec2.Tag) (string, bool) {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) != m.leaseTag() {
+			continue
+		}
+		parts := strings.SplitN(aws.StringValue(tag.Value), " ", 2)
+		if len(parts) != 2 {
+			return "", false
+		}
+		expires, err := time.Parse(time.RFC3339, parts[1])
+		if err != nil || m.now().After(expires) {
+			return "", false
+		}
+		return parts[0], true
+	}
+	return "", false
+}
+
+func (m *Manager) leaseValue() string {
+	return m.InstanceID + " " + m.now().Add(duration(m.LeaseTTL, DefaultLeaseTTL)).UTC().Format(time.RFC3339)
+}
+
+// lease tags the volume with the lease of the instance and reports whether the lease survived Settle. Tags have no check-and-set,
+// so the last instance to tag the volume wins. Two instances may still both believe they won if one of them tags the volume later
+// than Settle after reading it, in which case EC2 refuses the second attachment
+func (m *Manager) lease(ctx context.Context, id string) (bool, error) {
+	_, err := m.EC2.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+		Resources: aws.StringSlice([]string{id}),
+		Tags:      []*ec2.Tag{{Key: aws.String(m.leaseTag()), Value: aws.String(m.leaseValue())}},
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to lease volume %s: %w", id, err)
+	}
+	if err := m.sleep(ctx, duration(m.Settle, DefaultSettle)); err != nil {
+		return false, err
+	}
+
+	output, err := m.EC2.DescribeVolumesWithContext(ctx, &ec2.DescribeVolumesInput{VolumeIds: aws.StringSlice([]string{id})})
+	if err != nil {
+		return false, fmt.Errorf("unable to describe volume %s: %w", id, err)
+	}
+	if len(output.Volumes) == 0 || aws.StringValue(output.Volumes[0].State) != ec2.VolumeStateAvailable {
+		return false, nil
+	}
+	holder, ok := m.holder(output.Volumes[0].Tags)
+	return ok && holder == m.InstanceID, nil
+}
+
+// attach requests the attachment of the leased volume. EC2 refuses it if another instance attached the volume first
+func (m *Manager) attach(ctx context.Context, id string) error {
+	m.logf("INFO. Attaching volume %s as %s", id, m.device())
+	_, err := m.EC2.AttachVolumeWithContext(ctx, &ec2.AttachVolumeInput{
+		Device:     aws.String(m.device()),
+		InstanceId: aws.String(m.InstanceID),
+		VolumeId:   aws.String(id),
+	})
+	return err
+}
+
+// complete waits until EC2 reports the attachment complete, since the API call returns before that. The lease is dropped once the
+// volume is attached, as the attachment itself keeps the other instances away
+func (m *Manager) complete(ctx context.Context, id string) error {
+	err := m.waitVolume(ctx, id, "attached", func(volume *ec2.Volume) bool {
+		for _, attachment := range volume.Attachments {
+			if aws.StringValue(attachment.InstanceId) == m.InstanceID && aws.StringValue(attachment.State) == ec2.VolumeAttachmentStateAttached {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+
+	if m.DeleteOnTermination {
+		_, err := m.EC2.ModifyInstanceAttributeWithContext(ctx, &ec2.ModifyInstanceAttributeInput{
+			InstanceId: aws.String(m.InstanceID),
+			BlockDeviceMappings: []*ec2.InstanceBlockDeviceMappingSpecification{{
+				DeviceName: aws.String(m.device()),
+				Ebs:        &ec2.EbsInstanceBlockDeviceSpecification{DeleteOnTermination: aws.Bool(true)},
+			}},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to delete volume %s on termination: %w", id, err)
+		}
+	}
+
+	_, err = m.EC2.DeleteTagsWithContext(ctx, &ec2.DeleteTagsInput{
+		Resources: aws.StringSlice([]string{id}),
+		Tags:      []*ec2.Tag{{Key: aws.String(m.leaseTag())}},
+	})
+	if err != nil {
+		m.logf("ERROR! Unable to drop the lease of volume %s: %s", id, err)
+	}
+	m.logf("INFO. Volume %s is attached as %s", id, m.device())
+	return nil
+}
+
+// waitVolume polls the volume until done reports true or AttachTimeout passes
+func (m *Manager) waitVolume(ctx context.Context, id, state string, done func(*ec2.Volume) bool) error {
+	timeout := duration(m.AttachTimeout, DefaultAttachTimeout)
+	deadline := m.now().Add(timeout)
+	for {
+		output, err := m.EC2.DescribeVolumesWithContext(ctx, &ec2.DescribeVolumesInput{VolumeIds: aws.StringSlice([]string{id})})
+		if err != nil {
+			m.logf("ERROR! Unable to describe volume %s: %s", id, err)
+		} else if len(output.Volumes) > 0 && done(output.Volumes[0]) {
+			return nil
+		}
+		if m.now().After(deadline) {
+			return fmt.Errorf("volume %s is not %s after %s", id, state, timeout)
+		}
+		if err := m.sleep(ctx, m.interval()); err != nil {
+			return err
+		}
+	}
+}
+
+// releasing returns the volumes of the availability zone that are being detached or are attached to an instance that is stopping
+func (m *Manager) releasing(ctx context.Context, volumes []*ec2.Volume) ([]string, error) {
+	byInstance := make(map[string][]string)
+	var releasing []string
+	for _, volume := range volumes {
+		for _, attachment := range volume.Attachments {
+			if aws.StringValue(attachment.State) == ec2.VolumeAttachmentStateDetaching {
+				releasing = append(releasing, aws.StringValue(volume.VolumeId))
+			} else {
+				instance := aws.StringValue(attachment.InstanceId)
+				byInstance[instance] = append(byInstance[instance], aws.StringValue(volume.VolumeId))
+			}
+		}
+	}
+	if len(byInstance) == 0 {
+		return releasing, nil
+	}
+
+	var ids []string
+	for instance := range byInstance {
+		ids = append(ids, instance)
+	}
+	sort.Strings(ids)
+	input := &ec2.DescribeInstancesInput{
+		InstanceIds: aws.StringSlice(ids),
+		Filters: []*ec2.Filter{
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"shutting-down", "stopping", "stopped"})},
+		},
+	}
+	for {
+		output, err := m.EC2.DescribeInstancesWithContext(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe the instances the volumes are attached to: %w", err)
+		}
+		for _, reservation := range output.Reservations {
+			for _, instance := range reservation.Instances {
+				releasing = append(releasing, byInstance[aws.StringValue(instance.InstanceId)]...)
+			}
+		}
+		if aws.StringValue(output.NextToken) == "" {
+			return releasing, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// create creates a new volume in the availability zone, leased by the instance from the start, and attaches it
+func (m *Manager) create(ctx context.Context) (string, error) {
+	m.logf("INFO. No volume is available in %s, creating a new one of %d GiB", m.AvailabilityZone, m.Size)
+	volume, err := m.EC2.CreateVolumeWithContext(ctx, &ec2.CreateVolumeInput{
+		AvailabilityZone: aws.String(m.AvailabilityZone),
+		Size:             aws.Int64(m.Size),
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: aws.String(ec2.ResourceTypeVolume),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("prefix"), Value: aws.String(m.Prefix)},
+				{Key: aws.String("Name"), Value: aws.String(m.Prefix + "-polkadot-failover-data")},
+				{Key: aws.String(m.leaseTag()), Value: aws.String(m.leaseValue())},
+			},
+		}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to create a volume: %w", err)
+	}
+	id := aws.StringValue(volume.VolumeId)
+
+	err = m.waitVolume(ctx, id, ec2.VolumeStateAvailable, func(volume *ec2.Volume) bool {
+		return aws.StringValue(volume.State) == ec2.VolumeStateAvailable
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := m.attach(ctx, id); err != nil {
+		return "", fmt.Errorf("unable to attach volume %s: %w", id, err)
+	}
+	return id, m.complete(ctx, id)
+}
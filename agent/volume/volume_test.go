@@ -0,0 +1,355 @@
+package volume
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEC2 keeps the volumes in memory. Attachments complete after attachPolls descriptions of the volume, never if attachPolls is
+// negative. Volumes attached to an instance in stopping are detached after detachPolls descriptions of the volumes. The first tagErrs
+// taggings fail, and so does every attachment while attachErr is set
+type fakeEC2 struct {
+	ec2iface.EC2API
+
+	mu          sync.Mutex
+	volumes     map[string]*ec2.Volume
+	stopping    map[string]bool
+	attachPolls int
+	detachPolls int
+	polls       map[string]int
+	created     int
+	modified    []string
+	tagErrs     int
+	attachErr   error
+}
+
+func newFakeEC2(volumes ...*ec2.Volume) *fakeEC2 {
+	f := &fakeEC2{volumes: make(map[string]*ec2.Volume), stopping: make(map[string]bool), polls: make(map[string]int)}
+	for _, volume := range volumes {
+		f.volumes[aws.StringValue(volume.VolumeId)] = volume
+	}
+	return f
+}
+
+func volume(id, zone string, age time.Duration, tags ...*ec2.Tag) *ec2.Volume {
+	return &ec2.Volume{
+		VolumeId:         aws.String(id),
+		AvailabilityZone: aws.String(zone),
+		State:            aws.String(ec2.VolumeStateAvailable),
+		CreateTime:       aws.Time(time.Now().Add(-age)),
+		Tags:             append([]*ec2.Tag{{Key: aws.String("prefix"), Value: aws.String("test")}}, tags...),
+	}
+}
+
+func attachedTo(v *ec2.Volume, instance string) *ec2.Volume {
+	v.State = aws.String(ec2.VolumeStateInUse)
+	v.Attachments = []*ec2.VolumeAttachment{{InstanceId: aws.String(instance), Device: aws.String(DefaultDevice), State: aws.String(ec2.VolumeAttachmentStateAttached)}}
+	return v
+}
+
+func tag(tags []*ec2.Tag, key string) (string, bool) {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value), true
+		}
+	}
+	return "", false
+}
+
+func matches(v *ec2.Volume, filters []*ec2.Filter) bool {
+	for _, filter := range filters {
+		var value string
+		switch aws.StringValue(filter.Name) {
+		case "tag:prefix":
+			value, _ = tag(v.Tags, "prefix")
+		case "availability-zone":
+			value = aws.StringValue(v.AvailabilityZone)
+		}
+		if value != aws.StringValue(filter.Values[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// copyVolume returns a snapshot of the volume, so that the caller never sees later changes
+func copyVolume(v *ec2.Volume) *ec2.Volume {
+	result := *v
+	result.Tags = append([]*ec2.Tag(nil), v.Tags...)
+	result.Attachments = nil
+	for _, attachment := range v.Attachments {
+		a := *attachment
+		result.Attachments = append(result.Attachments, &a)
+	}
+	return &result
+}
+
+func (f *fakeEC2) DescribeVolumesWithContext(ctx aws.Context, input *ec2.DescribeVolumesInput, opts ...request.Option) (*ec2.DescribeVolumesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &ec2.DescribeVolumesOutput{}
+	for id, v := range f.volumes {
+		if len(input.VolumeIds) > 0 && aws.StringValue(input.VolumeIds[0]) != id || !matches(v, input.Filters) {
+			continue
+		}
+		f.polls[id]++
+		for _, attachment := range v.Attachments {
+			switch {
+			case aws.StringValue(attachment.State) == "attaching" && f.attachPolls >= 0 && f.polls[id] > f.attachPolls:
+				attachment.State = aws.String(ec2.VolumeAttachmentStateAttached)
+			case f.stopping[aws.StringValue(attachment.InstanceId)] && f.polls[id] > f.detachPolls:
+				v.Attachments, v.State = nil, aws.String(ec2.VolumeStateAvailable)
+			}
+		}
+		output.Volumes = append(output.Volumes, copyVolume(v))
+	}
+	return output, nil
+}
+
+func (f *fakeEC2) CreateTagsWithContext(ctx aws.Context, input *ec2.CreateTagsInput, opts ...request.Option) (*ec2.CreateTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.tagErrs > 0 {
+		f.tagErrs--
+		return nil, awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)
+	}
+	v := f.volumes[aws.StringValue(input.Resources[0])]
+	for _, t := range input.Tags {
+		var tags []*ec2.Tag
+		for _, existing := range v.Tags {
+			if aws.StringValue(existing.Key) != aws.StringValue(t.Key) {
+				tags = append(tags, existing)
+			}
+		}
+		v.Tags = append(tags, t)
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (f *fakeEC2) DeleteTagsWithContext(ctx aws.Context, input *ec2.DeleteTagsInput, opts ...request.Option) (*ec2.DeleteTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v := f.volumes[aws.StringValue(input.Resources[0])]
+	var tags []*ec2.Tag
+	for _, existing := range v.Tags {
+		if aws.StringValue(existing.Key) != aws.StringValue(input.Tags[0].Key) {
+			tags = append(tags, existing)
+		}
+	}
+	v.Tags = tags
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+func (f *fakeEC2) AttachVolumeWithContext(ctx aws.Context, input *ec2.AttachVolumeInput, opts ...request.Option) (*ec2.VolumeAttachment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.attachErr != nil {
+		return nil, f.attachErr
+	}
+	v := f.volumes[aws.StringValue(input.VolumeId)]
+	if aws.StringValue(v.State) != ec2.VolumeStateAvailable {
+		return nil, awserr.New("VolumeInUse", fmt.Sprintf("%s is already attached to an instance", aws.StringValue(input.VolumeId)), nil)
+	}
+	attachment := &ec2.VolumeAttachment{InstanceId: input.InstanceId, Device: input.Device, VolumeId: input.VolumeId, State: aws.String("attaching")}
+	v.State, v.Attachments = aws.String(ec2.VolumeStateInUse), []*ec2.VolumeAttachment{attachment}
+	f.polls[aws.StringValue(input.VolumeId)] = 0
+	return attachment, nil
+}
+
+func (f *fakeEC2) CreateVolumeWithContext(ctx aws.Context, input *ec2.CreateVolumeInput, opts ...request.Option) (*ec2.Volume, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.created++
+	v := &ec2.Volume{
+		VolumeId:         aws.String(fmt.Sprintf("vol-new-%d", f.created)),
+		AvailabilityZone: input.AvailabilityZone,
+		Size:             input.Size,
+		State:            aws.String(ec2.VolumeStateAvailable),
+		CreateTime:       aws.Time(time.Now()),
+		Tags:             input.TagSpecifications[0].Tags,
+	}
+	f.volumes[aws.StringValue(v.VolumeId)] = v
+	return copyVolume(v), nil
+}
+
+func (f *fakeEC2) DescribeInstancesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, opts ...request.Option) (*ec2.DescribeInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reservation := &ec2.Reservation{}
+	for _, id := range input.InstanceIds {
+		if f.stopping[aws.StringValue(id)] {
+			reservation.Instances = append(reservation.Instances, &ec2.Instance{InstanceId: id, State: &ec2.InstanceState{Name: aws.String("stopping")}})
+		}
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{reservation}}, nil
+}
+
+func (f *fakeEC2) ModifyInstanceAttributeWithContext(ctx aws.Context, input *ec2.ModifyInstanceAttributeInput, opts ...request.Option) (*ec2.ModifyInstanceAttributeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.modified = append(f.modified, aws.StringValue(input.InstanceId))
+	return &ec2.ModifyInstanceAttributeOutput{}, nil
+}
+
+func (f *fakeEC2) attachedTo(id string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v := f.volumes[id]
+	if len(v.Attachments) == 0 {
+		return ""
+	}
+	return aws.StringValue(v.Attachments[0].InstanceId)
+}
+
+func manager(f *fakeEC2, instance string) *Manager {
+	return &Manager{
+		EC2:              f,
+		Prefix:           "test",
+		InstanceID:       instance,
+		AvailabilityZone: "us-east-1a",
+		Size:             50,
+		Settle:           20 * time.Millisecond,
+		AttachTimeout:    time.Second,
+		ReleaseTimeout:   time.Second,
+		Interval:         time.Millisecond,
+	}
+}
+
+func TestAttachOldestVolumeOfTheZone(t *testing.T) {
+	f := newFakeEC2(
+		volume("vol-other-zone", "us-east-1b", 3*time.Hour),
+		volume("vol-old", "us-east-1a", 2*time.Hour),
+		volume("vol-new", "us-east-1a", time.Hour),
+		attachedTo(volume("vol-used", "us-east-1a", 4*time.Hour), "i-validator"),
+	)
+	f.attachPolls = 3
+	m := manager(f, "i-1")
+	m.DeleteOnTermination = true
+
+	id, err := m.Attach(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "vol-old", id)
+	assert.Equal(t, "i-1", f.attachedTo("vol-old"))
+	assert.Equal(t, []string{"i-1"}, f.modified)
+	assert.Equal(t, 0, f.created)
+
+	_, leased := tag(f.volumes["vol-old"].Tags, DefaultLeaseTag)
+	assert.False(t, leased, "lease should be dropped once the volume is attached")
+
+	// Running it again keeps the attached volume
+	id, err = m.Attach(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "vol-old", id)
+}
+
+func TestAttachSkipsLeasedVolumes(t *testing.T) {
+	lease := &ec2.Tag{Key: aws.String(DefaultLeaseTag), Value: aws.String("i-2 " + time.Now().Add(time.Minute).UTC().Format(time.RFC3339))}
+	expired := &ec2.Tag{Key: aws.String(DefaultLeaseTag), Value: aws.String("i-3 " + time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))}
+	f := newFakeEC2(
+		volume("vol-leased", "us-east-1a", 2*time.Hour, lease),
+		volume("vol-expired", "us-east-1a", time.Hour, expired),
+	)
+
+	id, err := manager(f, "i-1").Attach(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "vol-expired", id)
+}
+
+func TestConcurrentAttachTakesDifferentVolumes(t *testing.T) {
+	f := newFakeEC2(volume("vol-1", "us-east-1a", time.Hour))
+
+	var wg sync.WaitGroup
+	ids := make([]string, 3)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, err := manager(f, fmt.Sprintf("i-%d", i)).Attach(context.Background())
+			assert.NoError(t, err)
+			ids[i] = id
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, id := range ids {
+		assert.False(t, seen[id], "%s attached twice", id)
+		seen[id] = true
+		assert.Equal(t, fmt.Sprintf("i-%d", i), f.attachedTo(id))
+	}
+	assert.True(t, seen["vol-1"])
+	assert.Equal(t, 2, f.created)
+}
+
+func TestAttachWaitsForVolumesOfStoppingInstances(t *testing.T) {
+	f := newFakeEC2(attachedTo(volume("vol-1", "us-east-1a", time.Hour), "i-old"))
+	f.stopping["i-old"] = true
+	f.detachPolls = 5
+
+	id, err := manager(f, "i-1").Attach(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "vol-1", id)
+	assert.Equal(t, 0, f.created)
+}
+
+func TestAttachCreatesVolumeInTheZone(t *testing.T) {
+	f := newFakeEC2(volume("vol-other-zone", "us-east-1b", time.Hour))
+
+	id, err := manager(f, "i-1").Attach(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "vol-new-1", id)
+	assert.Equal(t, "us-east-1a", aws.StringValue(f.volumes[id].AvailabilityZone))
+	assert.Equal(t, int64(50), aws.Int64Value(f.volumes[id].Size))
+	name, _ := tag(f.volumes[id].Tags, "Name")
+	assert.Equal(t, "test-polkadot-failover-data", name)
+}
+
+func TestAttachDeadline(t *testing.T) {
+	f := newFakeEC2(volume("vol-1", "us-east-1a", time.Hour))
+	f.attachPolls = -1
+	m := manager(f, "i-1")
+	m.AttachTimeout = 50 * time.Millisecond
+
+	_, err := m.Attach(context.Background())
+	assert.EqualError(t, err, "volume vol-1 is not attached after 50ms")
+}
+
+func TestAttachRetriesLeaseErrors(t *testing.T) {
+	f := newFakeEC2(volume("vol-1", "us-east-1a", time.Hour))
+	f.tagErrs = 2
+
+	id, err := manager(f, "i-1").Attach(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "vol-1", id)
+	assert.Equal(t, 0, f.created)
+}
+
+func TestAttachTimeout(t *testing.T) {
+	f := newFakeEC2(volume("vol-1", "us-east-1a", time.Hour), volume("vol-2", "us-east-1a", 2*time.Hour))
+	f.attachErr = awserr.New("IncorrectState", "vol-1 is not available", nil)
+	m := manager(f, "i-1")
+	m.Timeout = 100 * time.Millisecond
+
+	_, err := m.Attach(context.Background())
+	assert.EqualError(t, err, "none of the volumes vol-2, vol-1 was attached within 100ms")
+	assert.Equal(t, 0, f.created)
+}
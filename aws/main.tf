@@ -54,6 +54,10 @@ module "primary_region" {
   key_name              = var.key_name
   key_content           = var.key_content
   chain                 = var.chain
+  agent_url             = var.agent_url
+  region_priorities     = var.region_priorities
+  max_sync_wait         = var.max_sync_wait
+  audit_retention_days  = var.audit_retention_days
 
   asg_role              = aws_iam_instance_profile.monitoring.name
   expose_ssh            = "true"
@@ -95,6 +99,10 @@ module "secondary_region" {
   key_name              = var.key_name
   key_content           = var.key_content
   chain                 = var.chain
+  agent_url             = var.agent_url
+  region_priorities     = var.region_priorities
+  max_sync_wait         = var.max_sync_wait
+  audit_retention_days  = var.audit_retention_days
   
   cpu_limit             = var.cpu_limit
   ram_limit             = var.ram_limit
@@ -136,6 +144,10 @@ module "tertiary_region" {
   key_name              = var.key_name
   key_content           = var.key_content
   chain                 = var.chain
+  agent_url             = var.agent_url
+  region_priorities     = var.region_priorities
+  max_sync_wait         = var.max_sync_wait
+  audit_retention_days  = var.audit_retention_days
   
   cpu_limit             = var.cpu_limit
   ram_limit             = var.ram_limit
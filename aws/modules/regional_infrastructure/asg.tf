@@ -48,7 +48,7 @@ resource "aws_launch_template" "polkadot" {
     }
   }
 
-  user_data = base64encode(templatefile("${path.module}/files/init.sh.tpl", { prefix = var.prefix, primary-region = var.regions[0], secondary-region = var.regions[1], tertiary-region = var.regions[2], autoscaling-name = "${var.prefix}-polkadot-validator", chain = var.chain, disk_size = var.disk_size, cpu_limit = var.cpu_limit, ram_limit = var.ram_limit, lb-primary = var.lbs[0].dns_name, lb-secondary = var.lbs[1].dns_name, lb-tertiary = var.lbs[2].dns_name, delete_on_termination = var.delete_on_termination, total_instance_count = var.total_instance_count }))
+  user_data = base64encode(templatefile("${path.module}/files/init.sh.tpl", { prefix = var.prefix, primary-region = var.regions[0], secondary-region = var.regions[1], tertiary-region = var.regions[2], autoscaling-name = "${var.prefix}-polkadot-validator", chain = var.chain, disk_size = var.disk_size, cpu_limit = var.cpu_limit, ram_limit = var.ram_limit, lb-primary = var.lbs[0].dns_name, lb-secondary = var.lbs[1].dns_name, lb-tertiary = var.lbs[2].dns_name, delete_on_termination = var.delete_on_termination, total_instance_count = var.total_instance_count, agent_url = var.agent_url, region_priorities = var.region_priorities, max_sync_wait = var.max_sync_wait }))
 }
 
 resource "aws_autoscaling_group" "polkadot" {
@@ -69,6 +69,17 @@ resource "aws_security_group" "validator-node" {
     cidr_blocks     = [var.cidrs[0],var.cidrs[1],var.cidrs[2]]
   }
   
+  # Health endpoint of failover-agent probed by the NLB
+  dynamic "ingress" {
+    for_each = var.agent_url == "" ? [] : [1]
+    content {
+        from_port   = 9781
+        to_port     = 9781
+        protocol    = "TCP"
+        cidr_blocks = [var.cidrs[0],var.cidrs[1],var.cidrs[2]]
+    }
+  }
+
   # Unrestricted outbound
   egress {
     from_port   = 0
@@ -78,6 +89,12 @@ resource "aws_security_group" "validator-node" {
   }
 
   tags = {
-    prefix = var.prefix
+    prefix  = var.prefix
+    # The API returns no creation time of security groups, failover-sweeper reads it from this tag
+    created = timestamp()
+  }
+
+  lifecycle {
+    ignore_changes = [tags["created"]]
   }
 }
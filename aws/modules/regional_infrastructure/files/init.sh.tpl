@@ -75,6 +75,12 @@ default_trap ()
 # On error notify cloudwatch and shutdown instance
   trap default_trap ERR EXIT
 
+%{ if agent_url != "" }
+# Install failover agent and attach a data disk of the availability zone of the instance with it
+/usr/bin/curl -sSfL "${agent_url}" -o /usr/local/bin/failover-agent
+chmod 755 /usr/local/bin/failover-agent
+/usr/local/bin/failover-agent -prefix "${prefix}" -attach-volume -volume-size ${disk_size} -delete-on-termination=${delete_on_termination}
+%{ else }
 # Check that there is no shutting down instance to prevent bug when disk is still not deattached from previous instance before attaching to this one
 INSTANCES_COUNT=$(aws ec2 describe-instances --region $(curl --silent http://169.254.169.254/latest/dynamic/instance-identity/document | jq -r .region) --filters "Name=instance-state-name,Values=shutting-down,stopping" "Name=tag:prefix,Values=${prefix}" --query 'Reservations[*].Instances[*].InstanceId' --output json | jq '. | length')
 
@@ -118,6 +124,7 @@ else
   done
 
 fi
+%{ endif }
 
 # Loop through attached disks
 for DISK in /dev/nvme?; do
@@ -161,6 +168,36 @@ done
 set -eE
 trap default_trap ERR EXIT
 
+%{ if agent_url != "" }
+# Run failover agent that checks node health from inside the VM and sends data to the CloudWatch. The settings file is the same in
+# every region, settings of a region can be overridden by the parameters under /polkadot/validator-failover/${prefix}/agent/
+cat <<EOF >/etc/failover-agent.yaml
+prefix: ${prefix}
+regions: ${primary-region},${secondary-region},${tertiary-region}
+autoscaling-group: ${autoscaling-name}
+chain: ${chain}
+ssm-overrides: true
+EOF
+
+cat <<EOF >/etc/systemd/system/failover-agent.service
+[Unit]
+Description=Polkadot failover agent
+After=docker.service
+Requires=docker.service
+
+[Service]
+EnvironmentFile=-/etc/default/failover-agent
+ExecStart=/usr/local/bin/failover-agent -config /etc/failover-agent.yaml \$AGENT_OPTS
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+EOF
+
+/usr/bin/systemctl daemon-reload
+/usr/bin/systemctl enable --now failover-agent
+%{ else }
 cat <<EOF >/usr/local/bin/watcher.sh
 #!/bin/bash
 \$(docker inspect -f "{{.State.Running}}" polkadot && curl -s -H "Content-Type: application/json" -d '{"id":1, "jsonrpc":"2.0", "method": "system_health", "params":[]}' http://127.0.0.1:9933);
@@ -187,6 +224,7 @@ chmod 777 /usr/local/bin/watcher.sh
 
 ### This will add a crontab entry that will check nodes health from inside the VM and send data to the CloudWatch
 (echo '* * * * * /usr/local/bin/watcher.sh') | crontab -
+%{ endif }
 
 # Clone and install consul
 git clone https://github.com/hashicorp/terraform-aws-consul.git
@@ -303,6 +341,36 @@ chmod 700 /usr/local/bin/key-insert.sh
 chmod 700 /usr/local/bin/best-grep.sh
 chmod 700 /usr/local/bin/double-signing-control.sh
 
+%{ if agent_url != "" }
+### The agent takes part in the election, guards against double signing and publishes the best block. It restarts the Polkadot container
+### as a validator or a full node itself, this script is its shutdown hook
+cat <<EOF >/usr/local/bin/node-shutdown.sh
+#!/bin/bash
+/usr/local/bin/consul leave
+shutdown now
+EOF
+
+chmod 700 /usr/local/bin/node-shutdown.sh
+
+cat <<EOF >>/etc/failover-agent.yaml
+election: true
+manage-container: true
+shutdown-command: /usr/local/bin/node-shutdown.sh
+audit-cloudwatch: true
+audit-file: /var/log/failover-audit.jsonl
+%{ if region_priorities != "" ~}
+region-priorities: ${region_priorities}
+%{ endif ~}
+%{ if max_sync_wait != "" ~}
+max-sync-wait: ${max_sync_wait}
+%{ endif ~}
+EOF
+/usr/bin/systemctl restart failover-agent
+
+# The agent shuts the instance down once it can not take part in the election anymore
+trap - ERR EXIT
+exit 0
+%{ else }
 # Create lock for the instance
 n=0
 set +eE
@@ -324,3 +392,4 @@ consul leave
 shutdown now
 
 # Instance will shutdown when loosing the lock because of no docker container will be running. ASG will replace the instance because it will not pass the ELB health check which monitors all the Consul ports and port 30333 (Polkadot node's port). 
+%{ endif }
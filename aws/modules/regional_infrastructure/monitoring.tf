@@ -91,3 +91,9 @@ resource "aws_cloudwatch_metric_alarm" "status" {
 
   depends_on = [aws_autoscaling_group.polkadot, aws_sns_topic.sns]
 }
+
+# Audit log of the role transitions shipped by failover-agent, every node writes to this group in every region
+resource "aws_cloudwatch_log_group" "audit" {
+  name              = "/polkadot/validator-failover/${var.prefix}/audit"
+  retention_in_days = var.audit_retention_days
+}
@@ -10,6 +10,11 @@ resource "aws_ssm_parameter" "keys" {
     environment = var.prefix
     type        = "key"
   }
+
+  # failoverctl rotate-keys replaces the keys, the variable only holds the initial ones
+  lifecycle {
+    ignore_changes = [value]
+  }
 }
 
 resource "aws_ssm_parameter" "seeds" {
@@ -24,6 +29,11 @@ resource "aws_ssm_parameter" "seeds" {
     environment = var.prefix
     type        = "seed"
   }
+
+  # failoverctl rotate-keys replaces the keys, the variable only holds the initial ones
+  lifecycle {
+    ignore_changes = [value]
+  }
 }
 
 resource "aws_ssm_parameter" "types" {
@@ -38,6 +48,11 @@ resource "aws_ssm_parameter" "types" {
     environment = var.prefix
     type        = "type"
   }
+
+  # failoverctl rotate-keys replaces the keys, the variable only holds the initial ones
+  lifecycle {
+    ignore_changes = [value]
+  }
 }
 
 resource "aws_ssm_parameter" "name" {
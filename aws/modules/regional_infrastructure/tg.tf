@@ -1,3 +1,11 @@
+# failover-agent serves the health of the node, so that a stalled or forked node is taken out of service and replaced by the
+# autoscaling group. Without the agent only the ports of the target groups are probed
+locals {
+  health_check_protocol = var.agent_url == "" ? "TCP" : "HTTP"
+  health_check_port     = var.agent_url == "" ? "traffic-port" : "9781"
+  health_check_path     = var.agent_url == "" ? null : "/health/alive"
+}
+
 resource "aws_lb_target_group" "http" {
   name     = "${var.prefix}-polkadot-validator-http"
   port     = 8500
@@ -9,7 +17,9 @@ resource "aws_lb_target_group" "http" {
   health_check {
 
     enabled  = true
-    protocol = "TCP"
+    protocol = local.health_check_protocol
+    port     = local.health_check_port
+    path     = local.health_check_path
     interval = var.health_check_interval
     healthy_threshold   = var.health_check_healthy_threshold
     unhealthy_threshold = var.health_check_unhealthy_threshold
@@ -28,7 +38,9 @@ resource "aws_lb_target_group" "dns" {
   health_check {
   
     enabled  = true
-    protocol = "TCP"
+    protocol = local.health_check_protocol
+    port     = local.health_check_port
+    path     = local.health_check_path
     interval = var.health_check_interval
     healthy_threshold   = var.health_check_healthy_threshold
     unhealthy_threshold = var.health_check_unhealthy_threshold
@@ -47,7 +59,9 @@ resource "aws_lb_target_group" "rpc" {
   health_check {
   
     enabled  = true
-    protocol = "TCP"
+    protocol = local.health_check_protocol
+    port     = local.health_check_port
+    path     = local.health_check_path
     interval = var.health_check_interval
     healthy_threshold   = var.health_check_healthy_threshold
     unhealthy_threshold = var.health_check_unhealthy_threshold
@@ -66,7 +80,9 @@ resource "aws_lb_target_group" "lan" {
   health_check {
   
     enabled  = true
-    protocol = "TCP"
+    protocol = local.health_check_protocol
+    port     = local.health_check_port
+    path     = local.health_check_path
     interval = var.health_check_interval
     healthy_threshold   = var.health_check_healthy_threshold
     unhealthy_threshold = var.health_check_unhealthy_threshold
@@ -85,7 +101,9 @@ resource "aws_lb_target_group" "wan" {
   health_check {
 
     enabled  = true
-    protocol = "TCP"
+    protocol = local.health_check_protocol
+    port     = local.health_check_port
+    path     = local.health_check_path
     interval = var.health_check_interval
     healthy_threshold   = var.health_check_healthy_threshold
     unhealthy_threshold = var.health_check_unhealthy_threshold
@@ -104,7 +122,9 @@ resource "aws_lb_target_group" "polkadot" {
   health_check {
 
     enabled  = true
-    protocol = "TCP"
+    protocol = local.health_check_protocol
+    port     = local.health_check_port
+    path     = local.health_check_path
     interval = var.health_check_interval
     healthy_threshold   = var.health_check_healthy_threshold
     unhealthy_threshold = var.health_check_unhealthy_threshold
@@ -98,3 +98,23 @@ variable "node_key" {
   description = "A unique ed25519 key that identifies the node"
 }
 
+
+variable "agent_url" {
+  default = ""
+  description = "URL to download the failover-agent binary from. If empty, the watcher.sh cron job publishes the metrics instead"
+}
+
+variable "region_priorities" {
+  default = ""
+  description = "Comma separated list of region=priority pairs passed to failover-agent"
+}
+
+variable "max_sync_wait" {
+  default = ""
+  description = "Time after which a syncing node may contend for the validator lock, passed to failover-agent"
+}
+
+variable "audit_retention_days" {
+  default = 90
+  description = "Number of days the audit log of failover-agent is kept in CloudWatch Logs"
+}
@@ -38,7 +38,8 @@ resource "aws_iam_policy" "monitoring" {
                 "ForAllValues:StringEquals": {
                     "aws:TagKeys": [
                         "prefix",
-                        "Name"
+                        "Name",
+                        "FailoverLease"
                     ]
                 }
             }
@@ -57,7 +58,8 @@ resource "aws_iam_policy" "monitoring" {
                 "ForAllValues:StringEquals": {
                     "aws:TagKeys": [
                         "prefix",
-                        "Name"
+                        "Name",
+                        "FailoverLease"
                     ]
                 },
                 "ForAnyValue:StringEquals": {
@@ -81,11 +83,42 @@ resource "aws_iam_policy" "monitoring" {
                 "ForAllValues:StringEquals": {
                     "aws:TagKeys": [
                         "prefix",
-                        "Name"
+                        "Name",
+                        "FailoverLease"
                     ]
                 }
             }
         },
+        {
+            "Effect": "Allow",
+            "Action": [
+                "ec2:CreateTags",
+                "ec2:DeleteTags"
+            ],
+            "Resource": "arn:aws:ec2:*:*:volume/*",
+            "Condition": {
+                "StringEquals": {
+                    "ec2:ResourceTag/prefix": "${var.prefix}"
+                },
+                "ForAllValues:StringEquals": {
+                    "aws:TagKeys": [
+                        "FailoverLease"
+                    ]
+                }
+            }
+        },
+        {
+            "Effect": "Allow",
+            "Action": [
+                "logs:CreateLogStream",
+                "logs:DescribeLogStreams",
+                "logs:PutLogEvents"
+            ],
+            "Resource": [
+                "arn:aws:logs:*:*:log-group:/polkadot/validator-failover/${var.prefix}/audit",
+                "arn:aws:logs:*:*:log-group:/polkadot/validator-failover/${var.prefix}/audit:*"
+            ]
+        },
         {
             "Effect": "Allow",
             "Action": [
@@ -106,3 +106,23 @@ variable "validator_keys" {
 variable "expose_ssh" {
   default = false
 }
+
+variable "agent_url" {
+  default = ""
+  description = "URL to download the failover-agent binary from (see cmd/README.md). If empty, the watcher.sh cron job publishes the health metrics instead"
+}
+
+variable "region_priorities" {
+  default = ""
+  description = "Comma separated list of region=priority pairs, e.g. us-east-1=100,us-east-2=50. Ready nodes of the region with the highest priority win the validator election. Only used by failover-agent"
+}
+
+variable "max_sync_wait" {
+  default = ""
+  description = "Time after which a syncing node may contend for the validator lock, e.g. 10m. If empty, a node has to finish syncing first. Only used by failover-agent"
+}
+
+variable "audit_retention_days" {
+  default = 90
+  description = "Number of days the audit log of the role transitions is kept in CloudWatch Logs. Only used by failover-agent"
+}
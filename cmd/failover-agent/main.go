@@ -0,0 +1,731 @@
+// failover-agent runs on every node of the deployment next to the Polkadot container. It samples the node and publishes
+// the "Health report", "Block Number" and "Validator count" CloudWatch metrics to every region, replacing the watcher.sh cron job.
+// With -election it also takes part in the validator election through the local Consul agent, replacing the `consul lock` loop.
+// With -attach-volume it attaches a data volume of the deployment to the instance and exits, replacing the disk_attach function.
+// Every flag can also be set in the -config file, in FAILOVER_AGENT_* environment variables and, with -ssm-overrides, in SSM.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/audit"
+	"github.com/protofire/polkadot-failover-mechanism/agent/config"
+	"github.com/protofire/polkadot-failover-mechanism/agent/docker"
+	"github.com/protofire/polkadot-failover-mechanism/agent/election"
+	"github.com/protofire/polkadot-failover-mechanism/agent/keys"
+	"github.com/protofire/polkadot-failover-mechanism/agent/metrics"
+	"github.com/protofire/polkadot-failover-mechanism/agent/notify"
+	"github.com/protofire/polkadot-failover-mechanism/agent/polkadot"
+	"github.com/protofire/polkadot-failover-mechanism/agent/readiness"
+	"github.com/protofire/polkadot-failover-mechanism/agent/status"
+	"github.com/protofire/polkadot-failover-mechanism/agent/volume"
+)
+
+func main() {
+	prefix := flag.String("prefix", os.Getenv("PREFIX"), "Prefix of the deployment, used as the CloudWatch namespace")
+	regions := flag.String("regions", "us-east-1,us-east-2,eu-west-1", "Comma separated list of the regions to publish metrics to")
+	asg := flag.String("autoscaling-group", "", "Name of the autoscaling group the node belongs to. Defaults to <prefix>-polkadot-validator")
+	instanceID := flag.String("instance-id", "", "ID of the instance. Taken from the instance metadata if not set")
+	rpcURL := flag.String("rpc-url", polkadot.DefaultURL, "RPC endpoint of the Polkadot node")
+	dockerSocket := flag.String("docker-socket", docker.DefaultSocket, "Path to the Docker Engine API socket")
+	container := flag.String("container", "polkadot", "Name of the Polkadot container")
+	interval := flag.Duration("interval", 15*time.Second, "Delay between two samples of the node. Samples are aggregated per minute")
+	flushInterval := flag.Duration("flush-interval", 10*time.Second, "Delay between two attempts to send the finished minutes to CloudWatch")
+	listen := flag.String("listen", status.DefaultListen, "Address of the status endpoint. Disabled if empty")
+	healthListen := flag.String("health-listen", status.DefaultHealthListen, "Address of the health endpoint probed by the NLB target groups. Disabled if empty")
+	maxFinalizedAge := flag.Duration("max-finalized-age", status.DefaultMaxFinalizedAge, "Time the finalized block may stay the same before the node is reported as not alive")
+
+	elect := flag.Bool("election", false, "Take part in the validator election through the local Consul agent")
+	lockKey := flag.String("lock-key", election.DefaultLockKey, "Consul key of the validator lock")
+	insertKeys := flag.String("insert-keys-command", "", "Command inserting the session keys into the node. The keys are inserted from SSM and verified by the agent if not set")
+	ssmRegion := flag.String("ssm-region", "", "Region to read the session keys from. Defaults to the region of the instance")
+	startValidator := flag.String("start-validator-command", "", "Command restarting the node as a validator. Required with -election unless -manage-container is set")
+	stopValidator := flag.String("stop-validator-command", "", "Command restarting the node as a full node. Required with -election unless -manage-container is set")
+	minPeers := flag.Int("min-peers", readiness.DefaultMinPeers, "Minimum number of peers the node needs to contend for the lock")
+	maxLag := flag.Uint64("max-lag", readiness.DefaultMaxLag, "Number of blocks the node may be behind the other nodes to contend for the lock")
+	maxSyncWait := flag.Duration("max-sync-wait", 0, "Time after which the node may contend for the lock while syncing, as long as it is within -max-lag of the other nodes. The node has to finish syncing if zero")
+	priority := flag.Int("priority", 0, "Election priority of the node, overrides -priority-tag and -region-priorities. Ready nodes with a higher priority win the election")
+	priorityTag := flag.String("priority-tag", "FailoverPriority", "Instance tag holding the election priority of the node, overrides -region-priorities")
+	regionPriorities := flag.String("region-priorities", "", "Comma separated list of region=priority pairs, e.g. us-east-1=100,us-east-2=50")
+	priorityDelay := flag.Duration("priority-delay", readiness.DefaultDelay, "Time a node delays its lock attempt for every ready node with a higher priority")
+	nonPreemptive := flag.Bool("non-preemptive", false, "Keep validating when a node with a higher priority becomes ready, instead of handing the role over to it")
+	epochFile := flag.String("epoch-file", election.DefaultEpochFile, "File the epoch the node validated under is recorded to")
+	protectionFile := flag.String("protection-file", election.DefaultProtectionFile, "File the heights the node validated at are recorded to, the slashing-protection record")
+	shutdown := flag.String("shutdown-command", "", "Command run once the node must not take part in the election anymore, e.g. to terminate the instance")
+	auditCloudWatch := flag.Bool("audit-cloudwatch", false, "Ship the audit log of the election to the CloudWatch Logs group /polkadot/validator-failover/<prefix>/audit of every region")
+	auditFile := flag.String("audit-file", "", "File the audit log of the election is appended to, one JSON event per line. Disabled if empty")
+	webhookURL := flag.String("webhook-url", "", "URL the failover events are posted to as JSON. Disabled if empty")
+	slackURL := flag.String("slack-webhook-url", "", "Slack incoming webhook the failover events are posted to. Disabled if empty")
+	minSeverity := flag.String("notify-min-severity", string(notify.SeverityInfo), "Lowest severity of the events posted to -webhook-url and -slack-webhook-url: info, warning or critical")
+	pagerDutyKey := flag.String("pagerduty-routing-key", "", "Routing key of the PagerDuty Events v2 integration the failover events trigger alerts of. Disabled if empty")
+	pagerDutyURL := flag.String("pagerduty-url", notify.DefaultPagerDutyURL, "Endpoint of the PagerDuty Events API v2")
+	pagerDutySeverity := flag.String("pagerduty-min-severity", string(notify.SeverityCritical), "Lowest severity of the events triggering PagerDuty alerts")
+
+	manageContainer := flag.Bool("manage-container", false, "Run the Polkadot container through the Docker Engine API and restart it when it drifts from its spec. Replaces the start and stop validator commands")
+	image := flag.String("image", "chevdor/polkadot:latest", "Image of the Polkadot container, preferably pinned by digest")
+	chain := flag.String("chain", "kusama", "Chain the Polkadot node runs")
+	dataDir := flag.String("data-dir", "/data", "Directory of the host mounted as /data in the Polkadot container")
+
+	attach := flag.Bool("attach-volume", false, "Attach a data volume of the deployment to the instance and exit, printing the volume ID")
+	volumeSize := flag.Int64("volume-size", 50, "Size in GiB of the volume created by -attach-volume if none is available")
+	deleteOnTermination := flag.Bool("delete-on-termination", false, "Make the volume attached by -attach-volume deleted along with the instance")
+	attachTimeout := flag.Duration("attach-timeout", volume.DefaultAttachTimeout, "Time -attach-volume waits for a volume to be created or attached")
+
+	configFile := flag.String("config", os.Getenv(config.EnvName(config.DefaultEnvPrefix, "config")), "YAML or JSON file of settings named after the flags. Flags, FAILOVER_AGENT_* environment variables and SSM override it")
+	ssmOverrides := flag.Bool("ssm-overrides", false, "Read settings from the parameters under /polkadot/validator-failover/<prefix>/agent/ in the region of -ssm-region")
+	printSchema := flag.Bool("print-schema", false, "Print the JSON schema of the settings file and exit")
+	flag.Parse()
+
+	// A settings file must not turn the long running agent into the one-shot volume attachment
+	commandLineOnly := []string{"config", "print-schema", "attach-volume"}
+	if *printSchema {
+		schema, err := config.Schema(flag.CommandLine, commandLineOnly...)
+		if err != nil {
+			log.Fatal("ERROR! " + err.Error())
+		}
+		fmt.Println(string(schema))
+		return
+	}
+	settings := config.New(flag.CommandLine, commandLineOnly...)
+	if *configFile != "" {
+		if err := settings.ApplyFile(*configFile); err != nil {
+			log.Fatal("ERROR! Invalid settings file:\n" + err.Error())
+		}
+	}
+	if err := settings.ApplyEnv(config.DefaultEnvPrefix, os.LookupEnv); err != nil {
+		log.Fatal("ERROR! Invalid settings in the environment:\n" + err.Error())
+	}
+
+	if *prefix == "" {
+		log.Fatal("ERROR! " + settings.Errorf("prefix", "is required, set it or the PREFIX environment variable").Error())
+	}
+	if *attach {
+		if err := attachVolume(*prefix, *volumeSize, *deleteOnTermination, *attachTimeout); err != nil {
+			log.Fatal("ERROR! " + err.Error())
+		}
+		return
+	}
+	if *asg == "" {
+		*asg = *prefix + "-polkadot-validator"
+	}
+
+	var instanceRegion string
+	if *instanceID == "" || *elect || *manageContainer || *ssmOverrides {
+		document, err := ec2metadata.New(session.Must(session.NewSession())).GetInstanceIdentityDocument()
+		if err != nil {
+			log.Fatal("ERROR! Unable to get the instance ID and region from the instance metadata: " + err.Error())
+		}
+		if *instanceID == "" {
+			*instanceID = document.InstanceID
+		}
+		instanceRegion = document.Region
+	}
+	if *ssmRegion == "" {
+		*ssmRegion = instanceRegion
+	}
+	if *ssmOverrides {
+		client := ssm.New(session.Must(session.NewSession(aws.NewConfig().WithRegion(*ssmRegion))))
+		if err := settings.ApplySSM(context.Background(), client, "/polkadot/validator-failover/"+*prefix+"/agent/"); err != nil {
+			log.Fatal("ERROR! Invalid settings in SSM:\n" + err.Error())
+		}
+	}
+
+	var invalid config.Errors
+	if *interval > time.Minute {
+		invalid = append(invalid, settings.Errorf("interval", "%s exceeds a minute, the CloudWatch alarms would see minutes without data", *interval))
+	}
+	for _, region := range strings.Split(*regions, ",") {
+		if strings.TrimSpace(region) == "" {
+			invalid = append(invalid, settings.Errorf("regions", "%q holds an empty region", *regions))
+			break
+		}
+	}
+	if parsed, err := url.Parse(*rpcURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		invalid = append(invalid, settings.Errorf("rpc-url", "%q is not an HTTP URL", *rpcURL))
+	}
+	if *minPeers < 0 {
+		invalid = append(invalid, settings.Errorf("min-peers", "must not be negative"))
+	}
+	if *maxSyncWait < 0 {
+		invalid = append(invalid, settings.Errorf("max-sync-wait", "must not be negative"))
+	}
+	if _, err := readiness.ParsePriorities(*regionPriorities); err != nil {
+		invalid = append(invalid, settings.Errorf("region-priorities", "%s", err))
+	}
+	if *elect && !*manageContainer && (*startValidator == "" || *stopValidator == "") {
+		invalid = append(invalid, settings.Errorf("start-validator-command", "start-validator-command and stop-validator-command are required with election unless manage-container is set"))
+	}
+	if *manageContainer && (*image == "" || *chain == "") {
+		invalid = append(invalid, settings.Errorf("image", "image and chain are required with manage-container"))
+	}
+	if _, err := notify.ParseSeverity(*minSeverity); err != nil {
+		invalid = append(invalid, settings.Errorf("notify-min-severity", "%s", err))
+	}
+	if _, err := notify.ParseSeverity(*pagerDutySeverity); err != nil {
+		invalid = append(invalid, settings.Errorf("pagerduty-min-severity", "%s", err))
+	}
+	if len(invalid) > 0 {
+		log.Fatal("ERROR! Invalid settings:\n" + invalid.Error())
+	}
+
+	clients := make(map[string]cloudwatchiface.CloudWatchAPI)
+	for _, region := range strings.Split(*regions, ",") {
+		region = strings.TrimSpace(region)
+		clients[region] = cloudwatch.New(session.Must(session.NewSession(aws.NewConfig().WithRegion(region))))
+	}
+
+	node := polkadot.NewClient(*rpcURL)
+	publisher := &metrics.Publisher{
+		Namespace:        *prefix,
+		AutoScalingGroup: *asg,
+		InstanceID:       *instanceID,
+		Clients:          clients,
+	}
+	sampler := &metrics.Sampler{
+		Node:       node,
+		Containers: docker.NewClient(*dockerSocket),
+		Container:  *container,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		log.Printf("INFO. Received %s, stopping", <-signals)
+		cancel()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		log.Printf("INFO. Publishing metrics of %s to %s every %s", *instanceID, *regions, *interval)
+		metrics.Run(ctx, sampler, publisher, *interval, *flushInterval, log.Printf)
+	}()
+
+	startHook, stopHook := command(*startValidator), command(*stopValidator)
+	if *manageContainer {
+		spec, err := containerSpec(ctx, ssm.New(session.Must(session.NewSession(aws.NewConfig().WithRegion(*ssmRegion)))), *prefix, *container, *image, *chain, *dataDir)
+		if err != nil {
+			log.Fatal("ERROR! Unable to read the container spec: " + err.Error())
+		}
+		controller := &docker.Controller{Client: docker.NewClient(*dockerSocket), Spec: spec, StopTimeout: time.Minute, Logf: log.Printf}
+		startHook, stopHook = controller.Validator, controller.Full
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Printf("INFO. Keeping the %s container running %s", *container, *image)
+			_ = controller.Run(ctx)
+		}()
+	}
+
+	finality := polkadot.NewFinality(node, 0)
+	go finality.Run(ctx)
+
+	current := &electionStatus{}
+	if *listen != "" {
+		handler := &status.Handler{Collect: func() status.Status {
+			result := status.Status{Instance: *instanceID}
+			number, at := finality.Latest()
+			if !at.IsZero() {
+				at = at.UTC()
+				result.FinalizedBlock, result.FinalizedAt = number, &at
+			}
+			if elector, bestBlock, epoch := current.get(); elector != nil {
+				result.Election = string(elector.State())
+				result.BestBlock = bestBlock.Published()
+				result.Epoch = epoch.Held()
+			}
+			return result
+		}}
+		mux := http.NewServeMux()
+		mux.Handle("/status", handler)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Printf("INFO. Serving the status on %s", *listen)
+			if err := status.Serve(ctx, *listen, mux); err != nil {
+				log.Printf("ERROR! Status endpoint failed: %s", err)
+			}
+		}()
+	}
+
+	if *healthListen != "" {
+		health := &status.Health{
+			Sample: func(ctx context.Context) (status.Node, error) {
+				return sampleNode(ctx, node, finality)
+			},
+			MinPeers:        *minPeers,
+			MaxFinalizedAge: *maxFinalizedAge,
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Printf("INFO. Serving the health on %s", *healthListen)
+			if err := status.Serve(ctx, *healthListen, health); err != nil {
+				log.Printf("ERROR! Health endpoint failed: %s", err)
+			}
+		}()
+	}
+
+	if *elect {
+		insert := command(*insertKeys)
+		if insert == nil {
+			inserter := &keys.Inserter{
+				Store:    &keys.Store{SSM: ssm.New(session.Must(session.NewSession(aws.NewConfig().WithRegion(*ssmRegion)))), Prefix: *prefix},
+				Keystore: node,
+				Logf:     log.Printf,
+			}
+			insert = inserter.Insert
+		}
+
+		explicit := false
+		flag.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "priority" })
+		if !explicit {
+			var err error
+			if *priority, err = resolvePriority(ctx, *instanceID, instanceRegion, *priorityTag, *regionPriorities); err != nil {
+				log.Fatal("ERROR! Unable to resolve the election priority: " + err.Error())
+			}
+		}
+		log.Printf("INFO. Election priority is %d", *priority)
+
+		notifier := &notify.Notifier{Deployment: *prefix, Logf: log.Printf}
+		notifySeverity, _ := notify.ParseSeverity(*minSeverity)
+		if *webhookURL != "" {
+			notifier.Targets = append(notifier.Targets, notify.Target{Name: "webhook", Channel: &notify.Webhook{URL: *webhookURL}, MinSeverity: notifySeverity})
+		}
+		if *slackURL != "" {
+			notifier.Targets = append(notifier.Targets, notify.Target{Name: "slack", Channel: &notify.Slack{URL: *slackURL}, MinSeverity: notifySeverity})
+		}
+		if *pagerDutyKey != "" {
+			pageSeverity, _ := notify.ParseSeverity(*pagerDutySeverity)
+			channel := &notify.PagerDuty{RoutingKey: *pagerDutyKey, URL: *pagerDutyURL}
+			notifier.Targets = append(notifier.Targets, notify.Target{Name: "pagerduty", Channel: channel, MinSeverity: pageSeverity})
+		}
+
+		// Notifications are sent from the audit log, so the log runs even without any of its own sinks
+		var auditLog *audit.Log
+		stopAudit := func() {}
+		if *auditCloudWatch || *auditFile != "" || len(notifier.Targets) > 0 {
+			auditLog = &audit.Log{Instance: *instanceID, Region: instanceRegion, Logf: log.Printf}
+			if *auditCloudWatch {
+				for _, region := range strings.Split(*regions, ",") {
+					client := cloudwatchlogs.New(session.Must(session.NewSession(aws.NewConfig().WithRegion(strings.TrimSpace(region)))))
+					auditLog.Sinks = append(auditLog.Sinks, &audit.CloudWatch{Client: client, Group: audit.LogGroup(*prefix), Stream: *instanceID})
+				}
+			}
+			if *auditFile != "" {
+				auditLog.Sinks = append(auditLog.Sinks, &audit.File{Path: *auditFile})
+			}
+			if len(notifier.Targets) > 0 {
+				auditLog.Sinks = append(auditLog.Sinks, notifier)
+			}
+
+			// The log outlives the election, so the reason the node left it is written before the shutdown command runs
+			auditCtx, cancelAudit := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				auditLog.Run(auditCtx)
+			}()
+			stopAudit = func() {
+				cancelAudit()
+				<-done
+			}
+		}
+
+		err := runElection(ctx, electionConfig{
+			lockKey:        *lockKey,
+			epochFile:      *epochFile,
+			protectionFile: *protectionFile,
+			minPeers:       *minPeers,
+			maxLag:         *maxLag,
+			maxSyncWait:    *maxSyncWait,
+			priority:       *priority,
+			priorityDelay:  *priorityDelay,
+			preemptive:     !*nonPreemptive,
+			insertKeys:     insert,
+			startValidator: startHook,
+			stopValidator:  stopHook,
+			audit:          auditLog,
+		}, finality, current)
+		stopAudit()
+		if err != nil {
+			log.Printf("ERROR! Leaving the election: %s", err)
+			if failErr := publisher.Fail(context.Background()); failErr != nil {
+				log.Printf("ERROR! %s", failErr)
+			}
+			if hook := command(*shutdown); hook != nil {
+				if cmdErr := hook(context.Background()); cmdErr != nil {
+					log.Printf("ERROR! Shutdown command failed: %s", cmdErr)
+				}
+			}
+		}
+		cancel()
+	}
+
+	wg.Wait()
+	log.Printf("INFO. Stopped")
+}
+
+// electionStatus keeps the parts of the election the status endpoint reports on
+type electionStatus struct {
+	mu        sync.Mutex
+	elector   *election.Elector
+	bestBlock *election.BestBlock
+	epoch     *election.Epoch
+}
+
+func (s *electionStatus) set(elector *election.Elector, bestBlock *election.BestBlock, epoch *election.Epoch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.elector, s.bestBlock, s.epoch = elector, bestBlock, epoch
+}
+
+func (s *electionStatus) get() (*election.Elector, *election.BestBlock, *election.Epoch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.elector, s.bestBlock, s.epoch
+}
+
+// sampleNode returns the state of the node judged by the health endpoint
+func sampleNode(ctx context.Context, node *polkadot.Client, finality *polkadot.Finality) (status.Node, error) {
+	health, err := node.Health(ctx)
+	if err != nil {
+		return status.Node{}, err
+	}
+	roles, err := node.NodeRoles(ctx)
+	if err != nil {
+		return status.Node{}, err
+	}
+
+	result := status.Node{Syncing: health.IsSyncing, Peers: health.Peers}
+	if len(roles) > 0 {
+		result.Role = roles[0]
+	}
+	number, at := finality.Advanced()
+	if !at.IsZero() {
+		at = at.UTC()
+		result.FinalizedBlock, result.FinalizedAt = number, &at
+	}
+	return result, nil
+}
+
+// fence stops a validator left running by a previous run of the agent: the session of that run is gone, so the node no longer holds the lock
+func fence(ctx context.Context, epoch *election.Epoch, node *polkadot.Client, stopValidator election.Hook, auditLog *audit.Log) error {
+	validating, err := node.IsValidator(ctx)
+	if err != nil || !validating {
+		// A node that does not answer yet is being started by the bootstrap script as a full node
+		return nil
+	}
+
+	recorded, err := epoch.Recorded()
+	if err != nil {
+		log.Printf("ERROR! Unable to read the recorded epoch: %s", err)
+	}
+	if current, _, err := epoch.Get(ctx); err == nil && current != nil && current.Epoch > recorded {
+		log.Printf("ERROR! Node validates under epoch %d, but epoch %d was started by %s", recorded, current.Epoch, current.Node)
+	}
+
+	log.Printf("INFO. Node validates without holding the lock, restarting it as a full node")
+	auditLog.Record(audit.Fenced, "node validated under epoch %d without holding the lock, restarting it as a full node", recorded)
+	if stopValidator == nil {
+		return election.ErrFenced
+	}
+	return stopValidator(ctx)
+}
+
+// electionConfig holds the flags of the election
+type electionConfig struct {
+	lockKey        string
+	epochFile      string
+	protectionFile string
+	minPeers       int
+	maxLag         uint64
+	maxSyncWait    time.Duration
+	priority       int
+	priorityDelay  time.Duration
+	preemptive     bool
+	insertKeys     election.Hook
+	startValidator election.Hook
+	stopValidator  election.Hook
+	// audit records the transitions of the node, nil if the audit log is disabled
+	audit *audit.Log
+}
+
+// attachVolume attaches a data volume in the availability zone of the instance, replacing disk_attach of the bootstrap script
+func attachVolume(prefix string, size int64, deleteOnTermination bool, timeout time.Duration) error {
+	document, err := ec2metadata.New(session.Must(session.NewSession())).GetInstanceIdentityDocument()
+	if err != nil {
+		return fmt.Errorf("unable to get the instance identity from the instance metadata: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	manager := &volume.Manager{
+		EC2:                 ec2.New(session.Must(session.NewSession(aws.NewConfig().WithRegion(document.Region)))),
+		Prefix:              prefix,
+		InstanceID:          document.InstanceID,
+		AvailabilityZone:    document.AvailabilityZone,
+		Size:                size,
+		DeleteOnTermination: deleteOnTermination,
+		AttachTimeout:       timeout,
+		Logf:                log.Printf,
+	}
+	id, err := manager.Attach(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to attach a volume: %w", err)
+	}
+	fmt.Println(id)
+	return nil
+}
+
+// containerSpec builds the spec of the Polkadot container from the parameters the bootstrap script read from SSM
+func containerSpec(ctx context.Context, client ssmiface.SSMAPI, prefix, name, image, chain, dataDir string) (docker.Spec, error) {
+	spec := docker.Spec{Name: name, Image: image, Chain: chain, DataDir: dataDir, P2PPort: 30333, RPCPort: 9933}
+
+	path := "/polkadot/validator-failover/" + prefix + "/"
+	parameters := make(map[string]string)
+	err := client.GetParametersByPathPagesWithContext(ctx, &ssm.GetParametersByPathInput{Path: aws.String(path), WithDecryption: aws.Bool(true)},
+		func(output *ssm.GetParametersByPathOutput, last bool) bool {
+			for _, parameter := range output.Parameters {
+				parameters[strings.TrimPrefix(aws.StringValue(parameter.Name), path)] = aws.StringValue(parameter.Value)
+			}
+			return true
+		})
+	if err != nil {
+		return spec, err
+	}
+	for _, name := range []string{"cpu_limit", "ram_limit", "name", "node_key"} {
+		if parameters[name] == "" {
+			return spec, fmt.Errorf("parameter %s%s is not set", path, name)
+		}
+	}
+
+	if spec.CPUs, err = strconv.ParseFloat(parameters["cpu_limit"], 64); err != nil {
+		return spec, fmt.Errorf("cpu_limit: %w", err)
+	}
+	if spec.MemoryGB, err = strconv.ParseInt(parameters["ram_limit"], 10, 64); err != nil {
+		return spec, fmt.Errorf("ram_limit: %w", err)
+	}
+	spec.NodeName, spec.NodeKey = parameters["name"], parameters["node_key"]
+	return spec, nil
+}
+
+// resolvePriority returns the priority of the instance from its tag or, if the tag is not set, from the priorities of the regions
+func resolvePriority(ctx context.Context, instanceID, region, tag, regionPriorities string) (int, error) {
+	if tag != "" {
+		output, err := ec2.New(session.Must(session.NewSession(aws.NewConfig().WithRegion(region)))).DescribeTagsWithContext(ctx, &ec2.DescribeTagsInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("resource-id"), Values: []*string{aws.String(instanceID)}},
+				{Name: aws.String("key"), Values: []*string{aws.String(tag)}},
+			},
+		})
+		if err != nil {
+			return 0, err
+		}
+		if len(output.Tags) > 0 {
+			priority, err := strconv.Atoi(aws.StringValue(output.Tags[0].Value))
+			if err != nil {
+				return 0, fmt.Errorf("tag %s: %w", tag, err)
+			}
+			return priority, nil
+		}
+	}
+
+	priorities, err := readiness.ParsePriorities(regionPriorities)
+	if err != nil {
+		return 0, err
+	}
+	return priorities[region], nil
+}
+
+// runElection takes part in the election until the context is done or the node must leave the election
+func runElection(ctx context.Context, config electionConfig, finality *polkadot.Finality, current *electionStatus) error {
+	locker, err := election.NewConsulLocker()
+	if err != nil {
+		return err
+	}
+	locker.Key = config.lockKey
+
+	// The session is bound to this check, so the lock is released soon after the agent dies. Consul may still be starting
+	for {
+		err := locker.RegisterCheck(30 * time.Second)
+		if err == nil {
+			break
+		}
+		log.Printf("ERROR! Unable to register the Consul check, retrying: %s", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(5 * time.Second):
+		}
+	}
+	go locker.Heartbeat(ctx, 10*time.Second, log.Printf)
+
+	bestBlock := &election.BestBlock{
+		KV:        locker.Client.KV(),
+		Finalized: finality.Finalized,
+		Interval:  7 * time.Second,
+		Logf:      log.Printf,
+	}
+
+	nodeName, err := locker.Client.Agent().NodeName()
+	if err != nil {
+		return err
+	}
+	handoff := &election.Handoff{
+		KV:       locker.Client.KV(),
+		Node:     nodeName,
+		Interval: 5 * time.Second,
+		Logf:     log.Printf,
+	}
+
+	freeze := &election.Freeze{
+		KV:       locker.Client.KV(),
+		Interval: 5 * time.Second,
+		Logf:     log.Printf,
+	}
+
+	epoch := &election.Epoch{
+		KV:       locker.Client.KV(),
+		Node:     nodeName,
+		File:     config.epochFile,
+		Interval: 5 * time.Second,
+		Logf:     log.Printf,
+	}
+	if config.audit != nil {
+		config.audit.Heights = func() (uint64, uint64) {
+			finalized, _ := finality.Latest()
+			return finalized, bestBlock.Published()
+		}
+		config.audit.Epoch = epoch.Held
+	}
+	if err := fence(ctx, epoch, finality.Client, config.stopValidator, config.audit); err != nil {
+		return err
+	}
+
+	protection := &election.Protection{
+		KV:        locker.Client.KV(),
+		File:      config.protectionFile,
+		Node:      nodeName,
+		Finalized: finality.Finalized,
+		Best:      finality.Client.BestBlock,
+		Session:   finality.Client.SessionIndex,
+		Epoch:     epoch.Held,
+		Interval:  7 * time.Second,
+		Logf:      log.Printf,
+	}
+	// Consul may have lost the record, so every node puts back the one of its volume before contending for the lock
+	if err := protection.Restore(ctx); err != nil {
+		log.Printf("ERROR! Unable to restore the slashing-protection record: %s", err)
+	}
+	go protection.Replicate(ctx)
+
+	// Every node publishes its height, so the others can tell whether they are synced
+	gate := &readiness.Gate{
+		Node:        finality.Client,
+		KV:          locker.Client.KV(),
+		Name:        nodeName,
+		MinPeers:    config.minPeers,
+		MaxLag:      config.maxLag,
+		MaxSyncWait: config.maxSyncWait,
+		Priority:    config.priority,
+		Interval:    10 * time.Second,
+		Logf:        log.Printf,
+	}
+	go gate.Publish(ctx)
+
+	preference := &readiness.Preference{
+		Gate:           gate,
+		LockKey:        locker.Key,
+		Delay:          config.priorityDelay,
+		Preemptive:     config.preemptive,
+		RequestHandoff: freeze.Unfrozen(handoff.Request),
+	}
+
+	// The lock may be won long after the gate passed, so the node is checked again before it gets the keys. The elections may have been
+	// frozen meanwhile as well, in which case the node holds the lock without advancing the epoch until they are unfrozen
+	elector := &election.Elector{
+		Locker:         locker,
+		BeforeAcquire:  election.Sequence(freeze.Wait, gate.Wait, preference.Wait, handoff.WaitTurn),
+		Guard:          election.Sequence(freeze.Wait, bestBlock.Guard, protection.Guard, epoch.Advance, config.audit.Hook(audit.EpochAdvanced, "epoch advanced")),
+		InsertKeys:     election.Sequence(gate.Check, config.insertKeys),
+		StartValidator: election.Sequence(epoch.Check, config.startValidator),
+		Hold:           handoff.Hold(election.Concurrently(bestBlock.Publish, protection.Track, epoch.Watch, preference.Watch)),
+		StopValidator:  election.Sequence(config.stopValidator, config.audit.Hook(audit.ValidatorStopped, "node restarted as a full node"), epoch.Release),
+		OnStepDown: func(ctx context.Context) error {
+			final, err := bestBlock.Final(ctx)
+			if err != nil {
+				return err
+			}
+			log.Printf("INFO. Stepped down at block %d", final)
+			config.audit.Record(audit.SteppedDown, "stepped down at block %d", final)
+			return handoff.Complete(ctx, final)
+		},
+		RetryDelay:      10 * time.Second,
+		StepDownTimeout: 2 * time.Minute,
+		OnTransition:    config.audit.Transition,
+		Logf:            log.Printf,
+	}
+	if config.audit != nil {
+		config.audit.State = elector.State
+	}
+	current.set(elector, bestBlock, epoch)
+
+	err = elector.Run(ctx)
+	var guardErr *election.GuardError
+	switch {
+	case err == nil:
+	case errors.As(err, &guardErr):
+		config.audit.Record(audit.GuardFailed, "%s", err)
+	case errors.Is(err, election.ErrFenced):
+		config.audit.Record(audit.Fenced, "%s", err)
+	default:
+		config.audit.Record(audit.ElectionLeft, "%s", err)
+	}
+	return err
+}
+
+func command(line string) election.Hook {
+	if line == "" {
+		return nil
+	}
+	fields := strings.Fields(line)
+	return election.Command(fields[0], fields[1:]...)
+}
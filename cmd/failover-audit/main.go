@@ -0,0 +1,91 @@
+// failover-audit runs the checks of the bundle test in read-only mode against an already running deployment.
+// It never applies or destroys infrastructure, so it is safe to run against production on demand or after every change.
+//
+// Set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (or AWS_PROFILE) before running it.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/ssh"
+
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+func main() {
+	prefix := flag.String("prefix", os.Getenv("PREFIX"), "Prefix of the deployment to audit")
+	regions := flag.String("regions", "us-east-1,us-east-2,eu-west-1", "Comma separated list of exactly three regions the deployment runs in")
+	sshKeyPath := flag.String("ssh-key", "", "Path to the private SSH key of the instances. Consul, Polkadot and Keystore checks are skipped if not set")
+	exposeSSH := flag.Bool("expose-ssh", true, "Expect port 22 to be open to the world, as set by the expose_ssh variable of the deployment")
+	timeout := flag.Duration("timeout", 10*time.Minute, "Upper bound of time the checks may spend waiting for eventually consistent state")
+	reportDir := flag.String("report-dir", "", "Folder to write junit.xml and report.json to")
+	quiet := flag.Bool("quiet", false, "Only print the summary, not the log of every check")
+	flag.Parse()
+
+	if *prefix == "" {
+		fmt.Fprintln(os.Stderr, "ERROR! -prefix (or PREFIX environment variable) is required")
+		os.Exit(2)
+	}
+
+	regionList := strings.Split(*regions, ",")
+	if len(regionList) != 3 {
+		fmt.Fprintln(os.Stderr, "ERROR! -regions should consist of exactly three regions")
+		os.Exit(2)
+	}
+
+	opts := checks.AuditOptions{
+		Prefix:    *prefix,
+		Regions:   [3]string{strings.TrimSpace(regionList[0]), strings.TrimSpace(regionList[1]), strings.TrimSpace(regionList[2])},
+		ExposeSSH: *exposeSSH,
+		Timeout:   *timeout,
+		Out:       os.Stdout,
+	}
+
+	if *quiet {
+		opts.Out = ioutil.Discard
+	}
+
+	if *sshKeyPath != "" {
+		privateKey, err := ioutil.ReadFile(*sshKeyPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR! Unable to read SSH key: "+err.Error())
+			os.Exit(2)
+		}
+		opts.SSHKey = &ssh.KeyPair{PrivateKey: string(privateKey)}
+	}
+
+	report := checks.Audit(context.Background(), opts)
+
+	fmt.Println()
+	fmt.Printf("Audit of %s in %s took %.0fs\n", *prefix, strings.Join(opts.Regions[:], ", "), report.Seconds)
+	for _, check := range report.Checks {
+		status := "PASS"
+		if !check.Passed {
+			status = "FAIL"
+		}
+		fmt.Printf("%s  %-24s %6.1fs\n", status, check.Name, check.Seconds)
+
+		for _, finding := range check.Findings {
+			if finding.Severity == checks.SeverityError {
+				fmt.Printf("      %s\n", finding.String())
+			}
+		}
+	}
+
+	if *reportDir != "" {
+		if err := report.Write(*reportDir); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR! Unable to write reports: "+err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if report.Failures() > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+// collector keeps the metrics of the last finished run of the checks. All the series are replaced at once on update,
+// so the nodes that were replaced by the autoscaling group do not leave stale series behind.
+type collector struct {
+	mu sync.Mutex
+
+	checkStatus    *prometheus.GaugeVec
+	checkDuration  *prometheus.GaugeVec
+	validatorCount prometheus.Gauge
+	lockHolder     *prometheus.GaugeVec
+	bestBlock      *prometheus.GaugeVec
+	peers          *prometheus.GaugeVec
+	lastRun        prometheus.Gauge
+	runDuration    prometheus.Gauge
+}
+
+func newCollector(prefix string) *collector {
+	labels := prometheus.Labels{"prefix": prefix}
+
+	return &collector{
+		checkStatus: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "failover_check_status",
+			Help:        "Result of the check: 1 if passed, 0 if failed. Region and instance are empty for the overall result of the check.",
+			ConstLabels: labels,
+		}, []string{"check", "region", "instance"}),
+		checkDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "failover_check_duration_seconds",
+			Help:        "Time spent running the check.",
+			ConstLabels: labels,
+		}, []string{"check"}),
+		validatorCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "failover_validator_count",
+			Help:        "Number of nodes that report the Authority role.",
+			ConstLabels: labels,
+		}),
+		lockHolder: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "failover_lock_holder",
+			Help:        "1 if the node holds the Consul validator lock, 0 otherwise.",
+			ConstLabels: labels,
+		}, []string{"region", "instance"}),
+		bestBlock: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "failover_best_block",
+			Help:        "Best block number reported by the node.",
+			ConstLabels: labels,
+		}, []string{"region", "instance"}),
+		peers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "failover_node_peers",
+			Help:        "Number of peers reported by the node.",
+			ConstLabels: labels,
+		}, []string{"region", "instance"}),
+		lastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "failover_last_run_timestamp_seconds",
+			Help:        "Unix time of the last finished run of the checks.",
+			ConstLabels: labels,
+		}),
+		runDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "failover_run_duration_seconds",
+			Help:        "Time spent on the last run of the checks.",
+			ConstLabels: labels,
+		}),
+	}
+}
+
+func (c *collector) metrics() []prometheus.Collector {
+	return []prometheus.Collector{c.checkStatus, c.checkDuration, c.validatorCount, c.lockHolder, c.bestBlock, c.peers, c.lastRun, c.runDuration}
+}
+
+// Describe implements prometheus.Collector
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range c.metrics() {
+		metric.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, metric := range c.metrics() {
+		metric.Collect(ch)
+	}
+}
+
+func (c *collector) update(report *checks.Report) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checkStatus.Reset()
+	c.checkDuration.Reset()
+	c.lockHolder.Reset()
+	c.bestBlock.Reset()
+	c.peers.Reset()
+
+	for _, check := range report.Checks {
+		c.checkDuration.WithLabelValues(check.Name).Set(check.Seconds)
+		c.checkStatus.WithLabelValues(check.Name, "", "").Set(boolToFloat(check.Passed))
+
+		// A region or an instance passes the check unless there is at least one error finding about it
+		statuses := make(map[[2]string]bool)
+		for _, finding := range check.Findings {
+			if finding.Region == "" && finding.Instance == "" {
+				continue
+			}
+			key := [2]string{finding.Region, finding.Instance}
+			passed, seen := statuses[key]
+			statuses[key] = (passed || !seen) && finding.Severity != checks.SeverityError
+		}
+		for key, passed := range statuses {
+			c.checkStatus.WithLabelValues(check.Name, key[0], key[1]).Set(boolToFloat(passed))
+		}
+	}
+
+	validators := 0
+	for _, node := range report.Nodes {
+		if node.Role == "Authority" {
+			validators++
+		}
+		c.lockHolder.WithLabelValues(node.Region, node.Instance).Set(boolToFloat(node.LockHolder))
+		c.bestBlock.WithLabelValues(node.Region, node.Instance).Set(float64(node.BestBlock))
+		c.peers.WithLabelValues(node.Region, node.Instance).Set(float64(node.Peers))
+	}
+	c.validatorCount.Set(float64(validators))
+
+	c.lastRun.SetToCurrentTime()
+	c.runDuration.Set(report.Seconds)
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
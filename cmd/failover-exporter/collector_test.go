@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+func TestCollectorCheckStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		findings []checks.Finding
+		// statuses are the expected values of failover_check_status by region and instance, the overall result excluded
+		statuses map[[2]string]float64
+	}{
+		{
+			name: "info only",
+			findings: []checks.Finding{
+				{Region: "us-east-1", Severity: checks.SeverityInfo},
+				{Region: "us-east-1", Instance: "i-1", Severity: checks.SeverityInfo},
+			},
+			statuses: map[[2]string]float64{{"us-east-1", ""}: 1, {"us-east-1", "i-1"}: 1},
+		},
+		{
+			name: "error after info",
+			findings: []checks.Finding{
+				{Region: "us-east-1", Instance: "i-1", Severity: checks.SeverityInfo},
+				{Region: "us-east-1", Instance: "i-1", Severity: checks.SeverityError},
+				{Region: "us-east-1", Instance: "i-1", Severity: checks.SeverityInfo},
+			},
+			statuses: map[[2]string]float64{{"us-east-1", "i-1"}: 0},
+		},
+		{
+			name: "error before info",
+			findings: []checks.Finding{
+				{Region: "us-east-2", Instance: "i-2", Severity: checks.SeverityError},
+				{Region: "us-east-2", Instance: "i-2", Severity: checks.SeverityInfo},
+			},
+			statuses: map[[2]string]float64{{"us-east-2", "i-2"}: 0},
+		},
+		{
+			name: "regions and instances fold separately",
+			findings: []checks.Finding{
+				{Region: "us-east-1", Severity: checks.SeverityError},
+				{Region: "us-east-1", Instance: "i-1", Severity: checks.SeverityInfo},
+				{Region: "us-east-2", Instance: "i-2", Severity: checks.SeverityInfo},
+				{Region: "us-east-2", Instance: "i-3", Severity: checks.SeverityError},
+			},
+			statuses: map[[2]string]float64{{"us-east-1", ""}: 0, {"us-east-1", "i-1"}: 1, {"us-east-2", "i-2"}: 1, {"us-east-2", "i-3"}: 0},
+		},
+		{
+			name: "findings without location",
+			findings: []checks.Finding{
+				{Severity: checks.SeverityError},
+			},
+			statuses: map[[2]string]float64{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newCollector("test")
+			c.update(&checks.Report{Checks: []*checks.CheckResult{{Name: "Consul", Passed: true, Seconds: 12, Findings: test.findings}}})
+
+			// The overall result plus one series per region and instance
+			assert.Equal(t, len(test.statuses)+1, testutil.CollectAndCount(c.checkStatus))
+			assert.Equal(t, 1.0, testutil.ToFloat64(c.checkStatus.WithLabelValues("Consul", "", "")))
+			for key, status := range test.statuses {
+				assert.Equal(t, status, testutil.ToFloat64(c.checkStatus.WithLabelValues("Consul", key[0], key[1])), "region %s, instance %s", key[0], key[1])
+			}
+			assert.Equal(t, 12.0, testutil.ToFloat64(c.checkDuration.WithLabelValues("Consul")))
+		})
+	}
+}
+
+func TestCollectorNodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		nodes      []checks.NodeStatus
+		validators float64
+		holders    map[string]float64
+	}{
+		{
+			name:       "no nodes",
+			validators: 0,
+			holders:    map[string]float64{},
+		},
+		{
+			name: "single validator",
+			nodes: []checks.NodeStatus{
+				{Instance: "i-1", Region: "us-east-1", Role: "Authority", LockHolder: true, BestBlock: 101, Peers: 3},
+				{Instance: "i-2", Region: "us-east-2", Role: "Full", BestBlock: 100, Peers: 2},
+			},
+			validators: 1,
+			holders:    map[string]float64{"i-1": 1, "i-2": 0},
+		},
+		{
+			name: "two validators",
+			nodes: []checks.NodeStatus{
+				{Instance: "i-1", Region: "us-east-1", Role: "Authority", BestBlock: 101},
+				{Instance: "i-2", Region: "us-east-2", Role: "Authority", LockHolder: true, BestBlock: 99},
+			},
+			validators: 2,
+			holders:    map[string]float64{"i-1": 0, "i-2": 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newCollector("test")
+			c.update(&checks.Report{Seconds: 42, Nodes: test.nodes})
+
+			assert.Equal(t, test.validators, testutil.ToFloat64(c.validatorCount))
+			assert.Equal(t, 42.0, testutil.ToFloat64(c.runDuration))
+			assert.Equal(t, len(test.nodes), testutil.CollectAndCount(c.lockHolder))
+			assert.Equal(t, len(test.nodes), testutil.CollectAndCount(c.bestBlock))
+			for _, node := range test.nodes {
+				assert.Equal(t, test.holders[node.Instance], testutil.ToFloat64(c.lockHolder.WithLabelValues(node.Region, node.Instance)))
+				assert.Equal(t, float64(node.BestBlock), testutil.ToFloat64(c.bestBlock.WithLabelValues(node.Region, node.Instance)))
+				assert.Equal(t, float64(node.Peers), testutil.ToFloat64(c.peers.WithLabelValues(node.Region, node.Instance)))
+			}
+		})
+	}
+}
+
+func TestCollectorDropsReplacedNodes(t *testing.T) {
+	c := newCollector("test")
+	c.update(&checks.Report{Nodes: []checks.NodeStatus{{Instance: "i-1", Region: "us-east-1", Role: "Authority", LockHolder: true}}})
+	c.update(&checks.Report{Nodes: []checks.NodeStatus{{Instance: "i-2", Region: "us-east-1", Role: "Authority", LockHolder: true}}})
+
+	assert.Equal(t, 1, testutil.CollectAndCount(c.lockHolder))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.lockHolder.WithLabelValues("us-east-1", "i-2")))
+}
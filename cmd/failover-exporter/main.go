@@ -0,0 +1,78 @@
+// failover-exporter periodically runs the read-only audit checks against a running deployment and exposes their results as Prometheus metrics.
+//
+// Set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (or AWS_PROFILE) before running it.
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/ssh"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+func main() {
+	prefix := flag.String("prefix", os.Getenv("PREFIX"), "Prefix of the deployment to watch")
+	regions := flag.String("regions", "us-east-1,us-east-2,eu-west-1", "Comma separated list of exactly three regions the deployment runs in")
+	sshKeyPath := flag.String("ssh-key", "", "Path to the private SSH key of the instances")
+	listen := flag.String("listen", ":9770", "Address to expose metrics at")
+	interval := flag.Duration("interval", 5*time.Minute, "Delay between two consecutive runs of the checks")
+	timeout := flag.Duration("timeout", 4*time.Minute, "Upper bound of time a single run of the checks may take")
+	verbose := flag.Bool("verbose", false, "Print the log of every check")
+	flag.Parse()
+
+	if *prefix == "" {
+		log.Fatal("ERROR! -prefix (or PREFIX environment variable) is required")
+	}
+
+	regionList := strings.Split(*regions, ",")
+	if len(regionList) != 3 {
+		log.Fatal("ERROR! -regions should consist of exactly three regions")
+	}
+
+	if *sshKeyPath == "" {
+		log.Fatal("ERROR! -ssh-key is required to query Consul and Polkadot on the nodes")
+	}
+	privateKey, err := ioutil.ReadFile(*sshKeyPath)
+	if err != nil {
+		log.Fatal("ERROR! Unable to read SSH key: " + err.Error())
+	}
+
+	opts := checks.AuditOptions{
+		Prefix:  *prefix,
+		Regions: [3]string{strings.TrimSpace(regionList[0]), strings.TrimSpace(regionList[1]), strings.TrimSpace(regionList[2])},
+		SSHKey:  &ssh.KeyPair{PrivateKey: string(privateKey)},
+		Timeout: *timeout,
+		Out:     ioutil.Discard,
+		Checks:  []string{checks.CheckConsul, checks.CheckPolkadot, checks.CheckNodeStatus, checks.CheckNLB, checks.CheckCloudWatch, checks.CheckSSM},
+	}
+	if *verbose {
+		opts.Out = os.Stdout
+	}
+
+	collector := newCollector(*prefix)
+	prometheus.MustRegister(collector)
+
+	go func() {
+		for {
+			log.Printf("INFO. Running checks against %s", *prefix)
+			report := checks.Audit(context.Background(), opts)
+			collector.update(report)
+			log.Printf("INFO. Checks finished in %.0fs, %d of %d failed", report.Seconds, report.Failures(), len(report.Checks))
+			time.Sleep(*interval)
+		}
+	}()
+
+	http.Handle("/metrics", promhttp.Handler())
+	log.Printf("INFO. Exposing metrics at %s/metrics", *listen)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
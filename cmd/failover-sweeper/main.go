@@ -0,0 +1,271 @@
+// failover-sweeper deletes the resources left behind by test runs that were killed before `terraform destroy`: volumes, security
+// groups, alarms, SSM parameters, audit log groups, published agents and Terraform states of the prefixes the tests generate.
+// A prefix is only swept once all of its resources are older than the threshold and none of its instances is running.
+//
+// Set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (or AWS_PROFILE) before running it.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+// The order resources are deleted in. Volumes and security groups go after the alarms and parameters since they are the most likely
+// to fail, and the state goes last, so it is only deleted once nothing else of the prefix is left
+var deletionOrder = []string{
+	checks.ResourceAlarm,
+	checks.ResourceParameter,
+	checks.ResourceLogGroup,
+	checks.ResourceVolume,
+	checks.ResourceSecurityGroup,
+	checks.ResourceAgent,
+	checks.ResourceStateKey,
+}
+
+// sweepPolicy decides which resources of the test prefixes are deleted
+type sweepPolicy struct {
+	Pattern   *regexp.Regexp
+	Excluded  map[string]bool
+	OlderThan time.Duration
+}
+
+// sweptResource is a resource and what the sweeper does with it
+type sweptResource struct {
+	checks.Resource
+	Age string `json:"age"`
+	// Action is delete, or keep followed by the reason
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+func main() {
+	regions := flag.String("regions", strings.Join(checks.TestRegions[:], ","), "Comma separated list of the regions to sweep, the regions of the tests by default")
+	pattern := flag.String("prefix-pattern", "^[a-z0-9]{5}$", "Regular expression matching the prefixes generated by the tests")
+	exclude := flag.String("exclude", "", "Comma separated list of prefixes never swept, e.g. of long-lived deployments")
+	olderThan := flag.Duration("older-than", 24*time.Hour, "Age all the resources of a prefix must have before it is swept")
+	bucket := flag.String("bucket", env("TF_STATE_BUCKET", "polkadot-validator-failover-tfstate"), "S3 bucket of the Terraform states")
+	bucketRegion := flag.String("bucket-region", env("TF_STATE_REGION", "us-east-1"), "Region of the S3 bucket of the Terraform states")
+	stateKey := flag.String("state-key", env("TF_STATE_KEY", "terraform.tfstate"), "Key of the Terraform state, stored as <prefix>-<key>")
+	dryRun := flag.Bool("dry-run", false, "Only print what would be deleted")
+	asJSON := flag.Bool("json", false, "Print the resources as a JSON array instead of a table")
+	quiet := flag.Bool("quiet", false, "Only print the result, not the log of every step")
+	flag.Parse()
+
+	prefixPattern, err := regexp.Compile(*pattern)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! -prefix-pattern: "+err.Error())
+		os.Exit(2)
+	}
+	policy := &sweepPolicy{Pattern: prefixPattern, Excluded: make(map[string]bool), OlderThan: *olderThan}
+	for _, prefix := range strings.Split(*exclude, ",") {
+		policy.Excluded[strings.TrimSpace(prefix)] = true
+	}
+	var regionList []string
+	for _, region := range strings.Split(*regions, ",") {
+		regionList = append(regionList, strings.TrimSpace(region))
+	}
+
+	var out io.Writer = os.Stderr
+	if *quiet {
+		out = ioutil.Discard
+	}
+	report := checks.NewReport("sweep", regionList)
+
+	var resources []checks.Resource
+	report.Audit("Resources discovery", out, func(t checks.TestingT, c *checks.CheckResult) {
+		for _, region := range regionList {
+			found, err := checks.GetPrefixedResourcesE(t, region)
+			if err != nil {
+				c.Error(region, "", "Unable to list the resources: "+err.Error())
+			}
+			resources = append(resources, found...)
+			c.Info(region, "", fmt.Sprintf("Found %d prefixed resources", len(found)))
+		}
+		states, err := checks.GetStateKeysE(t, *bucketRegion, *bucket, *stateKey)
+		if err != nil {
+			c.Error(*bucketRegion, "", "Unable to list the Terraform states and agents in "+*bucket+": "+err.Error())
+		}
+		resources = append(resources, states...)
+		c.Info(*bucketRegion, "", fmt.Sprintf("Found %d Terraform states and agents in %s", len(states), *bucket))
+	})
+	// A partial listing could make a prefix look older than it is
+	if report.Failures() > 0 {
+		os.Exit(1)
+	}
+
+	candidates := policy.candidates(resources)
+	latest := latestActivity(candidates)
+
+	running := make(map[string][]string)
+	report.Audit("Running instances", out, func(t checks.TestingT, c *checks.CheckResult) {
+		for prefix := range latest {
+			for _, region := range regionList {
+				// GetHealthyEc2InstanceIdsByTag located in ec2.go file
+				instances := checks.GetHealthyEc2InstanceIdsByTag(t, region, "prefix", prefix)
+				if len(instances) > 0 {
+					running[prefix] = append(running[prefix], instances...)
+					c.Info(region, "", "Prefix "+prefix+" has running instances: "+strings.Join(instances, ","))
+				}
+			}
+		}
+	})
+	if report.Failures() > 0 {
+		os.Exit(1)
+	}
+
+	swept := policy.decide(candidates, running, time.Now())
+
+	if !*dryRun {
+		report.Audit("Deletion", out, func(t checks.TestingT, c *checks.CheckResult) {
+			sweep(swept, func(resource checks.Resource) error {
+				if err := checks.DeleteResourceE(t, resource, *bucket); err != nil {
+					c.Error(resource.Region, "", "Unable to delete "+resource.Kind+" "+resource.ID+": "+err.Error())
+					return err
+				}
+				c.Info(resource.Region, "", "Deleted "+resource.Kind+" "+resource.ID+" of prefix "+resource.Prefix)
+				return nil
+			})
+		})
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if swept == nil {
+			swept = []*sweptResource{}
+		}
+		err = encoder.Encode(swept)
+	} else {
+		err = writeTable(os.Stdout, swept)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		os.Exit(1)
+	}
+
+	if report.Failures() > 0 {
+		os.Exit(1)
+	}
+}
+
+// candidates returns the resources of the prefixes the policy may sweep
+func (p *sweepPolicy) candidates(resources []checks.Resource) []checks.Resource {
+	var candidates []checks.Resource
+	for _, resource := range resources {
+		if resource.Prefix == "" || p.Excluded[resource.Prefix] || !p.Pattern.MatchString(resource.Prefix) {
+			continue
+		}
+		candidates = append(candidates, resource)
+	}
+	return candidates
+}
+
+// latestActivity returns the time of the most recent resource of every prefix, zero if none of its resources has a time, e.g. a prefix
+// left with security groups created before they carried the creation time
+func latestActivity(resources []checks.Resource) map[string]time.Time {
+	latest := make(map[string]time.Time)
+	for _, resource := range resources {
+		if last, ok := latest[resource.Prefix]; !ok || resource.Time.After(last) {
+			latest[resource.Prefix] = resource.Time
+		}
+	}
+	return latest
+}
+
+// decide returns what to do with every candidate, sorted by prefix and by the order of deletion. The most recent activity of every
+// prefix decides whether it is swept
+func (p *sweepPolicy) decide(candidates []checks.Resource, running map[string][]string, now time.Time) []*sweptResource {
+	latest := latestActivity(candidates)
+
+	var swept []*sweptResource
+	for _, resource := range candidates {
+		entry := &sweptResource{Resource: resource, Age: "-", Action: "delete"}
+		if !resource.Time.IsZero() {
+			entry.Age = now.Sub(resource.Time).Round(time.Minute).String()
+		}
+		switch {
+		case len(running[resource.Prefix]) > 0:
+			entry.Action = "keep: the prefix has running instances, run terraform destroy"
+		case latest[resource.Prefix].IsZero():
+			entry.Action = "keep: the age of the prefix is unknown"
+		case now.Sub(latest[resource.Prefix]) < p.OlderThan:
+			entry.Action = "keep: the prefix was active " + now.Sub(latest[resource.Prefix]).Round(time.Minute).String() + " ago"
+		case resource.InUse:
+			entry.Action = "keep: attached to an instance"
+		}
+		swept = append(swept, entry)
+	}
+	sort.SliceStable(swept, func(i, j int) bool {
+		if swept[i].Prefix != swept[j].Prefix {
+			return swept[i].Prefix < swept[j].Prefix
+		}
+		return kindRank(swept[i].Kind) < kindRank(swept[j].Kind)
+	})
+	return swept
+}
+
+// sweep deletes the resources decide marked for deletion in their order. The state of a prefix is kept if anything else of the prefix
+// is left, so it can still be destroyed with Terraform
+func sweep(swept []*sweptResource, deleteResource func(checks.Resource) error) {
+	leftPrefixes := make(map[string]bool)
+	for _, entry := range swept {
+		if entry.Action != "delete" {
+			leftPrefixes[entry.Prefix] = true
+			continue
+		}
+		if entry.Kind == checks.ResourceStateKey && leftPrefixes[entry.Prefix] {
+			entry.Action = "keep: other resources of the prefix are left"
+			continue
+		}
+		if err := deleteResource(entry.Resource); err != nil {
+			entry.Error = err.Error()
+			leftPrefixes[entry.Prefix] = true
+			continue
+		}
+		entry.Action = "deleted"
+	}
+}
+
+func writeTable(w io.Writer, swept []*sweptResource) error {
+	if len(swept) == 0 {
+		_, err := fmt.Fprintln(w, "No resources of test prefixes found")
+		return err
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "PREFIX\tKIND\tREGION\tID\tAGE\tACTION")
+	for _, entry := range swept {
+		action := entry.Action
+		if entry.Error != "" {
+			action = "failed: " + entry.Error
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Prefix, entry.Kind, entry.Region, entry.ID, entry.Age, action)
+	}
+	return table.Flush()
+}
+
+func kindRank(kind string) int {
+	for rank, k := range deletionOrder {
+		if k == kind {
+			return rank
+		}
+	}
+	return len(deletionOrder)
+}
+
+func env(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
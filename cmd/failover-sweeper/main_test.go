@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+var sweepNow = time.Date(2020, 10, 10, 12, 0, 0, 0, time.UTC)
+
+func testPolicy() *sweepPolicy {
+	return &sweepPolicy{Pattern: regexp.MustCompile("^[a-z0-9]{5}$"), Excluded: map[string]bool{"prod1": true}, OlderThan: 24 * time.Hour}
+}
+
+func resource(kind, prefix, id string, age time.Duration) checks.Resource {
+	resource := checks.Resource{Kind: kind, Region: "us-east-1", Prefix: prefix, ID: id}
+	if age > 0 {
+		resource.Time = sweepNow.Add(-age)
+	}
+	return resource
+}
+
+func actions(swept []*sweptResource) map[string]string {
+	result := make(map[string]string)
+	for _, entry := range swept {
+		result[entry.ID] = entry.Action
+	}
+	return result
+}
+
+func TestCandidates(t *testing.T) {
+	candidates := testPolicy().candidates([]checks.Resource{
+		resource(checks.ResourceVolume, "k3x9a", "vol-1", time.Hour),
+		resource(checks.ResourceVolume, "prod1", "vol-2", time.Hour),
+		resource(checks.ResourceVolume, "production", "vol-3", time.Hour),
+		resource(checks.ResourceVolume, "", "vol-4", time.Hour),
+	})
+
+	assert.Len(t, candidates, 1)
+	assert.Equal(t, "vol-1", candidates[0].ID)
+}
+
+func TestDecide(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources []checks.Resource
+		running   map[string][]string
+		actions   map[string]string
+	}{
+		{
+			name: "old prefix",
+			resources: []checks.Resource{
+				resource(checks.ResourceAlarm, "k3x9a", "k3x9a-polkadot-health", 50*time.Hour),
+				resource(checks.ResourceVolume, "k3x9a", "vol-1", 52*time.Hour),
+				resource(checks.ResourceSecurityGroup, "k3x9a", "sg-1", 0),
+			},
+			actions: map[string]string{"k3x9a-polkadot-health": "delete", "vol-1": "delete", "sg-1": "delete"},
+		},
+		{
+			name: "running instances",
+			resources: []checks.Resource{
+				resource(checks.ResourceVolume, "k3x9a", "vol-1", 52*time.Hour),
+			},
+			running: map[string][]string{"k3x9a": {"i-1"}},
+			actions: map[string]string{"vol-1": "keep: the prefix has running instances, run terraform destroy"},
+		},
+		{
+			name: "recent activity",
+			resources: []checks.Resource{
+				resource(checks.ResourceVolume, "k3x9a", "vol-1", 52*time.Hour),
+				resource(checks.ResourceParameter, "k3x9a", "/polkadot/validator-failover/k3x9a/name", 2*time.Hour),
+			},
+			actions: map[string]string{
+				"vol-1": "keep: the prefix was active 2h0m0s ago",
+				"/polkadot/validator-failover/k3x9a/name": "keep: the prefix was active 2h0m0s ago",
+			},
+		},
+		{
+			name: "attached volume",
+			resources: []checks.Resource{
+				{Kind: checks.ResourceVolume, Region: "us-east-1", Prefix: "k3x9a", ID: "vol-1", Time: sweepNow.Add(-52 * time.Hour), InUse: true},
+				resource(checks.ResourceVolume, "k3x9a", "vol-2", 52*time.Hour),
+			},
+			actions: map[string]string{"vol-1": "keep: attached to an instance", "vol-2": "delete"},
+		},
+		{
+			name: "unknown age",
+			resources: []checks.Resource{
+				resource(checks.ResourceSecurityGroup, "k3x9a", "sg-1", 0),
+			},
+			actions: map[string]string{"sg-1": "keep: the age of the prefix is unknown"},
+		},
+		{
+			name: "security groups aged by the state",
+			resources: []checks.Resource{
+				resource(checks.ResourceSecurityGroup, "k3x9a", "sg-1", 0),
+				resource(checks.ResourceStateKey, "k3x9a", "k3x9a-terraform.tfstate", 30*time.Hour),
+			},
+			actions: map[string]string{"sg-1": "delete", "k3x9a-terraform.tfstate": "delete"},
+		},
+		{
+			name: "prefixes are decided separately",
+			resources: []checks.Resource{
+				resource(checks.ResourceVolume, "k3x9a", "vol-1", 52*time.Hour),
+				resource(checks.ResourceVolume, "p0d7z", "vol-2", 2*time.Hour),
+			},
+			actions: map[string]string{"vol-1": "delete", "vol-2": "keep: the prefix was active 2h0m0s ago"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.actions, actions(testPolicy().decide(test.resources, test.running, sweepNow)))
+		})
+	}
+}
+
+func TestDecideOrder(t *testing.T) {
+	swept := testPolicy().decide([]checks.Resource{
+		resource(checks.ResourceStateKey, "k3x9a", "k3x9a-terraform.tfstate", 30*time.Hour),
+		resource(checks.ResourceSecurityGroup, "k3x9a", "sg-1", 30*time.Hour),
+		resource(checks.ResourceAgent, "k3x9a", "k3x9a-failover-agent", 30*time.Hour),
+		resource(checks.ResourceVolume, "a1b2c", "vol-2", 30*time.Hour),
+		resource(checks.ResourceVolume, "k3x9a", "vol-1", 30*time.Hour),
+		resource(checks.ResourceAlarm, "k3x9a", "k3x9a-polkadot-health", 30*time.Hour),
+	}, nil, sweepNow)
+
+	var ids []string
+	for _, entry := range swept {
+		ids = append(ids, entry.ID)
+	}
+	assert.Equal(t, []string{"vol-2", "k3x9a-polkadot-health", "vol-1", "sg-1", "k3x9a-failover-agent", "k3x9a-terraform.tfstate"}, ids)
+	assert.Equal(t, "30h0m0s", swept[0].Age)
+}
+
+func TestSweep(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions map[string]string
+		failing string
+		deleted []string
+		result  map[string]string
+	}{
+		{
+			name:    "everything deleted",
+			actions: map[string]string{"vol-1": "delete", "sg-1": "delete"},
+			deleted: []string{"vol-1", "sg-1", "k3x9a-terraform.tfstate"},
+			result:  map[string]string{"vol-1": "deleted", "sg-1": "deleted", "k3x9a-terraform.tfstate": "deleted"},
+		},
+		{
+			name:    "kept resource",
+			actions: map[string]string{"vol-1": "keep: attached to an instance", "sg-1": "delete"},
+			deleted: []string{"sg-1"},
+			result:  map[string]string{"vol-1": "keep: attached to an instance", "sg-1": "deleted", "k3x9a-terraform.tfstate": "keep: other resources of the prefix are left"},
+		},
+		{
+			name:    "failed deletion",
+			actions: map[string]string{"vol-1": "delete", "sg-1": "delete"},
+			failing: "sg-1",
+			deleted: []string{"vol-1", "sg-1"},
+			result:  map[string]string{"vol-1": "deleted", "sg-1": "delete", "k3x9a-terraform.tfstate": "keep: other resources of the prefix are left"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			swept := []*sweptResource{
+				{Resource: resource(checks.ResourceVolume, "k3x9a", "vol-1", 0), Action: test.actions["vol-1"]},
+				{Resource: resource(checks.ResourceSecurityGroup, "k3x9a", "sg-1", 0), Action: test.actions["sg-1"]},
+				{Resource: resource(checks.ResourceStateKey, "k3x9a", "k3x9a-terraform.tfstate", 0), Action: "delete"},
+			}
+
+			var deleted []string
+			sweep(swept, func(resource checks.Resource) error {
+				deleted = append(deleted, resource.ID)
+				if resource.ID == test.failing {
+					return errors.New("DependencyViolation")
+				}
+				return nil
+			})
+
+			assert.Equal(t, test.deleted, deleted)
+			assert.Equal(t, test.result, actions(swept))
+			if test.failing != "" {
+				assert.Equal(t, "DependencyViolation", swept[1].Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+// ssmDrift compares the SSM parameters of the deployment across the regions and, with -reconcile-from, overwrites the copies that
+// differ from the ones of the given region. Every region reads its own copy at boot, so a manual fix in one region silently diverges
+func ssmDrift(args []string) int {
+	flags := flag.NewFlagSet("ssm-drift", flag.ExitOnError)
+	prefix := flags.String("prefix", os.Getenv("PREFIX"), "Prefix of the deployment")
+	regions := flags.String("regions", "us-east-1,us-east-2,eu-west-1", "Comma separated list of the regions to compare")
+	source := flags.String("reconcile-from", "", "Region whose parameters overwrite the ones that differ in the other regions")
+	dryRun := flags.Bool("dry-run", false, "Only print what -reconcile-from would change")
+	asJSON := flags.Bool("json", false, "Print the drift as a JSON array instead of a table")
+	quiet := flags.Bool("quiet", false, "Only print the result, not the log of every step")
+	flags.Parse(args)
+
+	if *prefix == "" {
+		fmt.Fprintln(os.Stderr, "ERROR! -prefix (or PREFIX environment variable) is required")
+		return 2
+	}
+	var regionList []string
+	for _, region := range strings.Split(*regions, ",") {
+		regionList = append(regionList, strings.TrimSpace(region))
+	}
+	if *source != "" && !contains(regionList, *source) {
+		fmt.Fprintf(os.Stderr, "ERROR! -reconcile-from %s is not one of -regions\n", *source)
+		return 2
+	}
+
+	var out io.Writer = os.Stderr
+	if *quiet {
+		out = ioutil.Discard
+	}
+	// The messages go to stderr along with the log when the drift is printed as JSON
+	var messages io.Writer = os.Stdout
+	if *asJSON {
+		messages = os.Stderr
+	}
+
+	path := "/polkadot/validator-failover/" + *prefix + "/"
+	report := checks.NewReport(*prefix, regionList)
+
+	states := readParameterStates(report, out, regionList, path)
+	if failed(report) {
+		return 1
+	}
+	drifts := checks.CompareParameters(states, regionList)
+
+	if *source != "" {
+		var reconciled int
+		report.Audit("Reconcile", out, func(t checks.TestingT, c *checks.CheckResult) {
+			for _, drift := range drifts {
+				if !drift.Drifted() {
+					continue
+				}
+				from := drift.Regions[*source]
+				if from == nil {
+					c.Error(*source, "", "Parameter "+drift.Name+" is missing, delete it from the other regions or create it in "+*source+" first")
+					continue
+				}
+				for _, region := range regionList {
+					to := drift.Regions[region]
+					if region == *source || to != nil && to.Type == from.Type && to.Hash == from.Hash && to.KMSKey == from.KMSKey {
+						continue
+					}
+					action := "Overwriting"
+					if to == nil {
+						action = "Creating"
+					}
+					if *dryRun {
+						action = "Would be " + strings.ToLower(action)
+					}
+					fmt.Fprintf(messages, "%s %s in %s from %s\n", action, drift.Name, region, *source)
+					if *dryRun {
+						continue
+					}
+					if err := checks.ReconcileParameterE(t, region, path, from, to); err != nil {
+						c.Error(region, "", "Unable to reconcile "+drift.Name+": "+err.Error())
+						continue
+					}
+					reconciled++
+				}
+			}
+			c.Info("", "", strconv.Itoa(reconciled)+" parameters reconciled")
+		})
+
+		if reconciled > 0 {
+			states = readParameterStates(report, out, regionList, path)
+			drifts = checks.CompareParameters(states, regionList)
+		}
+	}
+
+	var err error
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if drifts == nil {
+			drifts = []checks.ParameterDrift{}
+		}
+		err = encoder.Encode(drifts)
+	} else {
+		err = writeDrift(os.Stdout, drifts, regionList)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 1
+	}
+
+	if failed(report) {
+		return 1
+	}
+	for _, drift := range drifts {
+		if drift.Drifted() {
+			return 1
+		}
+	}
+	return 0
+}
+
+// readParameterStates reads the parameters of every region in a step of the report. Regions that cannot be read are left out
+func readParameterStates(report *checks.Report, out io.Writer, regions []string, path string) map[string]map[string]*checks.ParameterState {
+	states := make(map[string]map[string]*checks.ParameterState)
+	report.Audit("SSM parameters", out, func(t checks.TestingT, c *checks.CheckResult) {
+		for _, region := range regions {
+			regionStates, err := checks.GetParameterStatesE(t, region, path)
+			if err != nil {
+				c.Error(region, "", "Unable to read SSM parameters under "+path+": "+err.Error())
+				continue
+			}
+			states[region] = regionStates
+			c.Info(region, "", "Found "+strconv.Itoa(len(regionStates))+" SSM parameters")
+		}
+	})
+	return states
+}
+
+// writeDrift prints a row for every copy of the parameters that differ. Only the beginning of the hashes of the values is printed
+func writeDrift(w io.Writer, drifts []checks.ParameterDrift, regions []string) error {
+	if len(drifts) == 0 {
+		_, err := fmt.Fprintf(w, "No drift across %s\n", strings.Join(regions, ", "))
+		return err
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "PARAMETER\tREGION\tTYPE\tVERSION\tKMS KEY\tVALUE SHA256\tDRIFT")
+	for _, drift := range drifts {
+		for _, region := range regions {
+			state := drift.Regions[region]
+			if state == nil {
+				fmt.Fprintf(table, "%s\t%s\t-\t-\t-\t-\t%s\n", drift.Name, region, strings.Join(drift.Kinds, ","))
+				continue
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", drift.Name, region, state.Type, state.Version, orNone(state.KMSKey), state.Hash[:12], strings.Join(drift.Kinds, ","))
+		}
+	}
+	return table.Flush()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/audit"
+	"github.com/protofire/polkadot-failover-mechanism/agent/election"
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+// freeze stops the elections: the agents do not contend for the lock while the flag is set, so the current validator is pinned and
+// nobody takes over if it fails. The flag is honoured by the agents, see agent/election/freeze.go
+func freeze(args []string) int {
+	flags := flag.NewFlagSet("freeze", flag.ExitOnError)
+	d := deploymentFlags(flags)
+	reason := flags.String("reason", "", "Why the elections are frozen, recorded with the flag and in the audit log")
+	flags.Parse(args)
+
+	if *reason == "" {
+		fmt.Fprintln(os.Stderr, "ERROR! -reason is required")
+		return 2
+	}
+
+	opts, err := d.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 2
+	}
+	ctx := context.Background()
+	report, publicIPs, err := consulNode(ctx, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 1
+	}
+
+	view := consulView(ctx, report, opts, publicIPs)
+	if view == nil {
+		return 1
+	}
+	if view.Freeze != nil {
+		fmt.Printf("Elections are already frozen by %s since %s: %s\n", view.Freeze.By, view.Freeze.FrozenAt.Format(time.RFC3339), view.Freeze.Reason)
+		return 0
+	}
+	// The validator of a pending handoff may have stepped down already, freezing now would leave the deployment without a validator
+	if view.Handoff != nil && !view.Handoff.Expired(time.Now()) {
+		fmt.Fprintf(os.Stderr, "ERROR! The handoff from %s to %q requested at %s is still pending\n", view.Handoff.From, view.Handoff.To, view.Handoff.RequestedAt.Format(time.RFC3339))
+		return 1
+	}
+
+	frozen := election.FreezeFlag{By: operator(), Reason: *reason, FrozenAt: time.Now().UTC()}
+	value, err := json.Marshal(frozen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 1
+	}
+	report.Audit("Freeze", opts.Out, func(t checks.TestingT, c *checks.CheckResult) {
+		checks.ConsulKVPut(ctx, t, publicIPs, opts.SSHKey, election.DefaultFreezeKey, value)
+		c.Info("", "", "Elections frozen")
+	})
+	if failed(report) {
+		return 1
+	}
+
+	if view.LockHolder != "" {
+		fmt.Printf("Elections are frozen, %s keeps the lock\n", view.LockHolder)
+	} else {
+		fmt.Println("Elections are frozen, no node holds the lock")
+	}
+	if !record(ctx, opts, audit.Event{Type: audit.Frozen, Message: "frozen by " + frozen.By + ": " + frozen.Reason}) {
+		return 1
+	}
+	return 0
+}
+
+// unfreeze removes the freeze flag, so the agents contend for the lock again
+func unfreeze(args []string) int {
+	flags := flag.NewFlagSet("unfreeze", flag.ExitOnError)
+	d := deploymentFlags(flags)
+	flags.Parse(args)
+
+	opts, err := d.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 2
+	}
+	ctx := context.Background()
+	report, publicIPs, err := consulNode(ctx, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 1
+	}
+
+	view := consulView(ctx, report, opts, publicIPs)
+	if view == nil {
+		return 1
+	}
+	if view.Freeze == nil {
+		fmt.Println("Elections are not frozen")
+		return 0
+	}
+
+	report.Audit("Unfreeze", opts.Out, func(t checks.TestingT, c *checks.CheckResult) {
+		checks.NodeQuery(ctx, t, publicIPs, opts.SSHKey, "consul kv delete "+election.DefaultFreezeKey)
+		c.Info("", "", "Elections unfrozen")
+	})
+	if failed(report) {
+		return 1
+	}
+
+	fmt.Printf("Elections are no longer frozen, they were frozen by %s since %s\n", view.Freeze.By, view.Freeze.FrozenAt.Format(time.RFC3339))
+	if !record(ctx, opts, audit.Event{Type: audit.Unfrozen, Message: "unfrozen by " + operator()}) {
+		return 1
+	}
+	return 0
+}
+
+// consulNode discovers the nodes and returns the public IP of the node the Consul commands run on, the lock holder if there is one.
+// Freezing is meant for incidents, so nodes that do not answer are reported but do not prevent it: the returned report only holds
+// the instances of the deployment, not the findings of the discovery
+func consulNode(ctx context.Context, opts checks.AuditOptions) (*checks.Report, map[string]string, error) {
+	opts.Checks = []string{checks.CheckNodeStatus}
+	discovery := checks.Audit(ctx, opts)
+	failed(discovery)
+
+	var nodes []checks.NodeStatus
+	for _, node := range discovery.Nodes {
+		if node.PublicIP != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, nil, fmt.Errorf("no node to reach Consul through")
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].LockHolder && !nodes[j].LockHolder })
+
+	report := checks.NewReport(opts.Prefix, opts.Regions[:])
+	for instance, region := range discovery.Instances {
+		report.AddInstance(instance, region)
+	}
+	return report, map[string]string{nodes[0].Instance: nodes[0].PublicIP}, nil
+}
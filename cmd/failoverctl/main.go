@@ -0,0 +1,165 @@
+// failoverctl performs operator actions on a running failover deployment, e.g. a planned handoff of the validator role.
+// Every subcommand discovers the deployment the same way failover-audit does and talks to the nodes over SSH.
+//
+// Set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (or AWS_PROFILE) before running it.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/gruntwork-io/terratest/modules/ssh"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/audit"
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+// commands maps the subcommand names to their implementations. Each one parses its own flags
+var commands = map[string]func(args []string) int{
+	"freeze":      freeze,
+	"promote":     promote,
+	"rotate-keys": rotateKeys,
+	"ssm-drift":   ssmDrift,
+	"status":      status,
+	"stepdown":    stepDown,
+	"timeline":    timeline,
+	"unfreeze":    unfreeze,
+}
+
+func main() {
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(commands[os.Args[1]](os.Args[2:]))
+}
+
+func usage() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "Usage: failoverctl <%s> [flags]\n", strings.Join(names, "|"))
+}
+
+// deployment holds the flags shared by all the subcommands
+type deployment struct {
+	prefix     *string
+	regions    *string
+	sshKeyPath *string
+	timeout    *time.Duration
+	quiet      *bool
+}
+
+func deploymentFlags(flags *flag.FlagSet) *deployment {
+	return &deployment{
+		prefix:     flags.String("prefix", os.Getenv("PREFIX"), "Prefix of the deployment"),
+		regions:    flags.String("regions", "us-east-1,us-east-2,eu-west-1", "Comma separated list of exactly three regions the deployment runs in"),
+		sshKeyPath: flags.String("ssh-key", "", "Path to the private SSH key of the instances"),
+		timeout:    flags.Duration("timeout", 15*time.Minute, "Upper bound of time to wait for the deployment to reach the requested state"),
+		quiet:      flags.Bool("quiet", false, "Only print the result, not the log of every step"),
+	}
+}
+
+// options validates the shared flags and returns the options to discover the deployment with
+func (d *deployment) options() (checks.AuditOptions, error) {
+	if *d.prefix == "" {
+		return checks.AuditOptions{}, fmt.Errorf("-prefix (or PREFIX environment variable) is required")
+	}
+
+	regionList := strings.Split(*d.regions, ",")
+	if len(regionList) != 3 {
+		return checks.AuditOptions{}, fmt.Errorf("-regions should consist of exactly three regions")
+	}
+
+	if *d.sshKeyPath == "" {
+		return checks.AuditOptions{}, fmt.Errorf("-ssh-key is required")
+	}
+	privateKey, err := ioutil.ReadFile(*d.sshKeyPath)
+	if err != nil {
+		return checks.AuditOptions{}, fmt.Errorf("Unable to read SSH key: %s", err)
+	}
+
+	opts := checks.AuditOptions{
+		Prefix:  *d.prefix,
+		Regions: [3]string{strings.TrimSpace(regionList[0]), strings.TrimSpace(regionList[1]), strings.TrimSpace(regionList[2])},
+		SSHKey:  &ssh.KeyPair{PrivateKey: string(privateKey)},
+		Out:     os.Stdout,
+	}
+	if *d.quiet {
+		opts.Out = ioutil.Discard
+	}
+	return opts, nil
+}
+
+// failed prints the error findings of the failed checks and reports whether there were any
+func failed(report *checks.Report) bool {
+	for _, check := range report.Checks {
+		if check.Passed {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "ERROR! %s failed\n", check.Name)
+		for _, finding := range check.Findings {
+			if finding.Severity == checks.SeverityError {
+				fmt.Fprintf(os.Stderr, "      %s\n", finding.String())
+			}
+		}
+	}
+	return report.Failures() > 0
+}
+
+// consulView reads the Consul cluster as seen by the nodes, including the freeze flag and the pending handoff request.
+// It returns nil if the step failed
+func consulView(ctx context.Context, report *checks.Report, opts checks.AuditOptions, publicIPs map[string]string) *checks.ConsulView {
+	var view *checks.ConsulView
+	report.Audit("Consul", opts.Out, func(t checks.TestingT, c *checks.CheckResult) {
+		view = checks.ConsulViewCheck(ctx, t, c, publicIPs, opts.SSHKey)
+	})
+	if failed(report) {
+		return nil
+	}
+	return view
+}
+
+// auditStream is the CloudWatch Logs stream of the audit log the operator actions are written to
+const auditStream = "failoverctl"
+
+// record writes an operator action to the audit log of every region, so it shows up in `failoverctl timeline` next to the transitions
+// of the nodes. It reports whether at least one region recorded it, the other regions hold the same events
+func record(ctx context.Context, opts checks.AuditOptions, event audit.Event) bool {
+	event.Time = time.Now().UTC()
+	recorded := false
+	for _, region := range opts.Regions {
+		sink := &audit.CloudWatch{
+			Client: cloudwatchlogs.New(session.Must(session.NewSession(aws.NewConfig().WithRegion(region)))),
+			Group:  audit.LogGroup(opts.Prefix),
+			Stream: auditStream,
+		}
+		if err := sink.Write(ctx, []audit.Event{event}); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR! Unable to record the %s event in the audit log of %s: %s\n", event.Type, region, err)
+			continue
+		}
+		recorded = true
+	}
+	return recorded
+}
+
+// operator returns the name of the local user, which is recorded with the actions
+func operator() string {
+	current, err := user.Current()
+	if err != nil {
+		return "unknown"
+	}
+	return current.Username
+}
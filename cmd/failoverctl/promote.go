@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+// promote makes the given standby the validator. It is a handoff like `failoverctl stepdown -to`, so the standby still goes through
+// the double-signing guard before it gets the keys
+func promote(args []string) int {
+	flags := flag.NewFlagSet("promote", flag.ExitOnError)
+	d := deploymentFlags(flags)
+	wait := flags.Bool("wait", true, "Wait until the instance holds the lock and runs with the Authority role")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: failoverctl promote [flags] <instance>")
+		return 2
+	}
+	instance := flags.Arg(0)
+
+	opts, err := d.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 2
+	}
+	opts.Checks = []string{checks.CheckNodeStatus}
+
+	ctx := context.Background()
+	report := checks.Audit(ctx, opts)
+	if failed(report) {
+		return 1
+	}
+
+	if validator, err := validatorNode(report.Nodes); err == nil && validator.Instance == instance {
+		fmt.Printf("%s already holds the lock and validates\n", instance)
+		return 0
+	}
+	from, target, err := handoffNodes(report.Nodes, instance)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 1
+	}
+
+	return handOver(ctx, report, opts, *d.timeout, from, target, *wait, "promotion of "+target.Instance)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/gruntwork-io/terratest/modules/ssh"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/keys"
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+const rpcCommand = `curl -s -H "Content-Type: application/json" -d '{"id":1, "jsonrpc":"2.0", "method": "%s", "params":%s}' http://127.0.0.1:9933`
+
+// rotateKeys gives the validator a new set of session keys, stores it in SSM in every region so that any standby inserts it on
+// promotion, and prints the blob for the setKeys extrinsic. Seeds are never printed
+func rotateKeys(args []string) int {
+	flags := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	d := deploymentFlags(flags)
+	offline := flags.Bool("offline", false, "Generate the keys locally with subkey and insert them into the validator, instead of author_rotateKeys on the validator")
+	subkey := flags.String("subkey", "subkey", "Path to the subkey binary used with -offline")
+	types := flags.String("types", strings.Join(keys.DefaultSessionTypes, ","), "Comma separated list of the session key types in the order of the SessionKeys of the runtime")
+	flags.Parse(args)
+
+	opts, err := d.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 2
+	}
+	opts.Checks = []string{checks.CheckNodeStatus}
+	typeList := strings.Split(*types, ",")
+
+	ctx := context.Background()
+	report := checks.Audit(ctx, opts)
+	if failed(report) {
+		return 1
+	}
+
+	validator, err := validatorNode(report.Nodes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 1
+	}
+	var rotated []keys.Key
+	if *offline {
+		if rotated, err = generateKeys(*subkey, typeList); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+			return 1
+		}
+		report.Audit("Key insertion", opts.Out, func(t checks.TestingT, c *checks.CheckResult) {
+			insertKeys(ctx, t, c, validator, opts.SSHKey, rotated)
+		})
+	} else {
+		report.Audit("Key rotation", opts.Out, func(t checks.TestingT, c *checks.CheckResult) {
+			rotated = rotateOnNode(ctx, t, c, validator, opts.SSHKey, typeList)
+		})
+	}
+	if failed(report) {
+		return 1
+	}
+
+	report.Audit("Key storage", opts.Out, func(t checks.TestingT, c *checks.CheckResult) {
+		for _, region := range opts.Regions {
+			store := &keys.Store{SSM: ssm.New(session.Must(session.NewSession(aws.NewConfig().WithRegion(region)))), Prefix: opts.Prefix}
+			storeKeys(ctx, c, region, store, rotated)
+		}
+	})
+	if failed(report) {
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "Session keys of %s rotated. Submit session.setKeys(keys, 0x) from the controller account with these keys:\n", validator.Instance)
+	fmt.Println(keys.SessionKeys(rotated))
+	return 0
+}
+
+// validatorNode returns the lock holder, which has to validate
+func validatorNode(nodes []checks.NodeStatus) (checks.NodeStatus, error) {
+	var validator checks.NodeStatus
+	holders := 0
+
+	for _, node := range nodes {
+		if node.LockHolder {
+			validator = node
+			holders++
+		}
+	}
+	if holders != 1 {
+		return validator, fmt.Errorf("expected exactly one lock holder, found %d", holders)
+	}
+	if validator.Role != "Authority" {
+		return validator, fmt.Errorf("lock holder %s does not validate yet, role: %q", validator.Instance, validator.Role)
+	}
+	return validator, nil
+}
+
+// rpcResult parses the result of a JSON-RPC call made with rpcCommand
+func rpcResult(output string, result interface{}) error {
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(output), &response); err != nil {
+		return fmt.Errorf("unexpected response %q: %w", output, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("node returned an error: %s", response.Error.Message)
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// rotateOnNode calls author_rotateKeys on the validator and reads the seeds of the new keys from its keystore, so that the standbys
+// can insert them too
+func rotateOnNode(ctx context.Context, t checks.TestingT, c *checks.CheckResult, validator checks.NodeStatus, key *ssh.KeyPair, types []string) []keys.Key {
+	instance := validator.Instance
+	host := map[string]string{instance: validator.PublicIP}
+
+	var blob string
+	output := checks.NodeQuery(ctx, t, host, key, fmt.Sprintf(rpcCommand, "author_rotateKeys", "[]"))[instance]
+	if err := rpcResult(output, &blob); err != nil {
+		c.NodeError(instance, "Unable to rotate the keys: "+err.Error())
+		return nil
+	}
+	publics, err := keys.SplitSessionKeys(blob, types)
+	if err != nil {
+		c.NodeError(instance, err.Error())
+		return nil
+	}
+
+	var rotated []keys.Key
+	for i, keyType := range types {
+		command := fmt.Sprintf("sudo find /data/chains -path '*/keystore/%s' -exec cat {} \\;", keys.KeystoreFile(keyType, publics[i]))
+		content := checks.NodeSecretQuery(ctx, t, host, key, command)[instance]
+		rotatedKey, err := keys.FromKeystoreFile(keyType, keyType, publics[i], []byte(content))
+		if err != nil {
+			c.NodeError(instance, err.Error())
+			return nil
+		}
+		rotated = append(rotated, rotatedKey)
+	}
+	c.NodeInfo(instance, fmt.Sprintf("Rotated %d session keys", len(rotated)))
+	return rotated
+}
+
+// generateKeys generates a key of every type with subkey
+func generateKeys(subkey string, types []string) ([]keys.Key, error) {
+	var generated []keys.Key
+	for _, keyType := range types {
+		output, err := exec.Command(subkey, "generate", "--scheme", keys.Scheme(keyType), "--output-type", "json").Output()
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate a %s key with %s: %w", keyType, subkey, err)
+		}
+		var parsed struct {
+			SecretPhrase string `json:"secretPhrase"`
+			PublicKey    string `json:"publicKey"`
+		}
+		if err := json.Unmarshal(output, &parsed); err != nil || parsed.SecretPhrase == "" || parsed.PublicKey == "" {
+			return nil, fmt.Errorf("unexpected output of %s generate", subkey)
+		}
+		generated = append(generated, keys.NewKey(keyType, keyType, parsed.PublicKey, parsed.SecretPhrase))
+	}
+	return generated, nil
+}
+
+// insertKeys inserts the keys into the validator and confirms them with author_hasKey. The requests are copied to the node as files,
+// as commands are logged and the requests hold the seeds
+func insertKeys(ctx context.Context, t checks.TestingT, c *checks.CheckResult, validator checks.NodeStatus, key *ssh.KeyPair, rotated []keys.Key) {
+	host := ssh.Host{Hostname: validator.PublicIP, SshKeyPair: key, SshUserName: "ec2-user"}
+	for _, rotatedKey := range rotated {
+		request, err := keys.InsertRequest(rotatedKey)
+		if err != nil {
+			c.NodeError(validator.Instance, err.Error())
+			return
+		}
+		if err := ssh.ScpFileToE(t, host, 0600, "/tmp/failover-key-"+rotatedKey.Type+".json", string(request)); err != nil {
+			c.NodeError(validator.Instance, "Unable to copy the insertion request of "+rotatedKey.String()+": "+err.Error())
+			return
+		}
+	}
+
+	publicIPs := map[string]string{validator.Instance: validator.PublicIP}
+	insert := `for f in /tmp/failover-key-*.json; do curl -s -H "Content-Type: application/json" -d @$f http://127.0.0.1:9933; rm -f $f; done`
+	checks.NodeQuery(ctx, t, publicIPs, key, insert)
+
+	for _, rotatedKey := range rotated {
+		var found bool
+		params := fmt.Sprintf(`["%s", "%s"]`, rotatedKey.Public, rotatedKey.Type)
+		output := checks.NodeQuery(ctx, t, publicIPs, key, fmt.Sprintf(rpcCommand, "author_hasKey", params))[validator.Instance]
+		if err := rpcResult(output, &found); err != nil || !found {
+			c.NodeError(validator.Instance, "Key "+rotatedKey.String()+" is not in the keystore after insertion")
+			continue
+		}
+		c.NodeInfo(validator.Instance, "Inserted key "+rotatedKey.String())
+	}
+}
+
+// storeKeys replaces the stored keys of the same types with the rotated ones and reads them back
+func storeKeys(ctx context.Context, c *checks.CheckResult, region string, store *keys.Store, rotated []keys.Key) {
+	// The region may hold no keys yet, then the rotated ones are named after their types
+	stored, _ := store.Load(ctx)
+	named := keys.Rename(rotated, stored)
+	if err := store.Save(ctx, named); err != nil {
+		c.Error(region, "", err.Error())
+		return
+	}
+
+	loaded, err := store.Load(ctx)
+	if err != nil {
+		c.Error(region, "", err.Error())
+		return
+	}
+	publics := make(map[string]string)
+	for _, key := range loaded {
+		publics[key.Name] = key.Public
+	}
+	for _, key := range named {
+		if publics[key.Name] != key.Public {
+			c.Error(region, "", "Key "+key.String()+" was not stored")
+			return
+		}
+	}
+	c.Info(region, "", fmt.Sprintf("Stored %d keys, the standbys insert them on promotion", len(named)))
+}
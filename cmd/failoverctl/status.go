@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/election"
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+// deploymentStatus is the state of the whole deployment as printed by `failoverctl status -json`
+type deploymentStatus struct {
+	Prefix       string    `json:"prefix"`
+	Time         time.Time `json:"time"`
+	ConsulLeader string    `json:"consul_leader"`
+	LockHolder   string    `json:"lock_holder"`
+	Validators   []string  `json:"validators"`
+	// Freeze is set while the elections are frozen and Handoff while a handoff request is stored
+	Freeze  *election.FreezeFlag     `json:"freeze"`
+	Handoff *election.HandoffRequest `json:"handoff"`
+	Nodes   []checks.NodeStatus      `json:"nodes"`
+	Regions []regionStatus           `json:"regions"`
+	// Problems lists everything that needs the attention of an operator, the command exits with 1 if there is any
+	Problems []string `json:"problems"`
+}
+
+type regionStatus struct {
+	Region       string                `json:"region"`
+	Members      []checks.ConsulMember `json:"consul_members"`
+	Alarms       []alarmStatus         `json:"alarms"`
+	TargetGroups []targetGroupStatus   `json:"target_groups"`
+	Volumes      []volumeStatus        `json:"volumes"`
+}
+
+type alarmStatus struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+type targetGroupStatus struct {
+	Name   string `json:"name"`
+	Health string `json:"health"`
+}
+
+type volumeStatus struct {
+	VolumeID         string `json:"volume_id"`
+	AvailabilityZone string `json:"availability_zone"`
+	State            string `json:"state"`
+	SizeGiB          int64  `json:"size_gib"`
+	Instance         string `json:"instance,omitempty"`
+}
+
+// status prints a single view of the deployment: the Consul members per region, the Consul leader, the lock holder, the node running
+// with the Authority role, the best and finalized block of every node, the CloudWatch alarms, the target groups of the load balancers
+// and the data volumes. It only reads the state, so it is safe to run at any time
+func status(args []string) int {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	d := deploymentFlags(flags)
+	asJSON := flags.Bool("json", false, "Print the status as JSON instead of tables")
+	flags.Parse(args)
+
+	opts, err := d.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 2
+	}
+	// The log of the steps goes to stderr, so the tables or the JSON document can be piped
+	opts.Out = os.Stderr
+	if *d.quiet {
+		opts.Out = ioutil.Discard
+	}
+	opts.Checks = []string{checks.CheckNodeStatus}
+
+	ctx := context.Background()
+	report := checks.Audit(ctx, opts)
+
+	result := newDeploymentStatus(opts.Prefix, time.Now().UTC(), opts.Regions[:], report.Nodes)
+	regions := make(map[string]*regionStatus)
+	for i := range result.Regions {
+		regions[result.Regions[i].Region] = &result.Regions[i]
+	}
+
+	publicIPs := make(map[string]string)
+	for _, node := range result.Nodes {
+		publicIPs[node.Instance] = node.PublicIP
+	}
+
+	report.Audit("Consul", opts.Out, func(t checks.TestingT, c *checks.CheckResult) {
+		view := checks.ConsulViewCheck(ctx, t, c, publicIPs, opts.SSHKey)
+		if view == nil {
+			return
+		}
+		result.ConsulLeader, result.LockHolder = view.Leader, view.LockHolder
+		result.Freeze, result.Handoff = view.Freeze, view.Handoff
+		result.addMembers(view.Members)
+	})
+
+	report.Audit("Alarms", opts.Out, func(t checks.TestingT, c *checks.CheckResult) {
+		for _, region := range result.Regions {
+			// GetAlarmsNamesAndStatesByPrefix located in cw.go file
+			alarms := checks.GetAlarmsNamesAndStatesByPrefix(t, region.Region, opts.Prefix)
+			for name, state := range alarms {
+				regions[region.Region].Alarms = append(regions[region.Region].Alarms, alarmStatus{Name: name, State: state})
+			}
+			sort.Slice(regions[region.Region].Alarms, func(i, j int) bool {
+				return regions[region.Region].Alarms[i].Name < regions[region.Region].Alarms[j].Name
+			})
+			c.Info(region.Region, "", fmt.Sprintf("%d alarms found", len(alarms)))
+		}
+	})
+
+	report.Audit("Target groups", opts.Out, func(t checks.TestingT, c *checks.CheckResult) {
+		for _, region := range result.Regions {
+			arn := checks.GetLBArnByName(t, region.Region, opts.Prefix+"-internal-lb-polkadot")
+			// GetHealthStatusSliceByLBsARN located in nlb.go file, the target groups are keyed by their ARN
+			for tg, health := range checks.GetHealthStatusSliceByLBsARN(t, region.Region, arn) {
+				regions[region.Region].TargetGroups = append(regions[region.Region].TargetGroups, targetGroupStatus{Name: targetGroupName(tg), Health: health})
+			}
+			sort.Slice(regions[region.Region].TargetGroups, func(i, j int) bool {
+				return regions[region.Region].TargetGroups[i].Name < regions[region.Region].TargetGroups[j].Name
+			})
+			c.Info(region.Region, "", fmt.Sprintf("%d target groups found", len(regions[region.Region].TargetGroups)))
+		}
+	})
+
+	report.Audit("Volumes", opts.Out, func(t checks.TestingT, c *checks.CheckResult) {
+		for _, region := range result.Regions {
+			for _, volume := range checks.GetVolumesByTag(t, region.Region, "prefix", opts.Prefix) {
+				entry := volumeStatus{
+					VolumeID:         aws.StringValue(volume.VolumeId),
+					AvailabilityZone: aws.StringValue(volume.AvailabilityZone),
+					State:            aws.StringValue(volume.State),
+					SizeGiB:          aws.Int64Value(volume.Size),
+				}
+				for _, attachment := range volume.Attachments {
+					entry.Instance = aws.StringValue(attachment.InstanceId)
+				}
+				regions[region.Region].Volumes = append(regions[region.Region].Volumes, entry)
+			}
+			sort.Slice(regions[region.Region].Volumes, func(i, j int) bool {
+				return regions[region.Region].Volumes[i].VolumeID < regions[region.Region].Volumes[j].VolumeID
+			})
+			c.Info(region.Region, "", fmt.Sprintf("%d volumes found", len(regions[region.Region].Volumes)))
+		}
+	})
+
+	result.Problems = statusProblems(report, result)
+
+	if *asJSON {
+		err = writeStatusJSON(os.Stdout, result)
+	} else {
+		err = writeStatus(os.Stdout, result)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 1
+	}
+
+	if len(result.Problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// newDeploymentStatus creates the status of the deployment with an empty entry for every region, the nodes sorted by instance and
+// the nodes running with the Authority role as validators
+func newDeploymentStatus(prefix string, now time.Time, regions []string, nodes []checks.NodeStatus) *deploymentStatus {
+	result := &deploymentStatus{Prefix: prefix, Time: now, Nodes: append([]checks.NodeStatus(nil), nodes...)}
+	sort.Slice(result.Nodes, func(i, j int) bool { return result.Nodes[i].Instance < result.Nodes[j].Instance })
+	for _, region := range regions {
+		result.Regions = append(result.Regions, regionStatus{Region: region})
+	}
+	for _, node := range result.Nodes {
+		if node.Role == "Authority" {
+			result.Validators = append(result.Validators, node.Instance)
+		}
+	}
+	return result
+}
+
+// addMembers adds the Consul members to the regions they run in. Members of other regions, e.g. of another deployment joined by
+// mistake, are left out
+func (s *deploymentStatus) addMembers(members []checks.ConsulMember) {
+	for _, member := range members {
+		for i := range s.Regions {
+			if s.Regions[i].Region == member.Region {
+				s.Regions[i].Members = append(s.Regions[i].Members, member)
+			}
+		}
+	}
+}
+
+// targetGroupName returns the name of a target group from its ARN, e.g. arn:aws:elasticloadbalancing:...:targetgroup/<name>/<id>
+func targetGroupName(arn string) string {
+	parts := strings.Split(arn, "/")
+	if len(parts) < 3 {
+		return arn
+	}
+	return parts[len(parts)-2]
+}
+
+// statusProblems lists the findings of the failed steps and the state that needs the attention of an operator
+func statusProblems(report *checks.Report, result *deploymentStatus) []string {
+	problems := []string{}
+	for _, check := range report.Checks {
+		for _, finding := range check.Findings {
+			if finding.Severity == checks.SeverityError {
+				problems = append(problems, check.Name+": "+finding.String())
+			}
+		}
+	}
+
+	switch len(result.Validators) {
+	case 0:
+		if result.Freeze != nil {
+			problems = append(problems, "No node runs with the Authority role and the elections are frozen, nobody takes over until they are unfrozen")
+		} else {
+			problems = append(problems, "No node runs with the Authority role")
+		}
+	case 1:
+		if result.LockHolder != "" && result.LockHolder != result.Validators[0] {
+			problems = append(problems, fmt.Sprintf("%s validates but %s holds the lock", result.Validators[0], result.LockHolder))
+		}
+	default:
+		problems = append(problems, "More than one node runs with the Authority role: "+strings.Join(result.Validators, ", "))
+	}
+	if result.LockHolder == "" {
+		problems = append(problems, "No node holds the lock")
+	}
+
+	for _, region := range result.Regions {
+		for _, member := range region.Members {
+			if member.Status != "alive" {
+				problems = append(problems, fmt.Sprintf("Consul member %s in %s is %s", member.Node, region.Region, member.Status))
+			}
+		}
+		for _, alarm := range region.Alarms {
+			if alarm.State == "ALARM" {
+				problems = append(problems, fmt.Sprintf("Alarm %s in %s is in ALARM state", alarm.Name, region.Region))
+			}
+		}
+		for _, tg := range region.TargetGroups {
+			if tg.Health != "healthy" {
+				problems = append(problems, fmt.Sprintf("Target group %s in %s is %s", tg.Name, region.Region, tg.Health))
+			}
+		}
+	}
+	return problems
+}
+
+// writeStatus prints the status as tables
+func writeStatus(out io.Writer, result *deploymentStatus) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Deployment %s at %s\n", result.Prefix, result.Time.Format(time.RFC3339))
+	fmt.Fprintf(w, "Consul leader:\t%s\n", orNone(result.ConsulLeader))
+	fmt.Fprintf(w, "Lock holder:\t%s\n", orNone(result.LockHolder))
+	fmt.Fprintf(w, "Authority:\t%s\n", orNone(strings.Join(result.Validators, ", ")))
+	if result.Freeze != nil {
+		fmt.Fprintf(w, "Elections:\tfrozen by %s since %s: %s\n", result.Freeze.By, result.Freeze.FrozenAt.Format(time.RFC3339), result.Freeze.Reason)
+	} else {
+		fmt.Fprintf(w, "Elections:\trunning\n")
+	}
+	if handoff := result.Handoff; handoff != nil {
+		state := "pending"
+		if handoff.Expired(result.Time) {
+			state = "expired"
+		} else if handoff.FinalBlock > 0 {
+			state = fmt.Sprintf("stepped down at block %d", handoff.FinalBlock)
+		}
+		fmt.Fprintf(w, "Handoff:\tfrom %s to %s requested at %s, %s\n", handoff.From, orNone(handoff.To), handoff.RequestedAt.Format(time.RFC3339), state)
+	}
+
+	fmt.Fprintln(w, "\nINSTANCE\tREGION\tROLE\tLOCK\tBEST\tFINALIZED\tPEERS\tSYNCING")
+	for _, node := range result.Nodes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%d\t%d\t%d\t%t\n", node.Instance, node.Region, orNone(node.Role), node.LockHolder, node.BestBlock, node.Finalized, node.Peers, node.IsSyncing)
+	}
+
+	for _, region := range result.Regions {
+		fmt.Fprintf(w, "\n%s\n", region.Region)
+		fmt.Fprintln(w, "  CONSUL MEMBER\tADDRESS\tSTATUS")
+		for _, member := range region.Members {
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", member.Node, member.Address, member.Status)
+		}
+		fmt.Fprintln(w, "  ALARM\tSTATE")
+		for _, alarm := range region.Alarms {
+			fmt.Fprintf(w, "  %s\t%s\n", alarm.Name, alarm.State)
+		}
+		fmt.Fprintln(w, "  TARGET GROUP\tHEALTH")
+		for _, tg := range region.TargetGroups {
+			fmt.Fprintf(w, "  %s\t%s\n", tg.Name, tg.Health)
+		}
+		fmt.Fprintln(w, "  VOLUME\tZONE\tSTATE\tSIZE\tINSTANCE")
+		for _, volume := range region.Volumes {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%dGiB\t%s\n", volume.VolumeID, volume.AvailabilityZone, volume.State, volume.SizeGiB, orNone(volume.Instance))
+		}
+	}
+
+	if len(result.Problems) > 0 {
+		fmt.Fprintln(w, "\nPROBLEMS")
+		for _, problem := range result.Problems {
+			fmt.Fprintln(w, "  "+problem)
+		}
+	}
+	return w.Flush()
+}
+
+// writeStatusJSON prints the status as an indented JSON document
+func writeStatusJSON(out io.Writer, result *deploymentStatus) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
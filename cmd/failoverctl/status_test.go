@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/election"
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+var statusTime = time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func healthyStatus() *deploymentStatus {
+	result := newDeploymentStatus("test", statusTime, []string{"us-east-1", "us-east-2"}, []checks.NodeStatus{
+		{Instance: "i-2", Region: "us-east-2", Role: "Full", BestBlock: 100, Finalized: 98},
+		{Instance: "i-1", Region: "us-east-1", Role: "Authority", LockHolder: true, BestBlock: 101, Finalized: 98, Peers: 3},
+	})
+	result.ConsulLeader, result.LockHolder = "i-2", "i-1"
+	result.addMembers([]checks.ConsulMember{
+		{Node: "i-1", Address: "10.0.1.1", Status: "alive", Region: "us-east-1"},
+		{Node: "i-2", Address: "10.1.1.1", Status: "alive", Region: "us-east-2"},
+	})
+	result.Regions[0].Alarms = []alarmStatus{{Name: "test-polkadot-health", State: "OK"}}
+	result.Regions[0].TargetGroups = []targetGroupStatus{{Name: "test-tg-rpc", Health: "healthy"}}
+	result.Regions[1].Volumes = []volumeStatus{{VolumeID: "vol-1", AvailabilityZone: "us-east-2a", State: "in-use", SizeGiB: 50, Instance: "i-2"}}
+	return result
+}
+
+func TestNewDeploymentStatus(t *testing.T) {
+	result := newDeploymentStatus("test", statusTime, []string{"us-east-1", "us-east-2", "us-west-1"}, []checks.NodeStatus{
+		{Instance: "i-3", Role: "Authority"},
+		{Instance: "i-1", Role: "Full"},
+		{Instance: "i-2", Role: "Authority"},
+	})
+
+	var instances []string
+	for _, node := range result.Nodes {
+		instances = append(instances, node.Instance)
+	}
+	assert.Equal(t, []string{"i-1", "i-2", "i-3"}, instances)
+	assert.Equal(t, []string{"i-2", "i-3"}, result.Validators)
+	require.Len(t, result.Regions, 3)
+	assert.Equal(t, "us-west-1", result.Regions[2].Region)
+}
+
+func TestAddMembers(t *testing.T) {
+	result := newDeploymentStatus("test", statusTime, []string{"us-east-1", "us-east-2"}, nil)
+	result.addMembers([]checks.ConsulMember{
+		{Node: "i-1", Region: "us-east-1"},
+		{Node: "i-2", Region: "us-east-2"},
+		{Node: "i-3", Region: "us-east-1"},
+		{Node: "i-4", Region: "eu-west-1"},
+		{Node: "i-5"},
+	})
+
+	nodes := func(members []checks.ConsulMember) []string {
+		var names []string
+		for _, member := range members {
+			names = append(names, member.Node)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"i-1", "i-3"}, nodes(result.Regions[0].Members))
+	assert.Equal(t, []string{"i-2"}, nodes(result.Regions[1].Members))
+}
+
+func TestStatusProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(result *deploymentStatus, report *checks.Report)
+		problems []string
+	}{
+		{
+			name:     "healthy",
+			modify:   func(result *deploymentStatus, report *checks.Report) {},
+			problems: []string{},
+		},
+		{
+			name: "failed step",
+			modify: func(result *deploymentStatus, report *checks.Report) {
+				report.Checks = append(report.Checks, &checks.CheckResult{Name: "Alarms", Findings: []checks.Finding{
+					{Region: "us-east-1", Severity: checks.SeverityInfo, Message: "2 alarms found"},
+					{Region: "us-east-2", Severity: checks.SeverityError, Message: "access denied"},
+				}})
+			},
+			problems: []string{"Alarms: [us-east-2] error: access denied"},
+		},
+		{
+			name: "no validator",
+			modify: func(result *deploymentStatus, report *checks.Report) {
+				result.Validators = nil
+			},
+			problems: []string{"No node runs with the Authority role"},
+		},
+		{
+			name: "no validator while frozen",
+			modify: func(result *deploymentStatus, report *checks.Report) {
+				result.Validators = nil
+				result.Freeze = &election.FreezeFlag{By: "operator"}
+			},
+			problems: []string{"No node runs with the Authority role and the elections are frozen, nobody takes over until they are unfrozen"},
+		},
+		{
+			name: "validator without the lock",
+			modify: func(result *deploymentStatus, report *checks.Report) {
+				result.LockHolder = "i-2"
+			},
+			problems: []string{"i-1 validates but i-2 holds the lock"},
+		},
+		{
+			name: "two validators and no lock holder",
+			modify: func(result *deploymentStatus, report *checks.Report) {
+				result.Validators = []string{"i-1", "i-2"}
+				result.LockHolder = ""
+			},
+			problems: []string{"More than one node runs with the Authority role: i-1, i-2", "No node holds the lock"},
+		},
+		{
+			name: "regions",
+			modify: func(result *deploymentStatus, report *checks.Report) {
+				result.Regions[1].Members[0].Status = "failed"
+				result.Regions[0].Alarms[0].State = "ALARM"
+				result.Regions[0].TargetGroups[0].Health = "unhealthy"
+			},
+			problems: []string{
+				"Alarm test-polkadot-health in us-east-1 is in ALARM state",
+				"Target group test-tg-rpc in us-east-1 is unhealthy",
+				"Consul member i-2 in us-east-2 is failed",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, report := healthyStatus(), &checks.Report{}
+			test.modify(result, report)
+			assert.Equal(t, test.problems, statusProblems(report, result))
+		})
+	}
+}
+
+func TestWriteStatus(t *testing.T) {
+	result := healthyStatus()
+	result.Handoff = &election.HandoffRequest{From: "i-1", To: "i-2", RequestedAt: statusTime.Add(-time.Minute), FinalBlock: 101}
+	result.Problems = []string{"No node holds the lock"}
+
+	var out bytes.Buffer
+	require.NoError(t, writeStatus(&out, result))
+
+	lines := strings.Split(out.String(), "\n")
+	assert.Equal(t, "Deployment test at 2020-10-01T12:00:00Z", lines[0])
+	for _, expected := range []string{
+		"Lock holder:    i-1",
+		"Authority:      i-1",
+		"Elections:      running",
+		"Handoff:        from i-1 to i-2 requested at 2020-10-01T11:59:00Z, stepped down at block 101",
+		"i-1       us-east-1  Authority  true   101   98         3      false",
+		"i-2       us-east-2  Full       false  100   98         0      false",
+		"  i-1                   10.0.1.1  alive",
+		"  test-polkadot-health  OK",
+		"  vol-1          us-east-2a  in-use  50GiB  i-2",
+		"PROBLEMS",
+		"  No node holds the lock",
+	} {
+		assert.Contains(t, lines, expected)
+	}
+
+	// Missing values are printed as a dash
+	result = newDeploymentStatus("test", statusTime, nil, nil)
+	result.Freeze = &election.FreezeFlag{By: "operator", Reason: "incident", FrozenAt: statusTime}
+	out.Reset()
+	require.NoError(t, writeStatus(&out, result))
+	assert.Contains(t, out.String(), "Consul leader:  -\n")
+	assert.Contains(t, out.String(), "Elections:      frozen by operator since 2020-10-01T12:00:00Z: incident\n")
+	assert.NotContains(t, out.String(), "PROBLEMS")
+}
+
+func TestWriteStatusJSON(t *testing.T) {
+	result := healthyStatus()
+	result.Problems = []string{}
+
+	var out bytes.Buffer
+	require.NoError(t, writeStatusJSON(&out, result))
+
+	var document map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &document))
+	assert.Equal(t, "i-1", document["lock_holder"])
+	assert.Equal(t, []interface{}{"i-1"}, document["validators"])
+	assert.Nil(t, document["freeze"])
+	assert.Equal(t, []interface{}{}, document["problems"])
+
+	var decoded deploymentStatus
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, *result, decoded)
+}
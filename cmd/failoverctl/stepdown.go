@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/audit"
+	"github.com/protofire/polkadot-failover-mechanism/agent/election"
+	checks "github.com/protofire/polkadot-failover-mechanism/tests/aws"
+)
+
+// stepDown asks the current validator to hand its role over to a healthy standby and waits until the standby validates.
+// The handoff is carried out by the agents, see agent/election/handoff.go
+func stepDown(args []string) int {
+	flags := flag.NewFlagSet("stepdown", flag.ExitOnError)
+	d := deploymentFlags(flags)
+	to := flags.String("to", "", "Instance ID of the standby to take over. The synced standby with the highest best block is chosen if not set")
+	wait := flags.Bool("wait", true, "Wait until the new validator holds the lock and runs with the Authority role")
+	flags.Parse(args)
+
+	opts, err := d.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 2
+	}
+	opts.Checks = []string{checks.CheckNodeStatus}
+
+	ctx := context.Background()
+	report := checks.Audit(ctx, opts)
+	if failed(report) {
+		return 1
+	}
+
+	from, target, err := handoffNodes(report.Nodes, *to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 1
+	}
+
+	return handOver(ctx, report, opts, *d.timeout, from, target, *wait, "stepdown of "+from.Instance)
+}
+
+// handOver stores the handoff request on the validator, records it in the audit log and, if wait is set, waits until a node other
+// than the validator holds the lock and validates. The request is refused while the elections are frozen or another handoff is pending
+func handOver(ctx context.Context, report *checks.Report, opts checks.AuditOptions, timeout time.Duration, from, target checks.NodeStatus, wait bool, action string) int {
+	publicIPs := make(map[string]string)
+	for _, node := range report.Nodes {
+		publicIPs[node.Instance] = node.PublicIP
+	}
+
+	view := consulView(ctx, report, opts, publicIPs)
+	if view == nil {
+		return 1
+	}
+	if view.Freeze != nil {
+		fmt.Fprintf(os.Stderr, "ERROR! Elections are frozen by %s since %s, run `failoverctl unfreeze` first\n", view.Freeze.By, view.Freeze.FrozenAt.Format(time.RFC3339))
+		return 1
+	}
+	if view.Handoff != nil && !view.Handoff.Expired(time.Now()) {
+		fmt.Fprintf(os.Stderr, "ERROR! The handoff from %s to %q requested at %s is still pending\n", view.Handoff.From, view.Handoff.To, view.Handoff.RequestedAt.Format(time.RFC3339))
+		return 1
+	}
+
+	request := election.HandoffRequest{
+		From:        from.Instance,
+		To:          target.Instance,
+		RequestedAt: time.Now().UTC(),
+		Timeout:     timeout,
+	}
+	value, err := json.Marshal(request)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 1
+	}
+
+	fmt.Printf("Asking %s (%s) to hand the validator role over to %s (%s)\n", from.Instance, from.Region, target.Instance, target.Region)
+
+	report.Audit("Handoff request", opts.Out, func(t checks.TestingT, c *checks.CheckResult) {
+		checks.ConsulKVPut(ctx, t, map[string]string{from.Instance: from.PublicIP}, opts.SSHKey, election.DefaultHandoffKey, value)
+		c.Info(from.Region, from.Instance, "Requested the handoff to "+target.Instance)
+	})
+	if failed(report) {
+		return 1
+	}
+	// The request is stored already, so a failure to record it only changes the exit status
+	recorded := record(ctx, opts, audit.Event{
+		Instance: target.Instance,
+		Region:   target.Region,
+		Type:     audit.HandoffRequested,
+		Message:  action + " requested by " + operator(),
+	})
+
+	if !wait {
+		if !recorded {
+			return 1
+		}
+		return 0
+	}
+
+	var holder string
+	report.Audit("Handoff", opts.Out, func(t checks.TestingT, c *checks.CheckResult) {
+		holder = checks.HandoffCheck(ctx, t, c, publicIPs, opts.SSHKey, from.Instance, timeout)
+	})
+	if failed(report) {
+		return 1
+	}
+
+	fmt.Printf("%s holds the lock and validates\n", holder)
+	if holder != target.Instance {
+		fmt.Fprintf(os.Stderr, "ERROR! %s took over instead of %s, the handoff request has probably expired\n", holder, target.Instance)
+		return 1
+	}
+	if !recorded {
+		return 1
+	}
+	return 0
+}
+
+// handoffNodes returns the current validator and the standby that should take over
+func handoffNodes(nodes []checks.NodeStatus, to string) (checks.NodeStatus, checks.NodeStatus, error) {
+	var target checks.NodeStatus
+	from, err := validatorNode(nodes)
+	if err != nil {
+		return from, target, err
+	}
+
+	for _, node := range nodes {
+		if node.Instance == from.Instance || node.Role != "Full" || node.IsSyncing {
+			continue
+		}
+		if to != "" {
+			if node.Instance == to {
+				return from, node, nil
+			}
+			continue
+		}
+		if target.Instance == "" || node.BestBlock > target.BestBlock {
+			target = node
+		}
+	}
+
+	if to != "" {
+		return from, target, fmt.Errorf("%s is not a synced standby of the deployment", to)
+	}
+	if target.Instance == "" {
+		return from, target, fmt.Errorf("no synced standby to take over")
+	}
+	return from, target, nil
+}
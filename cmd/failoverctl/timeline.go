@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/audit"
+)
+
+// timeline merges the audit logs of every node into a single timeline of the role transitions of the deployment. The logs are read
+// from CloudWatch Logs in every region, a region that lost the events of a node being covered by the other ones, or from local files
+func timeline(args []string) int {
+	flags := flag.NewFlagSet("timeline", flag.ExitOnError)
+	prefix := flags.String("prefix", os.Getenv("PREFIX"), "Prefix of the deployment")
+	regions := flags.String("regions", "us-east-1,us-east-2,eu-west-1", "Comma separated list of the regions to read the audit log from")
+	since := flags.Duration("since", 24*time.Hour, "Age of the oldest event to print")
+	files := flags.String("files", "", "Comma separated list of audit files written by failover-agent -audit-file, read instead of CloudWatch Logs")
+	instance := flags.String("instance", "", "Only print the events of this instance")
+	asJSON := flags.Bool("json", false, "Print the events as a JSON array instead of a table")
+	flags.Parse(args)
+
+	var sources [][]audit.Event
+	if *files != "" {
+		for _, path := range strings.Split(*files, ",") {
+			events, err := readAuditFile(strings.TrimSpace(path))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR! %s: %s\n", path, err)
+				return 1
+			}
+			sources = append(sources, events)
+		}
+	} else {
+		if *prefix == "" {
+			fmt.Fprintln(os.Stderr, "ERROR! -prefix (or PREFIX environment variable) is required")
+			return 2
+		}
+		end := time.Now()
+		start := end.Add(-*since)
+		ctx := context.Background()
+		for _, region := range strings.Split(*regions, ",") {
+			region = strings.TrimSpace(region)
+			client := cloudwatchlogs.New(session.Must(session.NewSession(aws.NewConfig().WithRegion(region))))
+			events, err := audit.Fetch(ctx, client, audit.LogGroup(*prefix), start.UnixNano()/1e6, end.UnixNano()/1e6)
+			if err != nil {
+				// The other regions hold the same events
+				fmt.Fprintf(os.Stderr, "ERROR! %s: %s\n", region, err)
+				continue
+			}
+			sources = append(sources, events)
+		}
+		if len(sources) == 0 {
+			return 1
+		}
+	}
+
+	events := audit.Timeline(sources...)
+	if *instance != "" {
+		var filtered []audit.Event
+		for _, event := range events {
+			if event.Instance == *instance {
+				filtered = append(filtered, event)
+			}
+		}
+		events = filtered
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if events == nil {
+			events = []audit.Event{}
+		}
+		if err := encoder.Encode(events); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+			return 1
+		}
+	} else if err := audit.WriteTable(os.Stdout, events); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR! "+err.Error())
+		return 1
+	}
+
+	overlaps := audit.Overlaps(events)
+	for _, overlap := range overlaps {
+		fmt.Fprintln(os.Stderr, "ERROR! More than one validator: "+overlap)
+	}
+	if len(overlaps) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func readAuditFile(path string) ([]audit.Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return audit.Read(file)
+}
@@ -0,0 +1,95 @@
+package test
+
+// This file contains all the supplementary functions that are required to deploy the nodes with failover-agent built from the tested source
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	taws "github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/ssh"
+	"github.com/stretchr/testify/require"
+)
+
+// AgentPackage is the package of failover-agent built by PublishAgent
+const AgentPackage = "github.com/protofire/polkadot-failover-mechanism/cmd/failover-agent"
+
+// AgentKeySuffix is appended to the prefix to name the agent the bundle test publishes to the bucket of the Terraform states
+const AgentKeySuffix = "-failover-agent"
+
+// AgentURLExpiry is how long the nodes can download the published agent. Instances the autoscaling group starts later fail to boot
+const AgentURLExpiry = 12 * time.Hour
+
+// External function that builds failover-agent for the instances, uploads it to the given bucket and returns a presigned URL to download it from
+func PublishAgent(t TestingT, region string, bucket string, key string) string {
+	url, err := PublishAgentE(t, region, bucket, key)
+	require.NoError(t, err)
+	return url
+}
+
+func PublishAgentE(t TestingT, region string, bucket string, key string) (string, error) {
+	dir, err := ioutil.TempDir("", "failover-agent")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	binary := filepath.Join(dir, "failover-agent")
+	build := exec.Command("go", "build", "-o", binary, AgentPackage)
+	build.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	if output, err := build.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("unable to build %s: %w: %s", AgentPackage, err, strings.TrimSpace(string(output)))
+	}
+
+	file, err := os.Open(binary)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	sess, err := taws.NewAuthenticatedSession(region)
+	if err != nil {
+		return "", err
+	}
+	client := s3.New(sess)
+	if _, err := client.PutObject(&s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: file}); err != nil {
+		return "", err
+	}
+	t.Log("INFO. failover-agent was published to s3://" + bucket + "/" + key)
+
+	request, _ := client.GetObjectRequest(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	return request.Presign(AgentURLExpiry)
+}
+
+// External function that deletes the agent published by PublishAgent
+func DeleteAgent(t TestingT, region string, bucket string, key string) {
+	err := DeleteAgentE(t, region, bucket, key)
+	require.NoError(t, err)
+}
+
+func DeleteAgentE(t TestingT, region string, bucket string, key string) error {
+	sess, err := taws.NewAuthenticatedSession(region)
+	if err != nil {
+		return err
+	}
+	_, err = s3.New(sess).DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	return err
+}
+
+// Supplementary function: reports whether the nodes were deployed with failover-agent, i.e. whether any node has it installed
+func AgentInstalled(ctx context.Context, t TestingT, publicIPs map[string]string, key *ssh.KeyPair) bool {
+	for _, output := range NodeQuery(ctx, t, publicIPs, key, "[ -x /usr/local/bin/failover-agent ] && echo true || echo false") {
+		if output == "true" {
+			return true
+		}
+	}
+	return false
+}
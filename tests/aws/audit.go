@@ -0,0 +1,246 @@
+package test
+
+// This file contains all the supplementary functions that are required to run the checks in read-only mode against an already running deployment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/ssh"
+)
+
+// Names of the checks run by Audit
+const (
+	CheckDiscovery      = "Instances discovery"
+	CheckInstanceCount  = "Instance count"
+	CheckConsul         = "Consul verifications"
+	CheckPolkadot       = "Polkadot verifications"
+	CheckKeystore       = "Keystore tests"
+	CheckNodeStatus     = "Node status"
+	CheckEpoch          = "Epoch tests"
+	CheckSSM            = "SSM tests"
+	CheckSecurityGroups = "Security groups tests"
+	CheckVolumes        = "Volumes tests"
+	CheckCloudWatch     = "CloudWatch tests"
+	CheckNLB            = "NLB tests"
+)
+
+// AuditOptions describes an already running deployment to be audited
+type AuditOptions struct {
+	Prefix  string
+	Regions [3]string
+	// SSHKey is used to query the nodes over SSH as ec2-user. If nil, the checks that require SSH access are skipped
+	SSHKey *ssh.KeyPair
+	// ExposeSSH is the expose_ssh variable of the deployment. The security groups check expects port 22 open to the world if set
+	ExposeSSH bool
+	// Timeout is the upper bound of time the checks may spend waiting for eventually consistent state
+	Timeout time.Duration
+	// Out receives the log of every check
+	Out io.Writer
+	// Checks limits the audit to the checks with the given names. All the checks are run if empty. Instances discovery is always run
+	Checks []string
+}
+
+func (opts AuditOptions) enabled(name string) bool {
+	if len(opts.Checks) == 0 || name == CheckDiscovery {
+		return true
+	}
+	for _, check := range opts.Checks {
+		if check == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Audit runs the same checks as the bundle test against an already running deployment. It never runs `terraform apply` or `terraform destroy`,
+// all the checks only read the state of the cloud resources and the nodes.
+func Audit(ctx context.Context, opts AuditOptions) *Report {
+
+	if opts.Out == nil {
+		opts.Out = ioutil.Discard
+	}
+
+	SetDeployment(opts.Prefix, opts.Regions)
+	report := NewReport(opts.Prefix, opts.Regions[:])
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	var instanceIDs []string
+	var publicIPs map[string]string
+
+	run := func(name string, check func(t TestingT, c *CheckResult)) {
+		if opts.enabled(name) {
+			report.Audit(name, opts.Out, check)
+		}
+	}
+
+	run(CheckDiscovery, func(t TestingT, c *CheckResult) {
+		instanceIDs, publicIPs = DiscoverInstances(t, c, report)
+	})
+
+	run(CheckInstanceCount, func(t TestingT, c *CheckResult) {
+		InstanceCountCheck(t, c, instanceIDs)
+	})
+
+	if opts.SSHKey != nil {
+		run(CheckConsul, func(t TestingT, c *CheckResult) {
+			ConsulLockCheck(ctx, t, c, publicIPs, opts.SSHKey)
+			ConsulCheck(ctx, t, c, publicIPs, opts.SSHKey)
+		})
+
+		run(CheckPolkadot, func(t TestingT, c *CheckResult) {
+			LeadersCheck(ctx, t, c, publicIPs, opts.SSHKey)
+			PolkadotCheck(ctx, t, c, publicIPs, opts.SSHKey)
+		})
+
+		run(CheckKeystore, func(t TestingT, c *CheckResult) {
+			KeystoreCheck(ctx, t, c, publicIPs, opts.SSHKey, CountValidatorKeys(t, awsRegion[0]))
+		})
+
+		run(CheckNodeStatus, func(t TestingT, c *CheckResult) {
+			report.Nodes = NodeStatusCheck(ctx, t, c, publicIPs, opts.SSHKey)
+		})
+
+		run(CheckEpoch, func(t TestingT, c *CheckResult) {
+			// Only failover-agent promotes validators under an epoch
+			if !AgentInstalled(ctx, t, publicIPs, opts.SSHKey) {
+				c.Info("", "", "The nodes run without failover-agent, there is no epoch to check")
+				return
+			}
+			EpochCheck(ctx, t, c, publicIPs, opts.SSHKey, 1)
+		})
+	} else {
+		fmt.Fprintln(opts.Out, "INFO. No SSH key given, skipping Consul, Polkadot, Keystore, Node status and Epoch checks")
+	}
+
+	run(CheckSSM, func(t TestingT, c *CheckResult) {
+		SSMConsistencyCheck(t, c)
+	})
+
+	run(CheckSecurityGroups, func(t TestingT, c *CheckResult) {
+		SGCheck(t, c, opts.ExposeSSH)
+	})
+
+	run(CheckVolumes, func(t TestingT, c *CheckResult) {
+		VolumesCheck(t, c)
+	})
+
+	run(CheckCloudWatch, func(t TestingT, c *CheckResult) {
+		CloudWatchCheck(ctx, t, c)
+	})
+
+	run(CheckNLB, func(t TestingT, c *CheckResult) {
+		var lbs []string
+		for _, region := range awsRegion {
+			lbs = append(lbs, GetLBArnByName(t, region, prefix+"-internal-lb-polkadot"))
+		}
+		NLBCheck(t, c, lbs)
+	})
+
+	report.Seconds = time.Since(report.Started).Seconds()
+	return report
+}
+
+// Audit executes the check outside of `go test` and records its findings, result and timing. Fatal errors stop the check only
+func (r *Report) Audit(name string, out io.Writer, check func(t TestingT, c *CheckResult)) bool {
+	result := r.start(name)
+	t := &auditT{name: name, out: out}
+	result.t = t
+
+	// Run the check in its own goroutine, so FailNow can stop it the same way *testing.T does
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if err := recover(); err != nil {
+				t.Error(fmt.Sprint("check panicked: ", err))
+			}
+		}()
+		check(t, result)
+	}()
+	<-done
+
+	passed := !t.Failed()
+	result.finish(passed)
+	return passed
+}
+
+// Supplementary function: returns the number of validator keys stored in SSM for the current prefix
+func CountValidatorKeys(t TestingT, region string) int {
+	path := "/polkadot/validator-failover/" + prefix + "/keys/"
+	names := make(map[string]bool)
+
+	for name := range GetParametersByPath(t, region, path) {
+		parts := strings.Split(strings.TrimPrefix(name, path), "/")
+		names[parts[0]] = true
+	}
+	return len(names)
+}
+
+// auditT implements TestingT for the checks that run in read-only audit mode
+type auditT struct {
+	name   string
+	out    io.Writer
+	mu     sync.Mutex
+	failed bool
+}
+
+func (a *auditT) Log(args ...interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.out != nil {
+		fmt.Fprintln(a.out, "["+a.name+"] "+strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+	}
+}
+
+func (a *auditT) Fail() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.failed = true
+}
+
+func (a *auditT) Failed() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.failed
+}
+
+func (a *auditT) FailNow() {
+	a.Fail()
+	runtime.Goexit()
+}
+
+func (a *auditT) Error(args ...interface{}) {
+	a.Log(args...)
+	a.Fail()
+}
+
+func (a *auditT) Errorf(format string, args ...interface{}) {
+	a.Log(fmt.Sprintf(format, args...))
+	a.Fail()
+}
+
+func (a *auditT) Fatal(args ...interface{}) {
+	a.Log(args...)
+	a.FailNow()
+}
+
+func (a *auditT) Fatalf(format string, args ...interface{}) {
+	a.Log(fmt.Sprintf(format, args...))
+	a.FailNow()
+}
+
+func (a *auditT) Name() string {
+	return a.name
+}
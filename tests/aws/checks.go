@@ -0,0 +1,966 @@
+package test
+
+// This file contains the checks that verify a deployment. They are shared by the bundle test and by the read-only audit mode
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/ssh"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/status"
+)
+
+// TestRegions are the regions the tests deploy to
+var TestRegions = [3]string{"us-east-1", "us-east-2", "us-west-1"}
+
+// Gather environmental variables and set reasonable defaults. Can be overridden with SetDeployment
+var awsRegion = TestRegions
+var prefix = os.Getenv("PREFIX")
+
+// TestingT is the subset of *testing.T the checks rely on. It is implemented by *testing.T and by the read-only audit runner
+type TestingT interface {
+	Fail()
+	FailNow()
+	Fatal(args ...interface{})
+	Fatalf(format string, args ...interface{})
+	Error(args ...interface{})
+	Errorf(format string, args ...interface{})
+	Log(args ...interface{})
+	Name() string
+}
+
+// SetDeployment points the checks to the deployment with the given prefix, deployed to the given regions
+func SetDeployment(deploymentPrefix string, regions [3]string) {
+	prefix = deploymentPrefix
+	awsRegion = regions
+}
+
+// Supplementary function: find healthy instances in each region with public ips assigned. Returns instance IDs and a map of instance ID to public IP
+func DiscoverInstances(t TestingT, c *CheckResult, report *Report) ([]string, map[string]string) {
+
+	var instanceIDs []string
+	publicIPs := make(map[string]string)
+
+	for _, value := range awsRegion {
+		// GetHealthyEc2InstanceIdsByTag located in ec2.go file
+		regionInstances := GetHealthyEc2InstanceIdsByTag(t, value, "prefix", prefix)
+
+		if len(regionInstances) < 1 {
+			c.Error(value, "", "No instances found in "+value+" region.")
+			continue
+		} else {
+			c.Info(value, "", "The following instances found in "+value+" region: "+strings.Join(regionInstances, ",")+".")
+		}
+
+		instanceIDs = append(instanceIDs, regionInstances...)
+		for _, instance := range regionInstances {
+			report.AddInstance(instance, value)
+		}
+
+		// Fetching PublicIPs for the instances we have found
+		regionIPs := aws.GetPublicIpsOfEc2Instances(t, regionInstances, value)
+
+		if len(regionIPs) < 1 {
+			c.Error(value, "", "No public IPs found for instances in "+value+" region.")
+		}
+
+		for k, v := range regionIPs {
+			publicIPs[k] = v
+			c.NodeInfo(k, "InstanceID: "+k+", InstanceIP: "+v)
+		}
+	}
+
+	return instanceIDs, publicIPs
+}
+
+// Supplementary function: verifies the number of instances - should be an odd number, at least 3
+func InstanceCountCheck(t TestingT, c *CheckResult, instanceIDs []string) bool {
+
+	instance_count := len(instanceIDs)
+	result := true
+
+	if instance_count%2 == 1 {
+		c.Info("", "", "There are odd instances running")
+	} else {
+		c.Error("", "", "There are even instances running")
+		result = false
+	}
+
+	if instance_count > 2 {
+		c.Info("", "", "Minimum viable instance count (3) reached. There are "+strconv.Itoa(instance_count)+" instances running.")
+	} else {
+		c.Error("", "", "Minimum viable instance count (3) not reached. There are "+strconv.Itoa(instance_count)+" instances running.")
+		result = false
+	}
+
+	return result
+}
+
+// TEST 9. exposeSSH is the expose_ssh variable of the deployment, which opens port 22 to the world
+func SGCheck(t TestingT, c *CheckResult, exposeSSH bool) bool {
+
+	// A set of predefined security rules to compare existing rules with.
+	fromPorts := []int64{30333, 22, 8301, 8600, 8500, 8300}
+	toPorts := []int64{30333, 22, 8302, 8600, 8500}
+	ipProtocols := []string{"tcp", "udp"}
+	cidrIPs := []string{"0.0.0.0/0", "10.2.0.0/16", "10.1.0.0/16", "10.0.0.0/16"}
+
+	var rules = []*ec2.IpPermission{
+		&ec2.IpPermission{
+			FromPort:   &fromPorts[0],
+			IpProtocol: &ipProtocols[0],
+			IpRanges: []*ec2.IpRange{
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[0],
+				},
+			},
+			ToPort: &toPorts[0],
+		},
+		&ec2.IpPermission{
+			FromPort:   &fromPorts[1],
+			IpProtocol: &ipProtocols[0],
+			IpRanges: []*ec2.IpRange{
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[0],
+				},
+			},
+			ToPort: &toPorts[1],
+		},
+		&ec2.IpPermission{
+			FromPort:   &fromPorts[2],
+			IpProtocol: &ipProtocols[1],
+			IpRanges: []*ec2.IpRange{
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[1],
+				},
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[2],
+				},
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[3],
+				},
+			},
+			ToPort: &toPorts[2],
+		},
+		&ec2.IpPermission{
+			FromPort:   &fromPorts[3],
+			IpProtocol: &ipProtocols[1],
+			IpRanges: []*ec2.IpRange{
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[2],
+				},
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[3],
+				},
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[1],
+				},
+			},
+			ToPort: &toPorts[3],
+		},
+		&ec2.IpPermission{
+			FromPort:   &fromPorts[4],
+			IpProtocol: &ipProtocols[1],
+			IpRanges: []*ec2.IpRange{
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[1],
+				},
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[2],
+				},
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[3],
+				},
+			},
+			ToPort: &toPorts[4],
+		},
+		&ec2.IpPermission{
+			FromPort:   &fromPorts[0],
+			IpProtocol: &ipProtocols[1],
+			IpRanges: []*ec2.IpRange{
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[0],
+				},
+			},
+			ToPort: &toPorts[0],
+		},
+		&ec2.IpPermission{
+			FromPort:   &fromPorts[4],
+			IpProtocol: &ipProtocols[0],
+			IpRanges: []*ec2.IpRange{
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[3],
+				},
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[2],
+				},
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[1],
+				},
+			},
+			ToPort: &toPorts[4],
+		},
+		&ec2.IpPermission{
+			FromPort:   &fromPorts[5],
+			IpProtocol: &ipProtocols[0],
+			IpRanges: []*ec2.IpRange{
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[3],
+				},
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[2],
+				},
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[1],
+				},
+			},
+			ToPort: &toPorts[2],
+		},
+		&ec2.IpPermission{
+			FromPort:   &fromPorts[3],
+			IpProtocol: &ipProtocols[0],
+			IpRanges: []*ec2.IpRange{
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[1],
+				},
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[3],
+				},
+				&ec2.IpRange{
+					CidrIp: &cidrIPs[2],
+				},
+			},
+			ToPort: &toPorts[3],
+		},
+	}
+
+	// The second rule opens port 22, it only exists if SSH is exposed
+	if !exposeSSH {
+		rules = append(rules[:1], rules[2:]...)
+	}
+
+	// For each region fetch all the security groups prefixed with predefined prefix and compare it one by one with a list of predefined groups
+	for _, region := range awsRegion {
+
+		ruleSlice := GetSGRulesMapByTag(t, region, "prefix", prefix)
+
+		// Deployments running failover-agent open its health port to the NLB within the VPCs
+		var otherRules []*ec2.IpPermission
+		for _, ruleSet := range ruleSlice {
+			if ruleSet.FromPort != nil && strconv.FormatInt(*ruleSet.FromPort, 10) == strings.TrimPrefix(status.DefaultHealthListen, ":") {
+				public := false
+				for _, ipRange := range ruleSet.IpRanges {
+					public = public || *ipRange.CidrIp == cidrIPs[0]
+				}
+				if *ruleSet.IpProtocol != ipProtocols[0] || len(ruleSet.IpRanges) != 3 || public {
+					c.Error(region, "", "The health port is expected to be open to the VPCs over TCP: "+ruleSet.String())
+					return false
+				}
+				c.Info(region, "", "The health port of failover-agent is open to the VPCs: "+ruleSet.String())
+				continue
+			}
+			otherRules = append(otherRules, ruleSet)
+		}
+		ruleSlice = otherRules
+		lenRuleSlice := len(ruleSlice)
+
+		if lenRuleSlice != len(rules) {
+			c.Error(region, "", "Expecting to get "+strconv.Itoa(len(rules))+" security groups, got "+strconv.Itoa(lenRuleSlice))
+			return false
+		}
+
+		for _, ruleSet := range ruleSlice {
+			found := 0
+			for _, ruleExpect := range rules {
+				if cmp.Equal(ruleSet, ruleExpect) {
+					found = 1
+					continue
+				} else {
+					t.Log(cmp.Diff(ruleSet, ruleExpect))
+				}
+			}
+			if found != 1 {
+				c.Error(region, "", "No match were found for current record: "+ruleSet.String())
+				return false
+			} else {
+				c.Info(region, "", "The following record matches one of the predefined security rules: "+ruleSet.String())
+			}
+		}
+	}
+
+	return true
+}
+
+// TEST 10
+func VolumesCheck(t TestingT, c *CheckResult) bool {
+
+	count := 0
+	// Go through each region. Select unattached labeled disks. If no disks found, then the test passes successfully
+	for _, region := range awsRegion {
+
+		check := GetVolumeDescribe(t, region, "prefix", prefix)
+
+		if len(check) == 0 {
+			c.Info(region, "", "No unnatached disks were found in region "+region)
+			continue
+		} else {
+			for _, volume := range check {
+				c.Error(region, "", "Unattached disk "+*volume.VolumeId+" was found in region "+region)
+			}
+			count = count + 1
+		}
+
+	}
+
+	if count == 0 {
+		return true
+	} else {
+		return false
+	}
+
+}
+
+// TEST 11
+func CloudWatchCheck(ctx context.Context, t TestingT, c *CheckResult) bool {
+
+	count := 0
+	for _, region := range awsRegion {
+		var check map[string]string
+
+		// Check that there are exactly 4 CloudWatch alarms (should be changed here if new alarms added). If alarm still has "INSUFFICIENT DATA" status - we need to wait until alarm either triggers or move into "OK" state.
+		err := Poll(ctx, "CloudWatch alarms in region "+region+" leave the INSUFFICIENT_DATA state", PollOptions{Timeout: 10 * time.Minute, Interval: 10 * time.Second, Log: t.Log}, func(ctx context.Context) (bool, string, error) {
+			alarms, err := GetAlarmsNamesAndStatesByPrefixE(t, region, prefix)
+			if err != nil {
+				return false, "", err
+			}
+			check = alarms
+
+			if len(check) != 4 {
+				return false, strconv.Itoa(len(check)) + " alarms found, expected 4", nil
+			}
+			for k, v := range check {
+				if v == "INSUFFICIENT_DATA" {
+					return false, "alarm " + k + " has insufficient data", nil
+				}
+			}
+			return true, "", nil
+		})
+
+		if err != nil {
+			c.Error(region, "", err.Error())
+			count = count + 1
+			continue
+		}
+		c.Info(region, "", "CloudWatch Alarms number matches the predefined value of 4")
+
+		for k, v := range check {
+			if v == "OK" {
+				c.Info(region, "", "The CloudWatch Alarm "+k+" in region "+region+" has the state OK!")
+			} else {
+				c.Error(region, "", "The CloudWatch Alarm "+k+" in region "+region+" has the state "+v+", which is not OK")
+				count = count + 1
+			}
+		}
+	}
+
+	if count == 0 {
+		return true
+	} else {
+		return false
+	}
+
+}
+
+// HealthCheck is the health check the TGs perform when the nodes run failover-agent: its alive path on the health port
+var HealthCheck = "HTTP:" + strings.TrimPrefix(status.DefaultHealthListen, ":") + status.AlivePath
+
+// TEST 12
+func NLBCheck(t TestingT, c *CheckResult, lbs []string) bool {
+	var err bool = false
+	for i, lb := range lbs {
+		var errLocal bool = false
+
+		resultMap := GetHealthStatusSliceByLBsARN(t, awsRegion[i], lb)
+		lenResultMap := len(resultMap)
+
+		// Check that there exactly 6 TargetGroup were created
+		if lenResultMap != 6 {
+			c.Error(awsRegion[i], "", "Expected 6 TGs at LoadBalancer "+lb+", got "+strconv.Itoa(lenResultMap))
+			err = true
+		} else {
+			c.Info(awsRegion[i], "", "There are exactly 6 TGs at LoadBalancer "+lb)
+		}
+
+		// Check that TG reports healthy status
+		for TG, result := range resultMap {
+			if result != "healthy" {
+				c.Error(awsRegion[i], "", "The LB "+lb+" contains TG "+TG+" with not healthy instances. Instance health status is "+result)
+				err = true
+				errLocal = true
+			}
+		}
+		if errLocal {
+			t.Log("ERROR! The LB " + lb + " contains some TG that are not healthy")
+		} else {
+			c.Info(awsRegion[i], "", "All TGs in LB "+lb+" contains only healthy instances.")
+		}
+
+		// Check that the TGs probe the health endpoint of failover-agent, so that a stalled node is not healthy. Deployments without
+		// the agent probe the ports of the TGs only
+		probes := make(map[string]bool)
+		for TG, check := range GetHealthChecksByLBsARN(t, awsRegion[i], lb) {
+			probes[check] = true
+			if strings.HasPrefix(check, "HTTP:") && check != HealthCheck {
+				c.Error(awsRegion[i], "", "The TG "+TG+" probes "+check+" instead of "+HealthCheck)
+				err = true
+			}
+		}
+		switch {
+		case len(probes) > 1:
+			c.Error(awsRegion[i], "", "The TGs of LB "+lb+" perform different health checks")
+			err = true
+		case probes[HealthCheck]:
+			c.Info(awsRegion[i], "", "All TGs in LB "+lb+" probe the health endpoint "+HealthCheck)
+		case probes["TCP:traffic-port"]:
+			c.Info(awsRegion[i], "", "All TGs in LB "+lb+" probe their ports only, the health endpoint requires failover-agent")
+		}
+	}
+	if err {
+		return false
+	} else {
+		return true
+	}
+}
+
+// Supplementary check for the deployments whose agent_url is known: SGCheck and NLBCheck accept the nodes either with or without
+// failover-agent, this check fails unless the health port is open and every TG probes the health endpoint exactly when agent is set
+func AgentHealthCheck(t TestingT, c *CheckResult, lbs []string, agent bool) bool {
+	healthPort := strings.TrimPrefix(status.DefaultHealthListen, ":")
+	result := true
+	for i, region := range awsRegion {
+		failed := false
+		open := false
+		for _, ruleSet := range GetSGRulesMapByTag(t, region, "prefix", prefix) {
+			open = open || (ruleSet.FromPort != nil && strconv.FormatInt(*ruleSet.FromPort, 10) == healthPort)
+		}
+		switch {
+		case open && !agent:
+			c.Error(region, "", "The health port "+healthPort+" is open although the nodes run without failover-agent")
+			failed = true
+		case !open && agent:
+			c.Error(region, "", "The health port "+healthPort+" of failover-agent is not open to the NLB")
+			failed = true
+		}
+
+		var checks map[string]string
+		if i < len(lbs) {
+			checks = GetHealthChecksByLBsARN(t, region, lbs[i])
+		}
+		if len(checks) == 0 {
+			c.Error(region, "", "No health checks were found for the LB of the region")
+			failed = true
+		}
+		for TG, check := range checks {
+			switch {
+			case agent && check != HealthCheck:
+				c.Error(region, "", "The TG "+TG+" probes "+check+" instead of the health endpoint "+HealthCheck)
+				failed = true
+			case !agent && check == HealthCheck:
+				c.Error(region, "", "The TG "+TG+" probes the health endpoint "+HealthCheck+" although the nodes run without failover-agent")
+				failed = true
+			}
+		}
+		if failed {
+			result = false
+		} else {
+			c.Info(region, "", "The health port and the health checks of the LB match the deployment")
+		}
+	}
+	return result
+}
+
+// Supplementary function: Checks that given parameter in each parameter exists and has the right type (e.g. all the encrypted parameters has the SecureString type)
+func TypeAndValueComparator(t TestingT, c *CheckResult, relativePath string, expectedType string, expectedValue string) int {
+
+	for _, region := range awsRegion {
+		ssmType, ssmValue := GetParameterTypeAndValue(t, region, "/polkadot/validator-failover/"+prefix+"/"+relativePath)
+		if ssmType == expectedType && ssmValue == expectedValue {
+			c.Info(region, "", "SSM Parameter "+relativePath+" of type "+ssmType+" and value "+ssmValue+" at region "+region+" matched prefedined value.")
+		} else {
+			c.Error(region, "", "No match for SSM parameter "+relativePath+" at region "+region+". Expected type: "+expectedType+", expected value: "+expectedValue+". Actual type: "+ssmType+", actual value: "+ssmValue)
+			return 0
+		}
+	}
+
+	return 1
+}
+
+// TEST 8
+func SSMCheck(t TestingT, c *CheckResult) bool {
+
+	result := TypeAndValueComparator(t, c, "cpu_limit", "String", "1") *
+		TypeAndValueComparator(t, c, "ram_limit", "String", "1") *
+		TypeAndValueComparator(t, c, "name", "String", "test") *
+		TypeAndValueComparator(t, c, "keys/key1/type", "String", "gran") *
+		TypeAndValueComparator(t, c, "keys/key1/seed", "SecureString", "favorite liar zebra assume hurt cage any damp inherit rescue delay panic") *
+		TypeAndValueComparator(t, c, "keys/key1/key", "String", "0x6ce96ae5c300096b09dbd4567b0574f6a1281ae0e5cfe4f6b0233d1821f6206b") *
+		TypeAndValueComparator(t, c, "keys/key2/type", "String", "aura") *
+		TypeAndValueComparator(t, c, "keys/key2/seed", "SecureString", "expire stage crawl shell boss any story swamp skull yellow bamboo copy") *
+		TypeAndValueComparator(t, c, "keys/key2/key", "String", "0x3ff0766f9ebbbceee6c2f40d9323164d07e70c70994c9d00a9512be6680c2394")
+
+	if result == 1 {
+		return true
+	} else {
+		return false
+	}
+
+}
+
+// TEST 6
+func LeadersCheck(ctx context.Context, t TestingT, c *CheckResult, publicIPs map[string]string, key *ssh.KeyPair) bool {
+
+	command := "curl -s -H \"Content-Type: application/json\" -d '{\"id\":1, \"jsonrpc\":\"2.0\", \"method\": \"system_nodeRoles\", \"params\":[]}' http://localhost:9933"
+	outputs := NodeQuery(ctx, t, publicIPs, key, command)
+
+	var leaders, nodes int = 0, 0
+
+	if len(outputs) == 0 {
+		return false
+	} else {
+
+		// SSH into the node and ensure, that only one node returns "Authority" for system_nodeRoles call
+		for instance, value := range outputs {
+
+			if value == "{\"jsonrpc\":\"2.0\",\"result\":[\"Authority\"],\"id\":1}" {
+				c.NodeInfo(instance, "Node works in Authority mode")
+				leaders++
+			} else if value == "{\"jsonrpc\":\"2.0\",\"result\":[\"Full\"],\"id\":1}" {
+				c.NodeInfo(instance, "Node works in Full mode")
+				nodes++
+			} else {
+				c.NodeError(instance, "Node working not in Full, not in Authority mode. Got: "+value)
+				return false
+			}
+		}
+	}
+
+	if leaders == 1 && nodes == len(publicIPs)-leaders {
+		t.Log("INFO. There are exactly one leader and the rest nodes are all working in a Full mode")
+		return true
+	} else if leaders > 1 {
+		c.Error("", "", "There are more than 1 leader at the same time.")
+		return false
+	} else if leaders < 1 {
+		c.Error("", "", "There are no leaders.")
+		return false
+	} else {
+		c.Error("", "", "Some of the full nodes are not working correctly.")
+		return false
+	}
+}
+
+// TEST 7
+func PolkadotCheck(ctx context.Context, t TestingT, c *CheckResult, publicIPs map[string]string, key *ssh.KeyPair) bool {
+
+	command := "curl -s -H \"Content-Type: application/json\" -d '{\"id\":1, \"jsonrpc\":\"2.0\", \"method\": \"system_health\", \"params\":[]}' http://localhost:9933"
+	outputs := NodeQuery(ctx, t, publicIPs, key, command)
+
+	if len(outputs) == 0 {
+		return false
+	} else {
+
+		// Parse JSON and verify that node has healthy state
+		for instance, v := range outputs {
+
+			type resultHealth struct {
+				Peers           int  `json:"peers"`
+				ShouldHavePeers bool `json:"shouldHavePeers"`
+				IsSyncing       bool `json:"isSyncing"`
+			}
+
+			type Health struct {
+				Jsonrpc string       `json:"jsonrpc"`
+				Result  resultHealth `json:"result"`
+				Id      int          `json:"id"`
+			}
+
+			var result Health
+			err := json.Unmarshal([]byte(v), &result)
+
+			if err != nil {
+				c.NodeError(instance, err.Error())
+				return false
+			}
+
+			if result.Result.Peers < 2 && result.Result.ShouldHavePeers {
+				c.NodeError(instance, "Node does not have enough peers")
+				return false
+			}
+
+			c.NodeInfo(instance, "Node has "+strconv.Itoa(result.Result.Peers)+" peers, syncing: "+strconv.FormatBool(result.Result.IsSyncing))
+		}
+	}
+
+	return true
+
+}
+
+// TEST 4
+func ConsulLockCheck(ctx context.Context, t TestingT, c *CheckResult, publicIPs map[string]string, key *ssh.KeyPair) bool {
+
+	command := "consul kv export | grep \"prefix/.lock\" | wc -l"
+	outputs := NodeQuery(ctx, t, publicIPs, key, command)
+
+	if len(outputs) == 0 {
+		return false
+	} else {
+
+		for instance, value := range outputs {
+
+			intValue, err := strconv.Atoi(value)
+
+			if err != nil {
+				c.NodeError(instance, err.Error())
+				return false
+			}
+
+			if intValue != 1 {
+				c.NodeError(instance, "Error while retrieving Consul lock. Got: "+strconv.Itoa(intValue)+" locks, should be exactly 1 lock.")
+				return false
+			}
+
+			c.NodeInfo(instance, "Node can see exactly 1 lock")
+		}
+	}
+
+	return true
+
+}
+
+// TEST 13
+func KeystoreCheck(ctx context.Context, t TestingT, c *CheckResult, publicIPs map[string]string, key *ssh.KeyPair, keysCount int) bool {
+
+	command := "ls -lah /data/chains/*/keystore | wc -l"
+	var keysExpected string = strconv.Itoa(keysCount + 3)
+	var outputs map[string]string
+
+	// Every node outputs either 3 lines (empty keystore) or 3 lines more than keys expected. Anything else means that init script is still running
+	err := Poll(ctx, "keystores of all nodes are either empty or full", PollOptions{Timeout: 5 * time.Minute, Interval: 10 * time.Second, Log: t.Log}, func(ctx context.Context) (bool, string, error) {
+		var err error
+		if outputs, err = NodeQueryE(ctx, t, publicIPs, key, command); err != nil {
+			return false, "a node cannot be reached", err
+		}
+
+		for instance, value := range outputs {
+			if value != "3" && value != keysExpected {
+				return false, "keystore listing of " + instance + " has " + value + " lines", nil
+			}
+		}
+		return true, "", nil
+	})
+
+	if err != nil {
+		c.Error("", "", err.Error())
+		return false
+	}
+
+	if len(outputs) == 0 {
+		return false
+	}
+
+	iterator := 0
+	for instance, value := range outputs {
+
+		if value != keysExpected {
+			c.NodeInfo(instance, "Keystore is empty")
+			iterator++
+		} else {
+			c.NodeInfo(instance, "Keystore contains the expected keys")
+		}
+	}
+
+	if iterator != len(outputs)-1 {
+		c.Error("", "", "Keys count not matched. There should be exactly "+strconv.Itoa(keysCount)+" keys on exactly 1 node.")
+		return false
+	}
+	return true
+}
+
+// TEST 5
+func ConsulCheck(ctx context.Context, t TestingT, c *CheckResult, publicIPs map[string]string, key *ssh.KeyPair) bool {
+
+	command := "consul members --status alive | wc -l"
+	outputs := NodeQuery(ctx, t, publicIPs, key, command)
+
+	if len(outputs) == 0 {
+		return false
+	} else {
+
+		for instance, value := range outputs {
+
+			intValue, err := strconv.Atoi(value)
+
+			if err != nil {
+				c.NodeError(instance, err.Error())
+				return false
+			}
+
+			var instanceCountExpected int = len(publicIPs) + 1
+
+			if intValue != instanceCountExpected {
+				c.NodeError(instance, "Consul node count not matched. Node responded the following healthy instance count: "+strconv.Itoa(intValue)+", while there should be "+strconv.Itoa(instanceCountExpected)+" instances")
+				return false
+			}
+
+			c.NodeInfo(instance, "Node can see the full Consul cluster")
+		}
+	}
+
+	return true
+
+}
+
+// Supplementary function: perform given SSH query on the nodes. Returns a map of instance ID to the command output
+func NodeQuery(ctx context.Context, t TestingT, publicIPs map[string]string, key *ssh.KeyPair, command string) map[string]string {
+	resultMap, err := NodeQueryE(ctx, t, publicIPs, key, command)
+	if err != nil {
+		t.Fatal("ERROR! " + err.Error())
+	}
+	return resultMap
+}
+
+// Supplementary function: same as NodeQuery, but returns the error of a node that cannot be reached instead of failing the test. Conditions
+// of Poll use it, so an SSH outage is retried until the poll times out
+func NodeQueryE(ctx context.Context, t TestingT, publicIPs map[string]string, key *ssh.KeyPair, command string) (map[string]string, error) {
+	return nodeQuery(ctx, t, publicIPs, key, command, true)
+}
+
+// Supplementary function: same as NodeQuery, but the output is never logged. Used to read secrets, which must not be part of the command
+func NodeSecretQuery(ctx context.Context, t TestingT, publicIPs map[string]string, key *ssh.KeyPair, command string) map[string]string {
+	resultMap, err := nodeQuery(ctx, t, publicIPs, key, command, false)
+	if err != nil {
+		t.Fatal("ERROR! " + err.Error())
+	}
+	return resultMap
+}
+
+// Supplementary function: puts value to the Consul key through each of the nodes. The value is copied to the node as a file rather than
+// quoted into the command, so free text such as the reason of a freeze is stored as is
+func ConsulKVPut(ctx context.Context, t TestingT, publicIPs map[string]string, key *ssh.KeyPair, consulKey string, value []byte) {
+	if err := ConsulKVPutE(ctx, t, publicIPs, key, consulKey, value); err != nil {
+		t.Fatal("ERROR! " + err.Error())
+	}
+}
+
+// Supplementary function: same as ConsulKVPut, but returns the error instead of failing the test
+func ConsulKVPutE(ctx context.Context, t TestingT, publicIPs map[string]string, key *ssh.KeyPair, consulKey string, value []byte) error {
+
+	file := "/tmp/failover-kv-" + strings.ReplaceAll(consulKey, "/", "-") + ".json"
+	for instance, publicInstanceIP := range publicIPs {
+		publicHost := ssh.Host{
+			Hostname:    publicInstanceIP,
+			SshKeyPair:  key,
+			SshUserName: "ec2-user",
+		}
+		if err := ssh.ScpFileToE(t, publicHost, 0600, file, string(value)); err != nil {
+			return fmt.Errorf("unable to copy the value of %s to %s: %w", consulKey, instance, err)
+		}
+		if _, err := NodeQueryE(ctx, t, map[string]string{instance: publicInstanceIP}, key, "consul kv put "+consulKey+" @"+file+" && rm -f "+file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nodeQuery(ctx context.Context, t TestingT, publicIPs map[string]string, key *ssh.KeyPair, command string, logOutput bool) (map[string]string, error) {
+
+	resultMap := make(map[string]string)
+
+	for instance, publicInstanceIP := range publicIPs {
+
+		publicHost := ssh.Host{
+			Hostname:    publicInstanceIP,
+			SshKeyPair:  key,
+			SshUserName: "ec2-user",
+		}
+
+		var result string
+
+		t.Log("DEBUG. Querying instance " + publicInstanceIP + " with command `" + command + "`")
+		// Verify that we can SSH to the Instance and run commands. It can take a minute or so for the Instance to boot up, so retry for a while
+		err := Poll(ctx, fmt.Sprintf("SSH to public host %s", publicInstanceIP), PollOptions{Timeout: 2 * time.Minute, Interval: 5 * time.Second, MaxInterval: 15 * time.Second}, func(ctx context.Context) (bool, string, error) {
+			output, err := ssh.CheckSshCommandE(t, publicHost, command)
+
+			if err != nil {
+				return false, "", err
+			}
+
+			result = strings.TrimSpace(output)
+			return true, "", nil
+		})
+
+		if err != nil {
+			return resultMap, err
+		}
+
+		if logOutput {
+			t.Log("DEBUG. Command output: " + result)
+		}
+		resultMap[instance] = result
+	}
+	return resultMap, nil
+}
+
+// Supplementary function: wait for the init scripts of all the nodes to finish. Each node has to see the full Consul cluster and exactly one node has to work as a validator
+// within timeout, which has to cover the time failover-agent waits for the nodes to sync before they contend for the lock
+func ConvergenceCheck(ctx context.Context, t TestingT, c *CheckResult, publicIPs map[string]string, key *ssh.KeyPair, timeout time.Duration) bool {
+
+	membersCommand := "consul members --status alive | wc -l"
+	rolesCommand := "curl -s -H \"Content-Type: application/json\" -d '{\"id\":1, \"jsonrpc\":\"2.0\", \"method\": \"system_nodeRoles\", \"params\":[]}' http://localhost:9933"
+	membersExpected := strconv.Itoa(len(publicIPs) + 1)
+
+	err := Poll(ctx, "Consul cluster is formed and exactly one node is validating", PollOptions{Timeout: timeout, Interval: 10 * time.Second, MaxInterval: time.Minute, Log: t.Log}, func(ctx context.Context) (bool, string, error) {
+
+		members, err := NodeQueryE(ctx, t, publicIPs, key, membersCommand)
+		if err != nil {
+			return false, "a node cannot be reached", err
+		}
+		for instance, value := range members {
+			if value != membersExpected {
+				return false, "node " + instance + " sees " + value + " Consul members lines, expected " + membersExpected, nil
+			}
+		}
+
+		leaders := 0
+		roles, err := NodeQueryE(ctx, t, publicIPs, key, rolesCommand)
+		if err != nil {
+			return false, "a node cannot be reached", err
+		}
+		for instance, value := range roles {
+			if strings.Contains(value, "\"Authority\"") {
+				leaders++
+			} else if !strings.Contains(value, "\"Full\"") {
+				return false, "node " + instance + " does not answer system_nodeRoles yet", nil
+			}
+		}
+
+		if leaders != 1 {
+			return false, strconv.Itoa(leaders) + " nodes work as a validator", nil
+		}
+		return true, "", nil
+	})
+
+	if err != nil {
+		c.Error("", "", err.Error())
+		return false
+	}
+	return true
+}
+
+// Supplementary function: verifies the SSM parameters tree of a deployment without knowing the expected values. Every region has to have the
+// same set of parameters with the same values, all the keys have to consist of key, seed and type, and seeds have to be encrypted.
+// Values are compared by their hashes and never logged.
+func SSMConsistencyCheck(t TestingT, c *CheckResult) bool {
+
+	path := "/polkadot/validator-failover/" + prefix + "/"
+	result := true
+	hashes := make(map[string]map[string]string)
+
+	for _, region := range awsRegion {
+
+		parameters, err := GetParametersByPathE(t, region, path)
+		if err != nil {
+			c.Error(region, "", "Unable to list SSM parameters under "+path+": "+err.Error())
+			result = false
+			continue
+		}
+
+		regionHashes := make(map[string]string)
+		keys := make(map[string]map[string]string)
+
+		for name, parameter := range parameters {
+			relativePath := strings.TrimPrefix(name, path)
+			sum := sha256.Sum256([]byte(*parameter.Value))
+			regionHashes[relativePath] = *parameter.Type + ":" + hex.EncodeToString(sum[:])
+
+			parts := strings.Split(relativePath, "/")
+			if len(parts) == 3 && parts[0] == "keys" {
+				if keys[parts[1]] == nil {
+					keys[parts[1]] = make(map[string]string)
+				}
+				keys[parts[1]][parts[2]] = *parameter.Type
+			}
+		}
+
+		for _, required := range []string{"name", "cpu_limit", "ram_limit", "node_key"} {
+			if _, ok := regionHashes[required]; !ok {
+				c.Error(region, "", "SSM parameter "+required+" is missing")
+				result = false
+			}
+		}
+
+		if len(keys) == 0 {
+			c.Error(region, "", "No validator keys found under "+path+"keys/")
+			result = false
+		}
+
+		for keyName, parts := range keys {
+			expected := map[string]string{"key": "String", "type": "String", "seed": "SecureString"}
+			for part, expectedType := range expected {
+				if actualType, ok := parts[part]; !ok {
+					c.Error(region, "", "Validator key "+keyName+" has no "+part+" parameter")
+					result = false
+				} else if actualType != expectedType {
+					c.Error(region, "", "Validator key "+keyName+" has "+part+" parameter of type "+actualType+", expected "+expectedType)
+					result = false
+				}
+			}
+		}
+
+		c.Info(region, "", "Found "+strconv.Itoa(len(parameters))+" SSM parameters and "+strconv.Itoa(len(keys))+" validator keys")
+		hashes[region] = regionHashes
+	}
+
+	// Compare every region with the first one
+	reference := hashes[awsRegion[0]]
+	for _, region := range awsRegion[1:] {
+		regionHashes, ok := hashes[region]
+		if !ok || reference == nil {
+			continue
+		}
+
+		var names []string
+		for name := range reference {
+			names = append(names, name)
+		}
+		for name := range regionHashes {
+			if _, ok := reference[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if reference[name] != regionHashes[name] {
+				c.Error(region, "", fmt.Sprintf("SSM parameter %s differs from the one in region %s", name, awsRegion[0]))
+				result = false
+			}
+		}
+	}
+
+	return result
+}
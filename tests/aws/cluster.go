@@ -0,0 +1,173 @@
+package test
+
+// This file contains all the supplementary functions that are required to build a cluster-wide view of the deployment, e.g. for `failoverctl status`
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	taws "github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/gruntwork-io/terratest/modules/ssh"
+	"github.com/stretchr/testify/require"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/election"
+)
+
+// ConsulMember is a node of the Consul cluster. Node names are the EC2 instance IDs
+type ConsulMember struct {
+	Node    string `json:"node"`
+	Address string `json:"address"`
+	Status  string `json:"status"`
+	Region  string `json:"region,omitempty"`
+}
+
+// ConsulView is the Consul cluster as seen by a single node
+type ConsulView struct {
+	// Observer is the instance the view was taken from
+	Observer string         `json:"observer"`
+	Members  []ConsulMember `json:"members"`
+	// Leader is the node of the Raft leader, LockHolder the node whose session holds the validator lock. Both are empty if there is none
+	Leader     string `json:"leader"`
+	LockHolder string `json:"lock_holder"`
+	// Freeze is set while the elections are frozen, Handoff while a handoff request is stored, see `failoverctl freeze` and `failoverctl stepdown`
+	Freeze  *election.FreezeFlag     `json:"freeze,omitempty"`
+	Handoff *election.HandoffRequest `json:"handoff,omitempty"`
+}
+
+// The command prints the members, the Raft leader, the session of the validator lock, the freeze flag and the handoff request known to the
+// local Consul agent as a single JSON document. Requests that fail and missing keys are printed as null
+var consulViewCommand = fmt.Sprintf(`S=$(curl -s -m 5 http://localhost:8500/v1/kv/prefix/.lock | jq -r '.[0].Session // empty' 2>/dev/null)
+get() { R=$(curl -sf -m 5 "http://localhost:8500/v1/$1"); echo "${R:-null}"; }
+echo "{\"members\": $(get agent/members), \"leader\": $(get status/leader), \"lock\": $([ -n "$S" ] && get session/info/$S || echo null), \"freeze\": $(get kv/%s?raw), \"handoff\": $(get kv/%s?raw)}"`,
+	election.DefaultFreezeKey, election.DefaultHandoffKey)
+
+type consulViewOutput struct {
+	Members []struct {
+		Name   string `json:"Name"`
+		Addr   string `json:"Addr"`
+		Status int    `json:"Status"`
+	} `json:"members"`
+	Leader string `json:"leader"`
+	Lock   []struct {
+		Node string `json:"Node"`
+	} `json:"lock"`
+	Freeze  *election.FreezeFlag     `json:"freeze"`
+	Handoff *election.HandoffRequest `json:"handoff"`
+}
+
+// Serf member statuses returned by /v1/agent/members
+var consulMemberStatuses = map[int]string{0: "none", 1: "alive", 2: "leaving", 3: "left", 4: "failed"}
+
+// Supplementary function: returns the Consul cluster as seen by the nodes. Every node has to see the same leader and the same lock holder,
+// the view of the first node in the order of the instance IDs is returned
+func ConsulViewCheck(ctx context.Context, t TestingT, c *CheckResult, publicIPs map[string]string, key *ssh.KeyPair) *ConsulView {
+
+	outputs := NodeQuery(ctx, t, publicIPs, key, consulViewCommand)
+
+	var instances []string
+	for instance := range outputs {
+		instances = append(instances, instance)
+	}
+	sort.Strings(instances)
+
+	var view *ConsulView
+	for _, instance := range instances {
+
+		current, err := parseConsulView(outputs[instance])
+		if err != nil {
+			c.NodeError(instance, "Unable to parse the Consul view: "+err.Error())
+			continue
+		}
+		current.Observer = instance
+		for i := range current.Members {
+			current.Members[i].Region = c.report.regionOf(current.Members[i].Node)
+		}
+
+		if view == nil {
+			view = current
+			continue
+		}
+		if current.Leader != view.Leader {
+			c.NodeError(instance, "Node sees "+current.Leader+" as the Consul leader while "+view.Observer+" sees "+view.Leader)
+		}
+		if current.LockHolder != view.LockHolder {
+			c.NodeError(instance, "Node sees "+current.LockHolder+" as the lock holder while "+view.Observer+" sees "+view.LockHolder)
+		}
+	}
+
+	if view == nil {
+		c.Error("", "", "No node reported its view of the Consul cluster")
+		return nil
+	}
+	if view.Leader == "" {
+		c.Error("", "", "Consul cluster has no leader")
+	} else {
+		c.Info("", "", "Consul leader is "+view.Leader)
+	}
+	if view.Freeze != nil {
+		c.Info("", "", "Elections are frozen by "+view.Freeze.By+": "+view.Freeze.Reason)
+	}
+	return view
+}
+
+// parseConsulView parses the output of consulViewCommand and resolves the address of the leader to its node
+func parseConsulView(output string) (*ConsulView, error) {
+
+	var parsed consulViewOutput
+	if err := json.Unmarshal([]byte(output), &parsed); err != nil {
+		return nil, err
+	}
+
+	view := &ConsulView{Freeze: parsed.Freeze, Handoff: parsed.Handoff}
+	for _, member := range parsed.Members {
+		view.Members = append(view.Members, ConsulMember{Node: member.Name, Address: member.Addr, Status: consulMemberStatuses[member.Status]})
+		// The leader is reported as the address of its server RPC port
+		if parsed.Leader != "" && strings.HasPrefix(parsed.Leader, member.Addr+":") {
+			view.Leader = member.Name
+		}
+	}
+	if view.Leader == "" {
+		view.Leader = parsed.Leader
+	}
+	if len(parsed.Lock) > 0 {
+		view.LockHolder = parsed.Lock[0].Node
+	}
+	sort.Slice(view.Members, func(i, j int) bool { return view.Members[i].Node < view.Members[j].Node })
+	return view, nil
+}
+
+// External function that returns all the volumes with the given tag in the given region, attached or not
+func GetVolumesByTag(t TestingT, region string, tagName string, tagValue string) []*ec2.Volume {
+	out, err := GetVolumesByTagE(t, region, tagName, tagValue)
+	require.NoError(t, err)
+	return out
+}
+
+func GetVolumesByTagE(t TestingT, region string, tagName string, tagValue string) ([]*ec2.Volume, error) {
+	sess, err := taws.NewAuthenticatedSession(region)
+	if err != nil {
+		return nil, err
+	}
+	client := ec2.New(sess)
+
+	var volumes []*ec2.Volume
+	input := &ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("tag:" + tagName), Values: []*string{aws.String(tagValue)}}},
+	}
+	for {
+		output, err := client.DescribeVolumes(input)
+		if err != nil {
+			return volumes, err
+		}
+		volumes = append(volumes, output.Volumes...)
+		if aws.StringValue(output.NextToken) == "" {
+			return volumes, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
@@ -3,15 +3,13 @@ package test
 // This file contains all the supplementary functions that are required to query Cloud Watch (AWS)
 
 import (
-	"testing"
-
 	taws "github.com/gruntwork-io/terratest/modules/aws"
     "github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/stretchr/testify/require"
 )
 
 // External function that receives prefix as argument and returns all alarms with that prefix in the given region
-func GetAlarmsNamesAndStatesByPrefix(t *testing.T, awsRegion string, prefix string) map[string]string {
+func GetAlarmsNamesAndStatesByPrefix(t TestingT, awsRegion string, prefix string) map[string]string {
 	out, err := GetAlarmsNamesAndStatesByPrefixE(t, awsRegion, prefix)
 	if err != nil {
 		t.Error(err)
@@ -20,7 +18,7 @@ func GetAlarmsNamesAndStatesByPrefix(t *testing.T, awsRegion string, prefix stri
 	return out
 }
 
-func GetAlarmsNamesAndStatesByPrefixE(t *testing.T, awsRegion string, prefix string) (map[string]string, error) {
+func GetAlarmsNamesAndStatesByPrefixE(t TestingT, awsRegion string, prefix string) (map[string]string, error) {
 	result := make(map[string]string)
 
         cw := NewCWClient(t, awsRegion)
@@ -32,7 +30,6 @@ func GetAlarmsNamesAndStatesByPrefixE(t *testing.T, awsRegion string, prefix str
 	output, err := cw.DescribeAlarms(input)
 
 	if err != nil {
-                t.Error(err)
                 return result, err
         }
 
@@ -47,13 +44,13 @@ func GetAlarmsNamesAndStatesByPrefixE(t *testing.T, awsRegion string, prefix str
 
 
 // Supplementary function that enables communications with CW API
-func NewCWClient(t *testing.T, region string) *cloudwatch.CloudWatch {
+func NewCWClient(t TestingT, region string) *cloudwatch.CloudWatch {
         client, err := NewCWClientE(t, region)
         require.NoError(t, err)
         return client
 }
 
-func NewCWClientE(t *testing.T, region string) (*cloudwatch.CloudWatch, error) {
+func NewCWClientE(t TestingT, region string) (*cloudwatch.CloudWatch, error) {
         sess, err := taws.NewAuthenticatedSession(region)
         if err != nil {
                 return nil, err
@@ -0,0 +1,201 @@
+package test
+
+// This file contains all the supplementary functions that are required to compare the SSM parameters of the deployment across regions and to reconcile them
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/require"
+)
+
+// Kinds of drift between the copies of a parameter. Versions are reported separately, since every region counts its own versions and
+// a reconciliation bumps them again
+const (
+	DriftMissing = "missing"
+	DriftType    = "type"
+	DriftValue   = "value"
+	DriftKMSKey  = "kms-key"
+	DriftVersion = "version"
+)
+
+// ParameterState describes a single SSM parameter of a region. The value itself is only kept unexported, so it is never printed
+type ParameterState struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Version     int64  `json:"version"`
+	KMSKey      string `json:"kms_key,omitempty"`
+	Hash        string `json:"value_sha256"`
+	Description string `json:"description,omitempty"`
+
+	value string
+}
+
+// ParameterDrift lists the copies of a parameter that differ across the regions. A nil state means the parameter is missing in the region
+type ParameterDrift struct {
+	Name    string                     `json:"name"`
+	Kinds   []string                   `json:"kinds"`
+	Regions map[string]*ParameterState `json:"regions"`
+}
+
+// Drifted reports whether the copies differ in anything but the version
+func (d *ParameterDrift) Drifted() bool {
+	for _, kind := range d.Kinds {
+		if kind != DriftVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// External function that returns the state of every parameter under the given path, keyed by the name relative to the path
+func GetParameterStates(t TestingT, region string, path string) map[string]*ParameterState {
+	states, err := GetParameterStatesE(t, region, path)
+	require.NoError(t, err)
+	return states
+}
+
+func GetParameterStatesE(t TestingT, region string, path string) (map[string]*ParameterState, error) {
+	parameters, err := GetParametersByPathE(t, region, path)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]*ParameterState)
+	for name, parameter := range parameters {
+		sum := sha256.Sum256([]byte(aws.StringValue(parameter.Value)))
+		states[strings.TrimPrefix(name, path)] = &ParameterState{
+			Name:    strings.TrimPrefix(name, path),
+			Type:    aws.StringValue(parameter.Type),
+			Version: aws.Int64Value(parameter.Version),
+			Hash:    hex.EncodeToString(sum[:]),
+			value:   aws.StringValue(parameter.Value),
+		}
+	}
+
+	// The KMS key and the description are only returned by DescribeParameters
+	ssmClient, err := NewSsmClientE(t, region)
+	if err != nil {
+		return nil, err
+	}
+	input := &ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{{Key: aws.String("Path"), Option: aws.String("Recursive"), Values: []*string{aws.String(path)}}},
+	}
+	err = ssmClient.DescribeParametersPages(input, func(page *ssm.DescribeParametersOutput, lastPage bool) bool {
+		for _, metadata := range page.Parameters {
+			if state, ok := states[strings.TrimPrefix(aws.StringValue(metadata.Name), path)]; ok {
+				state.KMSKey = kmsKeyName(aws.StringValue(metadata.KeyId))
+				state.Description = aws.StringValue(metadata.Description)
+			}
+		}
+		return true
+	})
+	return states, err
+}
+
+// kmsKeyName strips the region and the account from the ARN of a KMS key, so the same alias or multi-region key compares equal across
+// regions, e.g. arn:aws:kms:us-east-1:123456789012:alias/aws/ssm becomes alias/aws/ssm
+func kmsKeyName(keyID string) string {
+	if !strings.HasPrefix(keyID, "arn:") {
+		if keyID != "" && !strings.HasPrefix(keyID, "alias/") {
+			return "key/" + keyID
+		}
+		return keyID
+	}
+	parts := strings.SplitN(keyID, ":", 6)
+	if len(parts) < 6 {
+		return keyID
+	}
+	return parts[5]
+}
+
+// Supplementary function: compares the parameters of every region and returns the parameters whose copies differ, sorted by name
+func CompareParameters(states map[string]map[string]*ParameterState, regions []string) []ParameterDrift {
+
+	names := make(map[string]bool)
+	for _, regionStates := range states {
+		for name := range regionStates {
+			names[name] = true
+		}
+	}
+
+	var result []ParameterDrift
+	for name := range names {
+		drift := ParameterDrift{Name: name, Regions: make(map[string]*ParameterState)}
+		kinds := make(map[string]bool)
+
+		var reference *ParameterState
+		for _, region := range regions {
+			state := states[region][name]
+			drift.Regions[region] = state
+			if state == nil {
+				kinds[DriftMissing] = true
+				continue
+			}
+			if reference == nil {
+				reference = state
+				continue
+			}
+			kinds[DriftType] = kinds[DriftType] || state.Type != reference.Type
+			kinds[DriftValue] = kinds[DriftValue] || state.Hash != reference.Hash
+			kinds[DriftKMSKey] = kinds[DriftKMSKey] || state.KMSKey != reference.KMSKey
+			kinds[DriftVersion] = kinds[DriftVersion] || state.Version != reference.Version
+		}
+
+		for _, kind := range []string{DriftMissing, DriftType, DriftValue, DriftKMSKey, DriftVersion} {
+			if kinds[kind] {
+				drift.Kinds = append(drift.Kinds, kind)
+			}
+		}
+		if len(drift.Kinds) > 0 {
+			result = append(result, drift)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// External function that overwrites the parameter of the target region with the type, value, KMS key and description of the source.
+// The KMS key of the target is kept if the key of the source only exists in the source region
+func ReconcileParameter(t TestingT, region string, path string, source *ParameterState, target *ParameterState) {
+	err := ReconcileParameterE(t, region, path, source, target)
+	require.NoError(t, err)
+}
+
+func ReconcileParameterE(t TestingT, region string, path string, source *ParameterState, target *ParameterState) error {
+	input := &ssm.PutParameterInput{
+		Name:      aws.String(path + source.Name),
+		Type:      aws.String(source.Type),
+		Value:     aws.String(source.value),
+		Overwrite: aws.Bool(target != nil),
+	}
+	if source.Description != "" {
+		input.Description = aws.String(source.Description)
+	}
+
+	if source.Type == ssm.ParameterTypeSecureString {
+		switch {
+		case strings.HasPrefix(source.KMSKey, "alias/"):
+			input.KeyId = aws.String(source.KMSKey)
+		case strings.HasPrefix(source.KMSKey, "key/mrk-"):
+			input.KeyId = aws.String(strings.TrimPrefix(source.KMSKey, "key/"))
+		case target != nil && target.KMSKey != "":
+			input.KeyId = aws.String(strings.TrimPrefix(target.KMSKey, "key/"))
+		default:
+			return fmt.Errorf("KMS key %s of %s does not exist in region %s, use an alias or a multi-region key", source.KMSKey, source.Name, region)
+		}
+	}
+
+	ssmClient, err := NewSsmClientE(t, region)
+	if err != nil {
+		return err
+	}
+	_, err = ssmClient.PutParameter(input)
+	return err
+}
@@ -0,0 +1,142 @@
+package test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var driftRegions = []string{"us-east-1", "us-east-2", "us-west-1"}
+
+func parameterState(name string, version int64, hash string) *ParameterState {
+	return &ParameterState{Name: name, Type: "SecureString", Version: version, KMSKey: "alias/aws/ssm", Hash: hash}
+}
+
+func TestCompareParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(states map[string]map[string]*ParameterState)
+		kinds  map[string][]string
+	}{
+		{
+			name:   "in sync",
+			modify: func(states map[string]map[string]*ParameterState) {},
+			kinds:  map[string][]string{},
+		},
+		{
+			name: "missing",
+			modify: func(states map[string]map[string]*ParameterState) {
+				delete(states["us-east-2"], "keys/gran")
+			},
+			kinds: map[string][]string{"keys/gran": {DriftMissing}},
+		},
+		{
+			name: "missing in the first region",
+			modify: func(states map[string]map[string]*ParameterState) {
+				delete(states["us-east-1"], "keys/gran")
+				states["us-west-1"]["keys/gran"].Hash = "other"
+			},
+			kinds: map[string][]string{"keys/gran": {DriftMissing, DriftValue}},
+		},
+		{
+			name: "type",
+			modify: func(states map[string]map[string]*ParameterState) {
+				states["us-west-1"]["name"].Type = "String"
+			},
+			kinds: map[string][]string{"name": {DriftType}},
+		},
+		{
+			name: "value",
+			modify: func(states map[string]map[string]*ParameterState) {
+				states["us-east-2"]["keys/gran"].Hash = "other"
+			},
+			kinds: map[string][]string{"keys/gran": {DriftValue}},
+		},
+		{
+			name: "KMS key",
+			modify: func(states map[string]map[string]*ParameterState) {
+				states["us-west-1"]["keys/gran"].KMSKey = "key/1234abcd-12ab-34cd-56ef-1234567890ab"
+			},
+			kinds: map[string][]string{"keys/gran": {DriftKMSKey}},
+		},
+		{
+			name: "version",
+			modify: func(states map[string]map[string]*ParameterState) {
+				states["us-east-1"]["name"].Version = 3
+			},
+			kinds: map[string][]string{"name": {DriftVersion}},
+		},
+		{
+			name: "several kinds and parameters",
+			modify: func(states map[string]map[string]*ParameterState) {
+				states["us-east-2"]["name"].Hash = "other"
+				states["us-east-2"]["name"].Version = 2
+				delete(states["us-west-1"], "keys/gran")
+			},
+			kinds: map[string][]string{"keys/gran": {DriftMissing}, "name": {DriftValue, DriftVersion}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			states := make(map[string]map[string]*ParameterState)
+			for _, region := range driftRegions {
+				states[region] = map[string]*ParameterState{
+					"keys/gran": parameterState("keys/gran", 1, "gran"),
+					"name":      parameterState("name", 1, "name"),
+				}
+			}
+			test.modify(states)
+
+			kinds := make(map[string][]string)
+			var names []string
+			for _, drift := range CompareParameters(states, driftRegions) {
+				kinds[drift.Name] = drift.Kinds
+				names = append(names, drift.Name)
+				assert.Len(t, drift.Regions, len(driftRegions))
+			}
+			assert.Equal(t, test.kinds, kinds)
+			assert.True(t, sort.StringsAreSorted(names), "drifts are not sorted by name: %v", names)
+		})
+	}
+}
+
+func TestDrifted(t *testing.T) {
+	tests := []struct {
+		kinds   []string
+		drifted bool
+	}{
+		{nil, false},
+		{[]string{DriftVersion}, false},
+		{[]string{DriftMissing}, true},
+		{[]string{DriftType}, true},
+		{[]string{DriftValue, DriftVersion}, true},
+		{[]string{DriftKMSKey}, true},
+	}
+
+	for _, test := range tests {
+		drift := ParameterDrift{Name: "name", Kinds: test.kinds}
+		assert.Equal(t, test.drifted, drift.Drifted(), "kinds %v", test.kinds)
+	}
+}
+
+func TestKMSKeyName(t *testing.T) {
+	tests := []struct {
+		keyID string
+		name  string
+	}{
+		{"", ""},
+		{"arn:aws:kms:us-east-1:123456789012:alias/aws/ssm", "alias/aws/ssm"},
+		{"arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab", "key/1234abcd-12ab-34cd-56ef-1234567890ab"},
+		{"arn:aws:kms:us-west-1:123456789012:key/mrk-1234abcd12ab34cd56ef1234567890ab", "key/mrk-1234abcd12ab34cd56ef1234567890ab"},
+		{"alias/polkadot", "alias/polkadot"},
+		{"mrk-1234abcd12ab34cd56ef1234567890ab", "key/mrk-1234abcd12ab34cd56ef1234567890ab"},
+		{"1234abcd-12ab-34cd-56ef-1234567890ab", "key/1234abcd-12ab-34cd-56ef-1234567890ab"},
+		{"arn:aws:kms:us-east-1", "arn:aws:kms:us-east-1"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.name, kmsKeyName(test.keyID), "key ID %q", test.keyID)
+	}
+}
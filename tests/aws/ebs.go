@@ -4,7 +4,6 @@ package test
 
 import (
 	"fmt"
-	"testing"
 
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -13,7 +12,7 @@ import (
 )
 
 // This function list all prefixed volumes that does not attached to any instance 
-func GetVolumeDescribe(t *testing.T, region string, tag string, value string) ([]*ec2.Volume) {
+func GetVolumeDescribe(t TestingT, region string, tag string, value string) ([]*ec2.Volume) {
 
 	svc := ec2.New(session.New(&aws.Config{
 		Region: aws.String(region),
@@ -0,0 +1,107 @@
+package test
+
+// This file contains all the supplementary functions that are required to verify the fencing epoch of the validator promotions
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/ssh"
+
+	"github.com/protofire/polkadot-failover-mechanism/agent/election"
+)
+
+// The command prints the epoch record of Consul and the epoch the node recorded on its data volume as a single JSON document
+const epochCommand = `echo "{\"current\": $(consul kv get failover/epoch 2>/dev/null || echo null), \"recorded\": $(cat /data/failover-epoch 2>/dev/null || echo 0)}"`
+
+type epochOutput struct {
+	Current *struct {
+		Epoch uint64 `json:"epoch"`
+		Node  string `json:"node"`
+	} `json:"current"`
+	Recorded uint64 `json:"recorded"`
+}
+
+// Supplementary function: verifies that the epoch never goes back. Every node has to see the same epoch, which must be at least the given
+// minimum, and no node may have recorded an epoch newer than the current one. Nodes running failover-agent always have an epoch once a
+// validator was promoted, so a missing epoch is an error. Returns the current epoch
+func EpochCheck(ctx context.Context, t TestingT, c *CheckResult, publicIPs map[string]string, key *ssh.KeyPair, minimum uint64) (uint64, bool) {
+
+	result := true
+	var current uint64
+	seen := make(map[uint64]bool)
+
+	for instance, output := range NodeQuery(ctx, t, publicIPs, key, epochCommand) {
+
+		var parsed epochOutput
+		if err := json.Unmarshal([]byte(output), &parsed); err != nil {
+			c.NodeError(instance, "Unable to parse epoch: "+err.Error())
+			result = false
+			continue
+		}
+
+		var epoch uint64
+		if parsed.Current != nil {
+			epoch = parsed.Current.Epoch
+		}
+		seen[epoch] = true
+		if epoch > current {
+			current = epoch
+		}
+
+		if parsed.Recorded > epoch {
+			c.NodeError(instance, "Node recorded epoch "+strconv.FormatUint(parsed.Recorded, 10)+" that is newer than the current epoch "+strconv.FormatUint(epoch, 10))
+			result = false
+		}
+	}
+
+	if len(seen) > 1 {
+		c.Error("", "", "Nodes see different epochs")
+		result = false
+	}
+
+	switch {
+	case current == 0:
+		c.Error("", "", "No epoch was started, no validator was promoted by failover-agent")
+		result = false
+	case current < minimum:
+		c.Error("", "", "Epoch is "+strconv.FormatUint(current, 10)+", expected at least "+strconv.FormatUint(minimum, 10))
+		result = false
+	case result:
+		c.Info("", "", "Current epoch is "+strconv.FormatUint(current, 10))
+	}
+
+	return current, result
+}
+
+// Supplementary function: asks the validator to hand its role over to any standby, the same way `failoverctl stepdown` does, and waits until
+// another node validates. Returns the instance ID of the new validator, empty if the promotion did not happen within the timeout
+func PromotionCheck(ctx context.Context, t TestingT, c *CheckResult, publicIPs map[string]string, key *ssh.KeyPair, timeout time.Duration) string {
+
+	var from string
+	for _, node := range NodeStatusCheck(ctx, t, c, publicIPs, key) {
+		if node.LockHolder {
+			from = node.Instance
+		}
+	}
+	if from == "" {
+		c.Error("", "", "No node holds the validator lock")
+		return ""
+	}
+
+	value, err := json.Marshal(election.HandoffRequest{From: from, RequestedAt: time.Now().UTC(), Timeout: timeout})
+	if err != nil {
+		c.Error("", "", err.Error())
+		return ""
+	}
+	ConsulKVPut(ctx, t, map[string]string{from: publicIPs[from]}, key, election.DefaultHandoffKey, value)
+	c.NodeInfo(from, "Asked the validator to step down")
+
+	holder := HandoffCheck(ctx, t, c, publicIPs, key, from, timeout)
+	if holder != "" {
+		c.NodeInfo(holder, "Took over the validator role from "+from)
+	}
+	return holder
+}
@@ -3,15 +3,16 @@ package test
 // This file contains all the supplementary functions that are required to query Load Balancer API V2
 
 import (
-	"testing"
+	"fmt"
 
 	taws "github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/aws/aws-sdk-go/aws"
     "github.com/aws/aws-sdk-go/service/elbv2"
 	"github.com/stretchr/testify/require"
 )
 
 // External function that returns a map of target groups and their health statuses
-func GetHealthStatusSliceByLBsARN(t *testing.T, awsRegion string, arn string) (map[string]string) {
+func GetHealthStatusSliceByLBsARN(t TestingT, awsRegion string, arn string) (map[string]string) {
 	result := make(map[string]string)
 
 	TGSSlice := GetTGsbyLBsARN(t, awsRegion, arn)
@@ -26,14 +27,30 @@ func GetHealthStatusSliceByLBsARN(t *testing.T, awsRegion string, arn string) (m
 	return result
 }
 
+// External function that returns a map of target group names and the health checks they perform, e.g. HTTP:9781/health/alive
+// or TCP:traffic-port
+func GetHealthChecksByLBsARN(t TestingT, awsRegion string, arn string) map[string]string {
+	result := make(map[string]string)
+
+	for _, tg := range GetTGsbyLBsARN(t, awsRegion, arn).TargetGroups {
+		check := aws.StringValue(tg.HealthCheckProtocol) + ":" + aws.StringValue(tg.HealthCheckPort)
+		if tg.HealthCheckPath != nil {
+			check += aws.StringValue(tg.HealthCheckPath)
+		}
+		result[aws.StringValue(tg.TargetGroupName)] = check
+	}
+
+	return result
+}
+
 // Function that recieves health status of the given target group
-func GetHealthStatusOfTG(t *testing.T, awsRegion string, tg *string) *elbv2.DescribeTargetHealthOutput {
+func GetHealthStatusOfTG(t TestingT, awsRegion string, tg *string) *elbv2.DescribeTargetHealthOutput {
         rules, err := GetHealthStatusOfTGE(t, awsRegion, tg)
         require.NoError(t, err)
         return rules
 }
 
-func GetHealthStatusOfTGE(t *testing.T, awsRegion string, tg *string) (*elbv2.DescribeTargetHealthOutput, error) {
+func GetHealthStatusOfTGE(t TestingT, awsRegion string, tg *string) (*elbv2.DescribeTargetHealthOutput, error) {
 	nlb := NewNLBClient(t, awsRegion)
 
 	var input = &elbv2.DescribeTargetHealthInput {
@@ -44,13 +61,13 @@ func GetHealthStatusOfTGE(t *testing.T, awsRegion string, tg *string) (*elbv2.De
 }
 
 // Function that receives all the target groups for the given load balancer
-func GetTGsbyLBsARN(t *testing.T, awsRegion string, arn string) *elbv2.DescribeTargetGroupsOutput {
+func GetTGsbyLBsARN(t TestingT, awsRegion string, arn string) *elbv2.DescribeTargetGroupsOutput {
 	rules, err := GetTGsbyLBsARNE(t, awsRegion, arn)
         require.NoError(t, err)
         return rules
 }
 
-func GetTGsbyLBsARNE(t *testing.T, awsRegion string, arn string) (*elbv2.DescribeTargetGroupsOutput, error) {
+func GetTGsbyLBsARNE(t TestingT, awsRegion string, arn string) (*elbv2.DescribeTargetGroupsOutput, error) {
 	nlb := NewNLBClient(t, awsRegion)
 
 	var input = &elbv2.DescribeTargetGroupsInput {
@@ -59,14 +76,36 @@ func GetTGsbyLBsARNE(t *testing.T, awsRegion string, arn string) (*elbv2.Describ
 	return nlb.DescribeTargetGroups(input)
 }
 
+// Function that returns the ARN of the load balancer with the given name
+func GetLBArnByName(t TestingT, awsRegion string, name string) string {
+	arn, err := GetLBArnByNameE(t, awsRegion, name)
+	require.NoError(t, err)
+	return arn
+}
+
+func GetLBArnByNameE(t TestingT, awsRegion string, name string) (string, error) {
+	nlb := NewNLBClient(t, awsRegion)
+
+	output, err := nlb.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{
+		Names: []*string{&name},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(output.LoadBalancers) == 0 {
+		return "", fmt.Errorf("no load balancer %s found in region %s", name, awsRegion)
+	}
+	return *output.LoadBalancers[0].LoadBalancerArn, nil
+}
+
 // NewSsmClient creates a SSM client.
-func NewNLBClient(t *testing.T, region string) *elbv2.ELBV2 {
+func NewNLBClient(t TestingT, region string) *elbv2.ELBV2 {
         client, err := NewNLBClientE(t, region)
         require.NoError(t, err)
         return client
 }
 
-func NewNLBClientE(t *testing.T, region string) (*elbv2.ELBV2, error) {
+func NewNLBClientE(t TestingT, region string) (*elbv2.ELBV2, error) {
         sess, err := taws.NewAuthenticatedSession(region)
         if err != nil {
                 return nil, err
@@ -0,0 +1,155 @@
+package test
+
+// This file contains the supplementary functions that are required to wait for eventually consistent state (cluster convergence, alarms, keystores, SSH availability)
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Condition is called on every poll attempt. It reports whether the awaited state was reached and a short description of the state that was observed.
+// Returned errors are treated as transient: they are remembered and the condition is polled again. The context expires with the timeout of the poll,
+// so SSH and RPC calls made by the condition should honour it.
+type Condition func(ctx context.Context) (done bool, state string, err error)
+
+// PollOptions defines how often and for how long a condition is polled
+type PollOptions struct {
+	// Timeout bounds the time spent waiting for the condition. The deadline of the context passed to Poll is honoured as well
+	Timeout time.Duration
+	// Interval is the delay after the first failed attempt. Each subsequent delay is multiplied by Multiplier up to MaxInterval
+	Interval    time.Duration
+	MaxInterval time.Duration
+	Multiplier  float64
+	// Jitter is a fraction (0..1) of the delay that is randomly added or subtracted to avoid polling all the nodes in lockstep. Set it to NoJitter
+	// to poll at exact intervals
+	Jitter float64
+	// Log receives progress messages, usually t.Log. Can be nil
+	Log func(args ...interface{})
+}
+
+// DefaultPollOptions are used for any field that is not set explicitly
+var DefaultPollOptions = PollOptions{
+	Timeout:     5 * time.Minute,
+	Interval:    5 * time.Second,
+	MaxInterval: 30 * time.Second,
+	Multiplier:  1.5,
+	Jitter:      0.2,
+}
+
+// NoJitter disables the jitter, a zero Jitter falls back to the default one
+const NoJitter = -1.0
+
+// NotConvergedError is returned when the condition was not reached before the deadline
+type NotConvergedError struct {
+	Description string
+	Attempts    int
+	Elapsed     time.Duration
+	LastState   string
+	LastErr     error
+}
+
+func (e *NotConvergedError) Error() string {
+	message := fmt.Sprintf("%s never converged: gave up after %d attempts in %s", e.Description, e.Attempts, e.Elapsed.Round(time.Second))
+	if e.LastState != "" {
+		message += ", last observed state: " + e.LastState
+	}
+	if e.LastErr != nil {
+		message += ", last error: " + e.LastErr.Error()
+	}
+	return message
+}
+
+// Poll calls the condition until it reports success, using exponential backoff with jitter between the attempts.
+// The description should name the awaited state, e.g. "all Consul nodes see the full cluster", so that timeouts explain what never converged.
+func Poll(ctx context.Context, description string, opts PollOptions, condition Condition) error {
+	opts = opts.withDefaults()
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	delay := opts.Interval
+	result := &NotConvergedError{Description: description}
+
+	for {
+		result.Attempts++
+		done, state, err := condition(ctx)
+		if err == nil && done {
+			if opts.Log != nil {
+				opts.Log(fmt.Sprintf("INFO. %s: converged after %d attempts in %s", description, result.Attempts, time.Since(start).Round(time.Second)))
+			}
+			return nil
+		}
+		result.LastState, result.LastErr = state, err
+
+		sleep := opts.jitter(delay)
+		if opts.Log != nil {
+			opts.Log(fmt.Sprintf("INFO. %s: not converged yet (attempt %d, state: %s), retrying in %s", description, result.Attempts, describe(state, err), sleep.Round(100*time.Millisecond)))
+		}
+
+		select {
+		case <-ctx.Done():
+			result.Elapsed = time.Since(start)
+			return result
+		case <-time.After(sleep):
+		}
+
+		delay = opts.backoff(delay)
+	}
+}
+
+func (opts PollOptions) withDefaults() PollOptions {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultPollOptions.Timeout
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultPollOptions.Interval
+	}
+	if opts.MaxInterval < opts.Interval {
+		opts.MaxInterval = DefaultPollOptions.MaxInterval
+		if opts.MaxInterval < opts.Interval {
+			opts.MaxInterval = opts.Interval
+		}
+	}
+	if opts.Multiplier < 1 {
+		opts.Multiplier = DefaultPollOptions.Multiplier
+	}
+	if opts.Jitter < 0 {
+		opts.Jitter = 0
+	} else if opts.Jitter == 0 || opts.Jitter > 1 {
+		opts.Jitter = DefaultPollOptions.Jitter
+	}
+	return opts
+}
+
+// backoff returns the delay that follows the given one, capped by MaxInterval
+func (opts PollOptions) backoff(delay time.Duration) time.Duration {
+	delay = time.Duration(float64(delay) * opts.Multiplier)
+	if delay > opts.MaxInterval {
+		delay = opts.MaxInterval
+	}
+	return delay
+}
+
+func (opts PollOptions) jitter(delay time.Duration) time.Duration {
+	if opts.Jitter == 0 {
+		return delay
+	}
+	spread := float64(delay) * opts.Jitter
+	return time.Duration(float64(delay) - spread + rand.Float64()*2*spread)
+}
+
+func describe(state string, err error) string {
+	if err != nil {
+		if state != "" {
+			return state + " (" + err.Error() + ")"
+		}
+		return err.Error()
+	}
+	if state == "" {
+		return "unknown"
+	}
+	return state
+}
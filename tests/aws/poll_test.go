@@ -0,0 +1,101 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollConverges(t *testing.T) {
+	attempts := 0
+	err := Poll(context.Background(), "counter reaches 3", PollOptions{Interval: time.Millisecond, Jitter: NoJitter}, func(ctx context.Context) (bool, string, error) {
+		attempts++
+		if attempts == 1 {
+			return false, "", errors.New("transient")
+		}
+		return attempts == 3, "", nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestPollTimeout(t *testing.T) {
+	lastErr := errors.New("connection refused")
+	err := Poll(context.Background(), "node answers", PollOptions{Timeout: 50 * time.Millisecond, Interval: 5 * time.Millisecond}, func(ctx context.Context) (bool, string, error) {
+		return false, "node is down", lastErr
+	})
+
+	var notConverged *NotConvergedError
+	require.True(t, errors.As(err, &notConverged))
+	assert.Equal(t, "node answers", notConverged.Description)
+	assert.True(t, notConverged.Attempts > 1)
+	assert.True(t, notConverged.Elapsed >= 50*time.Millisecond)
+	assert.Equal(t, "node is down", notConverged.LastState)
+	assert.Equal(t, lastErr, notConverged.LastErr)
+	assert.Contains(t, err.Error(), "last observed state: node is down, last error: connection refused")
+}
+
+func TestPollConditionContext(t *testing.T) {
+	// The condition is bounded by the timeout of the poll, not only by the deadline of the parent context
+	err := Poll(context.Background(), "blocking condition", PollOptions{Timeout: 20 * time.Millisecond}, func(ctx context.Context) (bool, string, error) {
+		deadline, ok := ctx.Deadline()
+		require.True(t, ok)
+		assert.True(t, time.Until(deadline) <= 20*time.Millisecond)
+		<-ctx.Done()
+		return false, "", ctx.Err()
+	})
+
+	var notConverged *NotConvergedError
+	require.True(t, errors.As(err, &notConverged))
+	assert.Equal(t, 1, notConverged.Attempts)
+	assert.Equal(t, context.DeadlineExceeded, notConverged.LastErr)
+}
+
+func TestPollBackoff(t *testing.T) {
+	opts := PollOptions{Interval: time.Second, MaxInterval: 5 * time.Second, Multiplier: 2}.withDefaults()
+
+	var delays []time.Duration
+	delay := opts.Interval
+	for i := 0; i < 5; i++ {
+		delay = opts.backoff(delay)
+		delays = append(delays, delay)
+	}
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+}
+
+func TestPollDefaults(t *testing.T) {
+	opts := PollOptions{}.withDefaults()
+	assert.Equal(t, DefaultPollOptions.Timeout, opts.Timeout)
+	assert.Equal(t, DefaultPollOptions.Interval, opts.Interval)
+	assert.Equal(t, DefaultPollOptions.MaxInterval, opts.MaxInterval)
+	assert.Equal(t, DefaultPollOptions.Multiplier, opts.Multiplier)
+	assert.Equal(t, DefaultPollOptions.Jitter, opts.Jitter)
+
+	// A maximum below the interval is raised to the interval
+	opts = PollOptions{Interval: time.Hour}.withDefaults()
+	assert.Equal(t, time.Hour, opts.MaxInterval)
+
+	assert.Equal(t, 0.0, PollOptions{Jitter: NoJitter}.withDefaults().Jitter)
+	assert.Equal(t, DefaultPollOptions.Jitter, PollOptions{Jitter: 1.5}.withDefaults().Jitter)
+}
+
+func TestPollJitter(t *testing.T) {
+	delay := 10 * time.Second
+	opts := PollOptions{Jitter: 0.2}.withDefaults()
+
+	var below, above bool
+	for i := 0; i < 1000; i++ {
+		jittered := opts.jitter(delay)
+		assert.True(t, jittered >= 8*time.Second && jittered <= 12*time.Second, "jittered delay %s out of bounds", jittered)
+		below = below || jittered < delay
+		above = above || jittered > delay
+	}
+	assert.True(t, below && above, "jitter is not spread around the delay")
+
+	assert.Equal(t, delay, PollOptions{Jitter: NoJitter}.withDefaults().jitter(delay))
+}
@@ -0,0 +1,267 @@
+package test
+
+// This file contains all the supplementary functions that are required to collect check results and write them as JUnit XML and JSON reports
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Severity levels of a single finding
+const (
+	SeverityInfo  = "info"
+	SeverityError = "error"
+)
+
+// Finding is a single observation made by a check, optionally bound to a region and an instance
+type Finding struct {
+	Region   string    `json:"region,omitempty"`
+	Instance string    `json:"instance,omitempty"`
+	Severity string    `json:"severity"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+// CheckResult keeps the outcome of one check (one t.Run of the bundle)
+type CheckResult struct {
+	Name     string    `json:"name"`
+	Passed   bool      `json:"passed"`
+	Started  time.Time `json:"started"`
+	Seconds  float64   `json:"duration_seconds"`
+	Findings []Finding `json:"findings"`
+
+	t      TestingT
+	report *Report
+	mu     sync.Mutex
+}
+
+// Report is a collection of check results of a single run against a single prefix
+type Report struct {
+	Prefix    string            `json:"prefix"`
+	Regions   []string          `json:"regions"`
+	Started   time.Time         `json:"started"`
+	Seconds   float64           `json:"duration_seconds"`
+	Instances map[string]string `json:"instances"`
+	Checks    []*CheckResult    `json:"checks"`
+	Nodes     []NodeStatus      `json:"nodes,omitempty"`
+
+	mu sync.Mutex
+}
+
+// NewReport creates an empty report for the given prefix and regions
+func NewReport(prefix string, regions []string) *Report {
+	return &Report{
+		Prefix:    prefix,
+		Regions:   regions,
+		Started:   time.Now(),
+		Instances: make(map[string]string),
+	}
+}
+
+// AddInstance registers an instance so findings about it are attributed to its region
+func (r *Report) AddInstance(instanceID string, region string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Instances[instanceID] = region
+}
+
+func (r *Report) regionOf(instanceID string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Instances[instanceID]
+}
+
+// Run executes the check as a subtest and records its findings, result and timing
+func (r *Report) Run(t *testing.T, name string, check func(t TestingT, c *CheckResult)) bool {
+	result := r.start(name)
+
+	passed := t.Run(name, func(t *testing.T) {
+		result.t = t
+		check(t, result)
+	})
+
+	result.finish(passed)
+	return passed
+}
+
+func (r *Report) start(name string) *CheckResult {
+	result := &CheckResult{Name: name, Started: time.Now(), report: r}
+
+	r.mu.Lock()
+	r.Checks = append(r.Checks, result)
+	r.mu.Unlock()
+
+	return result
+}
+
+func (c *CheckResult) finish(passed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Passed = passed && !c.hasErrors()
+	c.Seconds = time.Since(c.Started).Seconds()
+}
+
+// Info records an informational finding and logs it to the test output
+func (c *CheckResult) Info(region string, instance string, message string) {
+	c.t.Log("INFO. " + message)
+	c.add(region, instance, SeverityInfo, message)
+}
+
+// Error records a failed finding and marks the check as failed
+func (c *CheckResult) Error(region string, instance string, message string) {
+	c.t.Error("ERROR! " + message)
+	c.add(region, instance, SeverityError, message)
+}
+
+// NodeInfo records an informational finding about an instance, resolving its region from the report
+func (c *CheckResult) NodeInfo(instance string, message string) {
+	c.Info(c.report.regionOf(instance), instance, message)
+}
+
+// NodeError records a failed finding about an instance, resolving its region from the report
+func (c *CheckResult) NodeError(instance string, message string) {
+	c.Error(c.report.regionOf(instance), instance, message)
+}
+
+func (c *CheckResult) add(region string, instance string, severity string, message string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Findings = append(c.Findings, Finding{
+		Region:   region,
+		Instance: instance,
+		Severity: severity,
+		Message:  message,
+		Time:     time.Now(),
+	})
+}
+
+func (c *CheckResult) hasErrors() bool {
+	for _, f := range c.Findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Failures returns the number of checks that did not pass
+func (r *Report) Failures() int {
+	failures := 0
+	for _, c := range r.Checks {
+		if !c.Passed {
+			failures++
+		}
+	}
+	return failures
+}
+
+// Write stores the report as junit.xml and report.json inside of the given directory
+func (r *Report) Write(dir string) error {
+	r.Seconds = time.Since(r.Started).Seconds()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := r.WriteJSON(filepath.Join(dir, "report.json")); err != nil {
+		return err
+	}
+	return r.WriteJUnit(filepath.Join(dir, "junit.xml"))
+}
+
+// WriteJSON stores the full report including every finding as an indented JSON document
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit stores the report in the JUnit XML format understood by CircleCI. Each check becomes a test case
+func (r *Report) WriteJUnit(path string) error {
+	suite := junitTestSuite{
+		Name:      "polkadot-failover-" + r.Prefix,
+		Tests:     len(r.Checks),
+		Failures:  r.Failures(),
+		Time:      fmt.Sprintf("%.3f", r.Seconds),
+		Timestamp: r.Started.UTC().Format("2006-01-02T15:04:05"),
+	}
+
+	for _, c := range r.Checks {
+		testCase := junitTestCase{
+			Name:      c.Name,
+			ClassName: "aws",
+			Time:      fmt.Sprintf("%.3f", c.Seconds),
+		}
+
+		var errors, output string
+		for _, f := range c.Findings {
+			line := f.String() + "\n"
+			if f.Severity == SeverityError {
+				errors += line
+			}
+			output += line
+		}
+
+		if !c.Passed {
+			testCase.Failure = &junitFailure{Message: c.Name + " check failed", Text: errors}
+		}
+		testCase.SystemOut = output
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), data...), 0644)
+}
+
+// String formats the finding as a single line prefixed with its region and instance
+func (f Finding) String() string {
+	location := ""
+	if f.Region != "" {
+		location += "[" + f.Region + "]"
+	}
+	if f.Instance != "" {
+		location += "[" + f.Instance + "]"
+	}
+	if location != "" {
+		location += " "
+	}
+	return location + f.Severity + ": " + f.Message
+}
@@ -3,8 +3,6 @@ package test
 // This file contains all the supplementary functions that are required to query EC2's Security groups API
 
 import (
-	"testing"
-
 	taws "github.com/gruntwork-io/terratest/modules/aws"
     aws "github.com/aws/aws-sdk-go/aws"
     "github.com/aws/aws-sdk-go/service/ec2"
@@ -12,13 +10,13 @@ import (
 )
 
 // Function that returns a set of Security group permissions for particular prefix
-func GetSGRulesMapByTag(t *testing.T, awsRegion string, tag string, value string) []*ec2.IpPermission {
+func GetSGRulesMapByTag(t TestingT, awsRegion string, tag string, value string) []*ec2.IpPermission {
 	rules, err := GetSGRulesMapByTagE(t, awsRegion, tag, value)
         require.NoError(t, err)
         return rules
 }
 
-func GetSGRulesMapByTagE(t *testing.T, awsRegion string, tag string, value string) ([]*ec2.IpPermission, error) {
+func GetSGRulesMapByTagE(t TestingT, awsRegion string, tag string, value string) ([]*ec2.IpPermission, error) {
 	asg := NewSGClient(t, awsRegion)
 
 	ec2FilterList := []*ec2.Filter{
@@ -44,13 +42,13 @@ func GetSGRulesMapByTagE(t *testing.T, awsRegion string, tag string, value strin
 }
 
 // NewSsmClient creates a SSM client.
-func NewSGClient(t *testing.T, region string) *ec2.EC2 {
+func NewSGClient(t TestingT, region string) *ec2.EC2 {
         client, err := NewSGClientE(t, region)
         require.NoError(t, err)
         return client
 }
 
-func NewSGClientE(t *testing.T, region string) (*ec2.EC2, error) {
+func NewSGClientE(t TestingT, region string) (*ec2.EC2, error) {
         sess, err := taws.NewAuthenticatedSession(region)
         if err != nil {
                 return nil, err
@@ -3,8 +3,6 @@ package test
 // This file contains all the supplementary functions that are required to query SSM API
 
 import (
-	"testing"
-
 	taws "github.com/gruntwork-io/terratest/modules/aws"
 	aws "github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ssm"
@@ -12,13 +10,13 @@ import (
 )
 
 // GetParameter retrieves the latest version of SSM Parameter and it's type with decryption
-func GetParameterTypeAndValue(t *testing.T, awsRegion string, keyName string) (string, string) {
+func GetParameterTypeAndValue(t TestingT, awsRegion string, keyName string) (string, string) {
 	keyType, keyValue, err := GetParameterTypeAndValueE(t, awsRegion, keyName)
 	require.NoError(t, err)
 	return keyType, keyValue
 }
 
-func GetParameterTypeAndValueE(t *testing.T, awsRegion string, keyName string) (string, string, error) {
+func GetParameterTypeAndValueE(t TestingT, awsRegion string, keyName string) (string, string, error) {
 	ssmClient := NewSsmClient(t, awsRegion)
 
 	resp, err := ssmClient.GetParameter(&ssm.GetParameterInput{Name: aws.String(keyName), WithDecryption: aws.Bool(true)})
@@ -30,14 +28,36 @@ func GetParameterTypeAndValueE(t *testing.T, awsRegion string, keyName string) (
 	return *parameter.Type, *parameter.Value, nil
 }
 
+// GetParametersByPath retrieves all the parameters under the given path recursively with decryption. Returns a map of parameter name to parameter
+func GetParametersByPath(t TestingT, awsRegion string, path string) map[string]*ssm.Parameter {
+	parameters, err := GetParametersByPathE(t, awsRegion, path)
+	require.NoError(t, err)
+	return parameters
+}
+
+func GetParametersByPathE(t TestingT, awsRegion string, path string) (map[string]*ssm.Parameter, error) {
+	ssmClient := NewSsmClient(t, awsRegion)
+	result := make(map[string]*ssm.Parameter)
+
+	input := &ssm.GetParametersByPathInput{Path: aws.String(path), Recursive: aws.Bool(true), WithDecryption: aws.Bool(true)}
+	err := ssmClient.GetParametersByPathPages(input, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+		for _, parameter := range page.Parameters {
+			result[*parameter.Name] = parameter
+		}
+		return true
+	})
+
+	return result, err
+}
+
 // NewSsmClient creates a SSM client.
-func NewSsmClient(t *testing.T, region string) *ssm.SSM {
+func NewSsmClient(t TestingT, region string) *ssm.SSM {
 	client, err := NewSsmClientE(t, region)
 	require.NoError(t, err)
 	return client
 }
 
-func NewSsmClientE(t *testing.T, region string) (*ssm.SSM, error) {
+func NewSsmClientE(t TestingT, region string) (*ssm.SSM, error) {
 	sess, err := taws.NewAuthenticatedSession(region)
 	if err != nil {
 		return nil, err
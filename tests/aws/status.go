@@ -0,0 +1,170 @@
+package test
+
+// This file contains all the supplementary functions that are required to collect the state of each node (role, lock, best block) over SSH
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/ssh"
+)
+
+// NodeStatus is the state of a single node as reported by the node itself
+type NodeStatus struct {
+	Instance   string `json:"instance"`
+	Region     string `json:"region"`
+	PublicIP   string `json:"public_ip"`
+	Role       string `json:"role"`
+	LockHolder bool   `json:"lock_holder"`
+	BestBlock  int64  `json:"best_block"`
+	Finalized  int64  `json:"finalized_block"`
+	Peers      int    `json:"peers"`
+	IsSyncing  bool   `json:"is_syncing"`
+}
+
+// The command queries Polkadot RPC and the local Consul agent and prints a single JSON document. RPC calls that fail are printed as null.
+// The node holds the lock if the session of the "prefix/.lock" key belongs to the local Consul node.
+const nodeStatusCommand = `rpc() { R=$(curl -s -m 5 -H "Content-Type: application/json" -d "{\"id\":1, \"jsonrpc\":\"2.0\", \"method\": \"$1\", \"params\":${2:-[]}}" http://localhost:9933); echo "${R:-null}"; }
+F=$(rpc chain_getFinalizedHead | jq -r '.result // empty' 2>/dev/null)
+S=$(curl -s -m 5 http://localhost:8500/v1/kv/prefix/.lock | jq -r '.[0].Session // empty' 2>/dev/null)
+H=false
+if [ -n "$S" ] && [ "$(curl -s -m 5 http://localhost:8500/v1/session/info/$S | jq -r '.[0].Node')" == "$(curl -s -m 5 http://localhost:8500/v1/agent/self | jq -r .Config.NodeName)" ]; then H=true; fi
+echo "{\"roles\": $(rpc system_nodeRoles), \"health\": $(rpc system_health), \"header\": $(rpc chain_getHeader), \"finalized\": $(rpc chain_getHeader "[\"$F\"]"), \"lock\": $H}"`
+
+type nodeStatusOutput struct {
+	Roles *struct {
+		Result []string `json:"result"`
+	} `json:"roles"`
+	Health *struct {
+		Result struct {
+			Peers     int  `json:"peers"`
+			IsSyncing bool `json:"isSyncing"`
+		} `json:"result"`
+	} `json:"health"`
+	Header    *headerOutput `json:"header"`
+	Finalized *headerOutput `json:"finalized"`
+	Lock      bool          `json:"lock"`
+}
+
+type headerOutput struct {
+	Result struct {
+		Number string `json:"number"`
+	} `json:"result"`
+}
+
+// Supplementary function: collects the status of every node. Nodes that return malformed output are reported as errors and skipped
+func NodeStatusCheck(ctx context.Context, t TestingT, c *CheckResult, publicIPs map[string]string, key *ssh.KeyPair) []NodeStatus {
+
+	var result []NodeStatus
+
+	for instance, output := range NodeQuery(ctx, t, publicIPs, key, nodeStatusCommand) {
+
+		status, err := parseNodeStatus(output)
+		if err != nil {
+			c.NodeError(instance, err.Error())
+			if status == nil {
+				continue
+			}
+		}
+
+		status.Instance = instance
+		status.Region = c.report.regionOf(instance)
+		status.PublicIP = publicIPs[instance]
+
+		if status.Role == "" {
+			c.NodeError(instance, "Node does not answer Polkadot RPC calls")
+		} else {
+			c.NodeInfo(instance, "Role: "+status.Role+", lock holder: "+strconv.FormatBool(status.LockHolder)+", best block: "+strconv.FormatInt(status.BestBlock, 10)+", peers: "+strconv.Itoa(status.Peers))
+		}
+
+		result = append(result, *status)
+	}
+
+	return result
+}
+
+// parseNodeStatus parses the output of nodeStatusCommand. A status is returned along with the error if only the block number is malformed
+func parseNodeStatus(output string) (*NodeStatus, error) {
+
+	var parsed nodeStatusOutput
+	if err := json.Unmarshal([]byte(output), &parsed); err != nil {
+		return nil, fmt.Errorf("Unable to parse node status: %s", err)
+	}
+
+	status := &NodeStatus{LockHolder: parsed.Lock}
+
+	if parsed.Roles != nil && len(parsed.Roles.Result) > 0 {
+		status.Role = parsed.Roles.Result[0]
+	}
+	if parsed.Health != nil {
+		status.Peers = parsed.Health.Result.Peers
+		status.IsSyncing = parsed.Health.Result.IsSyncing
+	}
+	if parsed.Header != nil {
+		number, err := strconv.ParseInt(strings.TrimPrefix(parsed.Header.Result.Number, "0x"), 16, 64)
+		if err != nil {
+			return status, fmt.Errorf("Unable to parse best block number %s", parsed.Header.Result.Number)
+		}
+		status.BestBlock = number
+	}
+	// The finalized header is an error if the node did not answer chain_getFinalizedHead
+	if parsed.Finalized != nil && parsed.Finalized.Result.Number != "" {
+		number, err := strconv.ParseInt(strings.TrimPrefix(parsed.Finalized.Result.Number, "0x"), 16, 64)
+		if err != nil {
+			return status, fmt.Errorf("Unable to parse finalized block number %s", parsed.Finalized.Result.Number)
+		}
+		status.Finalized = number
+	}
+
+	return status, nil
+}
+
+// Supplementary function: waits until a node other than the given one holds the lock and runs as the only validator, and returns its instance ID.
+// Nodes that are restarting are expected during the handoff, so they are not reported as errors
+func HandoffCheck(ctx context.Context, t TestingT, c *CheckResult, publicIPs map[string]string, key *ssh.KeyPair, from string, timeout time.Duration) string {
+
+	var holder string
+
+	err := Poll(ctx, "validator role handed over from "+from, PollOptions{Timeout: timeout, Interval: 10 * time.Second, MaxInterval: 30 * time.Second, Log: t.Log}, func(ctx context.Context) (bool, string, error) {
+		holder = ""
+		var validators []string
+		var states []string
+
+		outputs, err := NodeQueryE(ctx, t, publicIPs, key, nodeStatusCommand)
+		if err != nil {
+			// The nodes restart their services during the handoff
+			return false, "a node cannot be reached", err
+		}
+		for instance, output := range outputs {
+			status, err := parseNodeStatus(output)
+			if err != nil {
+				states = append(states, instance+": "+err.Error())
+				continue
+			}
+			if status.Role == "Authority" {
+				validators = append(validators, instance)
+			}
+			if status.LockHolder {
+				holder = instance
+			}
+			states = append(states, instance+": "+status.Role+", lock holder: "+strconv.FormatBool(status.LockHolder))
+		}
+
+		sort.Strings(states)
+		done := holder != "" && holder != from && len(validators) == 1 && validators[0] == holder
+		return done, strings.Join(states, "; "), nil
+	})
+
+	if err != nil {
+		c.NodeError(from, err.Error())
+		return ""
+	}
+
+	c.NodeInfo(holder, "Took over the validator role from "+from)
+	return holder
+}
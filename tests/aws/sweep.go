@@ -0,0 +1,226 @@
+package test
+
+// This file contains all the supplementary functions that are required to find and delete the resources left behind by test runs that were killed before `terraform destroy`
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	taws "github.com/gruntwork-io/terratest/modules/aws"
+	"github.com/stretchr/testify/require"
+)
+
+// Kinds of the resources a deployment leaves behind
+const (
+	ResourceVolume        = "volume"
+	ResourceSecurityGroup = "security-group"
+	ResourceAlarm         = "alarm"
+	ResourceParameter     = "ssm-parameter"
+	ResourceLogGroup      = "log-group"
+	ResourceStateKey      = "state-key"
+	ResourceAgent         = "agent"
+)
+
+// CreatedTag is the tag holding the creation time of the security groups, which the API does not return
+const CreatedTag = "created"
+
+// ParametersRoot is the path all the deployments store their SSM parameters and audit log groups under
+const ParametersRoot = "/polkadot/validator-failover/"
+
+// Resource is a resource that belongs to the deployment of a prefix
+type Resource struct {
+	Kind   string `json:"kind"`
+	Region string `json:"region"`
+	Prefix string `json:"prefix"`
+	// ID is the volume or security group ID, the name of the alarm, parameter or log group, or the key of the Terraform state
+	ID string `json:"id"`
+	// Time is the creation time of the resource, or its last modification for SSM parameters and the objects of the bucket. Security
+	// groups only have one if they carry CreatedTag
+	Time time.Time `json:"time,omitempty"`
+	// InUse is set for volumes attached to an instance, which cannot be deleted
+	InUse bool `json:"in_use,omitempty"`
+}
+
+// External function that returns all the volumes, security groups, alarms, SSM parameters and audit log groups of any prefix in the given region
+func GetPrefixedResources(t TestingT, region string) []Resource {
+	out, err := GetPrefixedResourcesE(t, region)
+	require.NoError(t, err)
+	return out
+}
+
+func GetPrefixedResourcesE(t TestingT, region string) ([]Resource, error) {
+	sess, err := taws.NewAuthenticatedSession(region)
+	if err != nil {
+		return nil, err
+	}
+	var result []Resource
+	// Resources created by the deployment carry the prefix tag, see asg.tf and asg_security.tf
+	tagged := []*ec2.Filter{{Name: aws.String("tag-key"), Values: []*string{aws.String("prefix")}}}
+	ec2Client := ec2.New(sess)
+
+	err = ec2Client.DescribeVolumesPages(&ec2.DescribeVolumesInput{Filters: tagged}, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		for _, volume := range page.Volumes {
+			result = append(result, Resource{
+				Kind:   ResourceVolume,
+				Region: region,
+				Prefix: ec2TagValue(volume.Tags, "prefix"),
+				ID:     aws.StringValue(volume.VolumeId),
+				Time:   aws.TimeValue(volume.CreateTime),
+				InUse:  len(volume.Attachments) > 0,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return result, err
+	}
+
+	err = ec2Client.DescribeSecurityGroupsPages(&ec2.DescribeSecurityGroupsInput{Filters: tagged}, func(page *ec2.DescribeSecurityGroupsOutput, lastPage bool) bool {
+		for _, group := range page.SecurityGroups {
+			// A missing or malformed tag leaves the time zero
+			created, _ := time.Parse(time.RFC3339, ec2TagValue(group.Tags, CreatedTag))
+			result = append(result, Resource{Kind: ResourceSecurityGroup, Region: region, Prefix: ec2TagValue(group.Tags, "prefix"), ID: aws.StringValue(group.GroupId), Time: created})
+		}
+		return true
+	})
+	if err != nil {
+		return result, err
+	}
+
+	err = cloudwatch.New(sess).DescribeAlarmsPages(&cloudwatch.DescribeAlarmsInput{}, func(page *cloudwatch.DescribeAlarmsOutput, lastPage bool) bool {
+		for _, alarm := range page.MetricAlarms {
+			name := aws.StringValue(alarm.AlarmName)
+			if prefix := alarmPrefix(name); prefix != "" {
+				result = append(result, Resource{Kind: ResourceAlarm, Region: region, Prefix: prefix, ID: name, Time: aws.TimeValue(alarm.AlarmConfigurationUpdatedTimestamp)})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return result, err
+	}
+
+	input := &ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{{Key: aws.String("Path"), Option: aws.String("Recursive"), Values: []*string{aws.String(ParametersRoot)}}},
+	}
+	err = ssm.New(sess).DescribeParametersPages(input, func(page *ssm.DescribeParametersOutput, lastPage bool) bool {
+		for _, parameter := range page.Parameters {
+			name := aws.StringValue(parameter.Name)
+			result = append(result, Resource{Kind: ResourceParameter, Region: region, Prefix: rootPrefix(name), ID: name, Time: aws.TimeValue(parameter.LastModifiedDate)})
+		}
+		return true
+	})
+	if err != nil {
+		return result, err
+	}
+
+	err = cloudwatchlogs.New(sess).DescribeLogGroupsPages(&cloudwatchlogs.DescribeLogGroupsInput{LogGroupNamePrefix: aws.String(ParametersRoot)}, func(page *cloudwatchlogs.DescribeLogGroupsOutput, lastPage bool) bool {
+		for _, group := range page.LogGroups {
+			name := aws.StringValue(group.LogGroupName)
+			result = append(result, Resource{Kind: ResourceLogGroup, Region: region, Prefix: rootPrefix(name), ID: name, Time: aws.MillisecondsTimeValue(group.CreationTime)})
+		}
+		return true
+	})
+	return result, err
+}
+
+// External function that returns the Terraform states and the agents the bundle test published of all the prefixes in the given bucket.
+// The bundle test stores the state of a prefix under <prefix>-<stateKey> and the agent under <prefix>-failover-agent
+func GetStateKeys(t TestingT, region string, bucket string, stateKey string) []Resource {
+	out, err := GetStateKeysE(t, region, bucket, stateKey)
+	require.NoError(t, err)
+	return out
+}
+
+func GetStateKeysE(t TestingT, region string, bucket string, stateKey string) ([]Resource, error) {
+	sess, err := taws.NewAuthenticatedSession(region)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Resource
+	err = s3.New(sess).ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String(bucket)}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			key := aws.StringValue(object.Key)
+			if kind, prefix := bucketKeyPrefix(key, stateKey); prefix != "" {
+				result = append(result, Resource{Kind: kind, Region: region, Prefix: prefix, ID: key, Time: aws.TimeValue(object.LastModified)})
+			}
+		}
+		return true
+	})
+	return result, err
+}
+
+// External function that deletes the resource. The bucket is only used for state keys and agents
+func DeleteResource(t TestingT, resource Resource, bucket string) {
+	err := DeleteResourceE(t, resource, bucket)
+	require.NoError(t, err)
+}
+
+func DeleteResourceE(t TestingT, resource Resource, bucket string) error {
+	sess, err := taws.NewAuthenticatedSession(resource.Region)
+	if err != nil {
+		return err
+	}
+
+	switch resource.Kind {
+	case ResourceVolume:
+		_, err = ec2.New(sess).DeleteVolume(&ec2.DeleteVolumeInput{VolumeId: aws.String(resource.ID)})
+	case ResourceSecurityGroup:
+		_, err = ec2.New(sess).DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: aws.String(resource.ID)})
+	case ResourceAlarm:
+		_, err = cloudwatch.New(sess).DeleteAlarms(&cloudwatch.DeleteAlarmsInput{AlarmNames: []*string{aws.String(resource.ID)}})
+	case ResourceParameter:
+		_, err = ssm.New(sess).DeleteParameters(&ssm.DeleteParametersInput{Names: []*string{aws.String(resource.ID)}})
+	case ResourceLogGroup:
+		_, err = cloudwatchlogs.New(sess).DeleteLogGroup(&cloudwatchlogs.DeleteLogGroupInput{LogGroupName: aws.String(resource.ID)})
+	case ResourceStateKey, ResourceAgent:
+		_, err = s3.New(sess).DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(resource.ID)})
+	default:
+		err = fmt.Errorf("unknown resource kind %s", resource.Kind)
+	}
+	return err
+}
+
+func ec2TagValue(tags []*ec2.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
+
+// rootPrefix returns the prefix of a name under ParametersRoot, e.g. test1 for /polkadot/validator-failover/test1/keys/gran/seed
+func rootPrefix(name string) string {
+	return strings.SplitN(strings.TrimPrefix(name, ParametersRoot), "/", 2)[0]
+}
+
+// alarmPrefix returns the prefix of an alarm named <prefix>-polkadot-..., see monitoring.tf, or an empty string for other alarms
+func alarmPrefix(name string) string {
+	if index := strings.Index(name, "-polkadot-"); index > 0 {
+		return name[:index]
+	}
+	return ""
+}
+
+// bucketKeyPrefix returns the kind and the prefix of a Terraform state or an agent stored at the top of the bucket, or an empty prefix
+// for other keys
+func bucketKeyPrefix(key string, stateKey string) (string, string) {
+	if strings.Contains(key, "/") {
+		return "", ""
+	}
+	for kind, suffix := range map[string]string{ResourceStateKey: "-" + stateKey, ResourceAgent: AgentKeySuffix} {
+		if strings.HasSuffix(key, suffix) && len(key) > len(suffix) {
+			return kind, strings.TrimSuffix(key, suffix)
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,62 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRootPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+	}{
+		{"/polkadot/validator-failover/test1/keys/gran/seed", "test1"},
+		{"/polkadot/validator-failover/test1/name", "test1"},
+		{"/polkadot/validator-failover/test1", "test1"},
+		{"/polkadot/validator-failover/", ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.prefix, rootPrefix(test.name), "name %q", test.name)
+	}
+}
+
+func TestAlarmPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+	}{
+		{"k3x9a-polkadot-validator-count", "k3x9a"},
+		{"my-deployment-polkadot-health", "my-deployment"},
+		{"k3x9a-polkadot-a-polkadot-b", "k3x9a"},
+		{"-polkadot-health", ""},
+		{"billing-alarm", ""},
+		{"k3x9a-polkadot", ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.prefix, alarmPrefix(test.name), "alarm %q", test.name)
+	}
+}
+
+func TestBucketKeyPrefix(t *testing.T) {
+	tests := []struct {
+		key    string
+		kind   string
+		prefix string
+	}{
+		{"k3x9a-terraform.tfstate", ResourceStateKey, "k3x9a"},
+		{"k3x9a-failover-agent", ResourceAgent, "k3x9a"},
+		{"env:/k3x9a-terraform.tfstate", "", ""},
+		{"-terraform.tfstate", "", ""},
+		{"terraform.tfstate", "", ""},
+		{"k3x9a-notes.txt", "", ""},
+	}
+
+	for _, test := range tests {
+		kind, prefix := bucketKeyPrefix(test.key, "terraform.tfstate")
+		assert.Equal(t, test.kind, kind, "key %q", test.key)
+		assert.Equal(t, test.prefix, prefix, "key %q", test.key)
+	}
+}